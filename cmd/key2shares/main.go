@@ -3,11 +3,14 @@ package main
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"strings"
 
 	"tendermint-signer/internal/signer"
 
@@ -18,54 +21,55 @@ import (
 	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
 )
 
+const rsaKeyBits = 4096
+
 func main() {
 	var threshold = flag.Int("threshold", 2, "the number of shares required to produce a valid signature")
 	var total = flag.Int("total", 2, "the total number of shareholders")
+	var seedFile = flag.String("seed-file", "", "derive the shares and rsa keys from the hex encoded seed in this file, for disaster recovery")
+	var nextKeyFile = flag.String("next-key", "", "also deal the priv_validator_key.json the chain switches to at key_switch_height")
 	flag.Parse()
 
 	if len(flag.Args()) != 1 {
 		log.Fatal("positional argument priv_validator_key.json is required")
 	}
 
-	keyFilePath := flag.Args()[0]
-	keyJSONBytes, err := ioutil.ReadFile(keyFilePath)
-	if err != nil {
-		tmOS.Exit(err.Error())
-	}
-	pvKey := privval.FilePVKey{}
-	err = tmjson.Unmarshal(keyJSONBytes, &pvKey)
-	if err != nil {
-		tmOS.Exit(fmt.Sprintf("Error reading PrivValidator key from %v: %v\n", keyFilePath, err))
-	}
+	pvKey, privKeyBytes := readPrivValidatorKey(flag.Args()[0])
 
-	privKeyBytes := [64]byte{}
+	var shares []tsed25519.Scalar
+	var rsaKeys []*rsa.PrivateKey
+	if *seedFile != "" {
+		shares, rsaKeys = seededKeys(*seedFile, privKeyBytes[:32], uint8(*threshold), uint8(*total))
+	} else {
+		// generate shares from secret
+		shares = tsed25519.DealShares(tsed25519.ExpandSecret(privKeyBytes[:32]), uint8(*threshold), uint8(*total))
 
-	// extract the raw private key bytes from the loaded key
-	// we need this to compute the expanded secret
-	switch ed25519Key := pvKey.PrivKey.(type) {
-	case ed25519.PrivKey:
-		if len(ed25519Key) != len(privKeyBytes) {
-			panic("Key length inconsistency")
+		// generate all rsa keys
+		rsaKeys = make([]*rsa.PrivateKey, len(shares))
+		for idx := range shares {
+			rsaKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+			if err != nil {
+				panic(err)
+			}
+			rsaKeys[idx] = rsaKey
 		}
-		copy(privKeyBytes[:], ed25519Key[:])
-		break
-	default:
-		panic("Not an ed25519 private key")
 	}
 
-	// generate shares from secret
-	shares := tsed25519.DealShares(tsed25519.ExpandSecret(privKeyBytes[:32]), uint8(*threshold), uint8(*total))
-
-	// generate all rsa keys
-	rsaKeys := make([]*rsa.PrivateKey, len(shares))
-	pubkeys := make([]*rsa.PublicKey, len(shares))
-	for idx := range shares {
-		bitSize := 4096
-		rsaKey, err := rsa.GenerateKey(rand.Reader, bitSize)
-		if err != nil {
-			panic(err)
+	// the shares of the next key go to the same cosigners, with the same threshold
+	var nextPvKey privval.FilePVKey
+	var nextShares []tsed25519.Scalar
+	if *nextKeyFile != "" {
+		var nextPrivKeyBytes [64]byte
+		nextPvKey, nextPrivKeyBytes = readPrivValidatorKey(*nextKeyFile)
+		if *seedFile != "" {
+			nextShares = seededNextShares(*seedFile, nextPrivKeyBytes[:32], uint8(*threshold), uint8(*total))
+		} else {
+			nextShares = tsed25519.DealShares(tsed25519.ExpandSecret(nextPrivKeyBytes[:32]), uint8(*threshold), uint8(*total))
 		}
-		rsaKeys[idx] = rsaKey
+	}
+
+	pubkeys := make([]*rsa.PublicKey, len(rsaKeys))
+	for idx, rsaKey := range rsaKeys {
 		pubkeys[idx] = &rsaKey.PublicKey
 	}
 
@@ -82,6 +86,10 @@ func main() {
 			RSAKey:       *rsaKeys[idx],
 			CosignerKeys: pubkeys,
 		}
+		if nextShares != nil {
+			cosignerKey.NextPubKey = nextPvKey.PubKey
+			cosignerKey.NextShareKey = nextShares[idx]
+		}
 
 		jsonBytes, err := json.MarshalIndent(&cosignerKey, "", "  ")
 		if err != nil {
@@ -95,3 +103,92 @@ func main() {
 		fmt.Printf("Created Share %d\n", shareID)
 	}
 }
+
+// readPrivValidatorKey reads a priv_validator_key.json and the raw bytes of its ed25519 private key
+func readPrivValidatorKey(keyFilePath string) (privval.FilePVKey, [64]byte) {
+	keyJSONBytes, err := ioutil.ReadFile(keyFilePath)
+	if err != nil {
+		tmOS.Exit(err.Error())
+	}
+	pvKey := privval.FilePVKey{}
+	err = tmjson.Unmarshal(keyJSONBytes, &pvKey)
+	if err != nil {
+		tmOS.Exit(fmt.Sprintf("Error reading PrivValidator key from %v: %v\n", keyFilePath, err))
+	}
+
+	privKeyBytes := [64]byte{}
+
+	// extract the raw private key bytes from the loaded key
+	// we need this to compute the expanded secret
+	switch ed25519Key := pvKey.PrivKey.(type) {
+	case ed25519.PrivKey:
+		if len(ed25519Key) != len(privKeyBytes) {
+			panic("Key length inconsistency")
+		}
+		copy(privKeyBytes[:], ed25519Key[:])
+	default:
+		panic("Not an ed25519 private key")
+	}
+	return pvKey, privKeyBytes
+}
+
+// seededKeys derives the shares and rsa keys from the seed in seedFile.
+// Running key2shares again with the same key, seed, threshold and total writes the same files.
+func seededKeys(seedFile string, secret []byte, threshold uint8, total uint8) ([]tsed25519.Scalar, []*rsa.PrivateKey) {
+	fmt.Fprintln(os.Stderr, "WARNING: the shares and rsa keys are derived from the seed.")
+	fmt.Fprintln(os.Stderr, "Anyone holding the seed and the validator key can recreate every share file.")
+	fmt.Fprintln(os.Stderr, "Keep the seed as secret as the validator key itself.")
+
+	seedHex, err := ioutil.ReadFile(seedFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(seedHex)))
+	if err != nil {
+		log.Fatalf("Invalid seed in %s: %v", seedFile, err)
+	}
+
+	sharesReader, err := signer.NewSeedReader(seed, "shares")
+	if err != nil {
+		log.Fatal(err)
+	}
+	shares, err := signer.DealSharesFrom(sharesReader, tsed25519.ExpandSecret(secret), threshold, total)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rsaKeys := make([]*rsa.PrivateKey, len(shares))
+	for idx := range shares {
+		rsaReader, err := signer.NewSeedReader(seed, fmt.Sprintf("rsa-%d", idx+1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		rsaKeys[idx], err = signer.GenerateRSAKeyFrom(rsaReader, rsaKeyBits)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return shares, rsaKeys
+}
+
+// seededNextShares derives the shares of the next key from the seed in seedFile, like seededKeys
+func seededNextShares(seedFile string, secret []byte, threshold uint8, total uint8) []tsed25519.Scalar {
+	seedHex, err := ioutil.ReadFile(seedFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(seedHex)))
+	if err != nil {
+		log.Fatalf("Invalid seed in %s: %v", seedFile, err)
+	}
+
+	sharesReader, err := signer.NewSeedReader(seed, "next-shares")
+	if err != nil {
+		log.Fatal(err)
+	}
+	shares, err := signer.DealSharesFrom(sharesReader, tsed25519.ExpandSecret(secret), threshold, total)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return shares
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"tendermint-signer/internal/signer"
+)
+
+const rsaKeyBits = 4096
+
+func main() {
+	var threshold = flag.Int("threshold", 2, "the number of shares of the new cosigner set required to produce a valid signature")
+	var total = flag.Int("total", 2, "the number of cosigners in the new set")
+	var outDir = flag.String("out", ".", "the directory to write the new share files to")
+	flag.Parse()
+
+	if len(flag.Args()) == 0 {
+		log.Fatal("positional arguments private_share.json of at least the current threshold of cosigners are required")
+	}
+
+	keys := make([]signer.CosignerKey, 0, len(flag.Args()))
+	for _, keyFile := range flag.Args() {
+		key, err := signer.LoadCosignerKey(keyFile)
+		if err != nil {
+			log.Fatalf("Error reading cosigner key from %s: %v", keyFile, err)
+		}
+		if key.NextPubKey != nil {
+			log.Fatalf("%s also holds a share of a next key, which reshare does not carry over", keyFile)
+		}
+		keys = append(keys, key)
+	}
+
+	// never overwrite the shares of the current set, they are needed until the new set is running
+	filenames := make([]string, *total)
+	for idx := range filenames {
+		filenames[idx] = filepath.Join(*outDir, fmt.Sprintf("private_share_%d.json", idx+1))
+		if _, err := os.Stat(filenames[idx]); err == nil {
+			log.Fatalf("%s already exists, choose another --out directory", filenames[idx])
+		}
+	}
+
+	shares, err := signer.ReshareKey(keys, uint8(*threshold), uint8(*total))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rsaKeys := make([]*rsa.PrivateKey, len(shares))
+	pubkeys := make([]*rsa.PublicKey, len(shares))
+	for idx := range shares {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			panic(err)
+		}
+		rsaKeys[idx] = rsaKey
+		pubkeys[idx] = &rsaKey.PublicKey
+	}
+
+	newKeys := make([]signer.CosignerKey, len(shares))
+	for idx, share := range shares {
+		newKeys[idx] = signer.CosignerKey{
+			PubKey:       keys[0].PubKey,
+			ShareKey:     share,
+			ID:           idx + 1,
+			RSAKey:       *rsaKeys[idx],
+			CosignerKeys: pubkeys,
+		}
+	}
+
+	// check the new set before writing anything
+	if _, err := signer.VerifyCosignerKeys(newKeys, *threshold); err != nil {
+		log.Fatalf("The new shares do not verify: %v", err)
+	}
+
+	if err := os.MkdirAll(*outDir, 0700); err != nil {
+		log.Fatal(err)
+	}
+	for idx := range newKeys {
+		err := signer.SaveCosignerKey(filenames[idx], &newKeys[idx])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Created Share %d\n", idx+1)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"tendermint-signer/internal/signer"
+)
+
+const usage = `usage:
+  rsarotate rotate <private_share.json>
+      replace the cosigner's rsa key and print the new public key for the peers
+  rsarotate import --id <peer id> --pub <public key> <private_share.json>
+      install a peer's new rsa public key
+  rsarotate finish <private_share.json>
+      drop the keys retained during the rotation`
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	switch os.Args[1] {
+	case "rotate":
+		rotate(os.Args[2:])
+	case "import":
+		importPublicKey(os.Args[2:])
+	case "finish":
+		finish(os.Args[2:])
+	default:
+		log.Fatal(usage)
+	}
+}
+
+func loadKey(flags *flag.FlagSet) (string, signer.CosignerKey) {
+	if len(flags.Args()) != 1 {
+		log.Fatal("positional argument private_share.json is required")
+	}
+
+	keyFile := flags.Args()[0]
+	if signer.IsKeyFileStream(keyFile) {
+		log.Fatalf("%s cannot be rotated in place, rsarotate needs the path of the key file", keyFile)
+	}
+	key, err := signer.LoadCosignerKey(keyFile)
+	if err != nil {
+		log.Fatalf("Error reading cosigner key from %s: %v", keyFile, err)
+	}
+	return keyFile, key
+}
+
+func saveKey(keyFile string, key *signer.CosignerKey) {
+	err := signer.SaveCosignerKey(keyFile, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Wrote %s (rsa_pubs_version %d)\n", keyFile, key.RSAPubsVersion)
+}
+
+func rotate(args []string) {
+	flags := flag.NewFlagSet("rotate", flag.ExitOnError)
+	flags.Parse(args)
+
+	keyFile, key := loadKey(flags)
+
+	bitSize := 4096
+	rsaKey, err := rsa.GenerateKey(rand.Reader, bitSize)
+	if err != nil {
+		panic(err)
+	}
+
+	err = key.RotateRSAKey(rsaKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	saveKey(keyFile, &key)
+
+	pub := base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+	fmt.Printf("\nRestart this cosigner, then on every peer run:\n\n")
+	fmt.Printf("rsarotate import --id %d --pub %s <private_share.json>\n\n", key.ID, pub)
+	fmt.Printf("Once all peers have imported the key and restarted, run `rsarotate finish` here.\n")
+}
+
+func importPublicKey(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	id := flags.Int("id", 0, "the id of the cosigner whose rsa key was rotated")
+	pub := flags.String("pub", "", "the new rsa public key printed by `rsarotate rotate`")
+	flags.Parse(args)
+
+	keyFile, key := loadKey(flags)
+
+	pubBytes, err := base64.StdEncoding.DecodeString(*pub)
+	if err != nil {
+		log.Fatalf("Invalid --pub: %v", err)
+	}
+	pubKey, err := x509.ParsePKCS1PublicKey(pubBytes)
+	if err != nil {
+		log.Fatalf("Invalid --pub: %v", err)
+	}
+
+	err = key.ImportRSAPublicKey(*id, pubKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	saveKey(keyFile, &key)
+}
+
+func finish(args []string) {
+	flags := flag.NewFlagSet("finish", flag.ExitOnError)
+	flags.Parse(args)
+
+	keyFile, key := loadKey(flags)
+	key.FinishRSAKeyRotation()
+	saveKey(keyFile, &key)
+}
@@ -1,48 +1,77 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
-	"path"
 	"sync"
 	"time"
 
 	internalSigner "tendermint-signer/internal/signer"
 
-	tmlog "github.com/tendermint/tendermint/libs/log"
+	"github.com/BurntSushi/toml"
 	tmOS "github.com/tendermint/tendermint/libs/os"
-	tmService "github.com/tendermint/tendermint/libs/service"
-	"github.com/tendermint/tendermint/privval"
-	"github.com/tendermint/tendermint/types"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
 )
 
-func fileExists(filename string) bool {
-	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		return false
-	}
-	return !info.IsDir()
-}
-
 func main() {
-	logger := tmlog.NewTMLogger(
-		tmlog.NewSyncWriter(os.Stdout),
-	).With("module", "validator")
+	if len(os.Args) > 1 && os.Args[1] == "verify-keys" {
+		verifyKeys(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "decode-state" {
+		decodeState(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "watch-state" {
+		watchState(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench-quorum" {
+		benchQuorum(os.Args[2:])
+		return
+	}
 
-	var configFile = flag.String("config", "", "path to configuration file")
+	var configFile = flag.String("config", "", "path to configuration file, or to a directory of *.toml fragments to merge")
+	var printConfig = flag.Bool("print-config", false, "print the configuration with defaults applied and exit")
+	var logTimestamps = flag.String("log-timestamps", "", "local or utc, overrides the log_timestamps option")
 	flag.Parse()
 
 	if *configFile == "" {
 		panic("--config flag is required")
 	}
 
-	config, err := internalSigner.LoadConfigFromFile(*configFile)
+	config, conflicts, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *logTimestamps != "" {
+		config.LogTimestamps = *logTimestamps
+	}
+
+	if *printConfig {
+		for _, conflict := range conflicts {
+			fmt.Fprintln(os.Stderr, "config fragments conflict, the last wins:", conflict)
+		}
+		err := toml.NewEncoder(os.Stdout).Encode(config.Redacted())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	logger, err := internalSigner.NewLogger(os.Stdout, config.LogTimestamps)
 	if err != nil {
 		log.Fatal(err)
 	}
+	logger = logger.With("module", "validator")
+	for _, conflict := range conflicts {
+		logger.Error("Config fragments conflict, the last wins", "conflict", conflict)
+	}
 
 	logger.Info(
 		"Tendermint Validator",
@@ -51,149 +80,239 @@ func main() {
 		"priv-state-dir", config.PrivValStateDir,
 	)
 
-	// services to stop on shutdown
-	var services []tmService.Service
-
-	var pv types.PrivValidator
-
-	chainID := config.ChainID
-	if chainID == "" {
-		log.Fatal("chain_id option is required")
+	service, err := internalSigner.New(config, logger)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	if config.Mode == "single" {
-		logger.Info("Mode: single")
-		stateFile := path.Join(config.PrivValStateDir, fmt.Sprintf("%s_priv_validator_state.json", chainID))
+	err = service.Start()
+	if err != nil {
+		panic(err)
+	}
 
-		var val types.PrivValidator
-		if fileExists(stateFile) {
-			val = privval.LoadFilePV(config.PrivValKeyFile, stateFile)
-		} else {
-			logger.Info("Initializing empty state file", "file", stateFile)
-			val = privval.LoadFilePVEmptyState(config.PrivValKeyFile, stateFile)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	tmOS.TrapSignal(logger, func() {
+		// logged as HANDOFF FAILED, the signer stops all the same
+		_ = service.HandOff()
+		err := service.Stop()
+		if err != nil {
+			panic(err)
 		}
+		wg.Done()
+	})
+	wg.Wait()
+}
 
-		pv = &internalSigner.PvGuard{PrivValidator: val}
-	} else if config.Mode == "mpc" {
-		logger.Info("Mode: mpc")
-		if config.CosignerThreshold == 0 {
-			log.Fatal("The `cosigner_threshold` option is required in `threshold` mode")
-		}
+// loadConfig loads the configuration file, or merges the fragments if configFile is a directory
+func loadConfig(configFile string) (internalSigner.Config, []string, error) {
+	info, err := os.Stat(configFile)
+	if err == nil && info.IsDir() {
+		return internalSigner.LoadConfigFromDir(configFile)
+	}
+	config, err := internalSigner.LoadConfigFromFile(configFile)
+	return config, nil, err
+}
 
-		if config.ListenAddress == "" {
-			log.Fatal("The cosigner_listen_address option is required in `threshold` mode")
-		}
+// verifyKeys checks that cosigner key files form a valid t-of-n sharing of one validator key
+func verifyKeys(args []string) {
+	flags := flag.NewFlagSet("verify-keys", flag.ExitOnError)
+	threshold := flags.Int("threshold", 2, "the number of shares required to produce a valid signature")
+	flags.Parse(args)
 
-		key, err := internalSigner.LoadCosignerKey(config.PrivValKeyFile)
-		if err != nil {
-			panic(err)
-		}
+	if len(flags.Args()) == 0 {
+		log.Fatal("usage: signer verify-keys --threshold <t> private_share_1.json private_share_2.json ...")
+	}
 
-		// ok to auto initialize on disk since the cosigner share is the one that actually
-		// protects against double sign - this exists as a cache for the final signature
-		stateFile := path.Join(config.PrivValStateDir, fmt.Sprintf("%s_priv_validator_state.json", chainID))
-		signState, err := internalSigner.LoadOrCreateSignState(stateFile)
+	keys := make([]internalSigner.CosignerKey, 0)
+	for _, keyFile := range flags.Args() {
+		key, err := internalSigner.LoadCosignerKey(keyFile)
 		if err != nil {
-			panic(err)
+			log.Fatalf("Error reading cosigner key from %s: %v", keyFile, err)
 		}
+		keys = append(keys, key)
+	}
 
-		// state for our cosigner share
-		// Not automatically initialized on disk to avoid double sign risk
-		shareStateFile := path.Join(config.PrivValStateDir, fmt.Sprintf("%s_share_sign_state.json", chainID))
-		shareSignState, err := internalSigner.LoadSignState(shareStateFile)
-		if err != nil {
-			panic(err)
-		}
+	results, err := internalSigner.VerifyCosignerKeys(keys, *threshold)
+	for _, result := range results {
+		fmt.Printf("shares %v reconstruct the public key: %v\n", result.IDs, result.Reconstructs)
+	}
+	if err != nil {
+		fmt.Printf("FAIL: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("PASS: %d keys form a %d-of-%d sharing of %X\n", len(keys), *threshold, len(keys[0].CosignerKeys), keys[0].PubKey.Bytes())
 
-		cosigners := []internalSigner.Cosigner{}
-		remoteCosigners := []internalSigner.RemoteCosigner{}
+	// the shares of the key switched to at key_switch_height, if any
+	nextKeys, ok := internalSigner.NextCosignerKeys(keys)
+	if !ok {
+		return
+	}
+	results, err = internalSigner.VerifyCosignerKeys(nextKeys, *threshold)
+	for _, result := range results {
+		fmt.Printf("next shares %v reconstruct the next public key: %v\n", result.IDs, result.Reconstructs)
+	}
+	if err != nil {
+		fmt.Printf("FAIL: next key: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("PASS: %d keys form a %d-of-%d sharing of the next key %X\n", len(keys), *threshold, len(keys[0].CosignerKeys), nextKeys[0].PubKey.Bytes())
+}
 
-		// add ourselves as a peer so localcosigner can handle GetEphSecPart requests
-		peers := []internalSigner.CosignerPeer{{
-			ID:        key.ID,
-			PublicKey: key.RSAKey.PublicKey,
-		}}
+// decodeState prints a sign state file with its sign bytes decoded
+func decodeState(args []string) {
+	flags := flag.NewFlagSet("decode-state", flag.ExitOnError)
+	file := flags.String("file", "", "path to a sign state file, e.g. state/chain-id_priv_validator_state.json")
+	flags.Parse(args)
 
-		for _, cosignerConfig := range config.Cosigners {
-			cosigner := internalSigner.NewRemoteCosigner(cosignerConfig.ID, cosignerConfig.Address)
-			cosigners = append(cosigners, cosigner)
-			remoteCosigners = append(remoteCosigners, *cosigner)
+	if *file == "" {
+		log.Fatal("usage: signer decode-state --file <sign state file>")
+	}
 
-			if cosignerConfig.ID < 1 || cosignerConfig.ID > len(key.CosignerKeys) {
-				log.Fatalf("Unexpected cosigner ID %d", cosignerConfig.ID)
-			}
+	state, err := internalSigner.LoadSignState(*file)
+	if err != nil {
+		log.Fatalf("Error reading sign state from %s: %v", *file, err)
+	}
+
+	fmt.Printf("height:           %d\n", state.Height)
+	fmt.Printf("round:            %d\n", state.Round)
+	fmt.Printf("step:             %d\n", state.Step)
+	if len(state.EphemeralPublic) > 0 {
+		fmt.Printf("ephemeral public: %X\n", state.EphemeralPublic)
+	}
+	if len(state.Signature) > 0 {
+		fmt.Printf("signature:        %X\n", state.Signature)
+	}
+	if len(state.SignBytes) == 0 {
+		fmt.Println("no sign bytes, nothing was signed yet")
+		return
+	}
+
+	proposal, vote, err := internalSigner.DecodeSignBytes(state.SignBytes)
+	if err != nil {
+		log.Fatalf("Error decoding sign bytes %X: %v", state.SignBytes, err)
+	}
+
+	if proposal != nil {
+		fmt.Println("sign bytes of a proposal:")
+		fmt.Printf("  chain id:  %s\n", proposal.ChainID)
+		fmt.Printf("  height:    %d\n", proposal.Height)
+		fmt.Printf("  round:     %d\n", proposal.Round)
+		fmt.Printf("  pol round: %d\n", proposal.POLRound)
+		fmt.Printf("  block id:  %s\n", formatBlockID(proposal.BlockID))
+		fmt.Printf("  timestamp: %s\n", proposal.Timestamp.UTC().Format(time.RFC3339Nano))
+		return
+	}
+
+	fmt.Printf("sign bytes of a %s:\n", vote.Type)
+	fmt.Printf("  chain id:  %s\n", vote.ChainID)
+	fmt.Printf("  height:    %d\n", vote.Height)
+	fmt.Printf("  round:     %d\n", vote.Round)
+	fmt.Printf("  block id:  %s\n", formatBlockID(vote.BlockID))
+	fmt.Printf("  timestamp: %s\n", vote.Timestamp.UTC().Format(time.RFC3339Nano))
+}
+
+// formatBlockID prints a block id the way tendermint logs it, nil for a vote for no block
+func formatBlockID(blockID *tmProto.CanonicalBlockID) string {
+	if blockID == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%X:%d:%X", blockID.Hash, blockID.PartSetHeader.Total, blockID.PartSetHeader.Hash)
+}
+
+// watchState polls a sign state file, printing every change and the anomalies of each, see SignStateAnomalies
+func watchState(args []string) {
+	flags := flag.NewFlagSet("watch-state", flag.ExitOnError)
+	file := flags.String("file", "", "path to a sign state file, e.g. state/chain-id_share_sign_state.json")
+	interval := flags.Duration("interval", time.Second, "how often to read the file")
+	flags.Parse(args)
+
+	if *file == "" {
+		log.Fatal("usage: signer watch-state --file <sign state file> [--interval 1s]")
+	}
 
-			pubKey := key.CosignerKeys[cosignerConfig.ID-1]
-			peers = append(peers, internalSigner.CosignerPeer{
-				ID:        cosigner.GetID(),
-				PublicKey: *pubKey,
-			})
+	var last internalSigner.SignState
+	var lastErr string
+	first := true
+	for ; ; time.Sleep(*interval) {
+		state, err := internalSigner.LoadSignState(*file)
+		if err != nil {
+			// printed once, not on every poll
+			if err.Error() != lastErr {
+				fmt.Printf("%s ERROR reading %s: %v\n", time.Now().UTC().Format(time.RFC3339), *file, err)
+				lastErr = err.Error()
+			}
+			continue
 		}
+		lastErr = ""
 
-		total := len(config.Cosigners) + 1
-		localCosignerConfig := internalSigner.LocalCosignerConfig{
-			CosignerKey: key,
-			SignState:   &shareSignState,
-			RsaKey:      key.RSAKey,
-			Peers:       peers,
-			Total:       uint8(total),
-			Threshold:   uint8(config.CosignerThreshold),
+		if !first && state.Height == last.Height && state.Round == last.Round && state.Step == last.Step &&
+			bytes.Equal(state.SignBytes, last.SignBytes) {
+			continue
 		}
 
-		localCosigner := internalSigner.NewLocalCosigner(localCosignerConfig)
-
-		val := internalSigner.NewThresholdValidator(&internalSigner.ThresholdValidatorOpt{
-			Pubkey:    key.PubKey,
-			Threshold: config.CosignerThreshold,
-			SignState: signState,
-			Cosigner:  localCosigner,
-			Peers:     cosigners,
-		})
-
-		rpcServerConfig := internalSigner.CosignerRpcServerConfig{
-			Logger:        logger,
-			ListenAddress: config.ListenAddress,
-			Cosigner:      localCosigner,
-			Peers:         remoteCosigners,
+		fmt.Printf("%s height %d round %d step %d\n", time.Now().UTC().Format(time.RFC3339), state.Height, state.Round, state.Step)
+		if !first {
+			for _, anomaly := range internalSigner.SignStateAnomalies(last, state) {
+				fmt.Printf("%s ANOMALY %s\n", time.Now().UTC().Format(time.RFC3339), anomaly)
+			}
 		}
+		last = state
+		first = false
+	}
+}
 
-		rpcServer := internalSigner.NewCosignerRpcServer(&rpcServerConfig)
-		rpcServer.Start()
-		services = append(services, rpcServer)
+// benchQuorum measures the signing latency of the cosigners of a config, see BenchQuorum
+func benchQuorum(args []string) {
+	flags := flag.NewFlagSet("bench-quorum", flag.ExitOnError)
+	configFile := flags.String("config", "", "path to the configuration file or directory of the signer to bench from")
+	count := flags.Int("count", 100, "the number of rounds")
+	timeout := flags.Duration("timeout", 2*time.Second, "how long a round waits for the cosigners")
+	flags.Parse(args)
 
-		pv = &internalSigner.PvGuard{PrivValidator: val}
-	} else {
-		log.Fatalf("Unsupported mode: %s", config.Mode)
+	if *configFile == "" || *count < 1 {
+		log.Fatal("usage: signer bench-quorum --config <config> [--count 100] [--timeout 2s]")
 	}
 
-	pubkey, err := pv.GetPubKey()
+	config, _, err := loadConfig(*configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-	logger.Info("Signer", "pubkey", pubkey)
-
-	for _, node := range config.Nodes {
-		dialer := net.Dialer{Timeout: 30 * time.Second}
-		signer := internalSigner.NewReconnRemoteSigner(node.Address, logger, config.ChainID, pv, dialer)
+	if err := config.NormalizeAddresses(); err != nil {
+		log.Fatal(err)
+	}
+	if len(config.Cosigners) == 0 {
+		log.Fatal("the config has no [[cosigner]] to bench")
+	}
 
-		err := signer.Start()
-		if err != nil {
-			panic(err)
+	cosigners := make([]*internalSigner.RemoteCosigner, 0, len(config.Cosigners))
+	for _, cosignerConfig := range config.Cosigners {
+		cosigner := internalSigner.NewRemoteCosigner(cosignerConfig.ID, cosignerConfig.Address)
+		if config.CosignerTransport != "" {
+			if err := cosigner.SetTransport(config.CosignerTransport); err != nil {
+				log.Fatal(err)
+			}
 		}
+		cosigners = append(cosigners, cosigner)
+	}
 
-		services = append(services, signer)
+	// the local share counts towards the threshold
+	needed := config.CosignerThreshold
+	if config.HasLocalShare() {
+		needed--
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	tmOS.TrapSignal(logger, func() {
-		for _, service := range services {
-			err := service.Stop()
-			if err != nil {
-				panic(err)
-			}
-		}
-		wg.Done()
-	})
-	wg.Wait()
+	fmt.Printf("Running %d sign rounds on throwaway keys on %d cosigners, waiting for %d of them each time.\n", *count, len(cosigners), needed)
+	if needed == 0 {
+		fmt.Println("The local share alone reaches the threshold, no cosigner is waited for.")
+	}
+	bench := internalSigner.BenchQuorum(context.Background(), cosigners, needed, *count, *timeout)
+
+	format := func(latency internalSigner.LatencyPercentiles) string {
+		return fmt.Sprintf("p50 %v  p95 %v  p99 %v  max %v", latency.P50, latency.P95, latency.P99, latency.Max)
+	}
+	for _, cosigner := range bench.Cosigners {
+		fmt.Printf("cosigner %d: %s  failed %d/%d\n", cosigner.ID, format(cosigner.Latency), cosigner.Failures, bench.Rounds)
+	}
+	fmt.Printf("quorum:     %s  failed %d/%d\n", format(bench.Quorum), bench.QuorumFailures, bench.Rounds)
 }
@@ -4,10 +4,18 @@ go 1.15
 
 require (
 	github.com/BurntSushi/toml v0.3.1
+	github.com/btcsuite/btcutil v1.0.2
+	github.com/go-kit/kit v0.10.0
 	github.com/gogo/protobuf v1.3.2
+	github.com/prometheus/client_golang v1.8.0
 	github.com/stretchr/testify v1.7.0
 	github.com/tendermint/go-amino v0.16.0
 	github.com/tendermint/tendermint v0.34.3
 	gitlab.com/polychainlabs/edwards25519 v0.0.0-20200206000358-2272e01758fb
 	gitlab.com/polychainlabs/threshold-ed25519 v0.0.0-20200221030822-1c35a36a51c1
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
 )
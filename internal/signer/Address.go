@@ -0,0 +1,70 @@
+package signer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultAddressScheme is the scheme of an address given without one, e.g. 1.2.3.4:1234
+const DefaultAddressScheme = "tcp"
+
+// normalizeAddress checks address is a scheme://host:port or unix:///path address with one of schemes,
+// and returns it with the default tcp:// scheme added if it has none.
+// A listen address may leave out the host, to listen on all interfaces, and may use port 0.
+func normalizeAddress(address string, listen bool, schemes ...string) (string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", fmt.Errorf("address is empty")
+	}
+	if !strings.Contains(address, "://") {
+		address = DefaultAddressScheme + "://" + address
+	}
+
+	// the zone of a link-local IPv6 address, e.g. [fe80::1%eth0], is escaped for url.Parse only
+	u, err := url.Parse(escapeIPv6Zone(address))
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	address = scheme + address[len(u.Scheme):]
+
+	supported := false
+	for _, s := range schemes {
+		supported = supported || s == scheme
+	}
+	if !supported {
+		return "", fmt.Errorf("address %s has scheme %s, expected one of %s", address, u.Scheme, strings.Join(schemes, ", "))
+	}
+
+	switch scheme {
+	case "unix":
+		if u.Host+u.Path == "" {
+			return "", fmt.Errorf("address %s has no socket path", address)
+		}
+		return address, nil
+	case "srv":
+		if u.Host == "" || u.Port() != "" {
+			return "", fmt.Errorf("address %s must be srv://<name> without a port", address)
+		}
+		return address, nil
+	}
+
+	if u.Path != "" && u.Path != "/" {
+		return "", fmt.Errorf("address %s must not have a path", address)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return "", fmt.Errorf("address %s must be %s://<host>:<port>: %w", address, scheme, err)
+	}
+	if host == "" && !listen {
+		return "", fmt.Errorf("address %s has no host", address)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 0 || portNumber > 65535 || (portNumber == 0 && !listen) {
+		return "", fmt.Errorf("address %s has an invalid port %q", address, port)
+	}
+	return address, nil
+}
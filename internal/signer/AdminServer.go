@@ -0,0 +1,397 @@
+package signer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/libs/service"
+)
+
+// AdminStatus is the response of the signing state endpoints
+type AdminStatus struct {
+	Active bool `json:"active"`
+
+	// set by /pause once the sign states were written and the process can be stopped
+	SafeToStop bool `json:"safe_to_stop,omitempty"`
+}
+
+// AdminServer serves runtime controls of the signer over http
+//
+//	GET  /status   reports whether the signer is active
+//	POST /active   signs normally
+//	POST /standby  stays connected to the nodes but refuses to sign
+//	POST /resync   advances the share watermark to the highest of the peers, in mpc mode only
+//	POST /pause    switches to standby once the sign request in progress completed and writes the sign states
+//	POST /resume   signs normally again, same as /active
+//	POST /handoff  takes over from the active signer: signs above the watermark posted as json only, see HandOff
+//	GET  /ready    200 once a node is connected and every node is on our chain, 503 otherwise
+//	GET  /health   healthy, degraded or unhealthy as json, 503 if unhealthy, see Health
+//	GET  /events   streams the outcome of every sign request as server-sent events, see SignEvent
+//	GET  /pubkey   the validator public key in hex, base64 and bech32, and its consensus address
+//	POST /faults   sets the faults injected into the responses of a remote cosigner, in faultinjection builds only
+//
+// There is no authentication, listen on a loopback or otherwise protected address only.
+type AdminServer struct {
+	service.BaseService
+
+	listenAddress string
+	dualStack     bool
+	listener      net.Listener
+	server        *http.Server
+
+	guard *PvGuard
+
+	// optional, serves /resync
+	resync func(ctx context.Context) (SignStateResync, error)
+
+	// optional, serves /ready
+	ready func() error
+
+	// optional, serves /health
+	health func() Health
+
+	// optional, serves /pause
+	pause func() error
+
+	// optional, serves /faults
+	setFaults func(faults FaultConfig) error
+
+	// optional, serves /pubkey
+	pubKey func() (PubKeyEncodings, error)
+
+	// optional, serves /events
+	events *SignEvents
+}
+
+// time allowed to query the peers on /resync
+const adminResyncTimeout = 10 * time.Second
+
+// interval of the comments keeping an /events stream without sign requests open through proxies
+const adminEventsKeepAlive = 15 * time.Second
+
+// NewAdminServer returns an AdminServer switching guard, listening on listenAddress once started
+func NewAdminServer(listenAddress string, guard *PvGuard, logger log.Logger) *AdminServer {
+	adminServer := &AdminServer{
+		listenAddress: listenAddress,
+		guard:         guard,
+	}
+
+	adminServer.BaseService = *service.NewBaseService(logger, "AdminServer", adminServer)
+	return adminServer
+}
+
+// SetDualStack listens on IPv4 and IPv6 separately for an address on all interfaces. Must be called before Start.
+func (adminServer *AdminServer) SetDualStack(dualStack bool) {
+	adminServer.dualStack = dualStack
+}
+
+// SetResync serves /resync with resync. Must be called before Start.
+func (adminServer *AdminServer) SetResync(resync func(ctx context.Context) (SignStateResync, error)) {
+	adminServer.resync = resync
+}
+
+// SetReadiness serves /ready with ready, which returns why the signer is not ready. Must be called before Start.
+func (adminServer *AdminServer) SetReadiness(ready func() error) {
+	adminServer.ready = ready
+}
+
+// SetHealth serves /health with health. Must be called before Start.
+func (adminServer *AdminServer) SetHealth(health func() Health) {
+	adminServer.health = health
+}
+
+// SetPause serves /pause with pause, which stops signing and writes the sign states. Must be called before Start.
+func (adminServer *AdminServer) SetPause(pause func() error) {
+	adminServer.pause = pause
+}
+
+// SetFaultInjection serves /faults with setFaults, for chaos testing only. Must be called before Start.
+func (adminServer *AdminServer) SetFaultInjection(setFaults func(faults FaultConfig) error) {
+	adminServer.setFaults = setFaults
+}
+
+// SetPubKey serves /pubkey with pubKey. Must be called before Start.
+func (adminServer *AdminServer) SetPubKey(pubKey func() (PubKeyEncodings, error)) {
+	adminServer.pubKey = pubKey
+}
+
+// SetEvents serves /events with the subscriptions of events. Must be called before Start.
+func (adminServer *AdminServer) SetEvents(events *SignEvents) {
+	adminServer.events = events
+}
+
+// OnStart starts serving the admin endpoints
+func (adminServer *AdminServer) OnStart() error {
+	lis, err := listen(adminServer.listenAddress, adminServer.dualStack, adminServer.Logger)
+	if err != nil {
+		return err
+	}
+	adminServer.listener = lis
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", adminServer.handleStatus)
+	mux.HandleFunc("/active", adminServer.handleSetActive(true))
+	mux.HandleFunc("/standby", adminServer.handleSetActive(false))
+	mux.HandleFunc("/handoff", adminServer.handleHandoff)
+	if adminServer.resync != nil {
+		mux.HandleFunc("/resync", adminServer.handleResync)
+	}
+	if adminServer.ready != nil {
+		mux.HandleFunc("/ready", adminServer.handleReady)
+	}
+	if adminServer.health != nil {
+		mux.HandleFunc("/health", adminServer.handleHealth)
+	}
+	if adminServer.pause != nil {
+		mux.HandleFunc("/pause", adminServer.handlePause)
+		mux.HandleFunc("/resume", adminServer.handleSetActive(true))
+	}
+	if adminServer.setFaults != nil {
+		mux.HandleFunc("/faults", adminServer.handleFaults)
+	}
+	if adminServer.pubKey != nil {
+		mux.HandleFunc("/pubkey", adminServer.handlePubKey)
+	}
+	if adminServer.events != nil {
+		mux.HandleFunc("/events", adminServer.handleEvents)
+	}
+	adminServer.server = &http.Server{Handler: mux}
+
+	go func() {
+		err := adminServer.server.Serve(lis)
+		if err != nil && err != http.ErrServerClosed {
+			adminServer.Logger.Error("Admin server", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// OnStop closes the listener
+func (adminServer *AdminServer) OnStop() {
+	if err := adminServer.server.Close(); err != nil {
+		adminServer.Logger.Error("Close", "err", err)
+	}
+}
+
+func (adminServer *AdminServer) Addr() net.Addr {
+	if adminServer.listener == nil {
+		return nil
+	}
+	return adminServer.listener.Addr()
+}
+
+func (adminServer *AdminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adminServer.writeStatus(w)
+}
+
+func (adminServer *AdminServer) handleSetActive(active bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if adminServer.guard.IsActive() != active {
+			adminServer.guard.SetActive(active)
+			adminServer.Logger.Info("Signing state changed", "active", active, "remote", r.RemoteAddr)
+		}
+		adminServer.writeStatus(w)
+	}
+}
+
+func (adminServer *AdminServer) handleResync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), adminResyncTimeout)
+	defer cancel()
+
+	adminServer.Logger.Info("Resyncing the share watermark with the peers", "remote", r.RemoteAddr)
+	result, err := adminServer.resync(ctx)
+	if err != nil {
+		adminServer.Logger.Error("Resync", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		adminServer.Logger.Error("Admin response", "err", err)
+	}
+}
+
+func (adminServer *AdminServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adminServer.Logger.Info("Pausing, waiting for the sign request in progress", "remote", r.RemoteAddr)
+	if err := adminServer.pause(); err != nil {
+		adminServer.Logger.Error("Pause", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	adminServer.Logger.Info("Paused, the sign states are written and the signer can be stopped")
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(AdminStatus{Active: adminServer.guard.IsActive(), SafeToStop: true})
+	if err != nil {
+		adminServer.Logger.Error("Admin response", "err", err)
+	}
+}
+
+func (adminServer *AdminServer) handleHandoff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var handoff Handoff
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1024)).Decode(&handoff); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	watermark := adminServer.guard.TakeOver(handoff.HRS())
+	adminServer.Logger.Info("Took over from the active signer, signing above its watermark only",
+		"height", watermark.Height, "round", watermark.Round, "step", watermark.Step, "remote", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(Handoff{Height: watermark.Height, Round: watermark.Round, Step: watermark.Step})
+	if err != nil {
+		adminServer.Logger.Error("Admin response", "err", err)
+	}
+}
+
+func (adminServer *AdminServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe, err := adminServer.events.Subscribe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(adminEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				adminServer.Logger.Error("Sign event", "err", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (adminServer *AdminServer) handleFaults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var faults FaultConfig
+	if err := json.NewDecoder(r.Body).Decode(&faults); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := adminServer.setFaults(faults); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(faults); err != nil {
+		adminServer.Logger.Error("Admin response", "err", err)
+	}
+}
+
+func (adminServer *AdminServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := adminServer.ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
+
+func (adminServer *AdminServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	health := adminServer.health()
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status == HealthUnhealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		adminServer.Logger.Error("Admin response", "err", err)
+	}
+}
+
+func (adminServer *AdminServer) handlePubKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	encodings, err := adminServer.pubKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(encodings); err != nil {
+		adminServer.Logger.Error("Admin response", "err", err)
+	}
+}
+
+func (adminServer *AdminServer) writeStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(AdminStatus{Active: adminServer.guard.IsActive()})
+	if err != nil {
+		adminServer.Logger.Error("Admin response", "err", err)
+	}
+}
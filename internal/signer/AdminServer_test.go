@@ -0,0 +1,248 @@
+package signer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func TestAdminServerStandby(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	guard := &PvGuard{PrivValidator: tm.NewMockPV()}
+
+	adminServer := NewAdminServer("tcp://127.0.0.1:0", guard, logger)
+	require.NoError(test, adminServer.Start())
+	defer adminServer.Stop()
+
+	url := "http://" + adminServer.Addr().String()
+	status := func(resp *http.Response, err error) bool {
+		require.NoError(test, err)
+		defer resp.Body.Close()
+		require.Equal(test, http.StatusOK, resp.StatusCode)
+
+		var adminStatus AdminStatus
+		require.NoError(test, json.NewDecoder(resp.Body).Decode(&adminStatus))
+		return adminStatus.Active
+	}
+
+	require.True(test, status(http.Get(url+"/status")))
+
+	require.False(test, status(http.Post(url+"/standby", "", nil)))
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1}
+	require.Equal(test, ErrStandby, guard.SignVote("chain-id", &vote))
+	require.False(test, status(http.Get(url+"/status")))
+
+	require.True(test, status(http.Post(url+"/active", "", nil)))
+	require.NoError(test, guard.SignVote("chain-id", &vote))
+
+	resp, err := http.Get(url + "/standby")
+	require.NoError(test, err)
+	resp.Body.Close()
+	require.Equal(test, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestAdminServerPause(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	guard := &PvGuard{PrivValidator: tm.NewMockPV()}
+
+	flushed := make(chan struct{}, 1)
+	adminServer := NewAdminServer("tcp://127.0.0.1:0", guard, logger)
+	adminServer.SetPause(func() error {
+		return guard.Pause(func() error {
+			flushed <- struct{}{}
+			return nil
+		})
+	})
+	require.NoError(test, adminServer.Start())
+	defer adminServer.Stop()
+
+	// a sign request in progress
+	guard.pvMutex.Lock()
+
+	url := "http://" + adminServer.Addr().String()
+	paused := make(chan AdminStatus)
+	go func() {
+		resp, err := http.Post(url+"/pause", "", nil)
+		require.NoError(test, err)
+		defer resp.Body.Close()
+		require.Equal(test, http.StatusOK, resp.StatusCode)
+
+		var adminStatus AdminStatus
+		require.NoError(test, json.NewDecoder(resp.Body).Decode(&adminStatus))
+		paused <- adminStatus
+	}()
+
+	// new requests are refused right away, the pause completes with the request in progress
+	require.Eventually(test, func() bool { return !guard.IsActive() }, time.Second, time.Millisecond)
+	select {
+	case <-paused:
+		test.Fatal("paused before the sign request in progress completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	guard.pvMutex.Unlock()
+
+	require.Equal(test, AdminStatus{Active: false, SafeToStop: true}, <-paused)
+	<-flushed
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1}
+	require.Equal(test, ErrStandby, guard.SignVote("chain-id", &vote))
+
+	resp, err := http.Post(url+"/resume", "", nil)
+	require.NoError(test, err)
+	resp.Body.Close()
+	require.True(test, guard.IsActive())
+	require.NoError(test, guard.SignVote("chain-id", &vote))
+}
+
+func TestAdminServerDualStack(test *testing.T) {
+	if lis, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		test.Skip("IPv6 is not available:", err)
+	} else {
+		lis.Close()
+	}
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	guard := &PvGuard{PrivValidator: tm.NewMockPV()}
+
+	adminServer := NewAdminServer("tcp://:0", guard, logger)
+	adminServer.SetDualStack(true)
+	require.NoError(test, adminServer.Start())
+	defer adminServer.Stop()
+
+	port := strconv.Itoa(adminServer.Addr().(*net.TCPAddr).Port)
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		resp, err := http.Get("http://" + net.JoinHostPort(host, port) + "/status")
+		require.NoError(test, err, host)
+		resp.Body.Close()
+		require.Equal(test, http.StatusOK, resp.StatusCode, host)
+	}
+}
+
+func TestAdminServerPubKey(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	privVal := tm.NewMockPV()
+	guard := &PvGuard{PrivValidator: privVal}
+
+	adminServer := NewAdminServer("tcp://127.0.0.1:0", guard, logger)
+	adminServer.SetPubKey(func() (PubKeyEncodings, error) {
+		return NewPubKeyEncodings(privVal.PrivKey.PubKey(), DefaultConsensusAddressPrefix)
+	})
+	require.NoError(test, adminServer.Start())
+	defer adminServer.Stop()
+
+	resp, err := http.Get("http://" + adminServer.Addr().String() + "/pubkey")
+	require.NoError(test, err)
+	defer resp.Body.Close()
+	require.Equal(test, http.StatusOK, resp.StatusCode)
+
+	var encodings PubKeyEncodings
+	require.NoError(test, json.NewDecoder(resp.Body).Decode(&encodings))
+	require.Equal(test, privVal.PrivKey.PubKey().Address().String(), encodings.Address)
+	require.NotEmpty(test, encodings.Bech32)
+}
+
+func TestAdminServerHandoff(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	guard := &PvGuard{PrivValidator: tm.NewMockPV()}
+	guard.SetActive(false)
+
+	adminServer := NewAdminServer("tcp://127.0.0.1:0", guard, logger)
+	require.NoError(test, adminServer.Start())
+	defer adminServer.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	url := "http://" + adminServer.Addr().String()
+	watermark := HRSKey{Height: 10, Round: 1, Step: stepPrevote}
+	ack, err := HandOff(ctx, url, watermark)
+	require.NoError(test, err)
+	require.Equal(test, Handoff{Height: 10, Round: 1, Step: stepPrevote}, ack)
+	require.True(test, guard.IsActive())
+
+	// nothing at or below the watermark is signed
+	prevote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 10, Round: 1}
+	require.Equal(test, ErrBelowHandoff, guard.SignVote("chain-id", &prevote))
+	proposal := tmProto.Proposal{Type: tmProto.ProposalType, Height: 10, Round: 1}
+	require.Equal(test, ErrBelowHandoff, guard.SignProposal("chain-id", &proposal))
+	precommit := tmProto.Vote{Type: tmProto.PrecommitType, Height: 10, Round: 1}
+	require.NoError(test, guard.SignVote("chain-id", &precommit))
+
+	// a lower watermark leaves it unchanged and is acknowledged with the higher one
+	ack, err = HandOff(ctx, url, HRSKey{Height: 9})
+	require.NoError(test, err)
+	require.Equal(test, int64(10), ack.Height)
+
+	// an acknowledgement below the watermark handed over is retried until the timeout
+	behind := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"height":9}`))
+	}))
+	defer behind.Close()
+	_, err = HandOff(ctx, behind.URL, watermark)
+	require.Error(test, err)
+}
+
+func TestAdminServerEvents(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	guard := &PvGuard{PrivValidator: tm.NewMockPV(), Events: NewSignEvents(1)}
+
+	adminServer := NewAdminServer("tcp://127.0.0.1:0", guard, logger)
+	adminServer.SetEvents(guard.Events)
+	require.NoError(test, adminServer.Start())
+	defer adminServer.Stop()
+
+	url := "http://" + adminServer.Addr().String()
+	resp, err := http.Get(url + "/events")
+	require.NoError(test, err)
+	defer resp.Body.Close()
+	require.Equal(test, http.StatusOK, resp.StatusCode)
+	require.Equal(test, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// over the cap
+	second, err := http.Get(url + "/events")
+	require.NoError(test, err)
+	second.Body.Close()
+	require.Equal(test, http.StatusServiceUnavailable, second.StatusCode)
+
+	vote := tmProto.Vote{Type: tmProto.PrecommitType, Height: 5, Round: 1}
+	require.NoError(test, guard.SignVote("chain-id", &vote))
+	guard.SetActive(false)
+	require.Equal(test, ErrStandby, guard.SignVote("chain-id", &vote))
+
+	reader := bufio.NewReader(resp.Body)
+	next := func() SignEvent {
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(test, err)
+			if strings.HasPrefix(line, "data: ") {
+				var event SignEvent
+				require.NoError(test, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+				return event
+			}
+		}
+	}
+
+	signed := next()
+	require.True(test, signed.Success)
+	require.Equal(test, "chain-id", signed.ChainID)
+	require.Equal(test, "precommit", signed.Type)
+	require.Equal(test, int64(5), signed.Height)
+	require.Equal(test, int64(1), signed.Round)
+
+	refused := next()
+	require.False(test, refused.Success)
+	require.Equal(test, ErrStandby.Error(), refused.Error)
+	require.Zero(test, refused.LatencyMs)
+}
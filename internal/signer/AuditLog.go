@@ -0,0 +1,152 @@
+package signer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	tmBytes "github.com/tendermint/tendermint/libs/bytes"
+)
+
+// DefaultAuditLogMaxMB is the default audit_log_max_mb
+const DefaultAuditLogMaxMB = 100
+
+// Kinds of AuditEntry
+const (
+	// AuditEventSignature is a signature produced by the ThresholdValidator
+	AuditEventSignature = "signature"
+
+	// AuditEventEphemeralCommitment is the public key of an ephemeral secret of a cosigner for an HRS,
+	// dealt by us or received from a peer. The secret itself and its shares are never logged.
+	AuditEventEphemeralCommitment = "ephemeral_commitment"
+)
+
+// AuditEntry is a line of the audit log, see the AuditEvent constants
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	ChainID string    `json:"chain_id,omitempty"`
+	Height  int64     `json:"height"`
+	Round   int64     `json:"round"`
+	Step    string    `json:"step"`
+
+	// the id of the node request, also in the log lines of the signer and the cosigners
+	RequestID string `json:"request_id,omitempty"`
+
+	// signature entries
+	BlockID   tmBytes.HexBytes `json:"block_id,omitempty"`
+	Cosigners []int            `json:"cosigners,omitempty"`
+
+	// ephemeral commitment entries
+	Cosigner        int              `json:"cosigner,omitempty"`
+	EphemeralPublic tmBytes.HexBytes `json:"ephemeral_public,omitempty"`
+}
+
+// AuditLog appends an AuditEntry as a json line for every signature, and optionally every ephemeral commitment
+// Once the file reaches maxBytes, it is renamed with a timestamp suffix and a new file is started.
+// Rotated files are never deleted.
+type AuditLog struct {
+	mtx      sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if needed
+func OpenAuditLog(path string, maxBytes int64) (*AuditLog, error) {
+	auditLog := &AuditLog{
+		path:     path,
+		maxBytes: maxBytes,
+	}
+	if err := auditLog.open(); err != nil {
+		return nil, err
+	}
+	return auditLog, nil
+}
+
+func (auditLog *AuditLog) open() error {
+	file, err := os.OpenFile(auditLog.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	auditLog.file = file
+	auditLog.size = info.Size()
+	return nil
+}
+
+// Write appends the entry and syncs it to disk
+func (auditLog *AuditLog) Write(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	auditLog.mtx.Lock()
+	defer auditLog.mtx.Unlock()
+
+	if auditLog.file == nil {
+		return fmt.Errorf("audit log %s is closed", auditLog.path)
+	}
+	if auditLog.maxBytes > 0 && auditLog.size > 0 && auditLog.size+int64(len(line)) > auditLog.maxBytes {
+		if err := auditLog.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := auditLog.file.Write(line)
+	auditLog.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return auditLog.file.Sync()
+}
+
+// rotate renames the current file and starts a new one
+func (auditLog *AuditLog) rotate() error {
+	if err := auditLog.file.Close(); err != nil {
+		return err
+	}
+	auditLog.file = nil
+
+	rotated := fmt.Sprintf("%s.%s", auditLog.path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(auditLog.path, rotated); err != nil {
+		return err
+	}
+	return auditLog.open()
+}
+
+// Close closes the file, later writes fail
+func (auditLog *AuditLog) Close() error {
+	auditLog.mtx.Lock()
+	defer auditLog.mtx.Unlock()
+
+	if auditLog.file == nil {
+		return nil
+	}
+	err := auditLog.file.Close()
+	auditLog.file = nil
+	return err
+}
+
+// stepName returns the name of a sign state step for the audit log
+func stepName(step int8) string {
+	switch step {
+	case stepPropose:
+		return "proposal"
+	case stepPrevote:
+		return "prevote"
+	case stepPrecommit:
+		return "precommit"
+	default:
+		return fmt.Sprintf("%d", step)
+	}
+}
@@ -0,0 +1,156 @@
+package signer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tmBytes "github.com/tendermint/tendermint/libs/bytes"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func readAuditEntries(test *testing.T, path string) []AuditEntry {
+	file, err := os.Open(path)
+	require.NoError(test, err)
+	defer file.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		require.NoError(test, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(test, scanner.Err())
+	return entries
+}
+
+func TestThresholdValidatorAuditLog(test *testing.T) {
+	validator, cosigner1, cosigner2, _ := newThresholdValidator2of2(test)
+
+	path := filepath.Join(test.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(path, 0)
+	require.NoError(test, err)
+	defer auditLog.Close()
+	validator.auditLog = auditLog
+
+	blockHash := bytes.Repeat([]byte{0xab}, 32)
+	proposal := tmProto.Proposal{
+		Type:     tmProto.ProposalType,
+		Height:   1,
+		Round:    0,
+		PolRound: -1,
+		BlockID: tmProto.BlockID{
+			Hash:          blockHash,
+			PartSetHeader: tmProto.PartSetHeader{Total: 1, Hash: blockHash},
+		},
+		Timestamp: time.Unix(1000, 0).UTC(),
+	}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, proposal.Height, int64(proposal.Round), stepPropose)
+	require.NoError(test, validator.SignProposal("chain-id", &proposal))
+
+	// a retry returns the cached signature and is not recorded again
+	require.NoError(test, validator.SignProposal("chain-id", &proposal))
+
+	entries := readAuditEntries(test, path)
+	require.Len(test, entries, 1)
+	require.Equal(test, AuditEventSignature, entries[0].Event)
+	require.Equal(test, "chain-id", entries[0].ChainID)
+	require.Equal(test, int64(1), entries[0].Height)
+	require.Equal(test, "proposal", entries[0].Step)
+	require.Equal(test, tmBytes.HexBytes(blockHash), entries[0].BlockID)
+	require.Equal(test, []int{1, 2}, entries[0].Cosigners)
+}
+
+func TestLocalCosignerAuditCommitments(test *testing.T) {
+	_, cosigner1, cosigner2, _ := newThresholdValidator2of2(test)
+
+	path := filepath.Join(test.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(path, 0)
+	require.NoError(test, err)
+	defer auditLog.Close()
+	cosigner2.(*LocalCosigner).auditLog = auditLog
+
+	// cosigner 2 receives the commitment of cosigner 1, dealing its own
+	exchangeEphemeralPart(test, cosigner1, cosigner2, 1, 0, stepPrevote)
+
+	// a second request for the same HRS reuses the dealt secret
+	part, err := cosigner2.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
+		ID:     1,
+		Height: 1,
+		Step:   stepPrevote,
+	})
+	require.NoError(test, err)
+
+	entries := readAuditEntries(test, path)
+	require.Len(test, entries, 2)
+	for _, entry := range entries {
+		require.Equal(test, AuditEventEphemeralCommitment, entry.Event)
+		require.Equal(test, int64(1), entry.Height)
+		require.Equal(test, "prevote", entry.Step)
+	}
+	require.Equal(test, 2, entries[0].Cosigner)
+	require.Equal(test, tmBytes.HexBytes(part.SourceEphemeralSecretPublicKey), entries[0].EphemeralPublic)
+	require.Equal(test, 1, entries[1].Cosigner)
+
+	// neither the ephemeral secret nor any of its shares are written
+	content, err := ioutil.ReadFile(path)
+	require.NoError(test, err)
+	meta := cosigner2.(*LocalCosigner).hrsMeta[HRSKey{Height: 1, Step: stepPrevote}]
+	require.NotContains(test, string(content), tmBytes.HexBytes(meta.Secret).String())
+	for _, share := range meta.DealtShares {
+		require.NotContains(test, string(content), tmBytes.HexBytes(share).String())
+	}
+}
+
+func TestAuditLogRequestID(test *testing.T) {
+	_, _, cosigner2, _ := newThresholdValidator2of2(test)
+
+	path := filepath.Join(test.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(path, 0)
+	require.NoError(test, err)
+	defer auditLog.Close()
+	cosigner2.(*LocalCosigner).auditLog = auditLog
+
+	ctx := withRequestID(context.Background(), newRequestID())
+	_, err = cosigner2.GetEphemeralSecretPart(ctx, CosignerGetEphemeralSecretPartRequest{
+		ID:     1,
+		Height: 1,
+		Step:   stepPrevote,
+	})
+	require.NoError(test, err)
+
+	entries := readAuditEntries(test, path)
+	require.Len(test, entries, 1)
+	require.Len(test, entries[0].RequestID, 16)
+	require.Equal(test, requestID(ctx), entries[0].RequestID)
+}
+
+func TestAuditLogRotate(test *testing.T) {
+	dir := test.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	auditLog, err := OpenAuditLog(path, 150)
+	require.NoError(test, err)
+	defer auditLog.Close()
+
+	for height := int64(1); height <= 3; height++ {
+		require.NoError(test, auditLog.Write(AuditEntry{ChainID: "chain-id", Height: height, Step: "prevote"}))
+	}
+
+	// each entry is over half the limit, so every write after the first rotates
+	files, err := filepath.Glob(path + ".*")
+	require.NoError(test, err)
+	require.Len(test, files, 2)
+
+	entries := readAuditEntries(test, path)
+	require.Len(test, entries, 1)
+	require.Equal(test, int64(3), entries[0].Height)
+}
@@ -0,0 +1,66 @@
+package signer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrColdStart is returned for signing requests during the cold start delay
+var ErrColdStart = errors.New("signer is observing the chain height after starting, refusing to sign")
+
+// ColdStart holds back signing for a delay after the signer starts. During the delay every request is refused,
+// and the highest height requested by any node is recorded. Afterwards, requests below that height are refused
+// until one at or above it is signed, so a node feeding a stale height right after a restart is not followed.
+//
+// A node requesting a height far ahead of the chain during the delay stalls signing until the chain gets there,
+// so the delay is best combined with authorized_node_keys.
+//
+// ColdStart is thread safe
+type ColdStart struct {
+	mtx sync.Mutex
+
+	until     time.Time
+	maxHeight int64
+	// true once a request at or above maxHeight was allowed after the delay
+	done bool
+
+	now func() time.Time
+}
+
+// NewColdStart returns a ColdStart refusing to sign for delay from now
+func NewColdStart(delay time.Duration) *ColdStart {
+	return &ColdStart{
+		until: time.Now().Add(delay),
+		now:   time.Now,
+	}
+}
+
+// Check records the height of a signing request and returns an error if it must be refused
+func (coldStart *ColdStart) Check(height int64) error {
+	coldStart.mtx.Lock()
+	defer coldStart.mtx.Unlock()
+
+	if coldStart.done {
+		return nil
+	}
+	if coldStart.now().Before(coldStart.until) {
+		if height > coldStart.maxHeight {
+			coldStart.maxHeight = height
+		}
+		return ErrColdStart
+	}
+	if height < coldStart.maxHeight {
+		return fmt.Errorf("%w: height %d is below height %d requested during the cold start delay", ErrColdStart, height, coldStart.maxHeight)
+	}
+	coldStart.done = true
+	return nil
+}
+
+// MaxHeight returns the highest height requested during the delay
+func (coldStart *ColdStart) MaxHeight() int64 {
+	coldStart.mtx.Lock()
+	defer coldStart.mtx.Unlock()
+	return coldStart.maxHeight
+}
@@ -0,0 +1,30 @@
+package signer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestColdStart(test *testing.T) {
+	coldStart := NewColdStart(time.Minute)
+	now := coldStart.until.Add(-time.Minute)
+	coldStart.now = func() time.Time { return now }
+
+	// everything is refused during the delay, the heights are recorded
+	require.Equal(test, ErrColdStart, coldStart.Check(10))
+	require.Equal(test, ErrColdStart, coldStart.Check(12))
+	require.Equal(test, ErrColdStart, coldStart.Check(11))
+	require.Equal(test, int64(12), coldStart.MaxHeight())
+
+	// a stale height is refused after the delay
+	now = now.Add(time.Minute)
+	err := coldStart.Check(11)
+	require.True(test, errors.Is(err, ErrColdStart))
+
+	// until the current height is reached, then signing is back to normal
+	require.NoError(test, coldStart.Check(12))
+	require.NoError(test, coldStart.Check(11))
+}
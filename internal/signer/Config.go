@@ -1,13 +1,23 @@
 package signer
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 
 	"github.com/BurntSushi/toml"
 )
 
 type NodeConfig struct {
 	Address string `toml:"address"`
+
+	// optional local IP address or network interface name to dial the node from
+	SourceAddress string `toml:"source_address"`
+
+	// optional chain id of this node's requests, defaults to the global chain_id
+	ChainID string `toml:"chain_id"`
 }
 
 type CosignerConfig struct {
@@ -15,22 +25,87 @@ type CosignerConfig struct {
 	Address string `toml:"remote_address"`
 }
 
+// ShareConfig is another key share run by this process, as a cosigner of its own,
+// to run a whole quorum on one machine for testing
+type ShareConfig struct {
+	KeyFile       string `toml:"key_file"`
+	ListenAddress string `toml:"listen_address"`
+
+	// optional, defaults to <chain_id>_share_<id>_sign_state.json in state_dir
+	StateFile string `toml:"state_file"`
+}
+
 type Config struct {
 	Mode              string           `toml:"mode"`
 	PrivValKeyFile    string           `toml:"key_file"`
 	PrivValStateDir   string           `toml:"state_dir"`
+	StrictPermissions bool             `toml:"strict_permissions"`
+	Mlock             bool             `toml:"mlock"`
 	ChainID           string           `toml:"chain_id"`
 	CosignerThreshold int              `toml:"cosigner_threshold"`
+	ProposalThreshold int              `toml:"proposal_threshold"`
 	ListenAddress     string           `toml:"cosigner_listen_address"`
+	ListenAddresses   []string         `toml:"cosigner_extra_listen_addresses"`
+	DualStack         bool             `toml:"dual_stack"`
+	LocalShare        *bool            `toml:"local_share"`
+	ValidatorPubKey   string           `toml:"validator_pub_key"`
+	NextValidatorKey  string           `toml:"next_validator_pub_key"`
+	KeySwitchHeight   int64            `toml:"key_switch_height"`
+	CosignerTransport string           `toml:"cosigner_transport"`
+	ReconnectWaitMs   int              `toml:"cosigner_reconnect_wait_ms"`
+	AddressPrefix     string           `toml:"consensus_address_prefix"`
+	SignDeadlineMs    int              `toml:"sign_deadline_ms"`
+	MinSignInterval   int              `toml:"min_sign_interval_ms"`
+	FastStateDir      string           `toml:"fast_state_dir"`
+	EtcdAddress       string           `toml:"sign_state_etcd_address"`
+	EtcdKey           string           `toml:"sign_state_etcd_key"`
+	SaveRetries       int              `toml:"sign_state_save_retries"`
+	SaveFailure       string           `toml:"sign_state_save_failure"`
+	SlowSaveMs        int              `toml:"sign_state_slow_save_ms"`
+	RSAWorkers        int              `toml:"rsa_workers"`
+	RSAQueueLength    int              `toml:"rsa_queue_length"`
+	MaxSignsPerMinute int              `toml:"max_signatures_per_minute"`
+	ColdStartDelayMs  int              `toml:"cold_start_delay_ms"`
+	PreSignWebhook    string           `toml:"pre_sign_webhook"`
+	PreSignTimeoutMs  int              `toml:"pre_sign_webhook_timeout_ms"`
+	Standby           bool             `toml:"standby"`
+	SignProposals     *bool            `toml:"sign_proposals"`
+	SignPrevotes      *bool            `toml:"sign_prevotes"`
+	SignPrecommits    *bool            `toml:"sign_precommits"`
+	AdminAddress      string           `toml:"admin_listen_address"`
+	EventSubscribers  int              `toml:"admin_event_subscribers"`
+	HandoffAddress    string           `toml:"handoff_address"`
+	HandoffTimeout    int              `toml:"handoff_timeout"`
+	DegradedNotReady  bool             `toml:"degraded_not_ready"`
+	WatchdogTimeout   int              `toml:"node_watchdog_timeout"`
+	NodeStartJitterMs int              `toml:"node_start_jitter_ms"`
+	NodeDialTimeout   int              `toml:"node_dial_timeout"`
+	NodeKeepAlive     int              `toml:"node_keepalive"`
+	NodeProxy         string           `toml:"node_proxy"`
+	NodeReadTimeout   int              `toml:"node_read_timeout"`
+	NodeWriteTimeout  int              `toml:"node_write_timeout"`
+	EquivocationStop  bool             `toml:"node_disconnect_on_equivocation"`
+	NodeInsecure      bool             `toml:"node_insecure"`
+	NodeKeyFile       string           `toml:"node_key_file"`
+	AuthorizedNodes   []string         `toml:"authorized_node_keys"`
+	PrometheusAddress string           `toml:"prometheus_listen_address"`
+	StatsdAddress     string           `toml:"statsd_address"`
+	StatsdProtocol    string           `toml:"statsd_protocol"`
+	PprofAddress      string           `toml:"pprof_listen_address"`
+	OtelEndpoint      string           `toml:"otel_endpoint"`
+	LogLevel          string           `toml:"log_level"`
+	LogTimestamps     string           `toml:"log_timestamps"`
+	SummaryInterval   int              `toml:"log_summary_interval"`
+	AuditLogFile      string           `toml:"audit_log_file"`
+	AuditLogMaxMB     int              `toml:"audit_log_max_mb"`
+	AuditEphemeral    bool             `toml:"audit_log_ephemeral"`
 	Nodes             []NodeConfig     `toml:"node"`
 	Cosigners         []CosignerConfig `toml:"cosigner"`
+	ExtraShares       []ShareConfig    `toml:"extra_share"`
 }
 
 func LoadConfigFromFile(file string) (Config, error) {
-	var config Config
-
-	// default mode is mpc
-	config.Mode = "mpc"
+	config := defaultConfig()
 
 	reader, err := os.Open(file)
 	if err != nil {
@@ -39,3 +114,252 @@ func LoadConfigFromFile(file string) (Config, error) {
 	_, err = toml.DecodeReader(reader, &config)
 	return config, err
 }
+
+// LoadConfigFromDir merges the *.toml fragments of dir and its subdirectories, e.g. nodes.d/ and cosigners.d/,
+// in lexical order of their paths into one Config, so different tooling can own different sections.
+// The [[node]], [[cosigner]] and [[extra_share]] tables of all fragments are appended to one another.
+// Any other option set by several fragments takes the value of the last, each such override is returned in conflicts.
+func LoadConfigFromDir(dir string) (config Config, conflicts []string, err error) {
+	config = defaultConfig()
+
+	var fragments []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".toml" {
+			fragments = append(fragments, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return config, nil, err
+	}
+	if len(fragments) == 0 {
+		return config, nil, fmt.Errorf("no *.toml config fragment in %s", dir)
+	}
+	sort.Strings(fragments)
+
+	setBy := make(map[string]string)
+	for _, fragment := range fragments {
+		nodes, cosigners, extraShares := config.Nodes, config.Cosigners, config.ExtraShares
+		config.Nodes, config.Cosigners, config.ExtraShares = nil, nil, nil
+
+		meta, err := toml.DecodeFile(fragment, &config)
+		if err != nil {
+			return config, conflicts, fmt.Errorf("%s: %w", fragment, err)
+		}
+
+		config.Nodes = append(nodes, config.Nodes...)
+		config.Cosigners = append(cosigners, config.Cosigners...)
+		config.ExtraShares = append(extraShares, config.ExtraShares...)
+
+		for _, key := range meta.Keys() {
+			if len(key) != 1 {
+				continue
+			}
+			option := key[0]
+			switch option {
+			case "node", "cosigner", "extra_share":
+				continue
+			}
+			if previous, ok := setBy[option]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("%s in %s overrides %s", option, fragment, previous))
+			}
+			setBy[option] = fragment
+		}
+	}
+	return config, conflicts, nil
+}
+
+// defaultConfig returns the config with the defaults of the options not set
+func defaultConfig() Config {
+	var config Config
+
+	// default mode is mpc
+	config.Mode = "mpc"
+	config.AddressPrefix = DefaultConsensusAddressPrefix
+	config.MaxSignsPerMinute = DefaultMaxSignaturesPerMinute
+	config.PreSignTimeoutMs = DefaultPreSignTimeoutMs
+	config.HandoffTimeout = DefaultHandoffTimeoutSeconds
+	config.EventSubscribers = DefaultEventSubscribers
+	config.SlowSaveMs = DefaultSlowSaveMs
+	config.SaveRetries = DefaultSaveRetries
+	config.WatchdogTimeout = DefaultWatchdogTimeoutSeconds
+	config.NodeStartJitterMs = DefaultNodeStartJitterMs
+	config.NodeDialTimeout = DefaultNodeDialTimeoutSeconds
+	config.NodeWriteTimeout = DefaultNodeWriteTimeoutSeconds
+	config.AuditLogMaxMB = DefaultAuditLogMaxMB
+	config.RSAQueueLength = DefaultRSAQueueLength
+	config.LogLevel = DefaultLogLevel
+	return config
+}
+
+// Redacted returns a copy of the config with the passwords of the urls it holds, e.g. of node_proxy, masked.
+// The config holds the paths of the key files only, never their contents.
+func (config Config) Redacted() Config {
+	config.NodeProxy = redactURL(config.NodeProxy)
+	config.OtelEndpoint = redactURL(config.OtelEndpoint)
+	config.PreSignWebhook = redactURL(config.PreSignWebhook)
+	config.HandoffAddress = redactURL(config.HandoffAddress)
+
+	cosigners := make([]CosignerConfig, len(config.Cosigners))
+	for idx, cosigner := range config.Cosigners {
+		cosigner.Address = redactURL(cosigner.Address)
+		cosigners[idx] = cosigner
+	}
+	config.Cosigners = cosigners
+	return config
+}
+
+// redactURL masks the password of a url, leaving anything else as is
+func redactURL(address string) string {
+	u, err := url.Parse(address)
+	if err != nil || u.User == nil {
+		return address
+	}
+	return u.Redacted()
+}
+
+// HasLocalShare returns false if local_share is disabled, for a coordinator that holds no key share
+func (config *Config) HasLocalShare() bool {
+	return config.LocalShare == nil || *config.LocalShare
+}
+
+// SignsProposals returns false if sign_proposals is disabled
+func (config *Config) SignsProposals() bool {
+	return config.SignProposals == nil || *config.SignProposals
+}
+
+// SignsPrevotes returns false if sign_prevotes is disabled
+func (config *Config) SignsPrevotes() bool {
+	return config.SignPrevotes == nil || *config.SignPrevotes
+}
+
+// SignsPrecommits returns false if sign_precommits is disabled
+func (config *Config) SignsPrecommits() bool {
+	return config.SignPrecommits == nil || *config.SignPrecommits
+}
+
+// NormalizeAddresses checks the listen and dial addresses of the config, see normalizeAddress,
+// and adds the default tcp:// scheme to those given without one
+func (config *Config) NormalizeAddresses() error {
+	normalize := func(name string, address *string, listen bool, schemes ...string) error {
+		if *address == "" {
+			return nil
+		}
+		normalized, err := normalizeAddress(*address, listen, schemes...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*address = normalized
+		return nil
+	}
+
+	type listener struct {
+		name    string
+		address *string
+	}
+	listeners := []listener{
+		{"cosigner_listen_address", &config.ListenAddress},
+		{"admin_listen_address", &config.AdminAddress},
+		{"prometheus_listen_address", &config.PrometheusAddress},
+		{"pprof_listen_address", &config.PprofAddress},
+	}
+	for idx := range config.ListenAddresses {
+		listeners = append(listeners, listener{fmt.Sprintf("cosigner_extra_listen_addresses[%d]", idx), &config.ListenAddresses[idx]})
+	}
+	for idx := range config.ExtraShares {
+		listeners = append(listeners, listener{fmt.Sprintf("extra_share[%d].listen_address", idx), &config.ExtraShares[idx].ListenAddress})
+	}
+	for _, lis := range listeners {
+		if err := normalize(lis.name, lis.address, true, "tcp", "unix"); err != nil {
+			return err
+		}
+	}
+
+	for idx := range config.Nodes {
+		name := fmt.Sprintf("node[%d].address", idx)
+		if config.Nodes[idx].Address == "" {
+			return fmt.Errorf("%s is required", name)
+		}
+		if err := normalize(name, &config.Nodes[idx].Address, false, "tcp", "unix"); err != nil {
+			return err
+		}
+	}
+	for idx := range config.Cosigners {
+		name := fmt.Sprintf("cosigner[%d].remote_address", idx)
+		if config.Cosigners[idx].Address == "" {
+			return fmt.Errorf("%s is required", name)
+		}
+		err := normalize(name, &config.Cosigners[idx].Address, false, "tcp", "unix", "http", "https", "srv")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDuplicateAddresses refuses a node or cosigner address configured more than once,
+// which would open several connections to the same node or peer. Addresses are compared once normalized.
+func (config *Config) checkDuplicateAddresses() error {
+	nodes := make(map[string]int, len(config.Nodes))
+	for idx, node := range config.Nodes {
+		if first, ok := nodes[node.Address]; ok {
+			return fmt.Errorf("node[%d].address %s is the same as node[%d].address", idx, node.Address, first)
+		}
+		nodes[node.Address] = idx
+	}
+
+	cosigners := make(map[string]int, len(config.Cosigners))
+	for idx, cosigner := range config.Cosigners {
+		if first, ok := cosigners[cosigner.Address]; ok {
+			return fmt.Errorf("cosigner[%d].remote_address %s is the same as cosigner[%d].remote_address", idx, cosigner.Address, first)
+		}
+		cosigners[cosigner.Address] = idx
+	}
+	return nil
+}
+
+// Validate checks the addresses, none configured twice, and the cosigner ids of an mpc config: together with
+// localID, the id of our own key share, they must be exactly 1..N for N cosigners, without gaps or duplicates.
+// localID is 0 for a coordinator without a share.
+func (config *Config) Validate(localID int) error {
+	if err := config.NormalizeAddresses(); err != nil {
+		return err
+	}
+	if err := config.checkDuplicateAddresses(); err != nil {
+		return err
+	}
+
+	total := len(config.Cosigners)
+	seen := map[int]bool{}
+	if localID != 0 {
+		total++
+		if localID < 1 || localID > total {
+			return fmt.Errorf("our cosigner id %d is outside 1..%d for %d cosigners", localID, total, total)
+		}
+		seen[localID] = true
+	}
+
+	for _, cosigner := range config.Cosigners {
+		if cosigner.ID == localID {
+			return fmt.Errorf("cosigner %s has our own id %d", cosigner.Address, cosigner.ID)
+		}
+		if seen[cosigner.ID] {
+			return fmt.Errorf("cosigner id %d is configured more than once", cosigner.ID)
+		}
+		if cosigner.ID < 1 || cosigner.ID > total {
+			return fmt.Errorf("cosigner id %d is outside 1..%d for %d cosigners", cosigner.ID, total, total)
+		}
+		seen[cosigner.ID] = true
+	}
+
+	if config.CosignerThreshold > total {
+		return fmt.Errorf("cosigner_threshold %d is more than the %d cosigners", config.CosignerThreshold, total)
+	}
+	if config.ProposalThreshold != 0 && (config.ProposalThreshold < config.CosignerThreshold || config.ProposalThreshold > total) {
+		return fmt.Errorf("proposal_threshold %d is outside cosigner_threshold..%d for %d cosigners", config.ProposalThreshold, total, total)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package signer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/tendermint/tendermint/crypto"
+)
+
+// DefaultConsensusAddressPrefix is the bech32 prefix for cosmos-sdk consensus addresses
+const DefaultConsensusAddressPrefix = "cosmosvalcons"
+
+// ConsensusAddress returns the bech32 encoded consensus address of the validator public key
+// This is the address chains show for the validator, e.g. cosmosvalcons1...
+func ConsensusAddress(pubKey crypto.PubKey, prefix string) (string, error) {
+	converted, err := bech32.ConvertBits(pubKey.Address(), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return bech32.Encode(prefix, converted)
+}
+
+// amino prefixes of the public key types, for the legacy bech32 public key encoding
+var aminoPubKeyPrefixes = map[string][]byte{
+	"ed25519":   {0x16, 0x24, 0xde, 0x64, 0x20},
+	"secp256k1": {0xeb, 0x5a, 0xe9, 0x87, 0x21},
+}
+
+// PubKeyEncodings is the validator public key in the encodings tools ask for, served by /pubkey
+type PubKeyEncodings struct {
+	Type   string `json:"type"`
+	Hex    string `json:"hex"`
+	Base64 string `json:"base64"`
+
+	// the amino encoded public key with the consensus address prefix followed by "pub", e.g. cosmosvalconspub1...
+	Bech32 string `json:"bech32,omitempty"`
+
+	// the address as the node logs it, hex encoded, and as the chain shows it, bech32 encoded
+	Address          string `json:"address"`
+	ConsensusAddress string `json:"consensus_address"`
+}
+
+// NewPubKeyEncodings returns the encodings of the validator public key, with the bech32 prefix of consensus addresses
+func NewPubKeyEncodings(pubKey crypto.PubKey, prefix string) (PubKeyEncodings, error) {
+	consensusAddress, err := ConsensusAddress(pubKey, prefix)
+	if err != nil {
+		return PubKeyEncodings{}, err
+	}
+	encodings := PubKeyEncodings{
+		Type:             pubKey.Type(),
+		Hex:              strings.ToUpper(hex.EncodeToString(pubKey.Bytes())),
+		Base64:           base64.StdEncoding.EncodeToString(pubKey.Bytes()),
+		Address:          pubKey.Address().String(),
+		ConsensusAddress: consensusAddress,
+	}
+
+	if aminoPrefix, ok := aminoPubKeyPrefixes[pubKey.Type()]; ok {
+		converted, err := bech32.ConvertBits(append(append([]byte{}, aminoPrefix...), pubKey.Bytes()...), 8, 5, true)
+		if err != nil {
+			return PubKeyEncodings{}, err
+		}
+		encodings.Bech32, err = bech32.Encode(prefix+"pub", converted)
+		if err != nil {
+			return PubKeyEncodings{}, err
+		}
+	}
+	return encodings, nil
+}
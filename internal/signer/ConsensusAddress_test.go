@@ -0,0 +1,51 @@
+package signer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/stretchr/testify/require"
+	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
+)
+
+func TestConsensusAddress(test *testing.T) {
+	pubKey := tmCryptoEd25519.GenPrivKey().PubKey()
+
+	address, err := ConsensusAddress(pubKey, "cosmosvalcons")
+	require.NoError(test, err)
+
+	prefix, data, err := bech32.Decode(address)
+	require.NoError(test, err)
+	require.Equal(test, "cosmosvalcons", prefix)
+
+	decoded, err := bech32.ConvertBits(data, 5, 8, false)
+	require.NoError(test, err)
+	require.Equal(test, []byte(pubKey.Address()), decoded)
+}
+
+func TestPubKeyEncodings(test *testing.T) {
+	pubKey := tmCryptoEd25519.GenPrivKey().PubKey()
+
+	encodings, err := NewPubKeyEncodings(pubKey, "cosmosvalcons")
+	require.NoError(test, err)
+	require.Equal(test, "ed25519", encodings.Type)
+	require.Equal(test, strings.ToUpper(hex.EncodeToString(pubKey.Bytes())), encodings.Hex)
+	require.Equal(test, base64.StdEncoding.EncodeToString(pubKey.Bytes()), encodings.Base64)
+	require.Equal(test, pubKey.Address().String(), encodings.Address)
+
+	address, err := ConsensusAddress(pubKey, "cosmosvalcons")
+	require.NoError(test, err)
+	require.Equal(test, address, encodings.ConsensusAddress)
+
+	// the amino prefix of ed25519 keys encodes as zcjduepq
+	require.True(test, strings.HasPrefix(encodings.Bech32, "cosmosvalconspub1zcjduepq"), encodings.Bech32)
+	prefix, data, err := bech32.Decode(encodings.Bech32)
+	require.NoError(test, err)
+	require.Equal(test, "cosmosvalconspub", prefix)
+	decoded, err := bech32.ConvertBits(data, 5, 8, false)
+	require.NoError(test, err)
+	require.Equal(test, pubKey.Bytes(), decoded[5:])
+}
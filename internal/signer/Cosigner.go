@@ -1,6 +1,9 @@
 package signer
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // CosignerSignRequest is sent to a co-signer to obtain their signature for the SignBytes
 // The SignBytes should be a serialized block
@@ -52,6 +55,8 @@ type CosignerSetEphemeralSecretPartRequest struct {
 
 // Cosigner interface is a set of methods for an m-of-n threshold signature.
 // This interface abstracts the underlying key storage and management
+//
+// Implementations should stop waiting and return an error once the context is done
 type Cosigner interface {
 	// Get the ID of the cosigner
 	// The ID is the shamir index: 1, 2, etc...
@@ -59,14 +64,14 @@ type Cosigner interface {
 
 	// Get the ephemeral secret part for an ephemeral share
 	// The ephemeral secret part is encrypted for the receiver
-	GetEphemeralSecretPart(req CosignerGetEphemeralSecretPartRequest) (CosignerGetEphemeralSecretPartResponse, error)
+	GetEphemeralSecretPart(ctx context.Context, req CosignerGetEphemeralSecretPartRequest) (CosignerGetEphemeralSecretPartResponse, error)
 
 	// Store an ephemeral secret share part provided by another cosigner
-	SetEphemeralSecretPart(req CosignerSetEphemeralSecretPartRequest) error
+	SetEphemeralSecretPart(ctx context.Context, req CosignerSetEphemeralSecretPartRequest) error
 
 	// Query whether the cosigner has an ehpemeral secret part set
-	HasEphemeralSecretPart(req CosignerHasEphemeralSecretPartRequest) (CosignerHasEphemeralSecretPartResponse, error)
+	HasEphemeralSecretPart(ctx context.Context, req CosignerHasEphemeralSecretPartRequest) (CosignerHasEphemeralSecretPartResponse, error)
 
 	// Sign the requested bytes
-	Sign(req CosignerSignRequest) (CosignerSignResponse, error)
+	Sign(ctx context.Context, req CosignerSignRequest) (CosignerSignResponse, error)
 }
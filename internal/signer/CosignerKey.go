@@ -4,12 +4,16 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 
 	amino "github.com/tendermint/go-amino"
 	tmCrypto "github.com/tendermint/tendermint/crypto"
 	tmEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
 	tmCryptoEncoding "github.com/tendermint/tendermint/crypto/encoding"
+	"github.com/tendermint/tendermint/libs/tempfile"
 	tmProtoCrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
 )
 
@@ -20,17 +24,55 @@ type CosignerKey struct {
 	RSAKey       rsa.PrivateKey   `json:"rsa_key"`
 	ID           int              `json:"id"`
 	CosignerKeys []*rsa.PublicKey `json:"rsa_pubs"`
+
+	// RSAPubsVersion is incremented whenever the rsa keys in this file are rotated
+	RSAPubsVersion int `json:"rsa_pubs_version"`
+
+	// During an RSA key rotation, the replaced keys are kept until the rotation is finished
+	// so that peers which have not yet imported the new public key keep working.
+	// PreviousCosignerKeys is indexed like CosignerKeys, with nil for cosigners not rotating.
+	PreviousRSAKey       *rsa.PrivateKey  `json:"previous_rsa_key,omitempty"`
+	PreviousCosignerKeys []*rsa.PublicKey `json:"previous_rsa_pubs,omitempty"`
+
+	// For a chain switching validator keys at key_switch_height, the share of the key signing from
+	// that height on. It is dealt to the same cosigner ids with the same threshold as ShareKey.
+	NextPubKey   tmCrypto.PubKey `json:"next_pub_key,omitempty"`
+	NextShareKey []byte          `json:"next_secret_share,omitempty"`
+}
+
+// CosignerKeyFormatVersion is the version of the key file format written by SaveCosignerKey
+//
+// Version 2 stores the rsa public keys of each cosigner together, the current key and the key
+// replaced by a rotation in progress. Version 1 and earlier files, storing the current keys and
+// the replaced keys in two separate arrays, are still read, and are migrated when saved again.
+const CosignerKeyFormatVersion = 2
+
+// MinRSAKeyBits is the smallest rsa key accepted in a key file, ours and those of the peers
+const MinRSAKeyBits = 2048
+
+// cosignerRSAPubsJSON is the entry of a cosigner in rsa_pubs since format version 2
+type cosignerRSAPubsJSON struct {
+	Pub         []byte `json:"pub"`
+	PreviousPub []byte `json:"previous_pub,omitempty"`
 }
 
 func (cosignerKey *CosignerKey) MarshalJSON() ([]byte, error) {
 	type Alias CosignerKey
 
-	// marshal our private key and all public keys
+	// marshal our private key and all public keys, along with any keys retained during a rotation
 	privateBytes := x509.MarshalPKCS1PrivateKey(&cosignerKey.RSAKey)
-	rsaPubKeysBytes := make([][]byte, 0)
-	for _, pubKey := range cosignerKey.CosignerKeys {
-		publicBytes := x509.MarshalPKCS1PublicKey(pubKey)
-		rsaPubKeysBytes = append(rsaPubKeysBytes, publicBytes)
+	rsaPubs := make([]cosignerRSAPubsJSON, 0)
+	for idx, pubKey := range cosignerKey.CosignerKeys {
+		entry := cosignerRSAPubsJSON{Pub: x509.MarshalPKCS1PublicKey(pubKey)}
+		if idx < len(cosignerKey.PreviousCosignerKeys) && cosignerKey.PreviousCosignerKeys[idx] != nil {
+			entry.PreviousPub = x509.MarshalPKCS1PublicKey(cosignerKey.PreviousCosignerKeys[idx])
+		}
+		rsaPubs = append(rsaPubs, entry)
+	}
+
+	var previousPrivateBytes []byte
+	if cosignerKey.PreviousRSAKey != nil {
+		previousPrivateBytes = x509.MarshalPKCS1PrivateKey(cosignerKey.PreviousRSAKey)
 	}
 
 	protoPubkey, err := tmCryptoEncoding.PubKeyToProto(cosignerKey.PubKey)
@@ -43,16 +85,35 @@ func (cosignerKey *CosignerKey) MarshalJSON() ([]byte, error) {
 		return nil, err
 	}
 
+	var nextProtoBytes []byte
+	if cosignerKey.NextPubKey != nil {
+		nextProtoPubkey, err := tmCryptoEncoding.PubKeyToProto(cosignerKey.NextPubKey)
+		if err != nil {
+			return nil, err
+		}
+		nextProtoBytes, err = nextProtoPubkey.Marshal()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return json.Marshal(&struct {
-		RSAKey       []byte   `json:"rsa_key"`
-		Pubkey       []byte   `json:"pub_key"`
-		CosignerKeys [][]byte `json:"rsa_pubs"`
+		FormatVersion        int                   `json:"key_format_version"`
+		RSAKey               []byte                `json:"rsa_key"`
+		Pubkey               []byte                `json:"pub_key"`
+		CosignerKeys         []cosignerRSAPubsJSON `json:"rsa_pubs"`
+		PreviousRSAKey       []byte                `json:"previous_rsa_key,omitempty"`
+		PreviousCosignerKeys [][]byte              `json:"previous_rsa_pubs,omitempty"`
+		NextPubkey           []byte                `json:"next_pub_key,omitempty"`
 		*Alias
 	}{
-		Pubkey:       protoBytes,
-		RSAKey:       privateBytes,
-		CosignerKeys: rsaPubKeysBytes,
-		Alias:        (*Alias)(cosignerKey),
+		FormatVersion:  CosignerKeyFormatVersion,
+		Pubkey:         protoBytes,
+		NextPubkey:     nextProtoBytes,
+		RSAKey:         privateBytes,
+		CosignerKeys:   rsaPubs,
+		PreviousRSAKey: previousPrivateBytes,
+		Alias:          (*Alias)(cosignerKey),
 	})
 }
 
@@ -60,9 +121,13 @@ func (cosignerKey *CosignerKey) UnmarshalJSON(data []byte) error {
 	type Alias CosignerKey
 
 	aux := &struct {
-		RSAKey       []byte   `json:"rsa_key"`
-		PubkeyBytes  []byte   `json:"pub_key"`
-		CosignerKeys [][]byte `json:"rsa_pubs"`
+		FormatVersion        int               `json:"key_format_version"`
+		RSAKey               []byte            `json:"rsa_key"`
+		PubkeyBytes          []byte            `json:"pub_key"`
+		CosignerKeys         []json.RawMessage `json:"rsa_pubs"`
+		PreviousRSAKey       []byte            `json:"previous_rsa_key,omitempty"`
+		PreviousCosignerKeys [][]byte          `json:"previous_rsa_pubs,omitempty"`
+		NextPubkeyBytes      []byte            `json:"next_pub_key,omitempty"`
 		*Alias
 	}{
 		Alias: (*Alias)(cosignerKey),
@@ -70,6 +135,11 @@ func (cosignerKey *CosignerKey) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &aux); err != nil {
 		return err
 	}
+	if aux.FormatVersion > CosignerKeyFormatVersion {
+		return fmt.Errorf("key file format version %d was written by a newer signer, this signer reads up to version %d",
+			aux.FormatVersion, CosignerKeyFormatVersion)
+	}
+
 	privateKey, err := x509.ParsePKCS1PrivateKey(aux.RSAKey)
 	if err != nil {
 		return err
@@ -103,13 +173,64 @@ func (cosignerKey *CosignerKey) UnmarshalJSON(data []byte) error {
 	}
 
 	// unmarshal the public key bytes for each cosigner
+	rsaPubs := aux.CosignerKeys
+	if aux.FormatVersion < 2 {
+		rsaPubs, err = migrateRSAPubs(aux.CosignerKeys, aux.PreviousCosignerKeys)
+		if err != nil {
+			return err
+		}
+	}
+
 	cosignerKey.CosignerKeys = make([]*rsa.PublicKey, 0)
-	for _, bytes := range aux.CosignerKeys {
-		cosignerRsaPubkey, err := x509.ParsePKCS1PublicKey(bytes)
+	cosignerKey.PreviousCosignerKeys = nil
+	rotating := false
+	previousPubs := make([]*rsa.PublicKey, 0)
+	for _, raw := range rsaPubs {
+		var entry cosignerRSAPubsJSON
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return err
+		}
+
+		cosignerRsaPubkey, err := x509.ParsePKCS1PublicKey(entry.Pub)
 		if err != nil {
 			return err
 		}
 		cosignerKey.CosignerKeys = append(cosignerKey.CosignerKeys, cosignerRsaPubkey)
+
+		var previousRsaPubkey *rsa.PublicKey
+		if len(entry.PreviousPub) > 0 {
+			previousRsaPubkey, err = x509.ParsePKCS1PublicKey(entry.PreviousPub)
+			if err != nil {
+				return err
+			}
+			rotating = true
+		}
+		previousPubs = append(previousPubs, previousRsaPubkey)
+	}
+	if rotating {
+		cosignerKey.PreviousCosignerKeys = previousPubs
+	}
+
+	// unmarshal our key retained during a rotation
+	cosignerKey.PreviousRSAKey = nil
+	if len(aux.PreviousRSAKey) > 0 {
+		cosignerKey.PreviousRSAKey, err = x509.ParsePKCS1PrivateKey(aux.PreviousRSAKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	// unmarshal the key the chain switches to, if any
+	cosignerKey.NextPubKey = nil
+	if len(aux.NextPubkeyBytes) > 0 {
+		var nextProtoPubkey tmProtoCrypto.PublicKey
+		if err := nextProtoPubkey.Unmarshal(aux.NextPubkeyBytes); err != nil {
+			return err
+		}
+		cosignerKey.NextPubKey, err = tmCryptoEncoding.PubKeyFromProto(nextProtoPubkey)
+		if err != nil {
+			return err
+		}
 	}
 
 	cosignerKey.RSAKey = *privateKey
@@ -117,10 +238,115 @@ func (cosignerKey *CosignerKey) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// migrateRSAPubs converts the rsa_pubs and previous_rsa_pubs arrays of format version 1 and
+// earlier into the rsa_pubs entries of format version 2
+func migrateRSAPubs(pubs []json.RawMessage, previousPubs [][]byte) ([]json.RawMessage, error) {
+	if len(previousPubs) > len(pubs) {
+		return nil, fmt.Errorf("%d previous_rsa_pubs for %d rsa_pubs", len(previousPubs), len(pubs))
+	}
+
+	migrated := make([]json.RawMessage, 0, len(pubs))
+	for idx, raw := range pubs {
+		entry := cosignerRSAPubsJSON{}
+		if err := json.Unmarshal(raw, &entry.Pub); err != nil {
+			return nil, err
+		}
+		if idx < len(previousPubs) {
+			entry.PreviousPub = previousPubs[idx]
+		}
+
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, entryBytes)
+	}
+	return migrated, nil
+}
+
+// RotateRSAKey replaces our RSA key with newKey
+// The replaced key is kept until FinishRSAKeyRotation so that peers holding our old
+// public key can still exchange ephemeral parts with us.
+func (cosignerKey *CosignerKey) RotateRSAKey(newKey *rsa.PrivateKey) error {
+	if err := checkRSAPrivateKey(newKey); err != nil {
+		return err
+	}
+	if cosignerKey.PreviousRSAKey != nil {
+		return errors.New("an rsa key rotation is already in progress")
+	}
+	if cosignerKey.ID < 1 || cosignerKey.ID > len(cosignerKey.CosignerKeys) {
+		return fmt.Errorf("cosigner ID %d is out of range", cosignerKey.ID)
+	}
+
+	previous := cosignerKey.RSAKey
+	cosignerKey.PreviousRSAKey = &previous
+	cosignerKey.RSAKey = *newKey
+	cosignerKey.CosignerKeys[cosignerKey.ID-1] = &newKey.PublicKey
+	cosignerKey.RSAPubsVersion++
+	return nil
+}
+
+// ImportRSAPublicKey replaces the RSA public key for the cosigner with the ID
+// The replaced public key is still accepted until FinishRSAKeyRotation.
+func (cosignerKey *CosignerKey) ImportRSAPublicKey(id int, pubKey *rsa.PublicKey) error {
+	if id < 1 || id > len(cosignerKey.CosignerKeys) {
+		return fmt.Errorf("cosigner ID %d is out of range", id)
+	}
+	if id == cosignerKey.ID {
+		return errors.New("use RotateRSAKey to rotate our own rsa key")
+	}
+	if err := checkRSAPublicKey(pubKey); err != nil {
+		return err
+	}
+
+	if len(cosignerKey.PreviousCosignerKeys) != len(cosignerKey.CosignerKeys) {
+		previous := make([]*rsa.PublicKey, len(cosignerKey.CosignerKeys))
+		copy(previous, cosignerKey.PreviousCosignerKeys)
+		cosignerKey.PreviousCosignerKeys = previous
+	}
+
+	cosignerKey.PreviousCosignerKeys[id-1] = cosignerKey.CosignerKeys[id-1]
+	cosignerKey.CosignerKeys[id-1] = pubKey
+	cosignerKey.RSAPubsVersion++
+	return nil
+}
+
+// FinishRSAKeyRotation drops the keys retained during a rotation
+// After this, only the current rsa keys are accepted.
+func (cosignerKey *CosignerKey) FinishRSAKeyRotation() {
+	if cosignerKey.PreviousRSAKey == nil && len(cosignerKey.PreviousCosignerKeys) == 0 {
+		return
+	}
+	cosignerKey.PreviousRSAKey = nil
+	cosignerKey.PreviousCosignerKeys = nil
+	cosignerKey.RSAPubsVersion++
+}
+
+// Zeroize overwrites the share and the rsa private keys in memory
+// The key can not be used afterwards.
+func (cosignerKey *CosignerKey) Zeroize() {
+	zeroizeBytes(cosignerKey.ShareKey)
+	zeroizeBytes(cosignerKey.NextShareKey)
+	zeroizeRSAKey(&cosignerKey.RSAKey)
+	zeroizeRSAKey(cosignerKey.PreviousRSAKey)
+}
+
 // LoadCosignerKey loads a CosignerKey from file.
+// The file may also be "-" for stdin or "fd:N" for an inherited file descriptor, see OpenKeyFile.
 func LoadCosignerKey(file string) (CosignerKey, error) {
+	reader, err := OpenKeyFile(file)
+	if err != nil {
+		return CosignerKey{}, err
+	}
+	defer reader.Close()
+
+	return ReadCosignerKey(reader)
+}
+
+// ReadCosignerKey reads a CosignerKey from reader.
+func ReadCosignerKey(reader io.Reader) (CosignerKey, error) {
 	pvKey := CosignerKey{}
-	keyJSONBytes, err := ioutil.ReadFile(file)
+	keyJSONBytes, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return pvKey, err
 	}
@@ -130,5 +356,66 @@ func LoadCosignerKey(file string) (CosignerKey, error) {
 		return pvKey, err
 	}
 
-	return pvKey, nil
+	return pvKey, pvKey.checkRSAKeys()
+}
+
+// checkRSAKeys refuses a key file holding an rsa key, ours or a peer's, current or retained during a rotation,
+// that is malformed or shorter than MinRSAKeyBits
+func (cosignerKey *CosignerKey) checkRSAKeys() error {
+	if err := checkRSAPrivateKey(&cosignerKey.RSAKey); err != nil {
+		return fmt.Errorf("rsa_key: %w", err)
+	}
+	if cosignerKey.PreviousRSAKey != nil {
+		if err := checkRSAPrivateKey(cosignerKey.PreviousRSAKey); err != nil {
+			return fmt.Errorf("previous_rsa_key: %w", err)
+		}
+	}
+	for idx, pubKey := range cosignerKey.CosignerKeys {
+		if err := checkRSAPublicKey(pubKey); err != nil {
+			return fmt.Errorf("rsa key of cosigner %d: %w", idx+1, err)
+		}
+	}
+	for idx, pubKey := range cosignerKey.PreviousCosignerKeys {
+		if pubKey == nil {
+			continue
+		}
+		if err := checkRSAPublicKey(pubKey); err != nil {
+			return fmt.Errorf("previous rsa key of cosigner %d: %w", idx+1, err)
+		}
+	}
+	return nil
+}
+
+// checkRSAPrivateKey refuses a malformed rsa key, or one shorter than MinRSAKeyBits
+func checkRSAPrivateKey(key *rsa.PrivateKey) error {
+	if err := checkRSAPublicKey(&key.PublicKey); err != nil {
+		return err
+	}
+	return key.Validate()
+}
+
+// checkRSAPublicKey refuses a malformed rsa public key, or one shorter than MinRSAKeyBits
+func checkRSAPublicKey(key *rsa.PublicKey) error {
+	if key == nil || key.N == nil {
+		return errors.New("missing rsa key")
+	}
+	if bits := key.N.BitLen(); bits < MinRSAKeyBits {
+		return fmt.Errorf("%d bit rsa key is weak, at least %d bits are required", bits, MinRSAKeyBits)
+	}
+	if key.N.Bit(0) == 0 {
+		return errors.New("rsa modulus is even")
+	}
+	if key.E < 3 || key.E%2 == 0 {
+		return fmt.Errorf("rsa public exponent %d is invalid", key.E)
+	}
+	return nil
+}
+
+// SaveCosignerKey writes a CosignerKey to file.
+func SaveCosignerKey(file string, key *CosignerKey) error {
+	jsonBytes, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tempfile.WriteFileAtomic(file, jsonBytes, 0600)
 }
@@ -0,0 +1,179 @@
+package signer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
+)
+
+// MinKeySeedLength is the minimum number of bytes of a key generation seed
+const MinKeySeedLength = 32
+
+// order of the ed25519 base point, shares are scalars modulo this
+var ed25519Order, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+
+// NewSeedReader returns an endless deterministic random stream derived from seed.
+// Each purpose gets an independent stream, so the shares and every rsa key can be
+// regenerated on their own. Anyone holding the seed can regenerate the same keys.
+func NewSeedReader(seed []byte, purpose string) (io.Reader, error) {
+	if len(seed) < MinKeySeedLength {
+		return nil, fmt.Errorf("seed must be at least %d bytes, got %d", MinKeySeedLength, len(seed))
+	}
+
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(purpose))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	// the AES-CTR keystream of a key used for this stream only
+	iv := make([]byte, aes.BlockSize)
+	return &cipher.StreamReader{S: cipher.NewCTR(block, iv), R: zeroReader{}}, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for idx := range b {
+		b[idx] = 0
+	}
+	return len(b), nil
+}
+
+// DealSharesFrom is tsed25519.DealShares drawing the polynomial coefficients from random
+// instead of crypto/rand, so that a seed reader deals the same shares every time.
+func DealSharesFrom(random io.Reader, secret []byte, threshold uint8, total uint8) ([]tsed25519.Scalar, error) {
+	if threshold < 1 || threshold > total {
+		return nil, fmt.Errorf("invalid threshold %d for %d shares", threshold, total)
+	}
+
+	coeffs := make([]*big.Int, threshold)
+	coeffs[0] = new(big.Int).SetBytes(reverseBytes(secret))
+	for i := 1; i < int(threshold); i++ {
+		coeff, err := randScalar(random)
+		if err != nil {
+			return nil, err
+		}
+		coeffs[i] = coeff
+	}
+
+	shares := make([]tsed25519.Scalar, total)
+	for i := 0; i < int(total); i++ {
+		// evaluate the polynomial at i + 1
+		shareCoeff := new(big.Int).Set(coeffs[threshold-1])
+		for j := int(threshold) - 2; j >= 0; j-- {
+			shareCoeff.Mul(shareCoeff, big.NewInt(int64(i+1)))
+			shareCoeff.Add(shareCoeff, coeffs[j])
+			shareCoeff.Mod(shareCoeff, ed25519Order)
+		}
+
+		shares[i] = make(tsed25519.Scalar, 32)
+		copy(shares[i], reverseBytes(shareCoeff.Bytes()))
+	}
+	return shares, nil
+}
+
+// randScalar returns a uniformly random scalar below the ed25519 order
+func randScalar(random io.Reader) (*big.Int, error) {
+	buf := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(random, buf); err != nil {
+			return nil, err
+		}
+		// the order is a 253 bit number, rejection is rare once the top bits are masked
+		buf[0] &= 0x1f
+		scalar := new(big.Int).SetBytes(buf)
+		if scalar.Cmp(ed25519Order) < 0 {
+			return scalar, nil
+		}
+	}
+}
+
+// GenerateRSAKeyFrom generates an rsa key of the given size with primes drawn from random.
+// Unlike rsa.GenerateKey, the same random stream always produces the same key.
+func GenerateRSAKeyFrom(random io.Reader, bits int) (*rsa.PrivateKey, error) {
+	if bits < 1024 || bits%16 != 0 {
+		return nil, fmt.Errorf("unsupported rsa key size %d", bits)
+	}
+
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	for {
+		p, err := randPrime(random, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := randPrime(random, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+
+		n := new(big.Int).Mul(p, q)
+		if n.BitLen() != bits {
+			continue
+		}
+
+		pminus1 := new(big.Int).Sub(p, one)
+		qminus1 := new(big.Int).Sub(q, one)
+		totient := new(big.Int).Mul(pminus1, qminus1)
+		d := new(big.Int).ModInverse(e, totient)
+		if d == nil {
+			// e is not coprime with the totient, draw new primes
+			continue
+		}
+
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		return key, nil
+	}
+}
+
+// randPrime returns a prime of exactly bits bits with its two top bits set
+func randPrime(random io.Reader, bits int) (*big.Int, error) {
+	if bits%8 != 0 {
+		return nil, errors.New("prime size must be a multiple of 8 bits")
+	}
+
+	buf := make([]byte, bits/8)
+	for {
+		if _, err := io.ReadFull(random, buf); err != nil {
+			return nil, err
+		}
+		// the top two bits make the product of two primes exactly twice as long
+		buf[0] |= 0xc0
+		buf[len(buf)-1] |= 1
+
+		candidate := new(big.Int).SetBytes(buf)
+		if candidate.ProbablyPrime(20) {
+			return candidate, nil
+		}
+	}
+}
+
+// reverseBytes converts between the little endian scalars of ed25519 and big endian big.Int bytes
+func reverseBytes(in []byte) []byte {
+	out := make([]byte, len(in))
+	for idx, b := range in {
+		out[len(in)-1-idx] = b
+	}
+	return out
+}
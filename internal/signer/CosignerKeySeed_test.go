@@ -0,0 +1,63 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
+	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
+)
+
+func TestDealSharesFromSeed(test *testing.T) {
+	seed := bytes.Repeat([]byte{7}, MinKeySeedLength)
+	privateKey := tmCryptoEd25519.GenPrivKey()
+	secret := tsed25519.ExpandSecret(privateKey[:32])
+
+	deal := func(purpose string) []tsed25519.Scalar {
+		reader, err := NewSeedReader(seed, purpose)
+		require.NoError(test, err)
+		shares, err := DealSharesFrom(reader, secret, 2, 3)
+		require.NoError(test, err)
+		return shares
+	}
+
+	shares := deal("shares")
+	require.Equal(test, shares, deal("shares"))
+	require.NotEqual(test, shares, deal("other"))
+
+	keys := make([]CosignerKey, len(shares))
+	for idx, share := range shares {
+		keys[idx] = CosignerKey{
+			PubKey:       privateKey.PubKey(),
+			ShareKey:     share,
+			ID:           idx + 1,
+			CosignerKeys: make([]*rsa.PublicKey, len(shares)),
+		}
+	}
+	_, err := VerifyCosignerKeys(keys, 2)
+	require.NoError(test, err)
+}
+
+func TestGenerateRSAKeyFromSeed(test *testing.T) {
+	seed := bytes.Repeat([]byte{7}, MinKeySeedLength)
+
+	generate := func() *rsa.PrivateKey {
+		reader, err := NewSeedReader(seed, "rsa-1")
+		require.NoError(test, err)
+		key, err := GenerateRSAKeyFrom(reader, 1024)
+		require.NoError(test, err)
+		return key
+	}
+
+	key := generate()
+	require.Equal(test, 1024, key.N.BitLen())
+	require.NoError(test, key.Validate())
+	require.Equal(test, key.D, generate().D)
+}
+
+func TestNewSeedReaderShortSeed(test *testing.T) {
+	_, err := NewSeedReader(make([]byte, MinKeySeedLength-1), "shares")
+	require.Error(test, err)
+}
@@ -0,0 +1,134 @@
+package signer
+
+import (
+	"bytes"
+	"fmt"
+
+	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
+)
+
+// above this many subsets of a given size, only a sliding window of subsets is checked
+const maxShareSubsets = 64
+
+// ShareSubsetResult is the outcome of reconstructing the validator public key from a subset of shares
+type ShareSubsetResult struct {
+	IDs []int
+
+	// Reconstructs is true if the shares combine into the validator public key
+	Reconstructs bool
+}
+
+// VerifyCosignerKeys checks that the cosigner keys are shares of one validator key
+// requiring threshold shares to sign.
+// Every subset of threshold keys must reconstruct the public key in the key files,
+// and no subset of threshold - 1 keys may. Large key sets are checked on a representative
+// selection of subsets that includes every key.
+// The returned error describes the first problem found; the results list every subset checked.
+func VerifyCosignerKeys(keys []CosignerKey, threshold int) ([]ShareSubsetResult, error) {
+	if threshold < 1 || threshold > len(keys) {
+		return nil, fmt.Errorf("threshold %d needs between 1 and %d keys", threshold, len(keys))
+	}
+
+	pubKey := keys[0].PubKey
+	total := len(keys[0].CosignerKeys)
+	seen := make(map[int]bool)
+	for _, key := range keys {
+		if !key.PubKey.Equals(pubKey) {
+			return nil, fmt.Errorf("key %d is for a different validator public key", key.ID)
+		}
+		if len(key.CosignerKeys) != total {
+			return nil, fmt.Errorf("key %d has %d rsa_pubs, expected %d", key.ID, len(key.CosignerKeys), total)
+		}
+		if key.ID < 1 || key.ID > total {
+			return nil, fmt.Errorf("key %d is outside the range 1 to %d", key.ID, total)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("key %d appears more than once", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	results := make([]ShareSubsetResult, 0)
+	var firstErr error
+
+	check := func(size int, expected bool) {
+		for _, subset := range shareSubsets(len(keys), size) {
+			ids := make([]int, 0, size)
+			shares := make([][]byte, 0, size)
+			for _, idx := range subset {
+				ids = append(ids, keys[idx].ID)
+				shares = append(shares, keys[idx].ShareKey)
+			}
+
+			secret := tsed25519.CombineShares(uint8(total), ids, shares)
+			reconstructs := bytes.Equal(tsed25519.ScalarMultiplyBase(secret), pubKey.Bytes())
+			results = append(results, ShareSubsetResult{IDs: ids, Reconstructs: reconstructs})
+
+			if firstErr != nil || reconstructs == expected {
+				continue
+			}
+			if expected {
+				firstErr = fmt.Errorf("keys %v do not reconstruct the validator public key", ids)
+			} else {
+				firstErr = fmt.Errorf("keys %v reconstruct the validator public key, the threshold is lower than %d", ids, threshold)
+			}
+		}
+	}
+
+	check(threshold, true)
+	if threshold > 1 {
+		check(threshold-1, false)
+	}
+
+	return results, firstErr
+}
+
+// shareSubsets returns subsets of size indexes out of count.
+// All subsets are returned if there are few enough, otherwise a sliding window
+// so that each index is still part of some subset.
+func shareSubsets(count int, size int) [][]int {
+	subsets := make([][]int, 0)
+
+	var combine func(start int, subset []int) bool
+	combine = func(start int, subset []int) bool {
+		if len(subset) == size {
+			subsets = append(subsets, append([]int(nil), subset...))
+			return len(subsets) <= maxShareSubsets
+		}
+		for idx := start; idx < count; idx++ {
+			if !combine(idx+1, append(subset, idx)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if combine(0, nil) {
+		return subsets
+	}
+
+	subsets = make([][]int, 0, count)
+	for start := 0; start < count; start++ {
+		subset := make([]int, 0, size)
+		for offset := 0; offset < size; offset++ {
+			subset = append(subset, (start+offset)%count)
+		}
+		subsets = append(subsets, subset)
+	}
+	return subsets
+}
+
+// NextCosignerKeys returns the keys with the shares of the key the chain switches to in place of their own,
+// to verify them with VerifyCosignerKeys. Returns false if not every key holds a next share.
+func NextCosignerKeys(keys []CosignerKey) ([]CosignerKey, bool) {
+	next := make([]CosignerKey, len(keys))
+	for idx, key := range keys {
+		if key.NextPubKey == nil || len(key.NextShareKey) == 0 {
+			return nil, false
+		}
+		key.PubKey = key.NextPubKey
+		key.ShareKey = key.NextShareKey
+		next[idx] = key
+	}
+	return next, true
+}
@@ -0,0 +1,77 @@
+package signer
+
+import (
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
+	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
+)
+
+func dealCosignerKeys(threshold uint8, total uint8) []CosignerKey {
+	privateKey := tmCryptoEd25519.GenPrivKey()
+	shares := tsed25519.DealShares(tsed25519.ExpandSecret(privateKey[:32]), threshold, total)
+
+	keys := make([]CosignerKey, 0)
+	for idx, share := range shares {
+		keys = append(keys, CosignerKey{
+			PubKey:       privateKey.PubKey(),
+			ShareKey:     share,
+			ID:           idx + 1,
+			CosignerKeys: make([]*rsa.PublicKey, total),
+		})
+	}
+	return keys
+}
+
+func TestVerifyCosignerKeys(test *testing.T) {
+	keys := dealCosignerKeys(2, 3)
+
+	results, err := VerifyCosignerKeys(keys, 2)
+	require.NoError(test, err)
+
+	// 3 pairs reconstruct, 3 single shares do not
+	require.Len(test, results, 6)
+	for _, result := range results {
+		require.Equal(test, len(result.IDs) == 2, result.Reconstructs)
+	}
+
+	// any quorum of the files can be checked on its own
+	_, err = VerifyCosignerKeys(keys[1:], 2)
+	require.NoError(test, err)
+}
+
+func TestVerifyCosignerKeysWrongThreshold(test *testing.T) {
+	keys := dealCosignerKeys(2, 3)
+
+	// shares dealt for 2-of-3 also reconstruct with any 2, so they are not a 3-of-3 sharing
+	_, err := VerifyCosignerKeys(keys, 3)
+	require.Error(test, err)
+
+	keys = dealCosignerKeys(3, 3)
+	_, err = VerifyCosignerKeys(keys, 2)
+	require.Error(test, err)
+}
+
+func TestVerifyCosignerKeysMixedValidators(test *testing.T) {
+	keys := dealCosignerKeys(2, 3)
+	other := dealCosignerKeys(2, 3)
+
+	_, err := VerifyCosignerKeys([]CosignerKey{keys[0], other[1]}, 2)
+	require.Error(test, err)
+
+	// a corrupted share fails to reconstruct
+	keys[1].ShareKey = other[1].ShareKey
+	_, err = VerifyCosignerKeys(keys, 2)
+	require.Error(test, err)
+}
+
+func TestShareSubsets(test *testing.T) {
+	require.Len(test, shareSubsets(4, 2), 6)
+
+	// C(20, 10) is too many to check, a window per key is used instead
+	subsets := shareSubsets(20, 10)
+	require.Len(test, subsets, 20)
+	require.Equal(test, []int{19, 0, 1, 2, 3, 4, 5, 6, 7, 8}, subsets[19])
+}
@@ -1,6 +1,12 @@
 package signer
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -14,3 +20,131 @@ func TestLoadCosignerKey(test *testing.T) {
 	// public key from cosigner pubs array should match public key from our private key
 	require.Equal(test, &key.RSAKey.PublicKey, key.CosignerKeys[key.ID-1])
 }
+
+func TestCosignerKeyRSARotation(test *testing.T) {
+	key, err := LoadCosignerKey("../../test/cosigner-key.json")
+	require.NoError(test, err)
+	oldKey := key.RSAKey
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+
+	require.NoError(test, key.RotateRSAKey(newKey))
+	require.Error(test, key.RotateRSAKey(newKey))
+	require.Equal(test, 1, key.RSAPubsVersion)
+
+	// a peer's key is replaced as well
+	peerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+	oldPeerPub := key.CosignerKeys[0]
+	require.NoError(test, key.ImportRSAPublicKey(1, &peerKey.PublicKey))
+	require.Error(test, key.ImportRSAPublicKey(key.ID, &peerKey.PublicKey))
+
+	// the retained keys survive a round trip through the key file format
+	jsonBytes, err := json.Marshal(&key)
+	require.NoError(test, err)
+
+	var loaded CosignerKey
+	require.NoError(test, json.Unmarshal(jsonBytes, &loaded))
+	require.Equal(test, 2, loaded.RSAPubsVersion)
+	require.Equal(test, newKey.PublicKey, loaded.RSAKey.PublicKey)
+	require.Equal(test, oldKey.PublicKey, loaded.PreviousRSAKey.PublicKey)
+	require.Equal(test, &newKey.PublicKey, loaded.CosignerKeys[loaded.ID-1])
+	require.Equal(test, &peerKey.PublicKey, loaded.CosignerKeys[0])
+	require.Equal(test, oldPeerPub, loaded.PreviousCosignerKeys[0])
+	require.Nil(test, loaded.PreviousCosignerKeys[1])
+
+	loaded.FinishRSAKeyRotation()
+	require.Nil(test, loaded.PreviousRSAKey)
+	require.Nil(test, loaded.PreviousCosignerKeys)
+	require.Equal(test, 3, loaded.RSAPubsVersion)
+}
+
+func TestCosignerKeyWeakRSA(test *testing.T) {
+	key, err := LoadCosignerKey("../../test/cosigner-key.json")
+	require.NoError(test, err)
+
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(test, err)
+	require.Error(test, key.RotateRSAKey(weakKey))
+	require.Error(test, key.ImportRSAPublicKey(1, &weakKey.PublicKey))
+	require.Error(test, key.ImportRSAPublicKey(1, &rsa.PublicKey{N: key.CosignerKeys[0].N, E: 4}))
+
+	// a key file holding a weak key of a peer is refused on load
+	key.CosignerKeys[0] = &weakKey.PublicKey
+	jsonBytes, err := json.Marshal(&key)
+	require.NoError(test, err)
+	_, err = ReadCosignerKey(bytes.NewReader(jsonBytes))
+	require.Error(test, err)
+	require.Contains(test, err.Error(), "cosigner 1")
+}
+
+func TestCosignerKeyFormatMigration(test *testing.T) {
+	// a version 1 key file in the middle of a rotation
+	legacy, err := LoadCosignerKey("../../test/cosigner-key.json")
+	require.NoError(test, err)
+	peerKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+	oldPeerPub := legacy.CosignerKeys[0]
+	require.NoError(test, legacy.ImportRSAPublicKey(1, &peerKey.PublicKey))
+
+	var legacyJSON map[string]interface{}
+	jsonBytes, err := ioutil.ReadFile("../../test/cosigner-key.json")
+	require.NoError(test, err)
+	require.NoError(test, json.Unmarshal(jsonBytes, &legacyJSON))
+	require.NotContains(test, legacyJSON, "key_format_version")
+	legacyJSON["rsa_pubs"].([]interface{})[0] = x509.MarshalPKCS1PublicKey(&peerKey.PublicKey)
+	legacyJSON["previous_rsa_pubs"] = [][]byte{x509.MarshalPKCS1PublicKey(oldPeerPub)}
+	jsonBytes, err = json.Marshal(legacyJSON)
+	require.NoError(test, err)
+
+	var migrated CosignerKey
+	require.NoError(test, json.Unmarshal(jsonBytes, &migrated))
+	require.Equal(test, legacy.CosignerKeys, migrated.CosignerKeys)
+	require.Equal(test, oldPeerPub, migrated.PreviousCosignerKeys[0])
+	require.Nil(test, migrated.PreviousCosignerKeys[1])
+
+	// saved in the current format, with the keys of each cosigner in one entry
+	jsonBytes, err = json.Marshal(&migrated)
+	require.NoError(test, err)
+	var saved struct {
+		FormatVersion int                   `json:"key_format_version"`
+		RSAPubs       []cosignerRSAPubsJSON `json:"rsa_pubs"`
+	}
+	require.NoError(test, json.Unmarshal(jsonBytes, &saved))
+	require.Equal(test, CosignerKeyFormatVersion, saved.FormatVersion)
+	require.Equal(test, x509.MarshalPKCS1PublicKey(oldPeerPub), saved.RSAPubs[0].PreviousPub)
+	require.Nil(test, saved.RSAPubs[1].PreviousPub)
+
+	var reloaded CosignerKey
+	require.NoError(test, json.Unmarshal(jsonBytes, &reloaded))
+	require.Equal(test, migrated.CosignerKeys, reloaded.CosignerKeys)
+	require.Equal(test, migrated.PreviousCosignerKeys, reloaded.PreviousCosignerKeys)
+
+	// a file from a newer signer is not misread
+	var newer map[string]interface{}
+	require.NoError(test, json.Unmarshal(jsonBytes, &newer))
+	newer["key_format_version"] = CosignerKeyFormatVersion + 1
+	jsonBytes, err = json.Marshal(newer)
+	require.NoError(test, err)
+	require.Error(test, json.Unmarshal(jsonBytes, &CosignerKey{}))
+}
+
+func TestCosignerKeyNextKey(test *testing.T) {
+	key, err := LoadCosignerKey("../../test/cosigner-key.json")
+	require.NoError(test, err)
+	require.Nil(test, key.NextPubKey)
+
+	next := dealCosignerKeys(2, 3)[key.ID-1]
+	key.NextPubKey = next.PubKey
+	key.NextShareKey = next.ShareKey
+
+	jsonBytes, err := json.Marshal(&key)
+	require.NoError(test, err)
+
+	var loaded CosignerKey
+	require.NoError(test, json.Unmarshal(jsonBytes, &loaded))
+	require.Equal(test, next.PubKey, loaded.NextPubKey)
+	require.Equal(test, next.ShareKey, loaded.NextShareKey)
+	require.Equal(test, key.PubKey, loaded.PubKey)
+}
@@ -1,26 +1,39 @@
 package signer
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/tendermint/tendermint/libs/log"
-	tmnet "github.com/tendermint/tendermint/libs/net"
 	"github.com/tendermint/tendermint/libs/service"
 	server "github.com/tendermint/tendermint/rpc/jsonrpc/server"
 	rpc_types "github.com/tendermint/tendermint/rpc/jsonrpc/types"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type RpcSignRequest struct {
 	SignBytes []byte
+
+	// optional W3C trace context of the requesting cosigner
+	TraceContext map[string]string
+
+	// optional id of the node request, for the logs
+	RequestID string
 }
 
 type RpcSignResponse struct {
 	Timestamp time.Time
 	Signature []byte
+
+	// the ephemeral public key the signature share was made with, needed by coordinators without a share
+	EphemeralPublic []byte
 }
 
 type RpcGetEphemeralSecretPartRequest struct {
@@ -28,6 +41,12 @@ type RpcGetEphemeralSecretPartRequest struct {
 	Height int64
 	Round  int64
 	Step   int8
+
+	// optional W3C trace context of the requesting cosigner
+	TraceContext map[string]string
+
+	// optional id of the node request, for the logs
+	RequestID string
 }
 
 type RpcGetEphemeralSecretPartResponse struct {
@@ -37,31 +56,59 @@ type RpcGetEphemeralSecretPartResponse struct {
 	SourceSig                      []byte
 }
 
+type RpcSignStateWatermarkResponse struct {
+	Height int64
+	Round  int64
+	Step   int8
+}
+
+type RpcBenchSignResponse struct {
+}
+
 type CosignerRpcServerConfig struct {
 	Logger        log.Logger
 	ListenAddress string
+	DualStack     bool
 	Cosigner      Cosigner
 	Peers         []RemoteCosigner
+
+	// optional, more addresses serving the same cosigner, e.g. on another network of a multi-homed host
+	ExtraListenAddresses []string
 }
 
 // CosignerRpcServer responds to rpc sign requests using a cosigner instance
 type CosignerRpcServer struct {
 	service.BaseService
 
-	logger        log.Logger
-	listenAddress string
-	listener      net.Listener
-	cosigner      Cosigner
-	peers         []RemoteCosigner
+	logger          log.Logger
+	listenAddresses []string
+	dualStack       bool
+	listeners       []net.Listener
+	httpServer      *http.Server
+	cosigner        Cosigner
+	peers           []RemoteCosigner
+
+	// bounds of reading a request body and of handling a request
+	readTimeout    time.Duration
+	handlerTimeout time.Duration
 }
 
+// time an idle connection is kept open
+const cosignerRpcIdleTimeout = 60 * time.Second
+
+// connContextKey is the context key of the connection a request came in on
+type connContextKey struct{}
+
 // NewCosignerRpcServer instantiates a local cosigner with the specified key and sign state
 func NewCosignerRpcServer(config *CosignerRpcServerConfig) *CosignerRpcServer {
 	cosignerRpcServer := &CosignerRpcServer{
-		cosigner:      config.Cosigner,
-		listenAddress: config.ListenAddress,
-		peers:         config.Peers,
-		logger:        config.Logger,
+		cosigner:        config.Cosigner,
+		listenAddresses: append([]string{config.ListenAddress}, config.ExtraListenAddresses...),
+		dualStack:       config.DualStack,
+		peers:           config.Peers,
+		logger:          config.Logger,
+		readTimeout:     server.DefaultConfig().ReadTimeout,
+		handlerTimeout:  server.DefaultConfig().WriteTimeout,
 	}
 
 	cosignerRpcServer.BaseService = *service.NewBaseService(config.Logger, "CosignerRpcServer", cosignerRpcServer)
@@ -70,44 +117,143 @@ func NewCosignerRpcServer(config *CosignerRpcServerConfig) *CosignerRpcServer {
 
 // OnStart starts the rpm server to respond to remote CosignerSignRequests
 func (rpcServer *CosignerRpcServer) OnStart() error {
-	proto, address := tmnet.ProtocolAndAddress(rpcServer.listenAddress)
-
-	lis, err := net.Listen(proto, address)
-	if err != nil {
-		return err
+	rpcServer.listeners = nil
+	for _, listenAddress := range rpcServer.listenAddresses {
+		lis, err := listen(listenAddress, rpcServer.dualStack, rpcServer.Logger)
+		if err != nil {
+			for _, lis := range rpcServer.listeners {
+				lis.Close()
+			}
+			rpcServer.listeners = nil
+			return err
+		}
+		rpcServer.listeners = append(rpcServer.listeners, lis)
 	}
-	rpcServer.listener = lis
 
 	routes := map[string]*server.RPCFunc{
 		"Sign":                   server.NewRPCFunc(rpcServer.rpcSignRequest, "arg"),
 		"GetEphemeralSecretPart": server.NewRPCFunc(rpcServer.rpcGetEphemeralSecretPart, "arg"),
+		"GetSignStateWatermark":  server.NewRPCFunc(rpcServer.rpcGetSignStateWatermark, ""),
+		"BenchSign":              server.NewRPCFunc(rpcServer.rpcBenchSign, ""),
 	}
 
 	mux := http.NewServeMux()
 	server.RegisterRPCFuncs(mux, routes, log.NewFilter(rpcServer.Logger, log.AllowError()))
 
 	tcpLogger := rpcServer.Logger.With("socket", "tcp")
-	tcpLogger = log.NewFilter(tcpLogger, log.AllowError())
 	config := server.DefaultConfig()
 
-	go func() {
-		defer lis.Close()
-		server.Serve(lis, mux, tcpLogger, config)
-	}()
+	// HTTP/1.1 and prior knowledge h2c on the same listener
+	// h2c connections are hijacked from the http server, so a ReadTimeout or WriteTimeout of the server
+	// would outlive the first request and break the connection. Each request is bounded instead.
+	handler := server.RecoverAndLogHandler(mux, log.NewFilter(tcpLogger, log.AllowError()))
+	handler = http.TimeoutHandler(handler, rpcServer.handlerTimeout, "cosigner rpc timed out")
+	handler = maxBytesHandler(readTimeoutHandler(handler, rpcServer.readTimeout), config.MaxBodyBytes)
+	rpcServer.httpServer = &http.Server{
+		Handler:           h2c.NewHandler(handler, &http2.Server{IdleTimeout: cosignerRpcIdleTimeout}),
+		ReadHeaderTimeout: config.ReadTimeout,
+		IdleTimeout:       cosignerRpcIdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, connContextKey{}, conn)
+		},
+	}
+
+	for _, lis := range rpcServer.listeners {
+		go func(lis net.Listener) {
+			defer lis.Close()
+			tcpLogger.Info("Starting cosigner rpc server", "address", lis.Addr())
+			err := rpcServer.httpServer.Serve(lis)
+			tcpLogger.Info("Cosigner rpc server stopped", "address", lis.Addr(), "err", err)
+		}(lis)
+	}
 
 	return nil
 }
 
+// OnStop closes the listeners and any open connections
+func (rpcServer *CosignerRpcServer) OnStop() {
+	if rpcServer.httpServer != nil {
+		rpcServer.httpServer.Close()
+	}
+}
+
+// maxBytesHandler limits the size of request bodies to n bytes
+func maxBytesHandler(handler http.Handler, n int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// readTimeoutHandler reads the request body within timeout before handing the request to handler,
+// answering 408 if it did not arrive in time
+func readTimeoutHandler(handler http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		var err error
+		if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok && r.ProtoMajor == 1 {
+			// the read fails at the deadline, which is lifted before the connection is read in the background.
+			// A connection that did not deliver the body in time keeps it and is closed after the reply.
+			_ = conn.SetReadDeadline(time.Now().Add(timeout))
+			body, err = ioutil.ReadAll(r.Body)
+			if err == nil {
+				_ = conn.SetReadDeadline(time.Time{})
+			}
+		} else {
+			// an h2c stream, reset once the handler returned, which ends the read
+			read := make(chan struct{})
+			go func() {
+				defer close(read)
+				body, err = ioutil.ReadAll(r.Body)
+			}()
+			select {
+			case <-read:
+			case <-time.After(timeout):
+				http.Error(w, "request body not read in time", http.StatusRequestTimeout)
+				return
+			}
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			http.Error(w, "request body not read in time", http.StatusRequestTimeout)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Addr returns the address of the listener on ListenAddress
 func (rpcServer *CosignerRpcServer) Addr() net.Addr {
-	if rpcServer.listener == nil {
+	if len(rpcServer.listeners) == 0 {
 		return nil
 	}
-	return rpcServer.listener.Addr()
+	return rpcServer.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of all listeners, ListenAddress first
+func (rpcServer *CosignerRpcServer) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(rpcServer.listeners))
+	for idx, lis := range rpcServer.listeners {
+		addrs[idx] = lis.Addr()
+	}
+	return addrs
 }
 
 func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req RpcSignRequest) (*RpcSignResponse, error) {
 	response := &RpcSignResponse{}
 
+	// canceled if the requesting cosigner goes away
+	reqCtx, span := tracer.Start(extractTraceContext(ctx.Context(), req.TraceContext), "CosignerRpcServer.Sign")
+	defer span.End()
+	reqCtx = withRequestID(reqCtx, req.RequestID)
+
 	height, round, step, err := UnpackHRS(req.SignBytes)
 	if err != nil {
 		return response, err
@@ -124,7 +270,7 @@ func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req R
 
 			// RPC requests are blocking
 			// to prevent it from hanging our process indefinitely, we use a timeout context and a goroutine
-			partReqCtx, partReqCtxCancel := context.WithTimeout(context.Background(), time.Second)
+			partReqCtx, partReqCtxCancel := context.WithTimeout(reqCtx, time.Second)
 
 			go func() {
 				partRequest := CosignerGetEphemeralSecretPartRequest{
@@ -135,7 +281,7 @@ func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req R
 				}
 
 				// if we already have an ephemeral secret part for this HRS, we don't need to re-query for it
-				hasResp, err := rpcServer.cosigner.HasEphemeralSecretPart(CosignerHasEphemeralSecretPartRequest{
+				hasResp, err := rpcServer.cosigner.HasEphemeralSecretPart(partReqCtx, CosignerHasEphemeralSecretPartRequest{
 					ID:     peer.GetID(),
 					Height: height,
 					Round:  round,
@@ -143,7 +289,7 @@ func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req R
 				})
 
 				if err != nil {
-					rpcServer.logger.Error("HasEphemeralSecretPart req error", "error", err)
+					rpcServer.logger.Error("HasEphemeralSecretPart req error", "error", err, "request", req.RequestID)
 					return
 				}
 
@@ -152,9 +298,9 @@ func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req R
 					return
 				}
 
-				partResponse, err := peer.GetEphemeralSecretPart(partRequest)
+				partResponse, err := peer.GetEphemeralSecretPart(partReqCtx, partRequest)
 				if err != nil {
-					rpcServer.logger.Error("GetEphemeralSecretPart req error", "error", err)
+					rpcServer.logger.Error("GetEphemeralSecretPart req error", "error", err, "request", req.RequestID)
 					return
 				}
 
@@ -168,7 +314,7 @@ func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req R
 				defer partReqCtxCancel()
 
 				// set the share part from the response
-				err = rpcServer.cosigner.SetEphemeralSecretPart(CosignerSetEphemeralSecretPartRequest{
+				err = rpcServer.cosigner.SetEphemeralSecretPart(partReqCtx, CosignerSetEphemeralSecretPartRequest{
 					SourceID:                       partResponse.SourceID,
 					SourceEphemeralSecretPublicKey: partResponse.SourceEphemeralSecretPublicKey,
 					EncryptedSharePart:             partResponse.EncryptedSharePart,
@@ -178,7 +324,7 @@ func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req R
 					SourceSig:                      partResponse.SourceSig,
 				})
 				if err != nil {
-					rpcServer.logger.Error("SetEphemeralSecretPart req error", "error", err)
+					rpcServer.logger.Error("SetEphemeralSecretPart req error", "error", err, "request", req.RequestID)
 				}
 			}()
 
@@ -196,7 +342,7 @@ func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req R
 	wg.Wait()
 
 	// after getting any share parts we could, we sign
-	resp, err := rpcServer.cosigner.Sign(CosignerSignRequest{
+	resp, err := rpcServer.cosigner.Sign(reqCtx, CosignerSignRequest{
 		SignBytes: req.SignBytes,
 	})
 	if err != nil {
@@ -205,20 +351,25 @@ func (rpcServer *CosignerRpcServer) rpcSignRequest(ctx *rpc_types.Context, req R
 
 	response.Timestamp = resp.Timestamp
 	response.Signature = resp.Signature
+	response.EphemeralPublic = resp.EphemeralPublic
 	return response, nil
 }
 
 func (rpcServer *CosignerRpcServer) rpcGetEphemeralSecretPart(ctx *rpc_types.Context, req RpcGetEphemeralSecretPartRequest) (*RpcGetEphemeralSecretPartResponse, error) {
 	response := &RpcGetEphemeralSecretPartResponse{}
 
-	partResp, err := rpcServer.cosigner.GetEphemeralSecretPart(CosignerGetEphemeralSecretPartRequest{
+	reqCtx, span := tracer.Start(extractTraceContext(ctx.Context(), req.TraceContext), "CosignerRpcServer.GetEphemeralSecretPart")
+	defer span.End()
+	reqCtx = withRequestID(reqCtx, req.RequestID)
+
+	partResp, err := rpcServer.cosigner.GetEphemeralSecretPart(reqCtx, CosignerGetEphemeralSecretPartRequest{
 		ID:     req.ID,
 		Height: req.Height,
 		Round:  req.Round,
 		Step:   req.Step,
 	})
 	if err != nil {
-		return response, nil
+		return response, err
 	}
 
 	response.SourceID = partResp.SourceID
@@ -228,3 +379,25 @@ func (rpcServer *CosignerRpcServer) rpcGetEphemeralSecretPart(ctx *rpc_types.Con
 
 	return response, nil
 }
+
+func (rpcServer *CosignerRpcServer) rpcGetSignStateWatermark(ctx *rpc_types.Context) (*RpcSignStateWatermarkResponse, error) {
+	local, ok := rpcServer.cosigner.(*LocalCosigner)
+	if !ok {
+		return nil, errors.New("cosigner does not keep a share watermark")
+	}
+
+	hrs := local.SignStateWatermark()
+	return &RpcSignStateWatermarkResponse{Height: hrs.Height, Round: hrs.Round, Step: hrs.Step}, nil
+}
+
+func (rpcServer *CosignerRpcServer) rpcBenchSign(ctx *rpc_types.Context) (*RpcBenchSignResponse, error) {
+	local, ok := rpcServer.cosigner.(*LocalCosigner)
+	if !ok {
+		return nil, errors.New("cosigner does not hold a share")
+	}
+
+	if err := local.BenchSign(ctx.Context()); err != nil {
+		return nil, err
+	}
+	return &RpcBenchSignResponse{}, nil
+}
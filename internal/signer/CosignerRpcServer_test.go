@@ -1,12 +1,22 @@
 package signer
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
 	"github.com/tendermint/tendermint/libs/log"
 	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	client "github.com/tendermint/tendermint/rpc/jsonrpc/client"
 	tm "github.com/tendermint/tendermint/types"
 )
 
@@ -16,13 +26,13 @@ func (cosigner *DummyCosigner) GetID() int {
 	return 0
 }
 
-func (cosigner *DummyCosigner) Sign(signReq CosignerSignRequest) (CosignerSignResponse, error) {
+func (cosigner *DummyCosigner) Sign(ctx context.Context, signReq CosignerSignRequest) (CosignerSignResponse, error) {
 	return CosignerSignResponse{
 		Signature: []byte("foobar"),
 	}, nil
 }
 
-func (cosigner *DummyCosigner) GetEphemeralSecretPart(req CosignerGetEphemeralSecretPartRequest) (CosignerGetEphemeralSecretPartResponse, error) {
+func (cosigner *DummyCosigner) GetEphemeralSecretPart(ctx context.Context, req CosignerGetEphemeralSecretPartRequest) (CosignerGetEphemeralSecretPartResponse, error) {
 	return CosignerGetEphemeralSecretPartResponse{
 		SourceID:                       1,
 		SourceEphemeralSecretPublicKey: []byte("foo"),
@@ -31,13 +41,13 @@ func (cosigner *DummyCosigner) GetEphemeralSecretPart(req CosignerGetEphemeralSe
 	}, nil
 }
 
-func (cosigner *DummyCosigner) HasEphemeralSecretPart(req CosignerHasEphemeralSecretPartRequest) (CosignerHasEphemeralSecretPartResponse, error) {
+func (cosigner *DummyCosigner) HasEphemeralSecretPart(ctx context.Context, req CosignerHasEphemeralSecretPartRequest) (CosignerHasEphemeralSecretPartResponse, error) {
 	return CosignerHasEphemeralSecretPartResponse{
 		Exists: false,
 	}, nil
 }
 
-func (cosigner *DummyCosigner) SetEphemeralSecretPart(req CosignerSetEphemeralSecretPartRequest) error {
+func (cosigner *DummyCosigner) SetEphemeralSecretPart(ctx context.Context, req CosignerSetEphemeralSecretPartRequest) error {
 	return nil
 }
 
@@ -63,8 +73,8 @@ func TestCosignerRpcServerSign(test *testing.T) {
 	vote.Type = tmProto.PrevoteType
 	signBytes := tm.VoteSignBytes("chain-id", &vote)
 
-	remoteCosigner := NewRemoteCosigner(2, rpcServer.listener.Addr().Network()+"://"+rpcServer.Addr().String())
-	resp, err := remoteCosigner.Sign(CosignerSignRequest{
+	remoteCosigner := NewRemoteCosigner(2, rpcServer.Addr().Network()+"://"+rpcServer.Addr().String())
+	resp, err := remoteCosigner.Sign(context.Background(), CosignerSignRequest{
 		SignBytes: signBytes,
 	})
 	require.NoError(test, err)
@@ -75,6 +85,41 @@ func TestCosignerRpcServerSign(test *testing.T) {
 	rpcServer.Stop()
 }
 
+func TestCosignerRpcServerExtraListenAddresses(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	rpcServer := NewCosignerRpcServer(&CosignerRpcServerConfig{
+		Logger:               logger,
+		ListenAddress:        "tcp://127.0.0.1:0",
+		ExtraListenAddresses: []string{"tcp://127.0.0.1:0"},
+		Cosigner:             &DummyCosigner{},
+	})
+	require.NoError(test, rpcServer.Start())
+
+	vote := tmProto.Vote{Height: 1, Type: tmProto.PrevoteType}
+	signReq := CosignerSignRequest{SignBytes: tm.VoteSignBytes("chain-id", &vote)}
+
+	addrs := rpcServer.Addrs()
+	require.Len(test, addrs, 2)
+	require.Equal(test, rpcServer.Addr(), addrs[0])
+	for _, addr := range addrs {
+		_, err := NewRemoteCosigner(2, "tcp://"+addr.String()).Sign(context.Background(), signReq)
+		require.NoError(test, err, addr)
+	}
+
+	// every listener is closed on stop
+	require.NoError(test, rpcServer.Stop())
+	for _, addr := range addrs {
+		require.Eventually(test, func() bool {
+			conn, err := net.Dial("tcp", addr.String())
+			if err == nil {
+				conn.Close()
+			}
+			return err != nil
+		}, time.Second, 10*time.Millisecond, addr)
+	}
+}
+
 func TestCosignerRpcServerGetEphemeralSecretPart(test *testing.T) {
 	dummyCosigner := &DummyCosigner{}
 
@@ -89,9 +134,9 @@ func TestCosignerRpcServerGetEphemeralSecretPart(test *testing.T) {
 	rpcServer := NewCosignerRpcServer(&config)
 	rpcServer.Start()
 
-	remoteCosigner := NewRemoteCosigner(2, rpcServer.listener.Addr().Network()+"://"+rpcServer.Addr().String())
+	remoteCosigner := NewRemoteCosigner(2, rpcServer.Addr().Network()+"://"+rpcServer.Addr().String())
 
-	resp, err := remoteCosigner.GetEphemeralSecretPart(CosignerGetEphemeralSecretPartRequest{})
+	resp, err := remoteCosigner.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{})
 	require.NoError(test, err)
 	require.Equal(test, resp, CosignerGetEphemeralSecretPartResponse{
 		SourceID:                       1,
@@ -102,3 +147,242 @@ func TestCosignerRpcServerGetEphemeralSecretPart(test *testing.T) {
 
 	rpcServer.Stop()
 }
+
+func TestCosignerRpcServerIPv6(test *testing.T) {
+	dummyCosigner := &DummyCosigner{}
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	config := CosignerRpcServerConfig{
+		Logger:        logger,
+		ListenAddress: "tcp://[::1]:0",
+		Cosigner:      dummyCosigner,
+	}
+
+	rpcServer := NewCosignerRpcServer(&config)
+	err := rpcServer.Start()
+	if err != nil {
+		test.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer rpcServer.Stop()
+
+	remoteCosigner := NewRemoteCosigner(2, "tcp://"+rpcServer.Addr().String())
+
+	resp, err := remoteCosigner.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{})
+	require.NoError(test, err)
+	require.Equal(test, 1, resp.SourceID)
+}
+
+func TestCosignerRpcServerH2C(test *testing.T) {
+	dummyCosigner := &DummyCosigner{}
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	config := CosignerRpcServerConfig{
+		Logger:        logger,
+		ListenAddress: "tcp://127.0.0.1:0",
+		Cosigner:      dummyCosigner,
+	}
+
+	rpcServer := NewCosignerRpcServer(&config)
+	require.NoError(test, rpcServer.Start())
+	defer rpcServer.Stop()
+
+	remoteCosigner := NewRemoteCosigner(2, "tcp://"+rpcServer.Addr().String())
+	require.NoError(test, remoteCosigner.SetTransport(CosignerTransportH2C))
+
+	vote := tmProto.Vote{Height: 1, Type: tmProto.PrevoteType}
+	signReq := CosignerSignRequest{SignBytes: tm.VoteSignBytes("chain-id", &vote)}
+
+	// concurrent requests share the one connection
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := remoteCosigner.Sign(context.Background(), signReq)
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(test, <-errs)
+	}
+}
+
+func TestCosignerRpcServerTimeouts(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	rpcServer := NewCosignerRpcServer(&CosignerRpcServerConfig{
+		Logger:        logger,
+		ListenAddress: "tcp://127.0.0.1:0",
+		Cosigner:      &slowCosigner{Cosigner: &DummyCosigner{}, delay: time.Second},
+	})
+	rpcServer.readTimeout = 100 * time.Millisecond
+	rpcServer.handlerTimeout = 200 * time.Millisecond
+	require.NoError(test, rpcServer.Start())
+	defer rpcServer.Stop()
+
+	// a body that does not arrive in time
+	conn, err := net.Dial("tcp", rpcServer.Addr().String())
+	require.NoError(test, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: cosigner\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{"))
+	require.NoError(test, err)
+	require.NoError(test, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	status := make([]byte, 12)
+	_, err = io.ReadFull(conn, status)
+	require.NoError(test, err)
+	require.Equal(test, "HTTP/1.1 408", string(status))
+
+	// a request not handled in time, over both transports
+	vote := tmProto.Vote{Height: 1, Type: tmProto.PrevoteType}
+	signReq := CosignerSignRequest{SignBytes: tm.VoteSignBytes("chain-id", &vote)}
+	for _, transport := range []string{CosignerTransportHTTP1, CosignerTransportH2C} {
+		remoteCosigner := NewRemoteCosigner(2, "tcp://"+rpcServer.Addr().String())
+		require.NoError(test, remoteCosigner.SetTransport(transport))
+		start := time.Now()
+		_, err = remoteCosigner.Sign(context.Background(), signReq)
+		require.Error(test, err)
+		require.Less(test, int64(time.Since(start)), int64(time.Second))
+	}
+}
+
+func TestRemoteCosignerUnsupportedTransport(test *testing.T) {
+	remoteCosigner := NewRemoteCosigner(2, "tcp://127.0.0.1:1234")
+	require.Error(test, remoteCosigner.SetTransport("quic"))
+}
+
+// handshakeDelayConn holds back the first read of a connection to model the round trip
+// spent on connection setup over a WAN link
+type handshakeDelayConn struct {
+	net.Conn
+	delay time.Duration
+	once  sync.Once
+}
+
+func (conn *handshakeDelayConn) Read(b []byte) (int, error) {
+	conn.once.Do(func() { time.Sleep(conn.delay) })
+	return conn.Conn.Read(b)
+}
+
+type handshakeDelayListener struct {
+	net.Listener
+	delay time.Duration
+}
+
+func (lis *handshakeDelayListener) Accept() (net.Conn, error) {
+	conn, err := lis.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &handshakeDelayConn{Conn: conn, delay: lis.delay}, nil
+}
+
+// BenchmarkCosignerRpcTransport compares a new connection per request with the reused
+// HTTP/1.1 and h2c transports, with 5ms of connection setup latency
+func BenchmarkCosignerRpcTransport(b *testing.B) {
+	logger := log.NewNopLogger()
+
+	rpcServer := NewCosignerRpcServer(&CosignerRpcServerConfig{
+		Logger:        logger,
+		ListenAddress: "tcp://127.0.0.1:0",
+		Cosigner:      &DummyCosigner{},
+	})
+	require.NoError(b, rpcServer.Start())
+	defer rpcServer.Stop()
+
+	// swap the listener for one that delays each new connection
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	go rpcServer.httpServer.Serve(&handshakeDelayListener{Listener: lis, delay: 5 * time.Millisecond})
+	address := "tcp://" + lis.Addr().String()
+
+	vote := tmProto.Vote{Height: 1, Type: tmProto.PrevoteType}
+	signReq := CosignerSignRequest{SignBytes: tm.VoteSignBytes("chain-id", &vote)}
+
+	b.Run("new-connection", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				remoteClient, err := client.New(address)
+				require.NoError(b, err)
+				_, err = remoteClient.Call(context.Background(), "Sign", map[string]interface{}{
+					"arg": RpcSignRequest{SignBytes: signReq.SignBytes},
+				}, &CosignerSignResponse{})
+				require.NoError(b, err)
+			}
+		})
+	})
+
+	for _, transport := range []string{CosignerTransportHTTP1, CosignerTransportH2C} {
+		remoteCosigner := NewRemoteCosigner(2, address)
+		require.NoError(b, remoteCosigner.SetTransport(transport))
+
+		b.Run(transport, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := remoteCosigner.Sign(context.Background(), signReq)
+					require.NoError(b, err)
+				}
+			})
+		})
+	}
+}
+
+func TestResyncSignState(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	newCosigner := func(id int, height int64) *LocalCosigner {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(test, err)
+		privateKey := tmCryptoEd25519.GenPrivKey()
+
+		signState, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "state.json"))
+		require.NoError(test, err)
+		signState.Height = height
+		signState.Step = stepPrecommit
+		require.NoError(test, signState.Save())
+
+		return NewLocalCosigner(LocalCosignerConfig{
+			CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ShareKey: privateKey[:32], ID: id},
+			SignState:   &signState,
+			RsaKey:      *rsaKey,
+			Total:       2,
+			Threshold:   2,
+		})
+	}
+
+	local := newCosigner(1, 10)
+	peer := newCosigner(2, 20)
+
+	rpcServer := NewCosignerRpcServer(&CosignerRpcServerConfig{
+		Logger:        logger,
+		ListenAddress: "tcp://127.0.0.1:0",
+		Cosigner:      peer,
+	})
+	require.NoError(test, rpcServer.Start())
+	defer rpcServer.Stop()
+	remotePeer := NewRemoteCosigner(2, "tcp://"+rpcServer.Addr().String())
+
+	result, err := ResyncSignState(context.Background(), local, []*RemoteCosigner{remotePeer}, logger)
+	require.NoError(test, err)
+	require.True(test, result.Advanced)
+	require.Equal(test, HRSKey{Height: 20, Step: stepPrecommit}, local.SignStateWatermark())
+
+	// persisted, and nothing is signed at the new watermark
+	saved, err := local.lastSignState.store.Load()
+	require.NoError(test, err)
+	require.Equal(test, int64(20), saved.Height)
+	require.Nil(test, saved.SignBytes)
+
+	// a peer behind us never lowers the watermark
+	_, err = peer.AdvanceSignState(HRSKey{Height: 5})
+	require.NoError(test, err)
+	local.lastSignState.Height = 30
+	result, err = ResyncSignState(context.Background(), local, []*RemoteCosigner{remotePeer}, logger)
+	require.NoError(test, err)
+	require.False(test, result.Advanced)
+	require.Equal(test, int64(30), result.Height)
+
+	// no watermark to sync from
+	unreachable := NewRemoteCosigner(3, "tcp://127.0.0.1:1")
+	_, err = ResyncSignState(context.Background(), local, []*RemoteCosigner{unreachable}, logger)
+	require.Error(test, err)
+}
@@ -0,0 +1,168 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	tmJson "github.com/tendermint/tendermint/libs/json"
+)
+
+// time an etcd request may take, a save taking longer fails
+const etcdRequestTimeout = 5 * time.Second
+
+// EtcdSignStateStore keeps the sign state under a key of an etcd v3 cluster, through the json gateway
+// of its client endpoint, so that several signers, e.g. a hot-standby pair, share the same watermark.
+//
+// CompareAndSave reads the key, checks its height, round and step against prev, and writes next in a
+// transaction on the revision read, so a write by another signer in between fails the save with
+// ErrSignStateConflict. The reads are linearizable, the etcd default, never served by a lagging member.
+type EtcdSignStateStore struct {
+	address string
+	key     string
+	client  *http.Client
+}
+
+// NewEtcdSignStateStore returns a store for the sign state under key of the etcd cluster at address,
+// e.g. http://10.0.0.5:2379
+func NewEtcdSignStateStore(address string, key string) *EtcdSignStateStore {
+	return &EtcdSignStateStore{
+		address: strings.TrimSuffix(address, "/"),
+		key:     key,
+		client:  &http.Client{Timeout: etcdRequestTimeout},
+	}
+}
+
+type etcdKeyValue struct {
+	ModRevision int64  `json:"mod_revision,string"`
+	Value       []byte `json:"value"`
+}
+
+type etcdRangeRequest struct {
+	Key []byte `json:"key"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdCompare struct {
+	Key         []byte `json:"key"`
+	Target      string `json:"target"`
+	Result      string `json:"result"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdRequestOp struct {
+	RequestPut etcdPutRequest `json:"request_put"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Succeeded bool `json:"succeeded"`
+}
+
+// Load implements SignStateStore
+func (store *EtcdSignStateStore) Load() (SignState, error) {
+	state, _, err := store.load()
+	return state, err
+}
+
+// load returns the stored sign state and the revision it was written at
+func (store *EtcdSignStateStore) load() (SignState, int64, error) {
+	state := SignState{}
+	var response etcdRangeResponse
+	if err := store.post("/v3/kv/range", etcdRangeRequest{Key: []byte(store.key)}, &response); err != nil {
+		return state, 0, err
+	}
+	if len(response.Kvs) == 0 {
+		return state, 0, &os.PathError{Op: "load", Path: store.String(), Err: os.ErrNotExist}
+	}
+
+	err := tmJson.Unmarshal(response.Kvs[0].Value, &state)
+	return state, response.Kvs[0].ModRevision, err
+}
+
+// CompareAndSave implements SignStateStore
+func (store *EtcdSignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	stored, revision, err := store.load()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// a save retried after its transaction went through but the response was lost
+	if revision != 0 && checkSameHRS(stored, next) == nil && bytes.Equal(stored.Signature, next.Signature) {
+		return nil
+	}
+	if err := checkSameHRS(stored, prev); err != nil {
+		return err
+	}
+
+	value, err := tmJson.Marshal(&next)
+	if err != nil {
+		return err
+	}
+
+	// the mod revision of a key that does not exist is 0, so the first save creates it
+	txn := etcdTxnRequest{
+		Compare: []etcdCompare{{Key: []byte(store.key), Target: "MOD", Result: "EQUAL", ModRevision: revision}},
+		Success: []etcdRequestOp{{RequestPut: etcdPutRequest{Key: []byte(store.key), Value: value}}},
+	}
+	var response etcdTxnResponse
+	if err := store.post("/v3/kv/txn", txn, &response); err != nil {
+		return err
+	}
+	if !response.Succeeded {
+		return fmt.Errorf("%w: %s was written since revision %d", ErrSignStateConflict, store, revision)
+	}
+	return nil
+}
+
+// post posts request to the json gateway at path and decodes its answer into response
+func (store *EtcdSignStateStore) post(path string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, store.address+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := store.client.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(httpResponse.Body, 512))
+		return fmt.Errorf("etcd %s answered %d: %s", path, httpResponse.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
+
+// String returns the address and key
+func (store *EtcdSignStateStore) String() string {
+	return store.address + "/" + store.key
+}
@@ -0,0 +1,98 @@
+package signer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEtcd serves the range and txn endpoints of the etcd json gateway for a single key
+type fakeEtcd struct {
+	mtx         sync.Mutex
+	value       []byte
+	modRevision int64
+	revision    int64
+
+	// answers the next txn with an error after applying it, as if the response was lost
+	loseResponse bool
+}
+
+func (etcd *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	etcd.mtx.Lock()
+	defer etcd.mtx.Unlock()
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		response := etcdRangeResponse{}
+		if etcd.modRevision != 0 {
+			response.Kvs = []etcdKeyValue{{ModRevision: etcd.modRevision, Value: etcd.value}}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	case "/v3/kv/txn":
+		var txn etcdTxnRequest
+		if err := json.NewDecoder(r.Body).Decode(&txn); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response := etcdTxnResponse{}
+		if txn.Compare[0].Target == "MOD" && txn.Compare[0].ModRevision == etcd.modRevision {
+			etcd.revision++
+			etcd.modRevision = etcd.revision
+			etcd.value = txn.Success[0].RequestPut.Value
+			response.Succeeded = true
+		}
+		if etcd.loseResponse {
+			etcd.loseResponse = false
+			http.Error(w, "lost", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcdSignStateStore(test *testing.T) {
+	etcd := &fakeEtcd{}
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+
+	active, err := LoadOrCreateSignStateFrom(NewEtcdSignStateStore(server.URL, "signer/state"))
+	require.NoError(test, err)
+	require.Equal(test, int64(1), etcd.modRevision)
+
+	// the standby loads the same watermark
+	standby, err := LoadOrCreateSignStateFrom(NewEtcdSignStateStore(server.URL+"/", "signer/state"))
+	require.NoError(test, err)
+	require.Equal(test, int64(1), etcd.modRevision)
+
+	active.Height = 10
+	active.Signature = []byte("active")
+	require.NoError(test, active.Save())
+
+	// the standby still holds the state before, its save is refused
+	standby.Height = 10
+	standby.Signature = []byte("standby")
+	require.True(test, errors.Is(standby.Save(), ErrSignStateConflict))
+
+	stored, err := LoadSignStateFrom(NewEtcdSignStateStore(server.URL, "signer/state"))
+	require.NoError(test, err)
+	require.Equal(test, []byte("active"), stored.Signature)
+
+	// a save whose response was lost is retried as done
+	etcd.loseResponse = true
+	active.Height = 11
+	require.Error(test, active.Save())
+	require.NoError(test, active.Save())
+	active.Height = 12
+	require.NoError(test, active.Save())
+
+	stored, err = LoadSignStateFrom(NewEtcdSignStateStore(server.URL, "signer/state"))
+	require.NoError(test, err)
+	require.Equal(test, int64(12), stored.Height)
+}
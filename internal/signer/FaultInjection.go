@@ -0,0 +1,128 @@
+package signer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrFaultInjected is returned for a cosigner response dropped by the fault injector
+var ErrFaultInjected = errors.New("fault injection: cosigner response dropped")
+
+// FaultConfig is the share of the responses of a remote cosigner that the fault injector tampers with,
+// each between 0 and 1
+type FaultConfig struct {
+	Cosigner int `json:"cosigner"`
+
+	// responses replaced by ErrFaultInjected, after the cosigner handled the request
+	Drop float64 `json:"drop"`
+
+	// responses held back for DelayMs, or until the request is done
+	Delay   float64 `json:"delay"`
+	DelayMs int     `json:"delay_ms"`
+
+	// responses with a bit flipped in each of their byte fields, e.g. the signature
+	Corrupt float64 `json:"corrupt"`
+}
+
+// Validate checks the shares are between 0 and 1
+func (faults FaultConfig) Validate() error {
+	for name, share := range map[string]float64{"drop": faults.Drop, "delay": faults.Delay, "corrupt": faults.Corrupt} {
+		if share < 0 || share > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, got %v", name, share)
+		}
+	}
+	if faults.DelayMs < 0 {
+		return fmt.Errorf("delay_ms must not be negative, got %d", faults.DelayMs)
+	}
+	return nil
+}
+
+// FaultInjector drops, delays or corrupts the responses of a remote cosigner, for chaos testing a quorum.
+// It is only wired up in binaries built with the faultinjection build tag, see FaultInjectionBuild.
+type FaultInjector struct {
+	mtx    sync.Mutex
+	faults FaultConfig
+	rand   *rand.Rand
+}
+
+// NewFaultInjector returns a FaultInjector that leaves every response alone until Set
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Set replaces the faults injected from now on
+func (injector *FaultInjector) Set(faults FaultConfig) error {
+	if err := faults.Validate(); err != nil {
+		return err
+	}
+	injector.mtx.Lock()
+	defer injector.mtx.Unlock()
+	injector.faults = faults
+	return nil
+}
+
+// Faults returns the faults currently injected
+func (injector *FaultInjector) Faults() FaultConfig {
+	injector.mtx.Lock()
+	defer injector.mtx.Unlock()
+	return injector.faults
+}
+
+// roll returns whether each fault applies to a response
+func (injector *FaultInjector) roll() (drop bool, delay time.Duration, corrupt bool) {
+	injector.mtx.Lock()
+	defer injector.mtx.Unlock()
+	faults := injector.faults
+	drop = injector.rand.Float64() < faults.Drop
+	if injector.rand.Float64() < faults.Delay {
+		delay = time.Duration(faults.DelayMs) * time.Millisecond
+	}
+	corrupt = injector.rand.Float64() < faults.Corrupt
+	return drop, delay, corrupt
+}
+
+// apply tampers with the outcome of an rpc, result being the decoded response if err is nil
+func (injector *FaultInjector) apply(ctx context.Context, result interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	drop, delay, corrupt := injector.roll()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if drop {
+		return ErrFaultInjected
+	}
+	if corrupt {
+		corruptBytes(reflect.ValueOf(result))
+	}
+	return nil
+}
+
+// corruptBytes flips a bit of every non-empty byte slice field of the struct value points to
+func corruptBytes(value reflect.Value) {
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return
+	}
+	for idx := 0; idx < value.NumField(); idx++ {
+		field := value.Field(idx)
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 && field.Len() > 0 {
+			field.Index(0).SetUint(field.Index(0).Uint() ^ 1)
+		}
+	}
+}
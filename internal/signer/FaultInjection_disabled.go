@@ -0,0 +1,7 @@
+//go:build !faultinjection
+// +build !faultinjection
+
+package signer
+
+// FaultInjectionBuild is true in binaries built with the faultinjection build tag, see FaultInjection_enabled.go
+const FaultInjectionBuild = false
@@ -0,0 +1,8 @@
+//go:build faultinjection
+// +build faultinjection
+
+package signer
+
+// FaultInjectionBuild is true in binaries built with the faultinjection build tag, which serve /faults
+// on the admin server to tamper with the responses of the remote cosigners. Never run such a build in production.
+const FaultInjectionBuild = true
@@ -0,0 +1,49 @@
+package signer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjector(test *testing.T) {
+	injector := NewFaultInjector()
+	response := func() *CosignerSignResponse {
+		return &CosignerSignResponse{Signature: []byte{2, 4}, EphemeralPublic: []byte{8}}
+	}
+
+	// nothing is injected until set
+	result := response()
+	require.NoError(test, injector.apply(context.Background(), result, nil))
+	require.Equal(test, response(), result)
+
+	require.Error(test, injector.Set(FaultConfig{Cosigner: 2, Drop: 1.5}))
+	require.Error(test, injector.Set(FaultConfig{Cosigner: 2, DelayMs: -1}))
+
+	require.NoError(test, injector.Set(FaultConfig{Cosigner: 2, Drop: 1}))
+	require.Equal(test, ErrFaultInjected, injector.apply(context.Background(), response(), nil))
+
+	// errors of the rpc itself are left alone
+	rpcErr := errors.New("connection refused")
+	require.Equal(test, rpcErr, injector.apply(context.Background(), response(), rpcErr))
+
+	require.NoError(test, injector.Set(FaultConfig{Cosigner: 2, Corrupt: 1}))
+	result = response()
+	require.NoError(test, injector.apply(context.Background(), result, nil))
+	require.Equal(test, []byte{3, 4}, result.Signature)
+	require.Equal(test, []byte{9}, result.EphemeralPublic)
+
+	require.NoError(test, injector.Set(FaultConfig{Cosigner: 2, Delay: 1, DelayMs: 50}))
+	start := time.Now()
+	require.NoError(test, injector.apply(context.Background(), response(), nil))
+	require.GreaterOrEqual(test, int64(time.Since(start)), int64(50*time.Millisecond))
+
+	// a delay ends with the request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.NoError(test, injector.Set(FaultConfig{Cosigner: 2, Delay: 1, DelayMs: 10000}))
+	require.Equal(test, context.DeadlineExceeded, injector.apply(ctx, response(), nil))
+}
@@ -0,0 +1,86 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHandoffTimeoutSeconds is the default handoff_timeout
+const DefaultHandoffTimeoutSeconds = 10
+
+// time between the attempts to hand off while the standby does not acknowledge
+const handoffRetryInterval = 500 * time.Millisecond
+
+// ErrBelowHandoff is returned for signing requests at or below the watermark handed over by the previous active signer
+var ErrBelowHandoff = errors.New("at or below the watermark handed over by the previous signer, refusing to sign")
+
+// Handoff is the watermark posted to /handoff of the standby, and the watermark it acknowledges in reply
+type Handoff struct {
+	Height int64 `json:"height"`
+	Round  int64 `json:"round"`
+	Step   int8  `json:"step"`
+}
+
+// HRS returns the height, round and step of the watermark
+func (handoff Handoff) HRS() HRSKey {
+	return HRSKey{Height: handoff.Height, Round: handoff.Round, Step: handoff.Step}
+}
+
+// HandOff posts watermark, the last height, round and step signed, to the admin endpoints at url
+// until the standby acknowledges a watermark at or above it, or ctx is done.
+// The standby then signs above the watermark only.
+func HandOff(ctx context.Context, url string, watermark HRSKey) (Handoff, error) {
+	body, err := json.Marshal(Handoff{Height: watermark.Height, Round: watermark.Round, Step: watermark.Step})
+	if err != nil {
+		return Handoff{}, err
+	}
+	url = strings.TrimSuffix(url, "/") + "/handoff"
+
+	for {
+		ack, err := postHandoff(ctx, url, body)
+		if err == nil {
+			acknowledged := ack.HRS()
+			if !acknowledged.Less(watermark) {
+				return ack, nil
+			}
+			err = fmt.Errorf("standby acknowledged height %d round %d step %d, below ours", ack.Height, ack.Round, ack.Step)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ack, fmt.Errorf("handoff not acknowledged: %w", err)
+		case <-time.After(handoffRetryInterval):
+		}
+	}
+}
+
+func postHandoff(ctx context.Context, url string, body []byte) (Handoff, error) {
+	var ack Handoff
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return ack, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return ack, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return ack, fmt.Errorf("standby answered %d: %s", response.StatusCode, strings.TrimSpace(string(message)))
+	}
+	err = json.NewDecoder(response.Body).Decode(&ack)
+	return ack, err
+}
@@ -0,0 +1,50 @@
+package signer
+
+import "fmt"
+
+// The states reported by /health
+const (
+	HealthHealthy   = "healthy"
+	HealthDegraded  = "degraded"
+	HealthUnhealthy = "unhealthy"
+)
+
+// Health is the state of the signer reported by /health
+//
+// healthy: a node is connected and, in mpc mode, every cosigner returned a share for the last block
+// degraded: as healthy, but some cosigners did not return a share, while at least threshold did
+// unhealthy: no node is ready, or fewer than threshold cosigners returned a share for the last block
+type Health struct {
+	Status string `json:"status"`
+
+	// why the signer is not healthy
+	Reason string `json:"reason,omitempty"`
+
+	// in mpc mode, the cosigners, ourselves included if we hold a share, that returned a share for the last block
+	CosignersReachable int `json:"cosigners_reachable,omitempty"`
+	CosignersTotal     int `json:"cosigners_total,omitempty"`
+	CosignerThreshold  int `json:"cosigner_threshold,omitempty"`
+}
+
+// cosignerHealth returns the health of a quorum in which reachable of total cosigners returned a share
+// for the last block, -1 before the first block
+func cosignerHealth(reachable int, total int, threshold int) Health {
+	health := Health{
+		Status:             HealthHealthy,
+		CosignersReachable: reachable,
+		CosignersTotal:     total,
+		CosignerThreshold:  threshold,
+	}
+	switch {
+	case reachable == noBlockYet:
+		health.CosignersReachable = 0
+		health.Reason = "no block signed yet, the cosigners are checked with the first one"
+	case reachable < threshold:
+		health.Status = HealthUnhealthy
+		health.Reason = fmt.Sprintf("%d of %d required cosigners reachable", reachable, threshold)
+	case reachable < total:
+		health.Status = HealthDegraded
+		health.Reason = fmt.Sprintf("%d of %d cosigners reachable", reachable, total)
+	}
+	return health
+}
@@ -0,0 +1,77 @@
+package signer
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func TestCosignerHealth(test *testing.T) {
+	require.Equal(test, HealthHealthy, cosignerHealth(noBlockYet, 3, 2).Status)
+	require.Equal(test, HealthUnhealthy, cosignerHealth(0, 3, 2).Status)
+	require.Equal(test, HealthHealthy, cosignerHealth(3, 3, 2).Status)
+	require.Equal(test, HealthDegraded, cosignerHealth(2, 3, 2).Status)
+	require.Equal(test, HealthUnhealthy, cosignerHealth(1, 3, 2).Status)
+}
+
+func TestCoordinatorHealthOutage(test *testing.T) {
+	_, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	signState, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "coordinator_state.json"))
+	require.NoError(test, err)
+	coordinator := NewThresholdValidator(&ThresholdValidatorOpt{
+		Pubkey:    privateKey.PubKey(),
+		Threshold: 2,
+		SignState: signState,
+		Peers: []Cosigner{
+			&unreachableCosigner{Cosigner: cosigner1, down: true},
+			&unreachableCosigner{Cosigner: cosigner2, down: true},
+		},
+	})
+	require.Equal(test, HealthHealthy, cosignerHealth(coordinator.ReachableCosigners(), 2, 2).Status)
+
+	// a coordinator holds no share, none of the cosigners answering is an outage
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1}
+	require.Error(test, coordinator.SignVote("chain-id", &vote))
+	require.Equal(test, 0, coordinator.ReachableCosigners())
+	require.Equal(test, HealthUnhealthy, cosignerHealth(coordinator.ReachableCosigners(), 2, 2).Status)
+}
+
+func TestAdminServerHealth(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	guard := &PvGuard{PrivValidator: tm.NewMockPV()}
+
+	health := cosignerHealth(2, 3, 2)
+	adminServer := NewAdminServer("tcp://127.0.0.1:0", guard, logger)
+	adminServer.SetHealth(func() Health { return health })
+	require.NoError(test, adminServer.Start())
+	defer adminServer.Stop()
+
+	get := func() (int, Health) {
+		resp, err := http.Get("http://" + adminServer.Addr().String() + "/health")
+		require.NoError(test, err)
+		defer resp.Body.Close()
+
+		var health Health
+		require.NoError(test, json.NewDecoder(resp.Body).Decode(&health))
+		return resp.StatusCode, health
+	}
+
+	// degraded still serves
+	code, got := get()
+	require.Equal(test, http.StatusOK, code)
+	require.Equal(test, HealthDegraded, got.Status)
+	require.Equal(test, 2, got.CosignersReachable)
+
+	health = cosignerHealth(1, 3, 2)
+	code, got = get()
+	require.Equal(test, http.StatusServiceUnavailable, code)
+	require.Equal(test, HealthUnhealthy, got.Status)
+}
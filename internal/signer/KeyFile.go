@@ -0,0 +1,81 @@
+package signer
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	tmjson "github.com/tendermint/tendermint/libs/json"
+	"github.com/tendermint/tendermint/privval"
+)
+
+const (
+	// StdinKeyFile reads the key from stdin
+	StdinKeyFile = "-"
+
+	// fdKeyFilePrefix reads the key from an inherited file descriptor, e.g. "fd:3"
+	fdKeyFilePrefix = "fd:"
+)
+
+// IsKeyFileStream returns true if name refers to stdin or a file descriptor rather than a path.
+// Keys read from a stream never touch the filesystem and cannot be written back.
+func IsKeyFileStream(name string) bool {
+	return name == StdinKeyFile || strings.HasPrefix(name, fdKeyFilePrefix)
+}
+
+// OpenKeyFile opens a key for reading.
+// name is either a path, "-" for stdin or "fd:N" for file descriptor N, which lets a
+// secret manager (vault agent, systemd credentials) hand over the key without writing it to disk.
+func OpenKeyFile(name string) (io.ReadCloser, error) {
+	if name == StdinKeyFile {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+
+	if strings.HasPrefix(name, fdKeyFilePrefix) {
+		fd, err := strconv.ParseUint(strings.TrimPrefix(name, fdKeyFilePrefix), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file descriptor %q: %w", name, err)
+		}
+		file := os.NewFile(uintptr(fd), name)
+		if file == nil {
+			return nil, fmt.Errorf("invalid key file descriptor %q", name)
+		}
+		return file, nil
+	}
+
+	return os.Open(name)
+}
+
+// ReadFilePV reads a FilePV key from reader.
+// The last sign state is loaded from stateFile if it exists, otherwise it starts empty.
+// keyFile is only recorded in the FilePV, the key is never written back.
+func ReadFilePV(reader io.Reader, keyFile string, stateFile string) (*privval.FilePV, error) {
+	keyJSONBytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	pvKey := privval.FilePVKey{}
+	err = tmjson.Unmarshal(keyJSONBytes, &pvKey)
+	if err != nil {
+		return nil, fmt.Errorf("error reading PrivValidator key from %v: %w", keyFile, err)
+	}
+
+	pv := privval.NewFilePV(pvKey.PrivKey, keyFile, stateFile)
+
+	if fileExists(stateFile) {
+		stateJSONBytes, err := ioutil.ReadFile(stateFile)
+		if err != nil {
+			return nil, err
+		}
+		err = tmjson.Unmarshal(stateJSONBytes, &pv.LastSignState)
+		if err != nil {
+			return nil, fmt.Errorf("error reading PrivValidator state from %v: %w", stateFile, err)
+		}
+	}
+
+	return pv, nil
+}
@@ -0,0 +1,78 @@
+package signer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/crypto/secp256k1"
+	"github.com/tendermint/tendermint/privval"
+)
+
+func TestOpenKeyFileDescriptor(test *testing.T) {
+	keyJSONBytes, err := ioutil.ReadFile("../../test/cosigner-key.json")
+	require.NoError(test, err)
+
+	reader, writer, err := os.Pipe()
+	require.NoError(test, err)
+
+	go func() {
+		writer.Write(keyJSONBytes)
+		writer.Close()
+	}()
+
+	key, err := LoadCosignerKey(fmt.Sprintf("fd:%d", reader.Fd()))
+	require.NoError(test, err)
+	require.Equal(test, key.ID, 3)
+}
+
+func TestOpenKeyFileInvalidDescriptor(test *testing.T) {
+	_, err := OpenKeyFile("fd:three")
+	require.Error(test, err)
+}
+
+func TestIsKeyFileStream(test *testing.T) {
+	require.True(test, IsKeyFileStream("-"))
+	require.True(test, IsKeyFileStream("fd:3"))
+	require.False(test, IsKeyFileStream("/path/to/priv_validator_key.json"))
+}
+
+func TestReadFilePV(test *testing.T) {
+	dir, err := ioutil.TempDir("", "keyfile")
+	require.NoError(test, err)
+	defer os.RemoveAll(dir)
+
+	keyFile := path.Join(dir, "priv_validator_key.json")
+	stateFile := path.Join(dir, "priv_validator_state.json")
+	filePV := privval.GenFilePV(keyFile, stateFile)
+	filePV.Save()
+
+	reader, err := os.Open(keyFile)
+	require.NoError(test, err)
+	defer reader.Close()
+
+	pv, err := ReadFilePV(reader, StdinKeyFile, stateFile)
+	require.NoError(test, err)
+	require.Equal(test, filePV.Key.PubKey, pv.Key.PubKey)
+	require.Equal(test, filePV.Key.Address, pv.Key.Address)
+}
+
+func TestReadFilePVSecp256k1(test *testing.T) {
+	dir := test.TempDir()
+	keyFile := path.Join(dir, "priv_validator_key.json")
+	stateFile := path.Join(dir, "priv_validator_state.json")
+	filePV := privval.NewFilePV(secp256k1.GenPrivKey(), keyFile, stateFile)
+	filePV.Save()
+
+	reader, err := os.Open(keyFile)
+	require.NoError(test, err)
+	defer reader.Close()
+
+	pv, err := ReadFilePV(reader, keyFile, stateFile)
+	require.NoError(test, err)
+	require.Equal(test, filePV.Key.PubKey, pv.Key.PubKey)
+	require.IsType(test, secp256k1.PubKey{}, pv.Key.PubKey)
+}
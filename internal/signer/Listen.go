@@ -0,0 +1,225 @@
+package signer
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tendermint/tendermint/libs/log"
+	tmnet "github.com/tendermint/tendermint/libs/net"
+)
+
+// listen listens on listenAddress, e.g. tcp://0.0.0.0:2222, tcp6://[::1]:2222 or unix:///path.
+// With dualStack, a tcp address on all interfaces (no host, 0.0.0.0 or ::) listens on IPv4 and
+// IPv6 separately, instead of relying on the OS to map IPv4 onto an IPv6 socket.
+// A socket of the same address passed by systemd socket activation is used instead of listening anew.
+func listen(listenAddress string, dualStack bool, logger log.Logger) (net.Listener, error) {
+	proto, address := tmnet.ProtocolAndAddress(listenAddress)
+	if lis := takeActivatedListener(proto, address, logger); lis != nil {
+		return lis, nil
+	}
+	if !dualStack || proto != "tcp" {
+		return net.Listen(proto, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			return net.Listen(proto, address)
+		}
+	}
+
+	lis4, err := net.Listen("tcp4", net.JoinHostPort("0.0.0.0", port))
+	if err != nil {
+		return nil, err
+	}
+
+	// the same port on both stacks, also when the port was picked by the OS
+	port = strconv.Itoa(lis4.Addr().(*net.TCPAddr).Port)
+	lis6, err := net.Listen("tcp6", net.JoinHostPort("::", port))
+	if err != nil {
+		lis4.Close()
+		return nil, err
+	}
+
+	return newDualListener(lis4, lis6), nil
+}
+
+// the first file descriptor passed by systemd socket activation, see sd_listen_fds(3)
+const listenFdsStart = 3
+
+// activated holds the sockets passed by systemd socket activation that were not taken yet
+var activated struct {
+	sync.Mutex
+	loaded    bool
+	listeners []net.Listener
+}
+
+// takeActivatedListener returns the socket passed by systemd listening on address, or nil if there is none.
+// Addresses match by IP and port, or path for unix sockets, so configure the listen addresses with IPs.
+func takeActivatedListener(proto string, address string, logger log.Logger) net.Listener {
+	activated.Lock()
+	defer activated.Unlock()
+	if !activated.loaded {
+		activated.listeners = loadActivatedListeners(logger)
+		activated.loaded = true
+	}
+
+	for idx, lis := range activated.listeners {
+		if sameListenAddress(lis.Addr(), proto, address) {
+			activated.listeners = append(activated.listeners[:idx], activated.listeners[idx+1:]...)
+			return lis
+		}
+	}
+	return nil
+}
+
+// loadActivatedListeners returns the sockets passed by systemd if LISTEN_PID is our pid.
+// The variables are unset so that child processes do not take the sockets as well.
+func loadActivatedListeners(logger log.Logger) []net.Listener {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil {
+		return nil
+	}
+
+	listeners := []net.Listener{}
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		lis, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			logger.Error("Socket activation, file descriptor is not a listening socket", "fd", fd, "err", err)
+			continue
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners
+}
+
+// sameListenAddress returns true if addr is the address a listener on proto and address would listen on
+func sameListenAddress(addr net.Addr, proto string, address string) bool {
+	switch addr := addr.(type) {
+	case *net.UnixAddr:
+		return strings.HasPrefix(proto, "unix") && addr.Name == address
+	case *net.TCPAddr:
+		if !strings.HasPrefix(proto, "tcp") {
+			return false
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || port != strconv.Itoa(addr.Port) {
+			return false
+		}
+		ip := net.ParseIP(host)
+		if host == "" || (ip != nil && ip.IsUnspecified()) {
+			return addr.IP.IsUnspecified()
+		}
+		return ip != nil && ip.Equal(addr.IP)
+	}
+	return false
+}
+
+// dualListener accepts the connections of an IPv4 and an IPv6 listener
+type dualListener struct {
+	listeners []net.Listener
+	conns     chan net.Conn
+	errs      chan error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newDualListener(listeners ...net.Listener) *dualListener {
+	dual := &dualListener{
+		listeners: listeners,
+		conns:     make(chan net.Conn),
+		errs:      make(chan error, len(listeners)),
+		closed:    make(chan struct{}),
+	}
+	for _, lis := range listeners {
+		go dual.accept(lis)
+	}
+	return dual
+}
+
+// longest wait before accepting again after a temporary error, e.g. running out of file descriptors
+const maxAcceptRetryDelay = time.Second
+
+func (dual *dualListener) accept(lis net.Listener) {
+	var retryDelay time.Duration
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			// retried with backoff like http.Server.Serve does, instead of closing both stacks for good
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				if retryDelay == 0 {
+					retryDelay = 5 * time.Millisecond
+				} else if retryDelay *= 2; retryDelay > maxAcceptRetryDelay {
+					retryDelay = maxAcceptRetryDelay
+				}
+				select {
+				case <-time.After(retryDelay):
+					continue
+				case <-dual.closed:
+					return
+				}
+			}
+			dual.errs <- err
+			return
+		}
+		retryDelay = 0
+
+		select {
+		case dual.conns <- conn:
+		case <-dual.closed:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Accept implements net.Listener
+func (dual *dualListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-dual.conns:
+		return conn, nil
+	case err := <-dual.errs:
+		// one of the stacks failed, stop serving the other as well
+		dual.Close()
+		return nil, err
+	case <-dual.closed:
+		return nil, errors.New("use of closed network connection")
+	}
+}
+
+// Close implements net.Listener
+func (dual *dualListener) Close() error {
+	var err error
+	dual.closeOnce.Do(func() {
+		close(dual.closed)
+		for _, lis := range dual.listeners {
+			if closeErr := lis.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the IPv4 listener
+func (dual *dualListener) Addr() net.Addr {
+	return dual.listeners[0].Addr()
+}
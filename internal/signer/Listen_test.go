@@ -0,0 +1,97 @@
+package signer
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func TestListenSocketActivation(test *testing.T) {
+	inherited, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer inherited.Close()
+	port := strconv.Itoa(inherited.Addr().(*net.TCPAddr).Port)
+
+	activated.Lock()
+	loaded, listeners := activated.loaded, activated.listeners
+	activated.loaded, activated.listeners = true, []net.Listener{inherited}
+	activated.Unlock()
+	defer func() {
+		activated.Lock()
+		activated.loaded, activated.listeners = loaded, listeners
+		activated.Unlock()
+	}()
+
+	// another address binds a new socket
+	lis, err := listen("tcp://127.0.0.1:0", false, log.NewNopLogger())
+	require.NoError(test, err)
+	lis.Close()
+
+	lis, err = listen("tcp://127.0.0.1:"+port, true, log.NewNopLogger())
+	require.NoError(test, err)
+	require.Equal(test, inherited, lis)
+
+	// taken once only, listening again on the address fails as it is in use
+	_, err = listen("tcp://127.0.0.1:"+port, false, log.NewNopLogger())
+	require.Error(test, err)
+}
+
+func TestSameListenAddress(test *testing.T) {
+	any4 := &net.TCPAddr{IP: net.IPv4zero, Port: 2222}
+	any6 := &net.TCPAddr{IP: net.IPv6unspecified, Port: 2222}
+	local := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2222}
+
+	require.True(test, sameListenAddress(any4, "tcp", "0.0.0.0:2222"))
+	require.True(test, sameListenAddress(any6, "tcp", ":2222"))
+	require.True(test, sameListenAddress(local, "tcp", "127.0.0.1:2222"))
+	require.False(test, sameListenAddress(local, "tcp", "127.0.0.1:2223"))
+	require.False(test, sameListenAddress(local, "tcp", "0.0.0.0:2222"))
+	require.False(test, sameListenAddress(any4, "tcp", "127.0.0.1:2222"))
+	require.False(test, sameListenAddress(local, "unix", "127.0.0.1:2222"))
+
+	require.True(test, sameListenAddress(&net.UnixAddr{Name: "/run/signer.sock", Net: "unix"}, "unix", "/run/signer.sock"))
+}
+
+// temporaryError is a temporary accept error, like running out of file descriptors
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails the first failures accepts with a temporary error
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (lis *flakyListener) Accept() (net.Conn, error) {
+	if lis.failures > 0 {
+		lis.failures--
+		return nil, temporaryError{}
+	}
+	return lis.Listener.Accept()
+}
+
+func TestDualListenerTemporaryError(test *testing.T) {
+	ipv4, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	ipv6, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+
+	// both stacks keep accepting after a temporary error of one
+	dual := newDualListener(&flakyListener{Listener: ipv4, failures: 3}, ipv6)
+	defer dual.Close()
+	for _, lis := range []net.Listener{ipv4, ipv6} {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		require.NoError(test, err)
+		defer conn.Close()
+
+		accepted, err := dual.Accept()
+		require.NoError(test, err)
+		accepted.Close()
+	}
+}
@@ -2,16 +2,20 @@ package signer
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
 	tmJson "github.com/tendermint/tendermint/libs/json"
+	"github.com/tendermint/tendermint/libs/log"
 	"gitlab.com/polychainlabs/edwards25519"
 	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
 )
@@ -55,6 +59,9 @@ func (hrsKey *HRSKey) Less(other HRSKey) bool {
 type CosignerPeer struct {
 	ID        int
 	PublicKey rsa.PublicKey
+
+	// the peer's replaced public key, accepted while the peer rotates its rsa key
+	PreviousPublicKey *rsa.PublicKey
 }
 
 type LocalCosignerConfig struct {
@@ -64,6 +71,25 @@ type LocalCosignerConfig struct {
 	Peers       []CosignerPeer
 	Total       uint8
 	Threshold   uint8
+
+	// our replaced rsa key while an rsa key rotation is in progress
+	PreviousRsaKey *rsa.PrivateKey
+
+	// optional, records the public ephemeral commitments of every HRS
+	AuditLog *AuditLog
+
+	// optional, the height from which the next key of CosignerKey signs, 0 to only sign with its key
+	SwitchHeight int64
+
+	// optional, reports the size of the ephemeral metadata cache
+	Metrics *Metrics
+
+	// optional, defaults to a logger discarding everything
+	Logger log.Logger
+
+	// optional, bounds the rsa operations running at once,
+	// defaults to runtime.NumCPU() workers with DefaultRSAQueueLength waiting
+	RSAPool *RSAWorkerPool
 }
 
 type PeerMetadata struct {
@@ -76,18 +102,34 @@ type HrsMetadata struct {
 	Secret      []byte
 	DealtShares []tsed25519.Scalar
 	Peers       []PeerMetadata
+
+	// when our secret was dealt, for the eviction by age
+	Created time.Time
 }
 
+// DefaultMaxEphemeralEntries bounds the number of HRS a LocalCosigner keeps ephemeral metadata for.
+// Entries are normally dropped once our share signs a higher HRS, this only bounds the cache
+// when parts keep arriving for heights our share is never asked to sign.
+const DefaultMaxEphemeralEntries = 1000
+
+// DefaultEphemeralMaxAge is the age after which ephemeral metadata below the newest height is evicted
+const DefaultEphemeralMaxAge = 5 * time.Minute
+
 // LocalCosigner responds to sign requests using their share key
 // The cosigner maintains a watermark to avoid double-signing
 //
 // LocalCosigner signing is thread saafe
 type LocalCosigner struct {
-	pubKeyBytes []byte
-	key         CosignerKey
-	rsaKey      rsa.PrivateKey
-	total       uint8
-	threshold   uint8
+	pubKeyBytes    []byte
+	key            CosignerKey
+	rsaKey         rsa.PrivateKey
+	previousRsaKey *rsa.PrivateKey
+	total          uint8
+	threshold      uint8
+
+	// the key signing from switchHeight on, if the chain switches validator keys
+	nextPubKeyBytes []byte
+	switchHeight    int64
 
 	// stores the last sign state for a share we have fully signed
 	// incremented whenever we are asked to sign a share
@@ -99,17 +141,64 @@ type LocalCosigner struct {
 	// Height, Round, Step -> metadata
 	hrsMeta map[HRSKey]HrsMetadata
 	peers   map[int]CosignerPeer
+
+	// bounds of hrsMeta, see evictHrsMeta
+	maxEphemeralEntries int
+	ephemeralMaxAge     time.Duration
+
+	// held for reading by the rsa operations, Zeroize waits for them before overwriting the rsa keys
+	rsaKeyMutex sync.RWMutex
+
+	// set once the keys were zeroized, nothing is signed afterwards
+	// written under both lastSignStateMutex and rsaKeyMutex
+	zeroized bool
+
+	auditLog *AuditLog
+	metrics  *Metrics
+	rsaPool  *RSAWorkerPool
+	logger   log.Logger
+
+	// the throwaway rsa key of BenchSign, generated on its first call
+	benchKeyOnce sync.Once
+	benchKey     *rsa.PrivateKey
+	benchKeyErr  error
 }
 
+// ErrInvalidEphemeralPart is returned for an ephemeral secret part from a peer that fails verification
+var ErrInvalidEphemeralPart = errors.New("invalid ephemeral secret part")
+
+// ErrEphemeralReuse is returned instead of signing other sign bytes with an ephemeral secret that already signed
+var ErrEphemeralReuse = errors.New("ephemeral secret already signed other sign bytes")
+
+// ErrCosignerZeroized is returned by a LocalCosigner after Zeroize
+var ErrCosignerZeroized = errors.New("cosigner keys were zeroized")
+
 func NewLocalCosigner(cfg LocalCosignerConfig) *LocalCosigner {
 	cosigner := &LocalCosigner{
-		key:           cfg.CosignerKey,
-		lastSignState: cfg.SignState,
-		rsaKey:        cfg.RsaKey,
-		hrsMeta:       make(map[HRSKey]HrsMetadata),
-		peers:         make(map[int]CosignerPeer),
-		total:         cfg.Total,
-		threshold:     cfg.Threshold,
+		key:            cfg.CosignerKey,
+		lastSignState:  cfg.SignState,
+		rsaKey:         cfg.RsaKey,
+		previousRsaKey: cfg.PreviousRsaKey,
+		auditLog:       cfg.AuditLog,
+		hrsMeta:        make(map[HRSKey]HrsMetadata),
+		peers:          make(map[int]CosignerPeer),
+		total:          cfg.Total,
+		threshold:      cfg.Threshold,
+		metrics:        cfg.Metrics,
+		rsaPool:        cfg.RSAPool,
+		logger:         cfg.Logger,
+
+		maxEphemeralEntries: DefaultMaxEphemeralEntries,
+		ephemeralMaxAge:     DefaultEphemeralMaxAge,
+	}
+	if cosigner.metrics == nil {
+		cosigner.metrics = NopMetrics()
+	}
+	if cosigner.rsaPool == nil {
+		cosigner.rsaPool = NewRSAWorkerPool(0, DefaultRSAQueueLength, cosigner.metrics)
+	}
+	if cosigner.logger == nil {
+		cosigner.logger = log.NewNopLogger()
 	}
 
 	for _, peer := range cfg.Peers {
@@ -126,9 +215,26 @@ func NewLocalCosigner(cfg LocalCosignerConfig) *LocalCosigner {
 		panic("Not an ed25519 public key")
 	}
 
+	if cfg.SwitchHeight > 0 {
+		nextKey, ok := cosigner.key.NextPubKey.(tmCryptoEd25519.PubKey)
+		if !ok || len(cosigner.key.NextShareKey) == 0 {
+			panic("No next ed25519 key share to switch to")
+		}
+		cosigner.nextPubKeyBytes = append([]byte{}, nextKey...)
+		cosigner.switchHeight = cfg.SwitchHeight
+	}
+
 	return cosigner
 }
 
+// shareFor returns the key share and validator public key signing height
+func (cosigner *LocalCosigner) shareFor(height int64) (share []byte, pubKeyBytes []byte) {
+	if cosigner.switchHeight > 0 && height >= cosigner.switchHeight {
+		return cosigner.key.NextShareKey, cosigner.nextPubKeyBytes
+	}
+	return cosigner.key.ShareKey, cosigner.pubKeyBytes
+}
+
 // GetID returns the id of the cosigner
 // Implements Cosigner interface
 func (cosigner *LocalCosigner) GetID() int {
@@ -138,11 +244,20 @@ func (cosigner *LocalCosigner) GetID() int {
 // Sign the sign request using the cosigner's share
 // Return the signed bytes or an error
 // Implements Cosigner interface
-func (cosigner *LocalCosigner) Sign(req CosignerSignRequest) (CosignerSignResponse, error) {
+func (cosigner *LocalCosigner) Sign(ctx context.Context, req CosignerSignRequest) (CosignerSignResponse, error) {
 	cosigner.lastSignStateMutex.Lock()
 	defer cosigner.lastSignStateMutex.Unlock()
 
 	res := CosignerSignResponse{}
+
+	if cosigner.zeroized {
+		return res, ErrCosignerZeroized
+	}
+
+	// don't advance the watermark for a request that has already been abandoned
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
 	lss := cosigner.lastSignState
 
 	height, round, step, err := UnpackHRS(req.SignBytes)
@@ -166,7 +281,10 @@ func (cosigner *LocalCosigner) Sign(req CosignerSignRequest) (CosignerSignRespon
 			return res, errors.New("Mismatched data")
 		}
 
-		// saame HRS, and only differ by timestamp - ok to sign again
+		// same HRS, and only differ by timestamp - signing again would reuse the ephemeral secret of
+		// the HRS for other sign bytes, revealing our key share. The node gets the signature with the
+		// first timestamp from the validator instead.
+		return res, ErrEphemeralReuse
 	}
 
 	hrsKey := HRSKey{
@@ -207,16 +325,29 @@ func (cosigner *LocalCosigner) Sign(req CosignerSignRequest) (CosignerSignRespon
 		}
 	}
 
-	share := cosigner.key.ShareKey[:]
-	sig := tsed25519.SignWithShare(req.SignBytes, share, ephemeralShare, cosigner.pubKeyBytes, ephemeralPublic)
+	// last line of defense: an ephemeral secret must never sign two different messages
+	if bytes.Equal(ephemeralPublic, lss.EphemeralPublic) && !bytes.Equal(req.SignBytes, lss.SignBytes) {
+		cosigner.logger.Error("CRITICAL: ephemeral public key already signed other sign bytes, refusing to sign",
+			"request", requestID(ctx), "ephemeral_public", fmt.Sprintf("%X", ephemeralPublic), "height", height, "round", round, "step", step)
+		cosigner.metrics.EphemeralReuse.Add(1)
+		return res, ErrEphemeralReuse
+	}
 
-	cosigner.lastSignState.Height = height
-	cosigner.lastSignState.Round = round
-	cosigner.lastSignState.Step = step
-	cosigner.lastSignState.EphemeralPublic = ephemeralPublic
-	cosigner.lastSignState.Signature = sig
-	cosigner.lastSignState.SignBytes = req.SignBytes
-	cosigner.lastSignState.Save()
+	share, pubKeyBytes := cosigner.shareFor(height)
+	sig := tsed25519.SignWithShare(req.SignBytes, share, ephemeralShare, pubKeyBytes, ephemeralPublic)
+
+	// the share is only kept once saved, a retry must not get an unsaved share back
+	next := *lss
+	next.Height = height
+	next.Round = round
+	next.Step = step
+	next.EphemeralPublic = ephemeralPublic
+	next.Signature = sig
+	next.SignBytes = req.SignBytes
+	if err := next.Save(); err != nil {
+		return res, err
+	}
+	*lss = next
 
 	for existingKey := range cosigner.hrsMeta {
 		// delete any HRS lower than our signed level
@@ -225,21 +356,127 @@ func (cosigner *LocalCosigner) Sign(req CosignerSignRequest) (CosignerSignRespon
 			delete(cosigner.hrsMeta, existingKey)
 		}
 	}
+	cosigner.metrics.EphemeralCacheEntries.Set(float64(len(cosigner.hrsMeta)))
 
 	res.EphemeralPublic = ephemeralPublic
 	res.Signature = sig
 	return res, nil
 }
 
+// FlushSignState writes the sign state of the last share signed to its store again
+func (cosigner *LocalCosigner) FlushSignState() error {
+	cosigner.lastSignStateMutex.Lock()
+	defer cosigner.lastSignStateMutex.Unlock()
+	return cosigner.lastSignState.Save()
+}
+
+// SignStateWatermark returns the height, round and step of the last share signed
+func (cosigner *LocalCosigner) SignStateWatermark() HRSKey {
+	cosigner.lastSignStateMutex.Lock()
+	defer cosigner.lastSignStateMutex.Unlock()
+
+	lss := cosigner.lastSignState
+	return HRSKey{Height: lss.Height, Round: lss.Round, Step: lss.Step}
+}
+
+// AdvanceSignState moves the share watermark forward to hrs, without a signature for it.
+// A watermark at or above hrs is left unchanged. Returns whether the watermark moved.
+func (cosigner *LocalCosigner) AdvanceSignState(hrs HRSKey) (bool, error) {
+	cosigner.lastSignStateMutex.Lock()
+	defer cosigner.lastSignStateMutex.Unlock()
+
+	lss := cosigner.lastSignState
+	current := HRSKey{Height: lss.Height, Round: lss.Round, Step: lss.Step}
+	if !current.Less(hrs) {
+		return false, nil
+	}
+
+	// nothing was signed by us at the new watermark, so nothing can be signed there again
+	next := *lss
+	next.Height = hrs.Height
+	next.Round = hrs.Round
+	next.Step = hrs.Step
+	next.EphemeralPublic = nil
+	next.Signature = nil
+	next.SignBytes = nil
+	if err := next.Save(); err != nil {
+		return false, err
+	}
+	*lss = next
+
+	for existingKey := range cosigner.hrsMeta {
+		// we will not be providing parts for any lower HRS
+		if existingKey.Less(hrs) {
+			delete(cosigner.hrsMeta, existingKey)
+		}
+	}
+	cosigner.metrics.EphemeralCacheEntries.Set(float64(len(cosigner.hrsMeta)))
+	return true, nil
+}
+
 // Get the ephemeral secret part for an ephemeral share
 // The ephemeral secret part is encrypted for the receiver
-func (cosigner *LocalCosigner) GetEphemeralSecretPart(req CosignerGetEphemeralSecretPartRequest) (CosignerGetEphemeralSecretPartResponse, error) {
+func (cosigner *LocalCosigner) GetEphemeralSecretPart(ctx context.Context, req CosignerGetEphemeralSecretPartRequest) (CosignerGetEphemeralSecretPartResponse, error) {
 	res := CosignerGetEphemeralSecretPartResponse{}
 
+	sharePart, ourEphPublicKey, peer, err := cosigner.dealEphemeralSecretPart(ctx, req)
+	if err != nil {
+		return res, err
+	}
+
+	// the rsa operations run outside the lock, bounded by the worker pool
+	err = cosigner.rsaPool.Do(ctx, func() error {
+		// use RSA public to encrypt user's share part
+		encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &peer.PublicKey, sharePart, nil)
+		if err != nil {
+			return err
+		}
+
+		res.SourceID = cosigner.key.ID
+		res.SourceEphemeralSecretPublicKey = ourEphPublicKey
+		res.EncryptedSharePart = encrypted
+
+		// sign the response payload with our private key
+		// cosigners can verify the signature to confirm sender validity
+		jsonBytes, err := tmJson.Marshal(res)
+		if err != nil {
+			return err
+		}
+
+		digest := sha256.Sum256(jsonBytes)
+		return cosigner.withRSAKeys(func(rsaKey *rsa.PrivateKey, previousRsaKey *rsa.PrivateKey) error {
+			// while rotating, keep signing with the previous key until every peer has imported the new one
+			signingKey := rsaKey
+			if previousRsaKey != nil {
+				signingKey = previousRsaKey
+			}
+
+			signature, err := rsa.SignPSS(rand.Reader, signingKey, crypto.SHA256, digest[:], nil)
+			if err != nil {
+				return err
+			}
+
+			res.SourceSig = signature
+			return nil
+		})
+	})
+	return res, err
+}
+
+// dealEphemeralSecretPart returns the part of our ephemeral secret for the HRS dealt to the requesting peer,
+// dealing the secret on the first request, along with our ephemeral public key and the peer
+func (cosigner *LocalCosigner) dealEphemeralSecretPart(
+	ctx context.Context,
+	req CosignerGetEphemeralSecretPartRequest,
+) ([]byte, []byte, CosignerPeer, error) {
 	// protects the meta map
 	cosigner.lastSignStateMutex.Lock()
 	defer cosigner.lastSignStateMutex.Unlock()
 
+	if cosigner.zeroized {
+		return nil, nil, CosignerPeer{}, ErrCosignerZeroized
+	}
+
 	hrsKey := HRSKey{
 		Height: req.Height,
 		Round:  req.Round,
@@ -249,12 +486,17 @@ func (cosigner *LocalCosigner) GetEphemeralSecretPart(req CosignerGetEphemeralSe
 	meta, ok := cosigner.hrsMeta[hrsKey]
 	// generate metadata placeholder
 	if !ok {
+		if err := cosigner.checkUnsignedHRS(hrsKey); err != nil {
+			return nil, nil, CosignerPeer{}, err
+		}
+
 		secret := make([]byte, 32)
 		rand.Read(secret)
 
 		meta = HrsMetadata{
-			Secret: secret,
-			Peers:  make([]PeerMetadata, cosigner.total),
+			Secret:  secret,
+			Peers:   make([]PeerMetadata, cosigner.total),
+			Created: time.Now(),
 		}
 
 		// split this secret with shamirs
@@ -262,6 +504,8 @@ func (cosigner *LocalCosigner) GetEphemeralSecretPart(req CosignerGetEphemeralSe
 		meta.DealtShares = tsed25519.DealShares(meta.Secret, cosigner.threshold, cosigner.total)
 
 		cosigner.hrsMeta[hrsKey] = meta
+		cosigner.evictHrsMeta(hrsKey, time.Now())
+		cosigner.auditCommitment(ctx, hrsKey, cosigner.key.ID, tsed25519.ScalarMultiplyBase(meta.Secret))
 	}
 
 	ourEphPublicKey := tsed25519.ScalarMultiplyBase(meta.Secret)
@@ -273,43 +517,15 @@ func (cosigner *LocalCosigner) GetEphemeralSecretPart(req CosignerGetEphemeralSe
 	// grab the peer info for the ID being requested
 	peer, ok := cosigner.peers[req.ID]
 	if !ok {
-		return res, errors.New("Unknown peer ID")
-	}
-
-	sharePart := meta.DealtShares[req.ID-1]
-
-	// use RSA public to encrypt user's share part
-	encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &peer.PublicKey, sharePart, nil)
-	if err != nil {
-		return res, err
-	}
-
-	res.SourceID = cosigner.key.ID
-	res.SourceEphemeralSecretPublicKey = ourEphPublicKey
-	res.EncryptedSharePart = encrypted
-
-	// sign the response payload with our private key
-	// cosigners can verify the signature to confirm sender validity
-	{
-		jsonBytes, err := tmJson.Marshal(res)
-
-		if err != nil {
-			return res, err
-		}
-
-		digest := sha256.Sum256(jsonBytes)
-		signature, err := rsa.SignPSS(rand.Reader, &cosigner.rsaKey, crypto.SHA256, digest[:], nil)
-		if err != nil {
-			return res, err
-		}
-
-		res.SourceSig = signature
+		return nil, nil, CosignerPeer{}, errors.New("Unknown peer ID")
 	}
 
-	return res, nil
+	// a copy, the dealt shares are zeroized on shutdown
+	sharePart := append([]byte{}, meta.DealtShares[req.ID-1]...)
+	return sharePart, ourEphPublicKey, peer, nil
 }
 
-func (cosigner *LocalCosigner) HasEphemeralSecretPart(req CosignerHasEphemeralSecretPartRequest) (CosignerHasEphemeralSecretPartResponse, error) {
+func (cosigner *LocalCosigner) HasEphemeralSecretPart(ctx context.Context, req CosignerHasEphemeralSecretPartRequest) (CosignerHasEphemeralSecretPartResponse, error) {
 	res := CosignerHasEphemeralSecretPartResponse{
 		Exists: false,
 	}
@@ -337,10 +553,11 @@ func (cosigner *LocalCosigner) HasEphemeralSecretPart(req CosignerHasEphemeralSe
 }
 
 // Store an ephemeral secret share part provided by another cosigner
-func (cosigner *LocalCosigner) SetEphemeralSecretPart(req CosignerSetEphemeralSecretPartRequest) error {
-
-	// Verify the source signature
-	{
+func (cosigner *LocalCosigner) SetEphemeralSecretPart(ctx context.Context, req CosignerSetEphemeralSecretPartRequest) error {
+	// the rsa operations run outside the lock, bounded by the worker pool
+	var sharePart []byte
+	err := cosigner.rsaPool.Do(ctx, func() error {
+		// Verify the source signature
 		if req.SourceSig == nil {
 			return errors.New("SourceSig field is required")
 		}
@@ -364,15 +581,39 @@ func (cosigner *LocalCosigner) SetEphemeralSecretPart(req CosignerSetEphemeralSe
 
 		peerPub := peer.PublicKey
 		err = rsa.VerifyPSS(&peerPub, crypto.SHA256, digest[:], req.SourceSig, nil)
+		if err != nil && peer.PreviousPublicKey != nil {
+			err = rsa.VerifyPSS(peer.PreviousPublicKey, crypto.SHA256, digest[:], req.SourceSig, nil)
+		}
 		if err != nil {
 			return err
 		}
+
+		// decrypt share
+		return cosigner.withRSAKeys(func(rsaKey *rsa.PrivateKey, previousRsaKey *rsa.PrivateKey) error {
+			sharePart, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, rsaKey, req.EncryptedSharePart, nil)
+			if err != nil && previousRsaKey != nil {
+				// the peer may not have imported our new public key yet
+				sharePart, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, previousRsaKey, req.EncryptedSharePart, nil)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := checkEphemeralPart(sharePart, req.SourceEphemeralSecretPublicKey); err != nil {
+		return fmt.Errorf("from cosigner %d: %w", req.SourceID, err)
 	}
 
 	// protects the meta map
 	cosigner.lastSignStateMutex.Lock()
 	defer cosigner.lastSignStateMutex.Unlock()
 
+	if cosigner.zeroized {
+		return ErrCosignerZeroized
+	}
+
 	hrsKey := HRSKey{
 		Height: req.Height,
 		Round:  req.Round,
@@ -382,27 +623,244 @@ func (cosigner *LocalCosigner) SetEphemeralSecretPart(req CosignerSetEphemeralSe
 	meta, ok := cosigner.hrsMeta[hrsKey]
 	// generate metadata placeholder
 	if !ok {
+		// a replayed part must not create the metadata that GetEphemeralSecretPart would then deal from
+		if err := cosigner.checkUnsignedHRS(hrsKey); err != nil {
+			return err
+		}
+
 		secret := make([]byte, 32)
 		rand.Read(secret)
 
 		meta = HrsMetadata{
-			Secret: secret,
-			Peers:  make([]PeerMetadata, cosigner.total),
+			Secret:  secret,
+			Peers:   make([]PeerMetadata, cosigner.total),
+			Created: time.Now(),
 		}
 
 		meta.DealtShares = tsed25519.DealShares(meta.Secret, cosigner.threshold, cosigner.total)
 
 		cosigner.hrsMeta[hrsKey] = meta
+		cosigner.evictHrsMeta(hrsKey, time.Now())
+		cosigner.auditCommitment(ctx, hrsKey, cosigner.key.ID, tsed25519.ScalarMultiplyBase(meta.Secret))
 	}
 
-	// decrypt share
-	sharePart, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, &cosigner.rsaKey, req.EncryptedSharePart, nil)
+	// set slot
+	meta.Peers[req.SourceID-1].Share = sharePart
+	meta.Peers[req.SourceID-1].EphemeralSecretPublicKey = req.SourceEphemeralSecretPublicKey
+	cosigner.auditCommitment(ctx, hrsKey, req.SourceID, req.SourceEphemeralSecretPublicKey)
+	return nil
+}
+
+// checkUnsignedHRS returns an error if our share has already signed at or above hrsKey, with lastSignStateMutex held.
+// No ephemeral secret is dealt for such an HRS, e.g. for a replayed request after a restart emptied the metadata.
+func (cosigner *LocalCosigner) checkUnsignedHRS(hrsKey HRSKey) error {
+	sameHRS, err := cosigner.lastSignState.CheckHRS(hrsKey.Height, hrsKey.Round, hrsKey.Step)
 	if err != nil {
 		return err
 	}
+	if sameHRS {
+		return fmt.Errorf("share already signed at height %d round %d step %d", hrsKey.Height, hrsKey.Round, hrsKey.Step)
+	}
+	return nil
+}
+
+// checkEphemeralPart checks that a decrypted ephemeral secret part is a canonical scalar and
+// that the public key of the ephemeral secret it was dealt from is a point on the curve
+// A part failing these checks would poison the ephemeral share it is added to.
+func checkEphemeralPart(sharePart []byte, ephemeralPublic []byte) error {
+	if len(sharePart) != 32 {
+		return fmt.Errorf("%w: share part of %d bytes", ErrInvalidEphemeralPart, len(sharePart))
+	}
+	var scalarBytes [32]byte
+	copy(scalarBytes[:], sharePart)
+	if !edwards25519.ScMinimal(&scalarBytes) {
+		return fmt.Errorf("%w: share part is out of bounds", ErrInvalidEphemeralPart)
+	}
 
-	// set slot
-	meta.Peers[req.SourceID-1].Share = sharePart
-	meta.Peers[req.SourceID-1].EphemeralSecretPublicKey = req.SourceEphemeralSecretPublicKey
+	if len(ephemeralPublic) != 32 {
+		return fmt.Errorf("%w: ephemeral public key of %d bytes", ErrInvalidEphemeralPart, len(ephemeralPublic))
+	}
+	var pointBytes [32]byte
+	copy(pointBytes[:], ephemeralPublic)
+	var point edwards25519.ExtendedGroupElement
+	if !point.FromBytes(&pointBytes) {
+		return fmt.Errorf("%w: ephemeral public key is not a curve point", ErrInvalidEphemeralPart)
+	}
 	return nil
 }
+
+// evictHrsMeta drops the ephemeral metadata older than ephemeralMaxAge, then the lowest HRS
+// beyond maxEphemeralEntries. Entries at the newest height belong to rounds that may still be
+// in progress and are never evicted, nor is inFlight, the HRS just added.
+func (cosigner *LocalCosigner) evictHrsMeta(inFlight HRSKey, now time.Time) {
+	newest := inFlight.Height
+	for existingKey := range cosigner.hrsMeta {
+		if existingKey.Height > newest {
+			newest = existingKey.Height
+		}
+	}
+
+	evictable := make([]HRSKey, 0)
+	for existingKey, meta := range cosigner.hrsMeta {
+		if existingKey.Height == newest || existingKey == inFlight {
+			continue
+		}
+		if now.Sub(meta.Created) > cosigner.ephemeralMaxAge {
+			delete(cosigner.hrsMeta, existingKey)
+			cosigner.metrics.EphemeralCacheEvictions.Add(1)
+			continue
+		}
+		evictable = append(evictable, existingKey)
+	}
+
+	if excess := len(cosigner.hrsMeta) - cosigner.maxEphemeralEntries; excess > 0 {
+		sort.Slice(evictable, func(i, j int) bool {
+			return evictable[i].Less(evictable[j])
+		})
+		for idx := 0; idx < excess && idx < len(evictable); idx++ {
+			delete(cosigner.hrsMeta, evictable[idx])
+			cosigner.metrics.EphemeralCacheEvictions.Add(1)
+		}
+	}
+	cosigner.metrics.EphemeralCacheEntries.Set(float64(len(cosigner.hrsMeta)))
+}
+
+// auditCommitment records the public ephemeral key of a cosigner for the HRS, if there is an audit log
+func (cosigner *LocalCosigner) auditCommitment(ctx context.Context, hrsKey HRSKey, id int, ephemeralPublic []byte) {
+	if cosigner.auditLog == nil {
+		return
+	}
+	err := cosigner.auditLog.Write(AuditEntry{
+		Time:            time.Now().UTC(),
+		Event:           AuditEventEphemeralCommitment,
+		Height:          hrsKey.Height,
+		Round:           hrsKey.Round,
+		Step:            stepName(hrsKey.Step),
+		RequestID:       requestID(ctx),
+		Cosigner:        id,
+		EphemeralPublic: ephemeralPublic,
+	})
+	if err != nil {
+		cosigner.logger.Error("Audit log", "request", requestID(ctx), "err", err)
+	}
+}
+
+// withRSAKeys runs fn with our rsa key and the previous one, nil unless rotating,
+// which Zeroize does not overwrite until fn returned
+func (cosigner *LocalCosigner) withRSAKeys(fn func(rsaKey *rsa.PrivateKey, previousRsaKey *rsa.PrivateKey) error) error {
+	cosigner.rsaKeyMutex.RLock()
+	defer cosigner.rsaKeyMutex.RUnlock()
+
+	if cosigner.zeroized {
+		return ErrCosignerZeroized
+	}
+	return fn(&cosigner.rsaKey, cosigner.previousRsaKey)
+}
+
+// BenchSign runs the rsa and ed25519 work of a sign round for our share, with a throwaway rsa key
+// of the size of ours, a throwaway ed25519 key and a throwaway ephemeral secret, to measure signing
+// latency against a live quorum. The keys, the ephemeral metadata and the sign state are left as is,
+// and only random bytes are signed, with the throwaway ed25519 key. The rsa work queues for the
+// workers of the sign rounds.
+func (cosigner *LocalCosigner) BenchSign(ctx context.Context) error {
+	benchKey, err := cosigner.benchRSAKey()
+	if err != nil {
+		return err
+	}
+
+	keySecret := make([]byte, 32)
+	rand.Read(keySecret)
+	keyShares := tsed25519.DealShares(tsed25519.ExpandSecret(keySecret), cosigner.threshold, cosigner.total)
+	publicKey := tsed25519.ScalarMultiplyBase(tsed25519.ExpandSecret(keySecret))
+
+	ephemeralSecret := make([]byte, 32)
+	rand.Read(ephemeralSecret)
+	dealtShares := tsed25519.DealShares(ephemeralSecret, cosigner.threshold, cosigner.total)
+	ourEphPublicKey := tsed25519.ScalarMultiplyBase(ephemeralSecret)
+
+	shareParts := []tsed25519.Scalar{dealtShares[cosigner.key.ID-1]}
+	publicKeys := []tsed25519.Element{ourEphPublicKey}
+	for id := 1; id <= int(cosigner.total); id++ {
+		if id == cosigner.key.ID {
+			continue
+		}
+
+		// the part dealt to the peer, then the part the peer deals to us, as GetEphemeralSecretPart
+		// and SetEphemeralSecretPart handle them, with the throwaway key on both ends
+		err := cosigner.rsaPool.Do(ctx, func() error {
+			encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &benchKey.PublicKey, dealtShares[id-1], nil)
+			if err != nil {
+				return err
+			}
+			digest := sha256.Sum256(encrypted)
+			signature, err := rsa.SignPSS(rand.Reader, benchKey, crypto.SHA256, digest[:], nil)
+			if err != nil {
+				return err
+			}
+			if err := rsa.VerifyPSS(&benchKey.PublicKey, crypto.SHA256, digest[:], signature, nil); err != nil {
+				return err
+			}
+			sharePart, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, benchKey, encrypted, nil)
+			if err != nil {
+				return err
+			}
+			shareParts = append(shareParts, sharePart)
+			publicKeys = append(publicKeys, ourEphPublicKey)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	message := make([]byte, 128)
+	rand.Read(message)
+	ephemeralShare := tsed25519.AddScalars(shareParts)
+	ephemeralPublic := tsed25519.AddElements(publicKeys)
+	tsed25519.SignWithShare(message, keyShares[cosigner.key.ID-1], ephemeralShare, publicKey, ephemeralPublic)
+	return nil
+}
+
+// benchRSAKey returns the throwaway rsa key of BenchSign, generating it on the first call
+func (cosigner *LocalCosigner) benchRSAKey() (*rsa.PrivateKey, error) {
+	var bits int
+	err := cosigner.withRSAKeys(func(rsaKey *rsa.PrivateKey, previousRsaKey *rsa.PrivateKey) error {
+		bits = rsaKey.N.BitLen()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cosigner.benchKeyOnce.Do(func() {
+		cosigner.benchKey, cosigner.benchKeyErr = rsa.GenerateKey(rand.Reader, bits)
+	})
+	return cosigner.benchKey, cosigner.benchKeyErr
+}
+
+// Zeroize overwrites the share, the rsa keys and any ephemeral secrets in memory
+// Called on shutdown, the cosigner refuses all requests afterwards.
+func (cosigner *LocalCosigner) Zeroize() {
+	// waits for the rsa operations in flight
+	cosigner.rsaKeyMutex.Lock()
+	defer cosigner.rsaKeyMutex.Unlock()
+	cosigner.lastSignStateMutex.Lock()
+	defer cosigner.lastSignStateMutex.Unlock()
+
+	cosigner.zeroized = true
+	cosigner.key.Zeroize()
+	zeroizeRSAKey(&cosigner.rsaKey)
+	zeroizeRSAKey(cosigner.previousRsaKey)
+
+	for hrsKey, meta := range cosigner.hrsMeta {
+		zeroizeBytes(meta.Secret)
+		for _, share := range meta.DealtShares {
+			zeroizeBytes(share)
+		}
+		for _, peer := range meta.Peers {
+			zeroizeBytes(peer.Share)
+		}
+		delete(cosigner.hrsMeta, hrsKey)
+	}
+	cosigner.metrics.EphemeralCacheEntries.Set(0)
+}
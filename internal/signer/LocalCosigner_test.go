@@ -1,12 +1,14 @@
 package signer
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
@@ -80,11 +82,7 @@ func TestLocalCosignerSign2of2(test *testing.T) {
 		ID:       1,
 	}
 
-	stateFile1, err := ioutil.TempFile("", "state1.json")
-	require.NoError(test, err)
-	defer os.Remove(stateFile1.Name())
-
-	signState1, err := LoadOrCreateSignState(stateFile1.Name())
+	signState1, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "state1.json"))
 
 	key2 := CosignerKey{
 		PubKey:   privateKey.PubKey(),
@@ -92,10 +90,7 @@ func TestLocalCosignerSign2of2(test *testing.T) {
 		ID:       2,
 	}
 
-	stateFile2, err := ioutil.TempFile("", "state2.json")
-	require.NoError(test, err)
-	defer os.Remove(stateFile2.Name())
-	signState2, err := LoadOrCreateSignState(stateFile2.Name())
+	signState2, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "state2.json"))
 	require.NoError(test, err)
 
 	config1 := LocalCosignerConfig{
@@ -129,7 +124,7 @@ func TestLocalCosignerSign2of2(test *testing.T) {
 
 	// get part 2 from cosigner 1 and give to cosigner 2
 	{
-		resp, err := cosigner1.GetEphemeralSecretPart(CosignerGetEphemeralSecretPartRequest{
+		resp, err := cosigner1.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
 			ID:     2,
 			Height: 1,
 			Round:  0,
@@ -139,7 +134,7 @@ func TestLocalCosignerSign2of2(test *testing.T) {
 
 		publicKeys = append(publicKeys, resp.SourceEphemeralSecretPublicKey)
 
-		err = cosigner2.SetEphemeralSecretPart(CosignerSetEphemeralSecretPartRequest{
+		err = cosigner2.SetEphemeralSecretPart(context.Background(), CosignerSetEphemeralSecretPartRequest{
 			SourceID:                       resp.SourceID,
 			Height:                         1,
 			Round:                          0,
@@ -153,7 +148,7 @@ func TestLocalCosignerSign2of2(test *testing.T) {
 
 	// get part 1 from cosigner 2 and give to cosigner 1
 	{
-		resp, err := cosigner2.GetEphemeralSecretPart(CosignerGetEphemeralSecretPartRequest{
+		resp, err := cosigner2.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
 			ID:     1,
 			Height: 1,
 			Round:  0,
@@ -163,7 +158,7 @@ func TestLocalCosignerSign2of2(test *testing.T) {
 
 		publicKeys = append(publicKeys, resp.SourceEphemeralSecretPublicKey)
 
-		err = cosigner1.SetEphemeralSecretPart(CosignerSetEphemeralSecretPartRequest{
+		err = cosigner1.SetEphemeralSecretPart(context.Background(), CosignerSetEphemeralSecretPartRequest{
 			SourceID:                       resp.SourceID,
 			Height:                         1,
 			Round:                          0,
@@ -189,12 +184,12 @@ func TestLocalCosignerSign2of2(test *testing.T) {
 	signBytes := tm.VoteSignBytes("chain-id", &vote)
 
 	// sign with cosigner 1
-	sigRes1, err := cosigner1.Sign(CosignerSignRequest{
+	sigRes1, err := cosigner1.Sign(context.Background(), CosignerSignRequest{
 		SignBytes: signBytes,
 	})
 	require.NoError(test, err)
 
-	sigRes2, err := cosigner2.Sign(CosignerSignRequest{
+	sigRes2, err := cosigner2.Sign(context.Background(), CosignerSignRequest{
 		SignBytes: signBytes,
 	})
 	require.NoError(test, err)
@@ -225,11 +220,7 @@ func TestLocalCosignerWatermark(test *testing.T) {
 			ID:       1,
 		}
 
-		stateFile1, err := ioutil.TempFile("", "state1.json")
-		require.NoError(test, err)
-		defer os.Remove(stateFile1.Name())
-
-		signState1, err := LoadOrCreateSignState(stateFile1.Name())
+		signState1, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "state1.json"))
 
 		cosigner1 := NewLocalCosigner(key1, &signState1)
 
@@ -247,7 +238,7 @@ func TestLocalCosignerWatermark(test *testing.T) {
 			SignBytes:            []byte("Hello World!"),
 		}
 
-		_, err = cosigner1.Sign(signReq1)
+		_, err = cosigner1.Sign(context.Background(), signReq1)
 		require.NoError(test, err)
 
 		// watermark should have increased after signing
@@ -255,7 +246,374 @@ func TestLocalCosignerWatermark(test *testing.T) {
 
 		// revert the height to a lower number and check if signing is rejected
 		signReq1.Height = 1
-		_, err = cosigner1.Sign(signReq1)
+		_, err = cosigner1.Sign(context.Background(), signReq1)
 		require.Error(test, err, "height regression. Got 1, last height 2")
 	*/
 }
+
+func TestLocalCosignerGetEphemeralSecretPartBelowWatermark(test *testing.T) {
+	bitSize := 4096
+	rsaKey, err := rsa.GenerateKey(rand.Reader, bitSize)
+	require.NoError(test, err)
+
+	privateKey := tmCryptoEd25519.GenPrivKey()
+
+	// the share sign state as loaded after a restart
+	signState := SignState{
+		Height:    5,
+		Round:     0,
+		Step:      stepPrevote,
+		Signature: []byte("signature"),
+		SignBytes: []byte("sign bytes"),
+	}
+
+	cosigner := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{
+			PubKey: privateKey.PubKey(),
+			ID:     1,
+		},
+		SignState: &signState,
+		RsaKey:    *rsaKey,
+		Peers: []CosignerPeer{{
+			ID:        2,
+			PublicKey: rsaKey.PublicKey,
+		}},
+		Total:     2,
+		Threshold: 2,
+	})
+
+	req := CosignerGetEphemeralSecretPartRequest{
+		ID:     2,
+		Height: 4,
+		Round:  0,
+		Step:   stepPrevote,
+	}
+	_, err = cosigner.GetEphemeralSecretPart(context.Background(), req)
+	require.Error(test, err)
+
+	req.Height = 5
+	_, err = cosigner.GetEphemeralSecretPart(context.Background(), req)
+	require.Error(test, err)
+
+	req.Step = stepPrecommit
+	_, err = cosigner.GetEphemeralSecretPart(context.Background(), req)
+	require.NoError(test, err)
+}
+
+func TestLocalCosignerSetEphemeralSecretPartBelowWatermark(test *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+	peers := []CosignerPeer{{ID: 1, PublicKey: rsaKey.PublicKey}, {ID: 2, PublicKey: rsaKey.PublicKey}}
+
+	newCosigner := func(id int, signState SignState) *LocalCosigner {
+		privateKey := tmCryptoEd25519.GenPrivKey()
+		return NewLocalCosigner(LocalCosignerConfig{
+			CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ID: id},
+			SignState:   &signState,
+			RsaKey:      *rsaKey,
+			Peers:       peers,
+			Total:       2,
+			Threshold:   2,
+		})
+	}
+
+	// our share sign state as loaded after a restart, and a peer that has not signed as far
+	cosigner := newCosigner(1, SignState{Height: 5, Step: stepPrevote, Signature: []byte("signature"), SignBytes: []byte("sign bytes")})
+	peer := newCosigner(2, SignState{})
+
+	// a part of the peer for the HRS we already signed, replayed to us
+	part, err := peer.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
+		ID: 1, Height: 5, Round: 0, Step: stepPrevote,
+	})
+	require.NoError(test, err)
+	err = cosigner.SetEphemeralSecretPart(context.Background(), CosignerSetEphemeralSecretPartRequest{
+		SourceID:                       part.SourceID,
+		SourceEphemeralSecretPublicKey: part.SourceEphemeralSecretPublicKey,
+		EncryptedSharePart:             part.EncryptedSharePart,
+		SourceSig:                      part.SourceSig,
+		Height:                         5,
+		Round:                          0,
+		Step:                           stepPrevote,
+	})
+	require.Error(test, err)
+	require.Empty(test, cosigner.hrsMeta)
+
+	// so no part is dealt for it afterwards either
+	_, err = cosigner.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
+		ID: 2, Height: 5, Round: 0, Step: stepPrevote,
+	})
+	require.Error(test, err)
+}
+
+func TestLocalCosignerEvictsEphemeralMetadata(test *testing.T) {
+	_, _, cosigner2, _ := newThresholdValidator2of2(test)
+	cosigner := cosigner2.(*LocalCosigner)
+	cosigner.maxEphemeralEntries = 2
+
+	getPart := func(height int64, round int64) {
+		_, err := cosigner.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
+			ID:     1,
+			Height: height,
+			Round:  round,
+			Step:   stepPrevote,
+		})
+		require.NoError(test, err)
+	}
+	hasMeta := func(height int64, round int64) bool {
+		_, ok := cosigner.hrsMeta[HRSKey{Height: height, Round: round, Step: stepPrevote}]
+		return ok
+	}
+
+	// the lowest HRS is evicted beyond the bound
+	getPart(1, 0)
+	getPart(2, 0)
+	getPart(3, 0)
+	require.Len(test, cosigner.hrsMeta, 2)
+	require.False(test, hasMeta(1, 0))
+
+	// rounds of the newest height may still be in progress and are kept over the bound
+	getPart(3, 1)
+	getPart(3, 2)
+	require.Len(test, cosigner.hrsMeta, 3)
+	require.False(test, hasMeta(2, 0))
+
+	// a late request for a lower height keeps the secret it was just dealt
+	getPart(2, 0)
+	require.True(test, hasMeta(2, 0))
+
+	// entries below the newest height are evicted by age
+	cosigner.maxEphemeralEntries = DefaultMaxEphemeralEntries
+	meta := cosigner.hrsMeta[HRSKey{Height: 2, Step: stepPrevote}]
+	meta.Created = time.Now().Add(-2 * DefaultEphemeralMaxAge)
+	cosigner.hrsMeta[HRSKey{Height: 2, Step: stepPrevote}] = meta
+	getPart(4, 0)
+	require.False(test, hasMeta(2, 0))
+	require.True(test, hasMeta(3, 0))
+}
+
+func TestLocalCosignerRSAKeyRotation(test *testing.T) {
+	total := uint8(2)
+	threshold := uint8(2)
+
+	bitSize := 2048
+	rsaKey1, err := rsa.GenerateKey(rand.Reader, bitSize)
+	require.NoError(test, err)
+
+	oldRsaKey2, err := rsa.GenerateKey(rand.Reader, bitSize)
+	require.NoError(test, err)
+
+	newRsaKey2, err := rsa.GenerateKey(rand.Reader, bitSize)
+	require.NoError(test, err)
+
+	privateKey := tmCryptoEd25519.GenPrivKey()
+	signState := SignState{}
+
+	// cosigner 2 has rotated its rsa key and retains the old one
+	cosigner2 := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ID: 2},
+		SignState:   &signState,
+		RsaKey:      *newRsaKey2,
+		Peers: []CosignerPeer{
+			{ID: 1, PublicKey: rsaKey1.PublicKey},
+			{ID: 2, PublicKey: newRsaKey2.PublicKey, PreviousPublicKey: &oldRsaKey2.PublicKey},
+		},
+		Total:          total,
+		Threshold:      threshold,
+		PreviousRsaKey: oldRsaKey2,
+	})
+
+	exchange := func(source Cosigner, dest Cosigner, height int64) error {
+		part, err := source.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
+			ID:     dest.GetID(),
+			Height: height,
+			Step:   stepPrevote,
+		})
+		if err != nil {
+			return err
+		}
+		return dest.SetEphemeralSecretPart(context.Background(), CosignerSetEphemeralSecretPartRequest{
+			SourceID:                       part.SourceID,
+			SourceEphemeralSecretPublicKey: part.SourceEphemeralSecretPublicKey,
+			EncryptedSharePart:             part.EncryptedSharePart,
+			SourceSig:                      part.SourceSig,
+			Height:                         height,
+			Step:                           stepPrevote,
+		})
+	}
+
+	// cosigner 1 has not imported the new key yet
+	notImported := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ID: 1},
+		SignState:   &signState,
+		RsaKey:      *rsaKey1,
+		Peers: []CosignerPeer{
+			{ID: 1, PublicKey: rsaKey1.PublicKey},
+			{ID: 2, PublicKey: oldRsaKey2.PublicKey},
+		},
+		Total:     total,
+		Threshold: threshold,
+	})
+	require.NoError(test, exchange(notImported, cosigner2, 1))
+	require.NoError(test, exchange(cosigner2, notImported, 1))
+
+	// cosigner 1 has imported the new key
+	imported := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ID: 1},
+		SignState:   &signState,
+		RsaKey:      *rsaKey1,
+		Peers: []CosignerPeer{
+			{ID: 1, PublicKey: rsaKey1.PublicKey},
+			{ID: 2, PublicKey: newRsaKey2.PublicKey, PreviousPublicKey: &oldRsaKey2.PublicKey},
+		},
+		Total:     total,
+		Threshold: threshold,
+	})
+	require.NoError(test, exchange(imported, cosigner2, 2))
+	require.NoError(test, exchange(cosigner2, imported, 2))
+
+	// once the rotation is finished the old key is no longer accepted
+	finished := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ID: 2},
+		SignState:   &signState,
+		RsaKey:      *newRsaKey2,
+		Peers: []CosignerPeer{
+			{ID: 1, PublicKey: rsaKey1.PublicKey},
+			{ID: 2, PublicKey: newRsaKey2.PublicKey},
+		},
+		Total:     total,
+		Threshold: threshold,
+	})
+	require.Error(test, exchange(notImported, finished, 3))
+}
+
+func TestLocalCosignerZeroize(test *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+
+	privateKey := tmCryptoEd25519.GenPrivKey()
+	shareKey := make([]byte, 32)
+	copy(shareKey, privateKey[:32])
+
+	key := CosignerKey{
+		PubKey:   privateKey.PubKey(),
+		ShareKey: shareKey,
+		RSAKey:   *rsaKey,
+		ID:       1,
+	}
+	signState := SignState{}
+
+	cosigner := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: key,
+		SignState:   &signState,
+		RsaKey:      *rsaKey,
+		Peers: []CosignerPeer{{
+			ID:        1,
+			PublicKey: rsaKey.PublicKey,
+		}},
+		Total:     1,
+		Threshold: 1,
+	})
+
+	cosigner.Zeroize()
+	require.Equal(test, make([]byte, 32), shareKey)
+	require.Zero(test, rsaKey.D.Sign())
+	for _, prime := range rsaKey.Primes {
+		require.Zero(test, prime.Sign())
+	}
+
+	_, err = cosigner.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{ID: 1, Height: 1})
+	require.ErrorIs(test, err, ErrCosignerZeroized)
+
+	_, err = cosigner.Sign(context.Background(), CosignerSignRequest{})
+	require.ErrorIs(test, err, ErrCosignerZeroized)
+}
+
+func TestLocalCosignerZeroizeWaitsForRSA(test *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+
+	privateKey := tmCryptoEd25519.GenPrivKey()
+	signState := SignState{}
+	cosigner := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ShareKey: make([]byte, 32), ID: 1},
+		SignState:   &signState,
+		RsaKey:      *rsaKey,
+		Total:       1,
+		Threshold:   1,
+	})
+
+	// an rsa operation in flight keeps the keys until it returns
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+	used := make(chan error, 1)
+	go func() {
+		used <- cosigner.withRSAKeys(func(rsaKey *rsa.PrivateKey, _ *rsa.PrivateKey) error {
+			close(inFlight)
+			<-release
+			return rsaKey.Validate()
+		})
+	}()
+	<-inFlight
+
+	zeroized := make(chan struct{})
+	go func() {
+		cosigner.Zeroize()
+		close(zeroized)
+	}()
+
+	select {
+	case <-zeroized:
+		test.Fatal("zeroized while an rsa operation was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(test, <-used)
+	<-zeroized
+
+	err = cosigner.withRSAKeys(func(*rsa.PrivateKey, *rsa.PrivateKey) error { return nil })
+	require.ErrorIs(test, err, ErrCosignerZeroized)
+}
+
+func TestCheckEphemeralPart(test *testing.T) {
+	secret := make([]byte, 32)
+	secret[0] = 1
+	public := tsed25519.ScalarMultiplyBase(secret)
+	require.NoError(test, checkEphemeralPart(secret, public))
+
+	outOfBounds := bytes.Repeat([]byte{0xff}, 32)
+	require.ErrorIs(test, checkEphemeralPart(outOfBounds, public), ErrInvalidEphemeralPart)
+	require.ErrorIs(test, checkEphemeralPart(secret[:31], public), ErrInvalidEphemeralPart)
+	require.ErrorIs(test, checkEphemeralPart(secret, public[:31]), ErrInvalidEphemeralPart)
+}
+
+func TestLocalCosignerRefusesEphemeralReuse(test *testing.T) {
+	_, cosigner1, cosigner2, _ := newThresholdValidator2of2(test)
+	local := cosigner1.(*LocalCosigner)
+	reuse := &recordingCounter{}
+	metrics := *NopMetrics()
+	metrics.EphemeralReuse = reuse
+	local.metrics = &metrics
+
+	// our own part and the peer's, as the validator gathers them
+	_, err := local.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{ID: 1, Height: 1, Step: stepPrevote})
+	require.NoError(test, err)
+	exchangeEphemeralPart(test, cosigner2, cosigner1, 1, 0, stepPrevote)
+
+	now := time.Now()
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1, Timestamp: now}
+	_, err = local.Sign(context.Background(), CosignerSignRequest{SignBytes: tm.VoteSignBytes("chain-id", &vote)})
+	require.NoError(test, err)
+
+	// the same vote at another timestamp would sign other sign bytes with the same ephemeral secret
+	vote.Timestamp = now.Add(time.Second)
+	_, err = local.Sign(context.Background(), CosignerSignRequest{SignBytes: tm.VoteSignBytes("chain-id", &vote)})
+	require.Equal(test, ErrEphemeralReuse, err)
+
+	// a bug handing out the ephemeral secret of height 1 again at height 2 is caught and counted
+	local.hrsMeta[HRSKey{Height: 2, Step: stepPrevote}] = local.hrsMeta[HRSKey{Height: 1, Step: stepPrevote}]
+	vote.Height = 2
+	_, err = local.Sign(context.Background(), CosignerSignRequest{SignBytes: tm.VoteSignBytes("chain-id", &vote)})
+	require.Equal(test, ErrEphemeralReuse, err)
+	require.Equal(test, 1.0, reuse.Value())
+	require.Equal(test, int64(1), local.lastSignState.Height)
+}
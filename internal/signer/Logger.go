@@ -0,0 +1,62 @@
+package signer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	tmLog "github.com/tendermint/tendermint/libs/log"
+)
+
+const (
+	// LogTimestampsLocal logs the local time, e.g. I[2021-01-02|15:04:05.000], as tendermint does
+	LogTimestampsLocal = "local"
+
+	// LogTimestampsUTC logs UTC RFC3339 timestamps with milliseconds, e.g. I[2021-01-02T14:04:05.000Z]
+	LogTimestampsUTC = "utc"
+)
+
+// NewLogger returns the tendermint logger writing to w, with timestamps either LogTimestampsLocal or LogTimestampsUTC
+func NewLogger(w io.Writer, timestamps string) (tmLog.Logger, error) {
+	switch timestamps {
+	case "", LogTimestampsLocal:
+		return tmLog.NewTMLogger(tmLog.NewSyncWriter(w)), nil
+	case LogTimestampsUTC:
+		return tmLog.NewTMLogger(tmLog.NewSyncWriter(&utcTimestampWriter{w: w})), nil
+	default:
+		return nil, fmt.Errorf("log_timestamps must be %q or %q, got %q", LogTimestampsLocal, LogTimestampsUTC, timestamps)
+	}
+}
+
+// the layout of the local time tendermint loggers write at the start of each line
+const tmTimeLayout = "2006-01-02|15:04:05.000"
+
+// utcTimestampWriter converts the local time at the start of each line written by a tendermint logger
+// to UTC, keeping the time the line was logged at. The logger writes each line with a single call to Write.
+type utcTimestampWriter struct {
+	w io.Writer
+}
+
+// Write implements io.Writer
+func (writer *utcTimestampWriter) Write(line []byte) (int, error) {
+	// the level letter, possibly after a color escape sequence, then the bracketed time
+	start := bytes.IndexByte(line, '[')
+	end := start + 1 + len(tmTimeLayout)
+	if start < 0 || start > 16 || len(line) <= end || line[end] != ']' {
+		return writer.w.Write(line)
+	}
+	logged, err := time.ParseInLocation(tmTimeLayout, string(line[start+1:end]), time.Local)
+	if err != nil {
+		return writer.w.Write(line)
+	}
+
+	rewritten := make([]byte, 0, len(line)+8)
+	rewritten = append(rewritten, line[:start+1]...)
+	rewritten = logged.UTC().AppendFormat(rewritten, "2006-01-02T15:04:05.000Z07:00")
+	rewritten = append(rewritten, line[end:]...)
+	if _, err := writer.w.Write(rewritten); err != nil {
+		return 0, err
+	}
+	return len(line), nil
+}
@@ -0,0 +1,37 @@
+package signer
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoggerUTC(test *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, LogTimestampsUTC)
+	require.NoError(test, err)
+
+	logger.Info("Signed", "height", 10)
+	require.Regexp(test, regexp.MustCompile(`^I\[\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z\] Signed +height=10\n$`), buf.String())
+
+	// the time the line was logged at is kept, converted to UTC
+	writer := &utcTimestampWriter{w: &buf}
+	logged := time.Date(2021, 1, 2, 15, 4, 5, 6e6, time.Local)
+	buf.Reset()
+	_, err = writer.Write([]byte("I[" + logged.Format(tmTimeLayout) + "] Signed\n"))
+	require.NoError(test, err)
+	require.Equal(test, "I["+logged.UTC().Format("2006-01-02T15:04:05.000Z07:00")+"] Signed\n", buf.String())
+
+	// lines not written by a tendermint logger are left as is
+	buf.Reset()
+	n, err := writer.Write([]byte("ERROR [x]\n"))
+	require.NoError(test, err)
+	require.Equal(test, 10, n)
+	require.Equal(test, "ERROR [x]\n", buf.String())
+
+	_, err = NewLogger(&buf, "gmt")
+	require.Error(test, err)
+}
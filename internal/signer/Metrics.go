@@ -0,0 +1,296 @@
+package signer
+
+import (
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/metrics/multi"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// MetricsNamespace is the namespace of all metrics exposed by the signer
+	MetricsNamespace = "signer"
+)
+
+// Metrics contains metrics exposed by the signer.
+type Metrics struct {
+	// Unix time of the last request handled for a node, labeled by node address.
+	NodeLastActivity metrics.Gauge
+	// Number of times the watchdog dropped an idle node connection, labeled by node address.
+	NodeWatchdogReconnects metrics.Counter
+	// Number of requests conflicting with an already signed height, round and step, labeled by node address.
+	NodeEquivocations metrics.Counter
+	// Number of failed dials of the node, labeled by node address.
+	NodeDialFailures metrics.Counter
+	// Number of failed or unauthorized secret connection handshakes with the node, labeled by node address.
+	NodeHandshakeFailures metrics.Counter
+	// Number of connections to the node after the first, labeled by node address.
+	NodeReconnects metrics.Counter
+	// 1 if the last rpc to the cosigner succeeded, 0 otherwise, labeled by cosigner ID and address.
+	CosignerUp metrics.Gauge
+	// Seconds until a cosigner that refused connections is dialed again, 0 once it accepts them.
+	CosignerDialBackoff metrics.Gauge
+	// 1 while signing is halted because fewer than threshold cosigners are reachable.
+	QuorumBreakerOpen metrics.Gauge
+	// Number of ephemeral secret parts from the cosigner that failed verification, labeled by cosigner ID.
+	CosignerInvalidParts metrics.Counter
+	// Number of share signatures of the cosigner left out of a combined signature, labeled by cosigner ID.
+	CosignerExcludedShares metrics.Counter
+	// Number of heights, rounds and steps the local cosigner holds ephemeral secrets for.
+	EphemeralCacheEntries metrics.Gauge
+	// Number of ephemeral secrets evicted by age or to bound the cache, before our share signed past them.
+	EphemeralCacheEvictions metrics.Counter
+	// Number of share signatures refused because their ephemeral secret already signed other sign bytes.
+	// Anything but 0 is a bug that would have revealed the key share.
+	EphemeralReuse metrics.Counter
+	// Number of rsa operations of the ephemeral secret exchange waiting for a worker.
+	RSAQueueDepth metrics.Gauge
+	// Number of rsa workers busy, saturated at rsa_workers.
+	RSAWorkersBusy metrics.Gauge
+	// Number of rsa operations refused because the queue was full.
+	RSAQueueRejections metrics.Counter
+	// Seconds each write of a sign state file took, labeled by state, "validator" or "share".
+	SignStateSaveDuration metrics.Histogram
+}
+
+// PrometheusMetrics returns Metrics build using Prometheus client library, registered with registerer.
+// Optionally, labels can be provided along with their values ("foo",
+// "fooValue").
+func PrometheusMetrics(registerer stdprometheus.Registerer, namespace string, labelsAndValues ...string) *Metrics {
+	labels := []string{}
+	for i := 0; i < len(labelsAndValues); i += 2 {
+		labels = append(labels, labelsAndValues[i])
+	}
+
+	// as prometheus.NewCounterFrom and the like, which register with the default registry
+	newCounter := func(opts stdprometheus.CounterOpts, labelNames []string) *prometheus.Counter {
+		cv := stdprometheus.NewCounterVec(opts, labelNames)
+		registerer.MustRegister(cv)
+		return prometheus.NewCounter(cv)
+	}
+	newGauge := func(opts stdprometheus.GaugeOpts, labelNames []string) *prometheus.Gauge {
+		gv := stdprometheus.NewGaugeVec(opts, labelNames)
+		registerer.MustRegister(gv)
+		return prometheus.NewGauge(gv)
+	}
+	newHistogram := func(opts stdprometheus.HistogramOpts, labelNames []string) *prometheus.Histogram {
+		hv := stdprometheus.NewHistogramVec(opts, labelNames)
+		registerer.MustRegister(hv)
+		return prometheus.NewHistogram(hv)
+	}
+
+	return &Metrics{
+		NodeLastActivity: newGauge(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "node_last_activity_seconds",
+			Help:      "Unix time of the last request handled for the node.",
+		}, append(labels, "node")).With(labelsAndValues...),
+		NodeWatchdogReconnects: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "node_watchdog_reconnects_total",
+			Help:      "Number of times an idle node connection was dropped by the watchdog.",
+		}, append(labels, "node")).With(labelsAndValues...),
+		NodeEquivocations: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "node_equivocations_total",
+			Help:      "Number of sign requests conflicting with an already signed height, round and step.",
+		}, append(labels, "node")).With(labelsAndValues...),
+		NodeDialFailures: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "node_dial_failures_total",
+			Help:      "Number of failed dials of the node.",
+		}, append(labels, "node")).With(labelsAndValues...),
+		NodeHandshakeFailures: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "node_handshake_failures_total",
+			Help:      "Number of failed or unauthorized secret connection handshakes with the node.",
+		}, append(labels, "node")).With(labelsAndValues...),
+		NodeReconnects: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "node_reconnects_total",
+			Help:      "Number of connections to the node after the first.",
+		}, append(labels, "node")).With(labelsAndValues...),
+		CosignerUp: newGauge(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cosigner_up",
+			Help:      "Whether the last rpc to the cosigner succeeded (1) or failed (0).",
+		}, append(labels, "cosigner", "address")).With(labelsAndValues...),
+		CosignerDialBackoff: newGauge(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cosigner_dial_backoff_seconds",
+			Help:      "Seconds until a cosigner that refused connections is dialed again, 0 once it accepts them.",
+		}, append(labels, "cosigner", "address")).With(labelsAndValues...),
+		QuorumBreakerOpen: newGauge(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "quorum_breaker_open",
+			Help:      "Whether signing is halted because fewer than threshold cosigners are reachable.",
+		}, labels).With(labelsAndValues...),
+		CosignerInvalidParts: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cosigner_invalid_ephemeral_parts_total",
+			Help:      "Number of ephemeral secret parts from the cosigner that failed verification.",
+		}, append(labels, "cosigner")).With(labelsAndValues...),
+		CosignerExcludedShares: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cosigner_excluded_shares_total",
+			Help:      "Number of share signatures of the cosigner that did not combine into a valid signature.",
+		}, append(labels, "cosigner")).With(labelsAndValues...),
+		EphemeralCacheEntries: newGauge(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ephemeral_cache_entries",
+			Help:      "Number of heights, rounds and steps the local cosigner holds ephemeral secrets for.",
+		}, labels).With(labelsAndValues...),
+		EphemeralCacheEvictions: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ephemeral_cache_evictions_total",
+			Help:      "Number of ephemeral secrets evicted before the local cosigner signed past them.",
+		}, labels).With(labelsAndValues...),
+		EphemeralReuse: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ephemeral_reuse_total",
+			Help:      "Number of share signatures refused because their ephemeral secret already signed other sign bytes.",
+		}, labels).With(labelsAndValues...),
+		RSAQueueDepth: newGauge(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rsa_queue_depth",
+			Help:      "Number of rsa operations of the ephemeral secret exchange waiting for a worker.",
+		}, labels).With(labelsAndValues...),
+		RSAWorkersBusy: newGauge(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rsa_workers_busy",
+			Help:      "Number of rsa workers busy, saturated at rsa_workers.",
+		}, labels).With(labelsAndValues...),
+		RSAQueueRejections: newCounter(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rsa_queue_rejections_total",
+			Help:      "Number of rsa operations refused because the queue was full.",
+		}, labels).With(labelsAndValues...),
+		SignStateSaveDuration: newHistogram(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "sign_state_save_seconds",
+			Help:      "Seconds each write of a sign state file took.",
+			Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+		}, append(labels, "state")).With(labelsAndValues...),
+	}
+}
+
+// statsdProvider creates the metrics of a StatsD or DogStatsD client
+type statsdProvider interface {
+	NewCounter(name string) metrics.Counter
+	NewGauge(name string) metrics.Gauge
+	NewTiming(name string) metrics.Histogram
+}
+
+// statsdMetrics returns Metrics pushed to a StatsD or DogStatsD server through p, named like the
+// prometheus metrics. Labels become tags with DogStatsD and are dropped with plain StatsD.
+// Durations are sent as timings in milliseconds, e.g. sign_state_save_ms.
+func statsdMetrics(p statsdProvider) *Metrics {
+	return &Metrics{
+		NodeLastActivity:        p.NewGauge("node_last_activity_seconds"),
+		NodeWatchdogReconnects:  p.NewCounter("node_watchdog_reconnects_total"),
+		NodeEquivocations:       p.NewCounter("node_equivocations_total"),
+		NodeDialFailures:        p.NewCounter("node_dial_failures_total"),
+		NodeHandshakeFailures:   p.NewCounter("node_handshake_failures_total"),
+		NodeReconnects:          p.NewCounter("node_reconnects_total"),
+		CosignerUp:              p.NewGauge("cosigner_up"),
+		CosignerDialBackoff:     p.NewGauge("cosigner_dial_backoff_seconds"),
+		QuorumBreakerOpen:       p.NewGauge("quorum_breaker_open"),
+		CosignerInvalidParts:    p.NewCounter("cosigner_invalid_ephemeral_parts_total"),
+		CosignerExcludedShares:  p.NewCounter("cosigner_excluded_shares_total"),
+		EphemeralCacheEntries:   p.NewGauge("ephemeral_cache_entries"),
+		EphemeralCacheEvictions: p.NewCounter("ephemeral_cache_evictions_total"),
+		EphemeralReuse:          p.NewCounter("ephemeral_reuse_total"),
+		RSAQueueDepth:           p.NewGauge("rsa_queue_depth"),
+		RSAWorkersBusy:          p.NewGauge("rsa_workers_busy"),
+		RSAQueueRejections:      p.NewCounter("rsa_queue_rejections_total"),
+		SignStateSaveDuration:   millisecondHistogram{p.NewTiming("sign_state_save_ms")},
+	}
+}
+
+// millisecondHistogram observes durations given in seconds as milliseconds, the unit of statsd timings
+type millisecondHistogram struct {
+	metrics.Histogram
+}
+
+func (histogram millisecondHistogram) With(labelValues ...string) metrics.Histogram {
+	return millisecondHistogram{histogram.Histogram.With(labelValues...)}
+}
+
+func (histogram millisecondHistogram) Observe(seconds float64) {
+	histogram.Histogram.Observe(seconds * 1000)
+}
+
+// MultiMetrics returns Metrics reporting to each of all, e.g. to prometheus and statsd at once
+func MultiMetrics(all ...*Metrics) *Metrics {
+	if len(all) == 1 {
+		return all[0]
+	}
+	combined := &Metrics{}
+	counters := func(get func(*Metrics) metrics.Counter) metrics.Counter {
+		var counters []metrics.Counter
+		for _, m := range all {
+			counters = append(counters, get(m))
+		}
+		return multi.NewCounter(counters...)
+	}
+	gauges := func(get func(*Metrics) metrics.Gauge) metrics.Gauge {
+		var gauges []metrics.Gauge
+		for _, m := range all {
+			gauges = append(gauges, get(m))
+		}
+		return multi.NewGauge(gauges...)
+	}
+	histograms := func(get func(*Metrics) metrics.Histogram) metrics.Histogram {
+		var histograms []metrics.Histogram
+		for _, m := range all {
+			histograms = append(histograms, get(m))
+		}
+		return multi.NewHistogram(histograms...)
+	}
+
+	combined.NodeLastActivity = gauges(func(m *Metrics) metrics.Gauge { return m.NodeLastActivity })
+	combined.NodeWatchdogReconnects = counters(func(m *Metrics) metrics.Counter { return m.NodeWatchdogReconnects })
+	combined.NodeEquivocations = counters(func(m *Metrics) metrics.Counter { return m.NodeEquivocations })
+	combined.NodeDialFailures = counters(func(m *Metrics) metrics.Counter { return m.NodeDialFailures })
+	combined.NodeHandshakeFailures = counters(func(m *Metrics) metrics.Counter { return m.NodeHandshakeFailures })
+	combined.NodeReconnects = counters(func(m *Metrics) metrics.Counter { return m.NodeReconnects })
+	combined.CosignerUp = gauges(func(m *Metrics) metrics.Gauge { return m.CosignerUp })
+	combined.CosignerDialBackoff = gauges(func(m *Metrics) metrics.Gauge { return m.CosignerDialBackoff })
+	combined.QuorumBreakerOpen = gauges(func(m *Metrics) metrics.Gauge { return m.QuorumBreakerOpen })
+	combined.CosignerInvalidParts = counters(func(m *Metrics) metrics.Counter { return m.CosignerInvalidParts })
+	combined.CosignerExcludedShares = counters(func(m *Metrics) metrics.Counter { return m.CosignerExcludedShares })
+	combined.EphemeralCacheEntries = gauges(func(m *Metrics) metrics.Gauge { return m.EphemeralCacheEntries })
+	combined.EphemeralCacheEvictions = counters(func(m *Metrics) metrics.Counter { return m.EphemeralCacheEvictions })
+	combined.EphemeralReuse = counters(func(m *Metrics) metrics.Counter { return m.EphemeralReuse })
+	combined.RSAQueueDepth = gauges(func(m *Metrics) metrics.Gauge { return m.RSAQueueDepth })
+	combined.RSAWorkersBusy = gauges(func(m *Metrics) metrics.Gauge { return m.RSAWorkersBusy })
+	combined.RSAQueueRejections = counters(func(m *Metrics) metrics.Counter { return m.RSAQueueRejections })
+	combined.SignStateSaveDuration = histograms(func(m *Metrics) metrics.Histogram { return m.SignStateSaveDuration })
+	return combined
+}
+
+// NopMetrics returns no-op Metrics.
+func NopMetrics() *Metrics {
+	return &Metrics{
+		NodeLastActivity:       discard.NewGauge(),
+		NodeWatchdogReconnects: discard.NewCounter(),
+		NodeEquivocations:      discard.NewCounter(),
+		NodeDialFailures:       discard.NewCounter(),
+		NodeHandshakeFailures:  discard.NewCounter(),
+		NodeReconnects:         discard.NewCounter(),
+		CosignerUp:             discard.NewGauge(),
+		CosignerDialBackoff:    discard.NewGauge(),
+		QuorumBreakerOpen:      discard.NewGauge(),
+		CosignerInvalidParts:   discard.NewCounter(),
+		CosignerExcludedShares: discard.NewCounter(),
+
+		EphemeralCacheEntries:   discard.NewGauge(),
+		EphemeralCacheEvictions: discard.NewCounter(),
+		EphemeralReuse:          discard.NewCounter(),
+		RSAQueueDepth:           discard.NewGauge(),
+		RSAWorkersBusy:          discard.NewGauge(),
+		RSAQueueRejections:      discard.NewCounter(),
+		SignStateSaveDuration:   discard.NewHistogram(),
+	}
+}
@@ -0,0 +1,74 @@
+package signer
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/libs/service"
+)
+
+// MetricsServer serves the prometheus metrics
+type MetricsServer struct {
+	service.BaseService
+
+	listenAddress string
+	gatherer      prometheus.Gatherer
+	dualStack     bool
+	listener      net.Listener
+	server        *http.Server
+}
+
+// NewMetricsServer returns a MetricsServer serving the metrics of gatherer on listenAddress once started
+func NewMetricsServer(listenAddress string, gatherer prometheus.Gatherer, logger log.Logger) *MetricsServer {
+	metricsServer := &MetricsServer{
+		listenAddress: listenAddress,
+		gatherer:      gatherer,
+	}
+
+	metricsServer.BaseService = *service.NewBaseService(logger, "MetricsServer", metricsServer)
+	return metricsServer
+}
+
+// SetDualStack listens on IPv4 and IPv6 separately for an address on all interfaces. Must be called before Start.
+func (metricsServer *MetricsServer) SetDualStack(dualStack bool) {
+	metricsServer.dualStack = dualStack
+}
+
+// OnStart starts serving /metrics
+func (metricsServer *MetricsServer) OnStart() error {
+	lis, err := listen(metricsServer.listenAddress, metricsServer.dualStack, metricsServer.Logger)
+	if err != nil {
+		return err
+	}
+	metricsServer.listener = lis
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsServer.gatherer, promhttp.HandlerOpts{}))
+	metricsServer.server = &http.Server{Handler: mux}
+
+	go func() {
+		err := metricsServer.server.Serve(lis)
+		if err != nil && err != http.ErrServerClosed {
+			metricsServer.Logger.Error("Metrics server", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// OnStop closes the listener
+func (metricsServer *MetricsServer) OnStop() {
+	if err := metricsServer.server.Close(); err != nil {
+		metricsServer.Logger.Error("Close", "err", err)
+	}
+}
+
+func (metricsServer *MetricsServer) Addr() net.Addr {
+	if metricsServer.listener == nil {
+		return nil
+	}
+	return metricsServer.listener.Addr()
+}
@@ -0,0 +1,36 @@
+package signer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func TestMetricsServer(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	// the metrics of two signers in one process, each on its own registry
+	registry := prometheus.NewRegistry()
+	metrics := PrometheusMetrics(registry, MetricsNamespace)
+	other := PrometheusMetrics(prometheus.NewRegistry(), MetricsNamespace)
+	metrics.EphemeralReuse.Add(1)
+	other.RSAQueueRejections.Add(1)
+
+	metricsServer := NewMetricsServer("tcp://127.0.0.1:0", registry, logger)
+	require.NoError(test, metricsServer.Start())
+	defer metricsServer.Stop()
+
+	resp, err := http.Get("http://" + metricsServer.Addr().String() + "/metrics")
+	require.NoError(test, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(test, err)
+	require.Equal(test, http.StatusOK, resp.StatusCode)
+	require.Contains(test, string(body), "signer_ephemeral_reuse_total 1")
+	require.NotContains(test, string(body), "signer_rsa_queue_rejections_total 1")
+}
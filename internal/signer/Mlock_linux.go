@@ -0,0 +1,15 @@
+package signer
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// LockMemory locks all current and future memory of the process into RAM so that keys are never swapped to disk
+func LockMemory() error {
+	err := syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE)
+	if err != nil {
+		return fmt.Errorf("mlock: %w (raise the memlock limit, e.g. LimitMEMLOCK=infinity, or grant CAP_IPC_LOCK)", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package signer
+
+import "errors"
+
+// LockMemory is only supported on linux
+func LockMemory() error {
+	return errors.New("mlock is only supported on linux")
+}
@@ -0,0 +1,87 @@
+package signer
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serveSocks5 accepts a single no-auth CONNECT on listener and relays it to the requested address
+func serveSocks5(listener net.Listener, requested chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// greeting: version, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	io.ReadFull(conn, make([]byte, header[1]))
+	conn.Write([]byte{5, 0})
+
+	// request: version, connect, reserved, ipv4 address and port
+	request := make([]byte, 10)
+	if _, err := io.ReadFull(conn, request); err != nil || request[3] != 1 {
+		return
+	}
+	address := net.JoinHostPort(net.IP(request[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(request[8:]))))
+	requested <- address
+
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func TestNodeDialerSocks5(test *testing.T) {
+	node, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer node.Close()
+
+	proxyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer proxyListener.Close()
+	requested := make(chan string, 1)
+	go serveSocks5(proxyListener, requested)
+
+	dialer, err := NewNodeDialer(5*time.Second, 0, nil, "socks5://"+proxyListener.Addr().String())
+	require.NoError(test, err)
+
+	conn, err := dialer.Dial("tcp", node.Addr().String())
+	require.NoError(test, err)
+	defer conn.Close()
+	require.Equal(test, node.Addr().String(), <-requested)
+
+	nodeConn, err := node.Accept()
+	require.NoError(test, err)
+	defer nodeConn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(test, err)
+	received := make([]byte, 4)
+	_, err = io.ReadFull(nodeConn, received)
+	require.NoError(test, err)
+	require.Equal(test, "ping", string(received))
+}
+
+func TestNodeDialerInvalidProxy(test *testing.T) {
+	_, err := NewNodeDialer(time.Second, 0, nil, "ftp://127.0.0.1:21")
+	require.Error(test, err)
+
+	dialer, err := NewNodeDialer(time.Second, 0, nil, "")
+	require.NoError(test, err)
+	require.IsType(test, &net.Dialer{}, dialer)
+}
@@ -0,0 +1,58 @@
+package signer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// CheckPermissions looks for state and key files that other users could read or tamper with.
+// It returns one error per problem found: the state directory accessible by other users,
+// a key or state file readable by group or others, or any of them owned by another user.
+// Keys read from stdin or a file descriptor are not checked, and nothing is checked on windows.
+func CheckPermissions(stateDir string, keyFile string) []error {
+	problems := []error{}
+	if !unixPermissions {
+		return problems
+	}
+
+	check := func(path string, info os.FileInfo, unsafeBits os.FileMode) {
+		if mode := info.Mode().Perm(); mode&unsafeBits != 0 {
+			problems = append(problems, fmt.Errorf("%s is accessible by other users (mode %04o)", path, mode))
+		}
+		if uid, ok := fileOwner(info); ok && uid != os.Geteuid() {
+			problems = append(problems, fmt.Errorf("%s is owned by uid %d, not the signer's uid %d", path, uid, os.Geteuid()))
+		}
+	}
+
+	if stateDir != "" {
+		info, err := os.Stat(stateDir)
+		if err != nil {
+			problems = append(problems, err)
+		} else {
+			check(stateDir, info, 0007)
+
+			files, err := ioutil.ReadDir(stateDir)
+			if err != nil {
+				problems = append(problems, err)
+			}
+			for _, file := range files {
+				if file.Mode().IsRegular() {
+					check(filepath.Join(stateDir, file.Name()), file, 0077)
+				}
+			}
+		}
+	}
+
+	if keyFile != "" && !IsKeyFileStream(keyFile) {
+		info, err := os.Stat(keyFile)
+		if err != nil {
+			problems = append(problems, err)
+		} else {
+			check(keyFile, info, 0077)
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package signer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func TestCheckPermissions(test *testing.T) {
+	stateDir := filepath.Join(test.TempDir(), "state")
+	require.NoError(test, os.Mkdir(stateDir, 0700))
+	stateFile := filepath.Join(stateDir, "chain-id_priv_validator_state.json")
+	require.NoError(test, ioutil.WriteFile(stateFile, []byte("{}"), 0600))
+	keyFile := filepath.Join(test.TempDir(), "share.json")
+	require.NoError(test, ioutil.WriteFile(keyFile, []byte("{}"), 0600))
+
+	require.Empty(test, CheckPermissions(stateDir, keyFile))
+	require.Empty(test, CheckPermissions(stateDir, StdinKeyFile))
+
+	require.NoError(test, os.Chmod(keyFile, 0644))
+	require.Len(test, CheckPermissions(stateDir, keyFile), 1)
+
+	require.NoError(test, os.Chmod(stateDir, 0755))
+	require.NoError(test, os.Chmod(stateFile, 0640))
+	require.Len(test, CheckPermissions(stateDir, keyFile), 3)
+
+	require.Len(test, CheckPermissions(filepath.Join(stateDir, "missing"), ""), 1)
+}
+
+func TestNewServiceStrictPermissions(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	stateDir := test.TempDir()
+	require.NoError(test, os.Chmod(stateDir, 0777))
+
+	_, err := New(Config{Mode: "foo", ChainID: "chain-id", PrivValStateDir: stateDir, StrictPermissions: true}, logger)
+	require.Error(test, err)
+	require.Contains(test, err.Error(), "strict_permissions")
+
+	// only logged if not strict
+	_, err = New(Config{Mode: "foo", ChainID: "chain-id", PrivValStateDir: stateDir}, logger)
+	require.EqualError(test, err, "Unsupported mode: foo")
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package signer
+
+import (
+	"os"
+	"syscall"
+)
+
+// unixPermissions enables CheckPermissions
+const unixPermissions = true
+
+// fileOwner returns the uid owning the file
+func fileOwner(info os.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Uid), true
+}
@@ -0,0 +1,125 @@
+package signer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/tendermint/tendermint/libs/log"
+	tmnet "github.com/tendermint/tendermint/libs/net"
+	"github.com/tendermint/tendermint/libs/service"
+)
+
+// PprofServer serves the net/http/pprof handlers under /debug/pprof/ on a listener of its own
+type PprofServer struct {
+	service.BaseService
+
+	listenAddress string
+	listener      net.Listener
+	server        *http.Server
+}
+
+// NewPprofServer returns a PprofServer listening on listenAddress once started
+func NewPprofServer(listenAddress string, logger log.Logger) *PprofServer {
+	pprofServer := &PprofServer{
+		listenAddress: listenAddress,
+	}
+
+	pprofServer.BaseService = *service.NewBaseService(logger, "PprofServer", pprofServer)
+	return pprofServer
+}
+
+// OnStart starts serving /debug/pprof/
+func (pprofServer *PprofServer) OnStart() error {
+	lis, err := listen(pprofServer.listenAddress, false, pprofServer.Logger)
+	if err != nil {
+		return err
+	}
+	pprofServer.listener = lis
+
+	if !isLoopbackAddr(lis.Addr()) {
+		pprofServer.Logger.Error("pprof is reachable from other hosts, prefer a localhost address", "address", lis.Addr())
+	}
+
+	// registered on a mux of our own, never on http.DefaultServeMux
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	pprofServer.server = &http.Server{Handler: mux}
+
+	go func() {
+		err := pprofServer.server.Serve(lis)
+		if err != nil && err != http.ErrServerClosed {
+			pprofServer.Logger.Error("Pprof server", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// OnStop closes the listener
+func (pprofServer *PprofServer) OnStop() {
+	if err := pprofServer.server.Close(); err != nil {
+		pprofServer.Logger.Error("Close", "err", err)
+	}
+}
+
+func (pprofServer *PprofServer) Addr() net.Addr {
+	if pprofServer.listener == nil {
+		return nil
+	}
+	return pprofServer.listener.Addr()
+}
+
+// isLoopbackAddr returns true for a tcp address on a loopback interface, or a unix socket
+func isLoopbackAddr(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	return tcpAddr.IP.IsLoopback()
+}
+
+// checkPprofAddress refuses a pprof_listen_address on the port of another listener of the signer,
+// so profiles are never served next to the cosigner rpc, even on another interface
+func checkPprofAddress(config *Config) error {
+	proto, pprofAddress := tmnet.ProtocolAndAddress(config.PprofAddress)
+	if proto == "unix" {
+		return nil
+	}
+	_, pprofPort, err := net.SplitHostPort(pprofAddress)
+	if err != nil {
+		return fmt.Errorf("invalid pprof_listen_address %s: %w", config.PprofAddress, err)
+	}
+
+	others := map[string]string{
+		"cosigner_listen_address":   config.ListenAddress,
+		"admin_listen_address":      config.AdminAddress,
+		"prometheus_listen_address": config.PrometheusAddress,
+	}
+	for idx, address := range config.ListenAddresses {
+		others[fmt.Sprintf("cosigner_extra_listen_addresses[%d]", idx)] = address
+	}
+	for idx, share := range config.ExtraShares {
+		others[fmt.Sprintf("extra_share[%d].listen_address", idx)] = share.ListenAddress
+	}
+
+	for name, listenAddress := range others {
+		if listenAddress == "" {
+			continue
+		}
+		_, address := tmnet.ProtocolAndAddress(listenAddress)
+		_, port, err := net.SplitHostPort(address)
+		if err != nil || port == "0" {
+			continue
+		}
+		if port == pprofPort {
+			return fmt.Errorf("pprof_listen_address %s must not share a port with %s %s", config.PprofAddress, name, listenAddress)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package signer
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func TestPprofServer(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	pprofServer := NewPprofServer("tcp://127.0.0.1:0", logger)
+	require.NoError(test, pprofServer.Start())
+	defer pprofServer.Stop()
+
+	resp, err := http.Get("http://" + pprofServer.Addr().String() + "/debug/pprof/cmdline")
+	require.NoError(test, err)
+	resp.Body.Close()
+	require.Equal(test, http.StatusOK, resp.StatusCode)
+}
+
+func TestCheckPprofAddress(test *testing.T) {
+	config := Config{
+		ListenAddress: "tcp://0.0.0.0:2222",
+		PprofAddress:  "tcp://127.0.0.1:6060",
+	}
+	require.NoError(test, checkPprofAddress(&config))
+
+	// the same port as the cosigner rpc, also on another interface
+	config.PprofAddress = "tcp://127.0.0.1:2222"
+	require.Error(test, checkPprofAddress(&config))
+
+	config.PprofAddress = "unix:///tmp/pprof.sock"
+	require.NoError(test, checkPprofAddress(&config))
+}
@@ -0,0 +1,121 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	tmBytes "github.com/tendermint/tendermint/libs/bytes"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// DefaultPreSignTimeoutMs is the default pre_sign_webhook_timeout_ms
+const DefaultPreSignTimeoutMs = 200
+
+// ErrPreSignDenied is returned when the pre-sign webhook did not approve a signature
+var ErrPreSignDenied = errors.New("pre-sign webhook did not approve the signature")
+
+// PreSignRequest is the json posted to the pre-sign webhook for each vote or proposal before it is signed
+type PreSignRequest struct {
+	ChainID string `json:"chain_id"`
+
+	// "prevote", "precommit" or "proposal"
+	Type   string `json:"type"`
+	Height int64  `json:"height"`
+	Round  int64  `json:"round"`
+
+	// the proof of lock round of a proposal, -1 if none
+	POLRound int64 `json:"pol_round,omitempty"`
+
+	// empty for a nil vote
+	BlockHash         tmBytes.HexBytes `json:"block_hash"`
+	PartSetHeaderHash tmBytes.HexBytes `json:"part_set_header_hash"`
+
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// newVotePreSignRequest returns the pre-sign request of a vote
+func newVotePreSignRequest(chainID string, vote *tmProto.Vote) PreSignRequest {
+	voteType := "prevote"
+	if vote.Type == tmProto.PrecommitType {
+		voteType = "precommit"
+	}
+	return PreSignRequest{
+		ChainID:           chainID,
+		Type:              voteType,
+		Height:            vote.Height,
+		Round:             int64(vote.Round),
+		BlockHash:         vote.BlockID.Hash,
+		PartSetHeaderHash: vote.BlockID.PartSetHeader.Hash,
+		Timestamp:         vote.Timestamp,
+	}
+}
+
+// newProposalPreSignRequest returns the pre-sign request of a proposal
+func newProposalPreSignRequest(chainID string, proposal *tmProto.Proposal) PreSignRequest {
+	return PreSignRequest{
+		ChainID:           chainID,
+		Type:              "proposal",
+		Height:            proposal.Height,
+		Round:             int64(proposal.Round),
+		POLRound:          int64(proposal.PolRound),
+		BlockHash:         proposal.BlockID.Hash,
+		PartSetHeaderHash: proposal.BlockID.PartSetHeader.Hash,
+		Timestamp:         proposal.Timestamp,
+	}
+}
+
+// PreSignWebhook asks an external policy engine to approve each signature.
+// A signature is only made if the webhook answers 200 within the timeout, anything else refuses it.
+type PreSignWebhook struct {
+	url     string
+	timeout time.Duration
+	client  *http.Client
+}
+
+// NewPreSignWebhook returns a webhook posting to url, giving up on it after timeout,
+// DefaultPreSignTimeoutMs if 0
+func NewPreSignWebhook(url string, timeout time.Duration) *PreSignWebhook {
+	if timeout <= 0 {
+		timeout = DefaultPreSignTimeoutMs * time.Millisecond
+	}
+	return &PreSignWebhook{
+		url:     url,
+		timeout: timeout,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Approve posts the request to the webhook, returning an error unless it answered 200
+func (hook *PreSignWebhook) Approve(ctx context.Context, request PreSignRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hook.timeout)
+	defer cancel()
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	resp, err := hook.client.Do(httpRequest)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPreSignDenied, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%w: %s %s", ErrPreSignDenied, resp.Status, bytes.TrimSpace(reason))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package signer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func TestPvGuardPreSignWebhook(test *testing.T) {
+	// the webhook's answer, and the requests it got, shared with the handler
+	var mtx sync.Mutex
+	var requests []PreSignRequest
+	status := http.StatusOK
+	delay := time.Duration(0)
+	configure := func(newStatus int, newDelay time.Duration) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		status, delay = newStatus, newDelay
+	}
+	request := func(idx int) PreSignRequest {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return requests[idx]
+	}
+
+	// signaled once the handler of each request returned
+	handled := make(chan struct{}, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { handled <- struct{}{} }()
+		var decoded PreSignRequest
+		if err := json.NewDecoder(r.Body).Decode(&decoded); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mtx.Lock()
+		requests = append(requests, decoded)
+		answer, wait := status, delay
+		mtx.Unlock()
+
+		time.Sleep(wait)
+		w.WriteHeader(answer)
+	}))
+	defer server.Close()
+
+	pv := &PvGuard{
+		PrivValidator: tm.NewMockPV(),
+		PreSign:       NewPreSignWebhook(server.URL, 100*time.Millisecond),
+	}
+
+	// approved
+	vote := tmProto.Vote{Type: tmProto.PrecommitType, Height: 1, Round: 2}
+	vote.BlockID.Hash = bytes.Repeat([]byte{0xAB}, 32)
+	require.NoError(test, pv.SignVote("chain-id", &vote))
+	require.NotEmpty(test, vote.Signature)
+	<-handled
+	require.Equal(test, "chain-id", request(0).ChainID)
+	require.Equal(test, "precommit", request(0).Type)
+	require.Equal(test, int64(1), request(0).Height)
+	require.Equal(test, int64(2), request(0).Round)
+	require.Equal(test, vote.BlockID.Hash, []byte(request(0).BlockHash))
+
+	// refused
+	configure(http.StatusForbidden, 0)
+	proposal := tmProto.Proposal{Type: tmProto.ProposalType, Height: 2, PolRound: -1}
+	err := pv.SignProposal("chain-id", &proposal)
+	require.True(test, errors.Is(err, ErrPreSignDenied))
+	require.Empty(test, proposal.Signature)
+	<-handled
+	require.Equal(test, "proposal", request(1).Type)
+	require.Equal(test, int64(-1), request(1).POLRound)
+
+	// too slow
+	configure(http.StatusOK, 200*time.Millisecond)
+	vote = tmProto.Vote{Type: tmProto.PrevoteType, Height: 3}
+	err = pv.SignVote("chain-id", &vote)
+	require.True(test, errors.Is(err, ErrPreSignDenied))
+	require.Empty(test, vote.Signature)
+	<-handled
+
+	// without a timeout, e.g. from a Config literal, the default applies
+	configure(http.StatusOK, 0)
+	pv.PreSign = NewPreSignWebhook(server.URL, 0)
+	vote = tmProto.Vote{Type: tmProto.PrevoteType, Height: 4}
+	require.NoError(test, pv.SignVote("chain-id", &vote))
+	require.NotEmpty(test, vote.Signature)
+}
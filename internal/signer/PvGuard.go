@@ -1,18 +1,147 @@
 package signer
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/tendermint/tendermint/crypto"
 	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
 	tm "github.com/tendermint/tendermint/types"
 )
 
+// ContextPrivValidator is a PrivValidator whose sign operations can be abandoned
+// by canceling the context
+type ContextPrivValidator interface {
+	tm.PrivValidator
+	SignVoteContext(ctx context.Context, chainID string, vote *tmProto.Vote) error
+	SignProposalContext(ctx context.Context, chainID string, proposal *tmProto.Proposal) error
+}
+
+// ErrRateLimited is returned when signing is refused by the PvGuard rate limiter
+var ErrRateLimited = errors.New("signature rate limit exceeded, refusing to sign")
+
+// ErrStandby is returned for signing requests while the PvGuard is in standby
+var ErrStandby = errors.New("signer is in standby, refusing to sign")
+
 // PvGuard guards access to an underlying PrivValidator by using mutexes
 // for each of the PrivValidator interface functions
+//
+// Requests of all nodes are serialized, so identical requests of several sentries arriving at once
+// run a single threshold round: the later ones are answered from the sign state it left.
+//
+// If a RateLimiter is set, signing requests beyond the rate are refused.
+// This is a last resort against a node asking for far more signatures than expected.
+//
+// If a ColdStart is set, nothing is signed until the chain height has been observed, see ColdStart.
+//
+// If a PreSign webhook is set, it must approve every signature, see PreSignWebhook.
+//
+// If Events is set, the outcome of every sign request, refused ones included, is published to its subscribers.
+//
+// A PvGuard in standby refuses to sign, for active/standby setups switched over at runtime.
+// It starts out active. Once taken over from another signer, see TakeOver, it refuses to sign
+// at or below the watermark that signer handed over.
 type PvGuard struct {
 	PrivValidator tm.PrivValidator
+	RateLimiter   *RateLimiter
 	pvMutex       sync.Mutex
+
+	// optional, counts the signatures for the periodic summary
+	Stats *SignStats
+
+	// optional, holds back signing after a restart
+	ColdStart *ColdStart
+
+	// optional, approves every signature
+	PreSign *PreSignWebhook
+
+	// optional, streams the outcome of every sign request
+	Events *SignEvents
+
+	// optional, called when a signature is refused because its sign state could not be saved
+	OnSaveFailure func(err error)
+
+	// 1 in standby, read without pvMutex so switching never waits on a sign request
+	standby uint32
+
+	// the watermark handed over by the previous active signer, nothing at or below it is signed
+	handoff HRSKey
+}
+
+// SetActive switches between signing normally and refusing to sign
+func (pv *PvGuard) SetActive(active bool) {
+	var standby uint32
+	if !active {
+		standby = 1
+	}
+	atomic.StoreUint32(&pv.standby, standby)
+}
+
+// Pause switches to standby once the sign request in progress, if any, completed, then calls flush
+// before any other request is handled. Once Pause returns, nothing is signed until SetActive(true).
+func (pv *PvGuard) Pause(flush func() error) error {
+	pv.SetActive(false)
+
+	pv.pvMutex.Lock()
+	defer pv.pvMutex.Unlock()
+	return flush()
+}
+
+// TakeOver raises the handoff watermark to watermark, never lowering it, and switches to signing normally
+// once the sign request in progress, if any, completed. Returns the handoff watermark.
+func (pv *PvGuard) TakeOver(watermark HRSKey) HRSKey {
+	pv.pvMutex.Lock()
+	defer pv.pvMutex.Unlock()
+
+	if pv.handoff.Less(watermark) {
+		pv.handoff = watermark
+	}
+	pv.SetActive(true)
+	return pv.handoff
+}
+
+// IsActive returns false while in standby
+func (pv *PvGuard) IsActive() bool {
+	return atomic.LoadUint32(&pv.standby) == 0
+}
+
+func (pv *PvGuard) checkAllowed(height int64, round int64, step int8) error {
+	if !pv.IsActive() {
+		return ErrStandby
+	}
+	if hrs := (HRSKey{Height: height, Round: round, Step: step}); !pv.handoff.Less(hrs) {
+		return ErrBelowHandoff
+	}
+	if pv.ColdStart != nil {
+		if err := pv.ColdStart.Check(height); err != nil {
+			return err
+		}
+	}
+	if pv.RateLimiter != nil && !pv.RateLimiter.Allow() {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// approve asks the pre-sign webhook, if any, to approve the signature
+func (pv *PvGuard) approve(ctx context.Context, request PreSignRequest) error {
+	if pv.PreSign == nil {
+		return nil
+	}
+	return pv.PreSign.Approve(ctx, request)
+}
+
+// record adds a successful signature to the stats, if any, and reports a sign state that could not be saved
+func (pv *PvGuard) record(height int64, start time.Time, err error) {
+	if pv.Stats != nil && err == nil {
+		pv.Stats.record(height, time.Since(start))
+	}
+	if pv.OnSaveFailure != nil && IsSignStateSaveError(err) {
+		pv.OnSaveFailure(err)
+	}
 }
 
 // GetPubKey implementes types.PrivValidator
@@ -22,16 +151,86 @@ func (pv *PvGuard) GetPubKey() (crypto.PubKey, error) {
 	return pv.PrivValidator.GetPubKey()
 }
 
+// sign signs with signFn if allowed and approved, and records the outcome
+// Must be called with pvMutex held.
+func (pv *PvGuard) sign(ctx context.Context, request PreSignRequest, step int8, signFn func() error) error {
+	var start time.Time
+	err := pv.checkAllowed(request.Height, request.Round, step)
+	if err == nil {
+		err = pv.approve(ctx, request)
+	}
+	if err == nil {
+		start = time.Now()
+		err = signFn()
+		pv.record(request.Height, start, err)
+	}
+	pv.publish(request, start, err)
+	return err
+}
+
+// publish hands the outcome of a sign request to the event subscribers, if any
+// start is zero for a request refused before signing.
+func (pv *PvGuard) publish(request PreSignRequest, start time.Time, err error) {
+	if pv.Events == nil {
+		return
+	}
+	event := SignEvent{
+		Time:    time.Now(),
+		ChainID: request.ChainID,
+		Type:    request.Type,
+		Height:  request.Height,
+		Round:   request.Round,
+		Success: err == nil,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if !start.IsZero() {
+		event.LatencyMs = float64(event.Time.Sub(start)) / float64(time.Millisecond)
+	}
+	pv.Events.publish(event)
+}
+
 // SignVote implementes types.PrivValidator
 func (pv *PvGuard) SignVote(chainID string, vote *tmProto.Vote) error {
 	pv.pvMutex.Lock()
 	defer pv.pvMutex.Unlock()
-	return pv.PrivValidator.SignVote(chainID, vote)
+	return pv.sign(context.Background(), newVotePreSignRequest(chainID, vote), VoteToStep(vote), func() error {
+		return pv.PrivValidator.SignVote(chainID, vote)
+	})
 }
 
 // SignProposal implementes types.PrivValidator
 func (pv *PvGuard) SignProposal(chainID string, proposal *tmProto.Proposal) error {
 	pv.pvMutex.Lock()
 	defer pv.pvMutex.Unlock()
-	return pv.PrivValidator.SignProposal(chainID, proposal)
+	return pv.sign(context.Background(), newProposalPreSignRequest(chainID, proposal), stepPropose, func() error {
+		return pv.PrivValidator.SignProposal(chainID, proposal)
+	})
+}
+
+// SignVoteContext implements ContextPrivValidator
+// The context is only honored if the underlying PrivValidator is a ContextPrivValidator
+func (pv *PvGuard) SignVoteContext(ctx context.Context, chainID string, vote *tmProto.Vote) error {
+	pv.pvMutex.Lock()
+	defer pv.pvMutex.Unlock()
+	return pv.sign(ctx, newVotePreSignRequest(chainID, vote), VoteToStep(vote), func() error {
+		if ctxPv, ok := pv.PrivValidator.(ContextPrivValidator); ok {
+			return ctxPv.SignVoteContext(ctx, chainID, vote)
+		}
+		return pv.PrivValidator.SignVote(chainID, vote)
+	})
+}
+
+// SignProposalContext implements ContextPrivValidator
+// The context is only honored if the underlying PrivValidator is a ContextPrivValidator
+func (pv *PvGuard) SignProposalContext(ctx context.Context, chainID string, proposal *tmProto.Proposal) error {
+	pv.pvMutex.Lock()
+	defer pv.pvMutex.Unlock()
+	return pv.sign(ctx, newProposalPreSignRequest(chainID, proposal), stepPropose, func() error {
+		if ctxPv, ok := pv.PrivValidator.(ContextPrivValidator); ok {
+			return ctxPv.SignProposalContext(ctx, chainID, proposal)
+		}
+		return pv.PrivValidator.SignProposal(chainID, proposal)
+	})
 }
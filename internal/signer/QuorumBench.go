@@ -0,0 +1,128 @@
+package signer
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// LatencyPercentiles summarizes latency samples
+type LatencyPercentiles struct {
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// latencyPercentiles returns the nearest-rank percentiles of samples, zero if there are none
+func latencyPercentiles(samples []time.Duration) LatencyPercentiles {
+	if len(samples) == 0 {
+		return LatencyPercentiles{}
+	}
+	sorted := append([]time.Duration{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := func(percentile int) time.Duration {
+		idx := (percentile*len(sorted)+99)/100 - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return sorted[idx]
+	}
+	return LatencyPercentiles{P50: rank(50), P95: rank(95), P99: rank(99), Max: sorted[len(sorted)-1]}
+}
+
+// CosignerBench is the round trip latency of one cosigner
+type CosignerBench struct {
+	ID       int
+	Latency  LatencyPercentiles
+	Failures int
+}
+
+// QuorumBench is the result of BenchQuorum
+type QuorumBench struct {
+	Rounds int
+
+	// time until the needed peers answered, over the rounds they did, 0 if none is needed
+	Quorum LatencyPercentiles
+
+	// rounds in which fewer than the needed peers answered
+	QuorumFailures int
+
+	Cosigners []CosignerBench
+}
+
+// time the first round of BenchQuorum waits for the cosigners to generate their throwaway rsa key
+const benchWarmUpTimeout = 30 * time.Second
+
+// BenchQuorum measures the signing latency of the cosigners over count rounds, each having all of them
+// run the rsa and ed25519 work of a sign round at once and waiting until needed of them answered,
+// the peers a sign round waits for. With needed 0, the local share alone signs and no peer is waited for.
+//
+// The rounds run on throwaway keys and ephemeral secrets, see LocalCosigner.BenchSign, so the share
+// watermark of the cosigners is left as is and nothing is signed with the validator key. A first
+// round, not counted, has the cosigners generate their throwaway rsa key.
+func BenchQuorum(ctx context.Context, cosigners []*RemoteCosigner, needed int, count int, timeout time.Duration) QuorumBench {
+	type answer struct {
+		idx     int
+		latency time.Duration
+		err     error
+	}
+
+	warmUpCtx, cancelWarmUp := context.WithTimeout(ctx, benchWarmUpTimeout)
+	warmUp := make(chan struct{}, len(cosigners))
+	for _, cosigner := range cosigners {
+		go func(cosigner *RemoteCosigner) {
+			_ = cosigner.BenchSign(warmUpCtx)
+			warmUp <- struct{}{}
+		}(cosigner)
+	}
+	for range cosigners {
+		<-warmUp
+	}
+	cancelWarmUp()
+
+	bench := QuorumBench{Rounds: count, Cosigners: make([]CosignerBench, len(cosigners))}
+	samples := make([][]time.Duration, len(cosigners))
+	var quorumSamples []time.Duration
+
+	for round := 0; round < count; round++ {
+		roundCtx, cancel := context.WithTimeout(ctx, timeout)
+		answers := make(chan answer, len(cosigners))
+		start := time.Now()
+		if needed <= 0 {
+			quorumSamples = append(quorumSamples, 0)
+		}
+		for idx, cosigner := range cosigners {
+			go func(idx int, cosigner *RemoteCosigner) {
+				err := cosigner.BenchSign(roundCtx)
+				answers <- answer{idx: idx, latency: time.Since(start), err: err}
+			}(idx, cosigner)
+		}
+
+		answered := 0
+		for range cosigners {
+			answer := <-answers
+			if answer.err != nil {
+				bench.Cosigners[answer.idx].Failures++
+				continue
+			}
+			samples[answer.idx] = append(samples[answer.idx], answer.latency)
+			answered++
+			if answered == needed {
+				quorumSamples = append(quorumSamples, answer.latency)
+			}
+		}
+		cancel()
+		if answered < needed {
+			bench.QuorumFailures++
+		}
+	}
+
+	for idx, cosigner := range cosigners {
+		bench.Cosigners[idx].ID = cosigner.GetID()
+		bench.Cosigners[idx].Latency = latencyPercentiles(samples[idx])
+	}
+	bench.Quorum = latencyPercentiles(quorumSamples)
+	return bench
+}
@@ -0,0 +1,95 @@
+package signer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func TestLatencyPercentiles(test *testing.T) {
+	samples := make([]time.Duration, 100)
+	for idx := range samples {
+		samples[idx] = time.Duration(100-idx) * time.Millisecond
+	}
+	require.Equal(test, LatencyPercentiles{
+		P50: 50 * time.Millisecond,
+		P95: 95 * time.Millisecond,
+		P99: 99 * time.Millisecond,
+		Max: 100 * time.Millisecond,
+	}, latencyPercentiles(samples))
+
+	require.Equal(test, LatencyPercentiles{}, latencyPercentiles(nil))
+	single := latencyPercentiles([]time.Duration{time.Second})
+	require.Equal(test, time.Second, single.P50)
+	require.Equal(test, time.Second, single.P99)
+}
+
+func TestBenchQuorum(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+	privateKey := tmCryptoEd25519.GenPrivKey()
+	stateDir := test.TempDir()
+	signState, err := LoadOrCreateSignState(filepath.Join(stateDir, "state.json"))
+	require.NoError(test, err)
+	signState.Height = 10
+	require.NoError(test, signState.Save())
+	peer := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ShareKey: privateKey[:32], ID: 2},
+		SignState:   &signState,
+		RsaKey:      *rsaKey,
+		Total:       3,
+		Threshold:   2,
+	})
+
+	rpcServer := NewCosignerRpcServer(&CosignerRpcServerConfig{
+		Logger:        logger,
+		ListenAddress: "tcp://127.0.0.1:0",
+		Cosigner:      peer,
+	})
+	require.NoError(test, rpcServer.Start())
+	defer rpcServer.Stop()
+
+	cosigners := []*RemoteCosigner{
+		NewRemoteCosigner(2, "tcp://"+rpcServer.Addr().String()),
+		NewRemoteCosigner(3, "tcp://127.0.0.1:1"),
+	}
+	bench := BenchQuorum(context.Background(), cosigners, 1, 5, time.Second)
+	require.Equal(test, 5, bench.Rounds)
+	require.Zero(test, bench.QuorumFailures)
+	require.NotZero(test, bench.Quorum.P50)
+	require.Equal(test, 2, bench.Cosigners[0].ID)
+	require.Zero(test, bench.Cosigners[0].Failures)
+	require.Equal(test, 5, bench.Cosigners[1].Failures)
+
+	// the watermark, sign state and ephemeral metadata of the peer are left as is
+	require.Equal(test, HRSKey{Height: 10}, peer.SignStateWatermark())
+	require.Empty(test, peer.hrsMeta)
+	saved, err := LoadSignState(filepath.Join(stateDir, "state.json"))
+	require.NoError(test, err)
+	require.Equal(test, int64(10), saved.Height)
+	require.Nil(test, saved.Signature)
+
+	// the throwaway rsa key is not ours
+	require.NotNil(test, peer.benchKey)
+	require.False(test, peer.benchKey.Equal(rsaKey))
+
+	// both peers needed, the unreachable one fails every round
+	bench = BenchQuorum(context.Background(), cosigners, 2, 2, time.Second)
+	require.Equal(test, 2, bench.QuorumFailures)
+
+	// the local share alone reaches the threshold
+	bench = BenchQuorum(context.Background(), cosigners[1:], 0, 3, time.Second)
+	require.Zero(test, bench.QuorumFailures)
+	require.Equal(test, LatencyPercentiles{}, bench.Quorum)
+	require.Equal(test, 3, bench.Cosigners[0].Failures)
+}
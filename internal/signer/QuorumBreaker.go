@@ -0,0 +1,77 @@
+package signer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// ErrQuorumLost is returned without contacting the cosigners while the quorum breaker is open
+var ErrQuorumLost = errors.New("fewer than threshold cosigners reachable, refusing to sign until they recover")
+
+// DefaultQuorumProbeInterval is how often a sign request is let through to probe the
+// cosigners while the quorum breaker is open
+const DefaultQuorumProbeInterval = 10 * time.Second
+
+// quorumBreaker opens once a signing round reaches fewer than threshold cosigners.
+// A cosigner answering with an error, e.g. refusing to sign below its watermark, was reached.
+// While open, sign requests fail fast with ErrQuorumLost instead of each waiting out
+// the cosigner timeouts. Every probeInterval one request is let through to probe the
+// cosigners, closing the breaker if enough of them respond.
+type quorumBreaker struct {
+	threshold     int
+	probeInterval time.Duration
+
+	open      bool
+	nextProbe time.Time
+
+	// 1 while open, 0 while closed
+	gauge metrics.Gauge
+
+	logger log.Logger
+}
+
+// allow returns ErrQuorumLost if the request should not contact the cosigners
+func (breaker *quorumBreaker) allow(now time.Time) error {
+	if !breaker.open {
+		return nil
+	}
+	if now.Before(breaker.nextProbe) {
+		return ErrQuorumLost
+	}
+
+	// this request probes the cosigners
+	breaker.nextProbe = now.Add(breaker.probeInterval)
+	return nil
+}
+
+// record updates the breaker with the number of cosigners, ourselves included, that
+// were reached in a signing round
+func (breaker *quorumBreaker) record(reachable int, now time.Time) {
+	if reachable < breaker.threshold {
+		if !breaker.open {
+			breaker.logger.Error("Quorum lost", "reachable", reachable, "threshold", breaker.threshold)
+		}
+		breaker.open = true
+		breaker.nextProbe = now.Add(breaker.probeInterval)
+		breaker.gauge.Set(1)
+		return
+	}
+
+	if breaker.open {
+		breaker.logger.Info("Quorum recovered", "reachable", reachable)
+	}
+	breaker.open = false
+	breaker.gauge.Set(0)
+}
+
+// isTransportError returns true if err shows that a cosigner could not be reached or did not answer in time,
+// rather than an answer refusing the request
+func isTransportError(err error) bool {
+	var netErr net.Error
+	return isDialError(err) || errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
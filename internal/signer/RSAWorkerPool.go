@@ -0,0 +1,79 @@
+package signer
+
+import (
+	"context"
+	"errors"
+	"runtime"
+)
+
+// DefaultRSAQueueLength is the default rsa_queue_length
+const DefaultRSAQueueLength = 64
+
+// ErrRSAQueueFull is returned for an rsa operation while rsa_queue_length others are already waiting
+var ErrRSAQueueFull = errors.New("too many rsa operations waiting")
+
+// RSAWorkerPool bounds the rsa operations of the ephemeral secret exchange running at once.
+// Operations beyond the workers wait in a queue of bounded length, and fail right away once it is full,
+// so a burst of requests is shed instead of starving everything else of CPU.
+type RSAWorkerPool struct {
+	workers chan struct{}
+	queue   chan struct{}
+	metrics *Metrics
+}
+
+// NewRSAWorkerPool returns a pool running up to workers operations at once, runtime.NumCPU() if 0,
+// with up to queueLength more waiting
+func NewRSAWorkerPool(workers int, queueLength int, metrics *Metrics) *RSAWorkerPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if metrics == nil {
+		metrics = NopMetrics()
+	}
+	return &RSAWorkerPool{
+		workers: make(chan struct{}, workers),
+		queue:   make(chan struct{}, queueLength),
+		metrics: metrics,
+	}
+}
+
+// Do runs fn once a worker is free, returning its error
+// Returns ErrRSAQueueFull if the queue is full, or the error of ctx if it is done while waiting.
+func (pool *RSAWorkerPool) Do(ctx context.Context, fn func() error) error {
+	select {
+	case pool.workers <- struct{}{}:
+	default:
+		if err := pool.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	pool.metrics.RSAWorkersBusy.Set(float64(len(pool.workers)))
+	defer func() {
+		<-pool.workers
+		pool.metrics.RSAWorkersBusy.Set(float64(len(pool.workers)))
+	}()
+	return fn()
+}
+
+// wait queues for a worker, which is taken once wait returns without an error
+func (pool *RSAWorkerPool) wait(ctx context.Context) error {
+	select {
+	case pool.queue <- struct{}{}:
+	default:
+		pool.metrics.RSAQueueRejections.Add(1)
+		return ErrRSAQueueFull
+	}
+	pool.metrics.RSAQueueDepth.Set(float64(len(pool.queue)))
+	defer func() {
+		<-pool.queue
+		pool.metrics.RSAQueueDepth.Set(float64(len(pool.queue)))
+	}()
+
+	select {
+	case pool.workers <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,60 @@
+package signer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRSAWorkerPool(test *testing.T) {
+	pool := NewRSAWorkerPool(1, 1, nil)
+
+	// occupy the only worker
+	release := make(chan struct{})
+	running := make(chan struct{})
+	busy := make(chan error)
+	go func() {
+		busy <- pool.Do(context.Background(), func() error {
+			close(running)
+			<-release
+			return nil
+		})
+	}()
+	<-running
+
+	// the next operation waits in the queue
+	queued := make(chan error)
+	go func() {
+		queued <- pool.Do(context.Background(), func() error { return nil })
+	}()
+	require.Eventually(test, func() bool { return len(pool.queue) == 1 }, time.Second, time.Millisecond)
+
+	// beyond the queue, operations are refused right away
+	err := pool.Do(context.Background(), func() error { return nil })
+	require.Equal(test, ErrRSAQueueFull, err)
+
+	close(release)
+	require.NoError(test, <-busy)
+	require.NoError(test, <-queued)
+
+	// a waiting operation gives up with its context
+	release = make(chan struct{})
+	running = make(chan struct{})
+	go func() {
+		busy <- pool.Do(context.Background(), func() error {
+			close(running)
+			<-release
+			return nil
+		})
+	}()
+	<-running
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(test, context.DeadlineExceeded, pool.Do(ctx, func() error { return nil }))
+	require.Len(test, pool.queue, 0)
+
+	close(release)
+	require.NoError(test, <-busy)
+}
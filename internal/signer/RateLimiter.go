@@ -0,0 +1,55 @@
+package signer
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMaxSignaturesPerMinute is far above the ~3 signatures per block a healthy chain needs
+const DefaultMaxSignaturesPerMinute = 600
+
+// RateLimiter is a token bucket allowing bursts of up to perMinute events,
+// refilled continuously at perMinute events per minute.
+//
+// RateLimiter is thread safe
+type RateLimiter struct {
+	mtx sync.Mutex
+
+	capacity float64
+	tokens   float64
+	// tokens per second
+	rate float64
+	last time.Time
+
+	now func() time.Time
+}
+
+// NewRateLimiter returns a full token bucket for perMinute events per minute
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// Allow takes a token from the bucket, returning false if the bucket is empty
+func (rl *RateLimiter) Allow() bool {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	now := rl.now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.capacity {
+		rl.tokens = rl.capacity
+	}
+	rl.last = now
+
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
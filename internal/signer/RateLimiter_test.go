@@ -0,0 +1,52 @@
+package signer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func TestRateLimiter(test *testing.T) {
+	now := time.Unix(0, 0)
+
+	limiter := NewRateLimiter(60)
+	limiter.now = func() time.Time { return now }
+	limiter.last = now
+
+	// the full bucket allows a burst
+	for i := 0; i < 60; i++ {
+		require.True(test, limiter.Allow())
+	}
+	require.False(test, limiter.Allow())
+
+	// one token per second is refilled
+	now = now.Add(time.Second)
+	require.True(test, limiter.Allow())
+	require.False(test, limiter.Allow())
+
+	// the bucket never holds more than its capacity
+	now = now.Add(time.Hour)
+	for i := 0; i < 60; i++ {
+		require.True(test, limiter.Allow())
+	}
+	require.False(test, limiter.Allow())
+}
+
+func TestPvGuardRateLimit(test *testing.T) {
+	pv := &PvGuard{
+		PrivValidator: tm.NewMockPV(),
+		RateLimiter:   NewRateLimiter(2),
+	}
+
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1}
+	require.NoError(test, pv.SignVote("chain-id", &vote))
+
+	proposal := tmProto.Proposal{Type: tmProto.ProposalType, Height: 2}
+	require.NoError(test, pv.SignProposal("chain-id", &proposal))
+
+	vote = tmProto.Vote{Type: tmProto.PrevoteType, Height: 3}
+	require.Equal(test, ErrRateLimited, pv.SignVote("chain-id", &vote))
+}
@@ -2,30 +2,379 @@ package signer
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	client "github.com/tendermint/tendermint/rpc/jsonrpc/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
 )
 
-var (
-	ctx = context.Background()
+const (
+	// CosignerTransportHTTP1 sends cosigner rpc requests over HTTP/1.1 keep-alive connections
+	CosignerTransportHTTP1 = "http1"
+
+	// CosignerTransportH2C multiplexes cosigner rpc requests over a single cleartext HTTP/2 connection
+	CosignerTransportH2C = "h2c"
+)
+
+// CosignerSRVScheme prefixes a cosigner address looked up as a DNS SRV record, e.g. srv://_cosigner._tcp.example.com
+// The record is looked up again for every new connection, so the cosigner can move without a config change.
+const CosignerSRVScheme = "srv://"
+
+// lookupSRV resolves the SRV records of a cosigner address, replaced in tests
+var lookupSRV = net.LookupSRV
+
+// dialSRVTarget dials a target of the SRV records of a cosigner address, replaced in tests
+var dialSRVTarget = (&net.Dialer{}).DialContext
+
+// time a target of the SRV records may take to accept a connection before the next one is dialed
+const srvTargetDialTimeout = 2 * time.Second
+
+const (
+	// maxReconnectWaiters bounds the requests to a cosigner waiting for it to accept connections again
+	maxReconnectWaiters = 16
+
+	// how often a waiting request redials the cosigner
+	reconnectRetryInterval = 100 * time.Millisecond
+
+	// after failing to dial a cosigner, it is not dialed again for this long, doubling with every
+	// further failure up to cosignerBackoffMax, and reset once a dial succeeds
+	cosignerBackoffMin = 100 * time.Millisecond
+	cosignerBackoffMax = 5 * time.Second
 )
 
 // RemoteCosigner uses tendermint rpc to request signing from a remote cosigner
 type RemoteCosigner struct {
 	id      int
 	address string
+
+	// shared by all requests so connections to the cosigner are reused
+	httpClient    *http.Client
+	httpClientErr error
+
+	metrics *Metrics
+
+	// how long a request waits for a cosigner refusing connections to come back, 0 fails right away
+	// waiting is shared by copies of the cosigner and bounds the number of waiting requests
+	reconnectWait time.Duration
+	waiting       chan struct{}
+
+	// shared by copies of the cosigner, like waiting
+	backoff *dialBackoff
+
+	// tampers with the responses in faultinjection builds, nil otherwise
+	faults *FaultInjector
 }
 
 // NewRemoteCosigner returns a newly initialized RemoteCosigner
 func NewRemoteCosigner(id int, address string) *RemoteCosigner {
 	cosigner := &RemoteCosigner{
 		id:      id,
-		address: address,
+		address: escapeIPv6Zone(address),
+		metrics: NopMetrics(),
+		waiting: make(chan struct{}, maxReconnectWaiters),
+		backoff: &dialBackoff{},
 	}
+	cosigner.httpClient, cosigner.httpClientErr = newCosignerHTTPClient(cosigner.address, CosignerTransportHTTP1)
 	return cosigner
 }
 
+// SetTransport selects the protocol used to reach the cosigner, see CosignerTransportHTTP1 and CosignerTransportH2C
+// Should be called before the cosigner is used
+func (cosigner *RemoteCosigner) SetTransport(transport string) error {
+	httpClient, err := newCosignerHTTPClient(cosigner.address, transport)
+	if err != nil {
+		return err
+	}
+	cosigner.httpClient = httpClient
+	cosigner.httpClientErr = nil
+	return nil
+}
+
+// SetMetrics sets the metrics to report the cosigner's connectivity to
+func (cosigner *RemoteCosigner) SetMetrics(metrics *Metrics) {
+	cosigner.metrics = metrics
+}
+
+// SetReconnectWait makes requests wait up to wait for a cosigner that refuses connections,
+// e.g. while it restarts, instead of failing right away. The caller's context still bounds each request.
+// Should be called before the cosigner is used
+func (cosigner *RemoteCosigner) SetReconnectWait(wait time.Duration) {
+	cosigner.reconnectWait = wait
+}
+
+// SetFaultInjector has injector tamper with the responses of the cosigner, for chaos testing only
+// Should be called before the cosigner is used
+func (cosigner *RemoteCosigner) SetFaultInjector(injector *FaultInjector) {
+	cosigner.faults = injector
+}
+
+// call makes an rpc to the cosigner, injecting faults if a fault injector is set
+func (cosigner *RemoteCosigner) call(ctx context.Context, method string, params map[string]interface{}, result interface{}) error {
+	err := cosigner.callWithReconnectWait(ctx, method, params, result)
+	if cosigner.faults != nil {
+		return cosigner.faults.apply(ctx, result, err)
+	}
+	return err
+}
+
+// callWithReconnectWait makes an rpc to the cosigner
+// If the cosigner cannot be dialed, the request is retried until reconnectWait passes or ctx is done.
+// Only requests that never reached the cosigner are retried, and at most maxReconnectWaiters at once;
+// the cosigner checks its watermark on each request as usual.
+func (cosigner *RemoteCosigner) callWithReconnectWait(ctx context.Context, method string, params map[string]interface{}, result interface{}) error {
+	remoteClient, err := cosigner.rpcClient()
+	if err != nil {
+		return err
+	}
+
+	err = cosigner.attempt(ctx, remoteClient, method, params, result)
+	if err == nil || cosigner.reconnectWait <= 0 || !isDialError(err) {
+		return err
+	}
+
+	select {
+	case cosigner.waiting <- struct{}{}:
+		defer func() { <-cosigner.waiting }()
+	default:
+		// enough requests are waiting already
+		return err
+	}
+
+	deadline := time.NewTimer(cosigner.reconnectWait)
+	defer deadline.Stop()
+	retry := time.NewTicker(reconnectRetryInterval)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return err
+		case <-retry.C:
+		}
+
+		err = cosigner.attempt(ctx, remoteClient, method, params, result)
+		if err == nil || !isDialError(err) {
+			return err
+		}
+	}
+}
+
+// attempt makes the rpc, or fails right away with an errDialBackoff while backing off from failed dials
+func (cosigner *RemoteCosigner) attempt(
+	ctx context.Context,
+	remoteClient *client.Client,
+	method string,
+	params map[string]interface{},
+	result interface{},
+) error {
+	if wait := cosigner.backoff.wait(time.Now()); wait > 0 {
+		return &errDialBackoff{wait: wait}
+	}
+
+	_, err := remoteClient.Call(ctx, method, params, result)
+	if ctx.Err() == nil {
+		wait := cosigner.backoff.record(isDialError(err), time.Now())
+		cosigner.metrics.CosignerDialBackoff.With("cosigner", strconv.Itoa(cosigner.id), "address", cosigner.address).Set(wait.Seconds())
+	}
+	return err
+}
+
+// errDialBackoff is returned without dialing a cosigner that failed to be dialed just before
+type errDialBackoff struct {
+	wait time.Duration
+}
+
+func (err *errDialBackoff) Error() string {
+	return fmt.Sprintf("cosigner unreachable, not dialing it again for %v", err.wait)
+}
+
+// dialBackoff spaces out the dials of a cosigner that refuses connections, with capped exponential backoff and jitter
+type dialBackoff struct {
+	mtx      sync.Mutex
+	failures int
+	next     time.Time
+}
+
+// wait returns how long to wait before dialing again, 0 to dial now
+func (backoff *dialBackoff) wait(now time.Time) time.Duration {
+	backoff.mtx.Lock()
+	defer backoff.mtx.Unlock()
+	if now.Before(backoff.next) {
+		return backoff.next.Sub(now)
+	}
+	return 0
+}
+
+// record updates the backoff with the outcome of a dial and returns the time until the next dial
+func (backoff *dialBackoff) record(failed bool, now time.Time) time.Duration {
+	backoff.mtx.Lock()
+	defer backoff.mtx.Unlock()
+	if !failed {
+		backoff.failures = 0
+		backoff.next = time.Time{}
+		return 0
+	}
+
+	delay := cosignerBackoffMax
+	if backoff.failures < 16 {
+		delay = cosignerBackoffMin << backoff.failures
+		if delay > cosignerBackoffMax {
+			delay = cosignerBackoffMax
+		}
+	}
+	backoff.failures++
+
+	// between half and all of the delay, so cosigners dialing the same peer spread out
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	backoff.next = now.Add(delay)
+	return delay
+}
+
+// isDialError returns true if the request failed to connect, or was not sent while backing off,
+// so it never reached the cosigner
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	var backoffErr *errDialBackoff
+	return (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &backoffErr)
+}
+
+// reportResult updates the connectivity gauge with the outcome of an rpc
+// Calls we canceled ourselves, e.g. once enough other cosigners responded, say nothing about the peer
+func (cosigner *RemoteCosigner) reportResult(ctx context.Context, err error) {
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	cosigner.metrics.CosignerUp.With("cosigner", strconv.Itoa(cosigner.id), "address", cosigner.address).Set(up)
+}
+
+// newCosignerHTTPClient returns an http client for the cosigner address using the transport
+func newCosignerHTTPClient(address string, transport string) (*http.Client, error) {
+	var httpClient *http.Client
+	if strings.HasPrefix(address, CosignerSRVScheme) {
+		httpClient = &http.Client{
+			Transport: &http.Transport{
+				DisableCompression: true,
+				DialContext:        dialSRV(strings.TrimPrefix(address, CosignerSRVScheme)),
+			},
+		}
+	} else {
+		var err error
+		httpClient, err = client.DefaultHTTPClient(address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch transport {
+	case "", CosignerTransportHTTP1:
+		return httpClient, nil
+	case CosignerTransportH2C:
+		// prior knowledge h2c, the cosigner rpc server accepts it next to HTTP/1.1
+		transport := httpClient.Transport.(*http.Transport)
+		dial := transport.Dial
+		if transport.DialContext != nil {
+			dial = func(network, addr string) (net.Conn, error) {
+				return transport.DialContext(context.Background(), network, addr)
+			}
+		}
+		httpClient.Transport = &http2.Transport{
+			AllowHTTP:          true,
+			DisableCompression: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(network, addr)
+			},
+		}
+		return httpClient, nil
+	default:
+		return nil, fmt.Errorf("Unsupported cosigner transport: %s", transport)
+	}
+}
+
+// dialSRV returns a dial function connecting to the targets of the SRV records of name,
+// in the order of their priority and weight, until one accepts the connection.
+// Each target is dialed by name, trying each of its A and AAAA records in turn, for up to srvTargetDialTimeout
+// so that an unreachable target does not hold up the others.
+func dialSRV(name string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, records, err := lookupSRV("", "", name)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("no SRV records for %s", name)
+		}
+
+		for _, record := range records {
+			var conn net.Conn
+			target := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+			targetCtx, cancel := context.WithTimeout(ctx, srvTargetDialTimeout)
+			conn, err = dialSRVTarget(targetCtx, "tcp", target)
+			cancel()
+			if err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+}
+
+// rpcClient returns a jsonrpc client sharing the cosigner's http client
+func (cosigner *RemoteCosigner) rpcClient() (*client.Client, error) {
+	if cosigner.httpClientErr != nil {
+		return nil, cosigner.httpClientErr
+	}
+	address := cosigner.address
+	if strings.HasPrefix(address, CosignerSRVScheme) {
+		// the connections are made by the SRV dialer, the url only names the cosigner
+		address = "tcp://" + strings.TrimPrefix(address, CosignerSRVScheme)
+	}
+	return client.NewWithHTTPClient(address, cosigner.httpClient)
+}
+
+// escapeIPv6Zone escapes the zone of a scoped IPv6 literal, e.g. tcp://[fe80::1%eth0]:1234
+// The rpc client parses addresses as URLs, which require the % to be escaped as %25
+func escapeIPv6Zone(address string) string {
+	start := strings.Index(address, "[")
+	end := strings.Index(address, "]")
+	if start < 0 || end < start {
+		return address
+	}
+
+	host := address[start:end]
+	if !strings.Contains(host, "%") || strings.Contains(host, "%25") {
+		return address
+	}
+	return address[:start] + strings.Replace(host, "%", "%25", 1) + address[end:]
+}
+
+// startSpan starts a span for an rpc call to the cosigner
+func (cosigner *RemoteCosigner) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.Int("cosigner", cosigner.id),
+		attribute.String("address", cosigner.address),
+	))
+}
+
 // GetID returns the ID of the remote cosigner
 // Implements the cosigner interface
 func (cosigner *RemoteCosigner) GetID() int {
@@ -34,47 +383,57 @@ func (cosigner *RemoteCosigner) GetID() int {
 
 // Sign the sign request using the cosigner's share
 // Return the signed bytes or an error
-func (cosigner *RemoteCosigner) Sign(signReq CosignerSignRequest) (CosignerSignResponse, error) {
+func (cosigner *RemoteCosigner) Sign(ctx context.Context, signReq CosignerSignRequest) (res CosignerSignResponse, err error) {
+	ctx, span := cosigner.startSpan(ctx, "RemoteCosigner.Sign")
+	defer func() {
+		cosigner.reportResult(ctx, err)
+		endSpan(span, err)
+	}()
+
 	params := map[string]interface{}{
 		"arg": RpcSignRequest{
-			SignBytes: signReq.SignBytes,
+			SignBytes:    signReq.SignBytes,
+			TraceContext: injectTraceContext(ctx),
+			RequestID:    requestID(ctx),
 		},
 	}
 
-	remoteClient, err := client.New(cosigner.address)
-	if err != nil {
-		return CosignerSignResponse{}, err
-	}
 	result := &CosignerSignResponse{}
-	_, err = remoteClient.Call(ctx, "Sign", params, result)
+	err = cosigner.call(ctx, "Sign", params, result)
 	if err != nil {
 		return CosignerSignResponse{}, err
 	}
 
 	return CosignerSignResponse{
-		Timestamp: result.Timestamp,
-		Signature: result.Signature,
+		EphemeralPublic: result.EphemeralPublic,
+		Timestamp:       result.Timestamp,
+		Signature:       result.Signature,
 	}, nil
 }
 
-func (cosigner *RemoteCosigner) GetEphemeralSecretPart(req CosignerGetEphemeralSecretPartRequest) (CosignerGetEphemeralSecretPartResponse, error) {
-	resp := CosignerGetEphemeralSecretPartResponse{}
+func (cosigner *RemoteCosigner) GetEphemeralSecretPart(
+	ctx context.Context,
+	req CosignerGetEphemeralSecretPartRequest,
+) (resp CosignerGetEphemeralSecretPartResponse, err error) {
+	ctx, span := cosigner.startSpan(ctx, "RemoteCosigner.GetEphemeralSecretPart")
+	defer func() {
+		cosigner.reportResult(ctx, err)
+		endSpan(span, err)
+	}()
 
 	params := map[string]interface{}{
 		"arg": RpcGetEphemeralSecretPartRequest{
-			ID:     req.ID,
-			Height: req.Height,
-			Round:  req.Round,
-			Step:   req.Step,
+			ID:           req.ID,
+			Height:       req.Height,
+			Round:        req.Round,
+			Step:         req.Step,
+			TraceContext: injectTraceContext(ctx),
+			RequestID:    requestID(ctx),
 		},
 	}
 
-	remoteClient, err := client.New(cosigner.address)
-	if err != nil {
-		return CosignerGetEphemeralSecretPartResponse{}, err
-	}
 	result := &RpcGetEphemeralSecretPartResponse{}
-	_, err = remoteClient.Call(ctx, "GetEphemeralSecretPart", params, result)
+	err = cosigner.call(ctx, "GetEphemeralSecretPart", params, result)
 	if err != nil {
 		return CosignerGetEphemeralSecretPartResponse{}, err
 	}
@@ -87,11 +446,38 @@ func (cosigner *RemoteCosigner) GetEphemeralSecretPart(req CosignerGetEphemeralS
 	return resp, nil
 }
 
-func (cosigner *RemoteCosigner) HasEphemeralSecretPart(req CosignerHasEphemeralSecretPartRequest) (CosignerHasEphemeralSecretPartResponse, error) {
+// GetSignStateWatermark returns the height, round and step of the last share the cosigner signed
+func (cosigner *RemoteCosigner) GetSignStateWatermark(ctx context.Context) (hrs HRSKey, err error) {
+	ctx, span := cosigner.startSpan(ctx, "RemoteCosigner.GetSignStateWatermark")
+	defer func() {
+		cosigner.reportResult(ctx, err)
+		endSpan(span, err)
+	}()
+
+	result := &RpcSignStateWatermarkResponse{}
+	err = cosigner.call(ctx, "GetSignStateWatermark", map[string]interface{}{}, result)
+	if err != nil {
+		return HRSKey{}, err
+	}
+	return HRSKey{Height: result.Height, Round: result.Round, Step: result.Step}, nil
+}
+
+// BenchSign has the cosigner run the rsa and ed25519 work of a sign round on throwaway keys, see LocalCosigner.BenchSign
+func (cosigner *RemoteCosigner) BenchSign(ctx context.Context) (err error) {
+	ctx, span := cosigner.startSpan(ctx, "RemoteCosigner.BenchSign")
+	defer func() {
+		cosigner.reportResult(ctx, err)
+		endSpan(span, err)
+	}()
+
+	return cosigner.call(ctx, "BenchSign", map[string]interface{}{}, &RpcBenchSignResponse{})
+}
+
+func (cosigner *RemoteCosigner) HasEphemeralSecretPart(ctx context.Context, req CosignerHasEphemeralSecretPartRequest) (CosignerHasEphemeralSecretPartResponse, error) {
 	res := CosignerHasEphemeralSecretPartResponse{}
 	return res, errors.New("Not Implemented")
 }
 
-func (cosigner *RemoteCosigner) SetEphemeralSecretPart(req CosignerSetEphemeralSecretPartRequest) error {
+func (cosigner *RemoteCosigner) SetEphemeralSecretPart(ctx context.Context, req CosignerSetEphemeralSecretPartRequest) error {
 	return errors.New("Not Implemented")
 }
@@ -1,12 +1,16 @@
 package signer
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-kit/kit/metrics"
 	"github.com/stretchr/testify/require"
 	"github.com/tendermint/tendermint/libs/log"
 	server "github.com/tendermint/tendermint/rpc/jsonrpc/server"
@@ -50,7 +54,7 @@ func TestRemoteCosignerSign(test *testing.T) {
 	port := lis.Addr().(*net.TCPAddr).Port
 	cosigner := NewRemoteCosigner(2, fmt.Sprintf("tcp://0.0.0.0:%d", port))
 
-	resp, err := cosigner.Sign(CosignerSignRequest{})
+	resp, err := cosigner.Sign(context.Background(), CosignerSignRequest{})
 	require.NoError(test, err)
 	require.Equal(test, resp.Signature, []byte("hello world"))
 }
@@ -79,7 +83,7 @@ func TestRemoteCosignerGetEphemeralSecretPart(test *testing.T) {
 	port := lis.Addr().(*net.TCPAddr).Port
 	cosigner := NewRemoteCosigner(2, fmt.Sprintf("tcp://0.0.0.0:%d", port))
 
-	resp, err := cosigner.GetEphemeralSecretPart(CosignerGetEphemeralSecretPartRequest{})
+	resp, err := cosigner.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{})
 	require.NoError(test, err)
 	require.Equal(test, resp, CosignerGetEphemeralSecretPartResponse{
 		SourceID:                       1,
@@ -87,3 +91,241 @@ func TestRemoteCosignerGetEphemeralSecretPart(test *testing.T) {
 		EncryptedSharePart:             []byte("bar"),
 	})
 }
+
+func TestRemoteCosignerSRV(test *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer lis.Close()
+
+	// a target that refuses connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	go func() {
+		routes := map[string]*server.RPCFunc{
+			"Sign": server.NewRPCFunc(rpcSignRequest, "arg"),
+		}
+		mux := http.NewServeMux()
+		server.RegisterRPCFuncs(mux, routes, logger)
+		server.Serve(lis, mux, logger, server.DefaultConfig())
+	}()
+
+	lookups := 0
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		require.Equal(test, "_cosigner._tcp.example.com", name)
+		lookups++
+		return name, []*net.SRV{
+			{Target: "localhost.", Port: uint16(closedPort), Priority: 1},
+			{Target: "127.0.0.1", Port: uint16(lis.Addr().(*net.TCPAddr).Port), Priority: 2},
+		}, nil
+	}
+	defer func() { lookupSRV = net.LookupSRV }()
+
+	// the first target is unreachable, the cosigner is reached on the second
+	cosigner := NewRemoteCosigner(2, "srv://_cosigner._tcp.example.com")
+	resp, err := cosigner.Sign(context.Background(), CosignerSignRequest{})
+	require.NoError(test, err)
+	require.Equal(test, []byte("hello world"), resp.Signature)
+	require.Equal(test, 1, lookups)
+}
+
+func TestDialSRVUnreachableTarget(test *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer lis.Close()
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return name, []*net.SRV{
+			{Target: "blackholed.example.com.", Port: 2222, Priority: 1},
+			{Target: "127.0.0.1", Port: uint16(lis.Addr().(*net.TCPAddr).Port), Priority: 2},
+		}, nil
+	}
+	defer func() { lookupSRV = net.LookupSRV }()
+
+	// the first target never answers, as if its packets were dropped
+	dialSRVTarget = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if strings.HasPrefix(address, "blackholed") {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+	defer func() { dialSRVTarget = (&net.Dialer{}).DialContext }()
+
+	dial := dialSRV("_cosigner._tcp.example.com")
+	start := time.Now()
+	conn, err := dial(context.Background(), "tcp", "")
+	require.NoError(test, err)
+	conn.Close()
+	require.Less(test, int64(time.Since(start)), int64(srvTargetDialTimeout+time.Second))
+
+	// the caller's context bounds the whole dial
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = dial(ctx, "tcp", "")
+	require.Error(test, err)
+	require.Less(test, int64(time.Since(start)), int64(srvTargetDialTimeout))
+}
+
+func TestEscapeIPv6Zone(test *testing.T) {
+	cases := map[string]string{
+		"tcp://1.2.3.4:1234":           "tcp://1.2.3.4:1234",
+		"tcp://[::1]:1234":             "tcp://[::1]:1234",
+		"tcp://[fe80::1%eth0]:1234":    "tcp://[fe80::1%25eth0]:1234",
+		"tcp://[fe80::1%25eth0]:1234":  "tcp://[fe80::1%25eth0]:1234",
+		"[fe80::1%eth0]:1234":          "[fe80::1%25eth0]:1234",
+		"tcp://[2001:db8::1234]:26658": "tcp://[2001:db8::1234]:26658",
+	}
+	for address, expected := range cases {
+		require.Equal(test, expected, escapeIPv6Zone(address))
+	}
+}
+
+// recordingGauge keeps the last value set and the labels it was set with
+type recordingGauge struct {
+	labelValues []string
+	value       float64
+}
+
+func (gauge *recordingGauge) With(labelValues ...string) metrics.Gauge {
+	gauge.labelValues = labelValues
+	return gauge
+}
+
+func (gauge *recordingGauge) Set(value float64) {
+	gauge.value = value
+}
+
+func (gauge *recordingGauge) Add(delta float64) {
+	gauge.value += delta
+}
+
+func TestRemoteCosignerReportsConnectivity(test *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer lis.Close()
+
+	routes := map[string]*server.RPCFunc{
+		"Sign": server.NewRPCFunc(rpcSignRequest, "arg"),
+	}
+	mux := http.NewServeMux()
+	server.RegisterRPCFuncs(mux, routes, log.NewNopLogger())
+	go server.Serve(lis, mux, log.NewNopLogger(), server.DefaultConfig())
+
+	gauge := &recordingGauge{}
+	cosignerMetrics := NopMetrics()
+	cosignerMetrics.CosignerUp = gauge
+
+	address := "tcp://" + lis.Addr().String()
+	cosigner := NewRemoteCosigner(2, address)
+	cosigner.SetMetrics(cosignerMetrics)
+
+	_, err = cosigner.Sign(context.Background(), CosignerSignRequest{})
+	require.NoError(test, err)
+	require.Equal(test, []string{"cosigner", "2", "address", address}, gauge.labelValues)
+	require.Equal(test, 1.0, gauge.value)
+
+	// nothing listens on the address once the listener is closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	closed.Close()
+	unreachable := NewRemoteCosigner(3, "tcp://"+closed.Addr().String())
+	unreachable.SetMetrics(cosignerMetrics)
+
+	_, err = unreachable.Sign(context.Background(), CosignerSignRequest{})
+	require.Error(test, err)
+	require.Equal(test, "3", gauge.labelValues[1])
+	require.Equal(test, 0.0, gauge.value)
+
+	// abandoning a call says nothing about the cosigner
+	gauge.value = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = unreachable.Sign(ctx, CosignerSignRequest{})
+	require.Error(test, err)
+	require.Equal(test, 1.0, gauge.value)
+}
+
+func TestRemoteCosignerWaitsForReconnect(test *testing.T) {
+	// reserve an address nothing listens on yet
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	address := lis.Addr().String()
+	lis.Close()
+
+	cosigner := NewRemoteCosigner(2, "tcp://"+address)
+
+	// fails right away by default
+	_, err = cosigner.Sign(context.Background(), CosignerSignRequest{})
+	require.Error(test, err)
+
+	cosigner.SetReconnectWait(5 * time.Second)
+
+	// fails right away once too many requests are waiting
+	for i := 0; i < maxReconnectWaiters; i++ {
+		cosigner.waiting <- struct{}{}
+	}
+	_, err = cosigner.Sign(context.Background(), CosignerSignRequest{})
+	require.Error(test, err)
+	for i := 0; i < maxReconnectWaiters; i++ {
+		<-cosigner.waiting
+	}
+
+	// the cosigner comes back while the request waits
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		lis, err := net.Listen("tcp", address)
+		if err != nil {
+			return
+		}
+		routes := map[string]*server.RPCFunc{
+			"Sign": server.NewRPCFunc(rpcSignRequest, "arg"),
+		}
+		mux := http.NewServeMux()
+		server.RegisterRPCFuncs(mux, routes, log.NewNopLogger())
+		server.Serve(lis, mux, log.NewNopLogger(), server.DefaultConfig())
+	}()
+
+	resp, err := cosigner.Sign(context.Background(), CosignerSignRequest{})
+	require.NoError(test, err)
+	require.Equal(test, []byte("hello world"), resp.Signature)
+}
+
+func TestRemoteCosignerDialBackoff(test *testing.T) {
+	backoff := &dialBackoff{}
+	now := time.Now()
+	require.Equal(test, time.Duration(0), backoff.wait(now))
+
+	// doubling with every failure, between half and all of the delay, up to the cap
+	for _, max := range []time.Duration{100, 200, 400, 800, 1600, 3200, 5000, 5000} {
+		max *= time.Millisecond
+		delay := backoff.record(true, now)
+		require.True(test, delay >= max/2 && delay <= max, delay)
+		require.Equal(test, delay, backoff.wait(now))
+	}
+
+	require.Equal(test, time.Duration(0), backoff.record(false, now))
+	require.Equal(test, time.Duration(0), backoff.wait(now))
+
+	// a cosigner refusing connections is not dialed again right away
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	lis.Close()
+	gauge := &recordingGauge{}
+	cosignerMetrics := NopMetrics()
+	cosignerMetrics.CosignerDialBackoff = gauge
+	cosigner := NewRemoteCosigner(2, "tcp://"+lis.Addr().String())
+	cosigner.SetMetrics(cosignerMetrics)
+
+	_, err = cosigner.Sign(context.Background(), CosignerSignRequest{})
+	require.True(test, isDialError(err))
+	require.Greater(test, gauge.value, 0.0)
+
+	_, err = cosigner.Sign(context.Background(), CosignerSignRequest{})
+	var backoffErr *errDialBackoff
+	require.ErrorAs(test, err, &backoffErr)
+}
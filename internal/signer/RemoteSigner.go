@@ -1,15 +1,22 @@
 package signer
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
+	tmCrypto "github.com/tendermint/tendermint/crypto"
 	tmCryptoEd2219 "github.com/tendermint/tendermint/crypto/ed25519"
 	tmCryptoEncoding "github.com/tendermint/tendermint/crypto/encoding"
 	tmLog "github.com/tendermint/tendermint/libs/log"
 	tmNet "github.com/tendermint/tendermint/libs/net"
 	tmService "github.com/tendermint/tendermint/libs/service"
+	tmP2p "github.com/tendermint/tendermint/p2p"
 	tmP2pConn "github.com/tendermint/tendermint/p2p/conn"
 	tmProtoCrypto "github.com/tendermint/tendermint/proto/tendermint/crypto"
 	tmProtoPrivval "github.com/tendermint/tendermint/proto/tendermint/privval"
@@ -17,6 +24,20 @@ import (
 	tm "github.com/tendermint/tendermint/types"
 )
 
+// DefaultWatchdogTimeoutSeconds is the default node_watchdog_timeout.
+// Nodes ping every few seconds, so this only trips on a stalled connection.
+const DefaultWatchdogTimeoutSeconds = 30
+
+// DefaultNodeWriteTimeoutSeconds is the default node_write_timeout.
+// A response is small, a write taking longer means the node stopped reading.
+const DefaultNodeWriteTimeoutSeconds = 10
+
+// DefaultNodeStartJitterMs is the default node_start_jitter_ms
+const DefaultNodeStartJitterMs = 500
+
+// ErrStepDisabled is returned for requests of a step whose signing is disabled in the config
+var ErrStepDisabled = errors.New("signing this step is disabled")
+
 // ReconnRemoteSigner dials using its dialer and responds to any
 // signature requests using its privVal.
 type ReconnRemoteSigner struct {
@@ -27,7 +48,43 @@ type ReconnRemoteSigner struct {
 	privKey tmCryptoEd2219.PrivKey
 	privVal tm.PrivValidator
 
-	dialer net.Dialer
+	dialer Dialer
+
+	// skips the secret connection handshake, for troubleshooting only
+	insecure bool
+
+	// the ids of the node keys accepted on the secret connection, any key if empty
+	authorizedKeys map[tmP2p.ID]bool
+
+	// stop serving the node after it requested a conflicting signature
+	disconnectOnEquivocation bool
+
+	// the steps refused without signing, by sign_proposals, sign_prevotes and sign_precommits
+	disabledSteps map[int8]bool
+
+	// canceled on stop to abandon any in-flight sign request
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// the connection is dropped if no request is handled for this long, 0 disables the watchdog
+	watchdogTimeout time.Duration
+
+	// a read or write on the connection taking longer fails it, 0 disables the deadline
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// the first dial waits this long, to spread the dials of many nodes
+	startDelay time.Duration
+
+	// the current connection and the time of the last handled request, shared with the watchdog
+	connMtx      sync.Mutex
+	conn         net.Conn
+	lastActivity time.Time
+
+	// the chain id of the last request on the current connection that carried one
+	nodeChainID string
+
+	metrics *Metrics
 }
 
 // NewReconnRemoteSigner return a ReconnRemoteSigner that will dial using the given
@@ -40,7 +97,7 @@ func NewReconnRemoteSigner(
 	logger tmLog.Logger,
 	chainID string,
 	privVal tm.PrivValidator,
-	dialer net.Dialer,
+	dialer Dialer,
 ) *ReconnRemoteSigner {
 	rs := &ReconnRemoteSigner{
 		address: address,
@@ -48,21 +105,159 @@ func NewReconnRemoteSigner(
 		privVal: privVal,
 		dialer:  dialer,
 		privKey: tmCryptoEd2219.GenPrivKey(),
+		metrics: NopMetrics(),
 	}
 
 	rs.BaseService = *tmService.NewBaseService(logger, "RemoteSigner", rs)
 	return rs
 }
 
+// SetPrivKey sets the key the signer authenticates with on the secret connection,
+// in place of the key generated for this run. Must be called before Start.
+func (rs *ReconnRemoteSigner) SetPrivKey(privKey tmCryptoEd2219.PrivKey) {
+	rs.privKey = privKey
+}
+
+// LoadOrGenConnKey loads the secret connection key from a node_key.json style file,
+// generating and saving a new key on first use so the signer keeps its identity across restarts
+func LoadOrGenConnKey(file string) (tmCryptoEd2219.PrivKey, error) {
+	nodeKey, err := tmP2p.LoadOrGenNodeKey(file)
+	if err != nil {
+		return nil, err
+	}
+	privKey, ok := nodeKey.PrivKey.(tmCryptoEd2219.PrivKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", file)
+	}
+	return privKey, nil
+}
+
+// SetWatchdogTimeout sets how long the connection may go without a handled request
+// before it is dropped and redialed. Nodes ping regularly, so a quiet connection is a stalled one.
+// Must be called before Start.
+func (rs *ReconnRemoteSigner) SetWatchdogTimeout(timeout time.Duration) {
+	rs.watchdogTimeout = timeout
+}
+
+// SetTimeouts sets the deadlines for reading a request and writing a response,
+// exceeding one drops the connection to redial. 0 disables a deadline. Must be called before Start.
+func (rs *ReconnRemoteSigner) SetTimeouts(read time.Duration, write time.Duration) {
+	rs.readTimeout = read
+	rs.writeTimeout = write
+}
+
+// SetStartDelay delays the first dial after Start, which does not wait for it.
+// Must be called before Start.
+func (rs *ReconnRemoteSigner) SetStartDelay(delay time.Duration) {
+	rs.startDelay = delay
+}
+
+// SetInsecure makes the signer talk to the node over the raw connection, without the
+// encryption and authentication of the secret connection. For troubleshooting only.
+// Must be called before Start.
+func (rs *ReconnRemoteSigner) SetInsecure(insecure bool) {
+	rs.insecure = insecure
+}
+
+// SetAuthorizedKeys restricts the nodes served to those authenticating with one of the keys of ids
+// on the secret connection. Others are disconnected after the handshake. Must be called before Start.
+func (rs *ReconnRemoteSigner) SetAuthorizedKeys(ids []tmP2p.ID) {
+	rs.authorizedKeys = make(map[tmP2p.ID]bool, len(ids))
+	for _, id := range ids {
+		rs.authorizedKeys[id] = true
+	}
+}
+
+// ParseNodeID checks that id is a node id, the hex encoded address of a node key
+func ParseNodeID(id string) (tmP2p.ID, error) {
+	address, err := hex.DecodeString(id)
+	if err != nil || len(address) != tmCrypto.AddressSize {
+		return "", fmt.Errorf("%q is not a node id, expected %d hex encoded bytes", id, tmCrypto.AddressSize)
+	}
+	return tmP2p.ID(strings.ToLower(id)), nil
+}
+
+// authorize returns an error if the node key is not one of the authorized keys
+func (rs *ReconnRemoteSigner) authorize(pubKey tmCrypto.PubKey) error {
+	if len(rs.authorizedKeys) == 0 {
+		return nil
+	}
+	id := tmP2p.PubKeyToID(pubKey)
+	if !rs.authorizedKeys[id] {
+		return fmt.Errorf("node key %s is not in authorized_node_keys", id)
+	}
+	return nil
+}
+
+// SetDisconnectOnEquivocation stops the signer, dropping the node connection until restarted,
+// once the node requests a signature conflicting with one already given. Must be called before Start.
+func (rs *ReconnRemoteSigner) SetDisconnectOnEquivocation(disconnect bool) {
+	rs.disconnectOnEquivocation = disconnect
+}
+
+// SetSignSteps refuses to sign the steps passed false, answering their requests with ErrStepDisabled.
+// Must be called before Start.
+func (rs *ReconnRemoteSigner) SetSignSteps(proposals bool, prevotes bool, precommits bool) {
+	rs.disabledSteps = map[int8]bool{
+		stepPropose:   !proposals,
+		stepPrevote:   !prevotes,
+		stepPrecommit: !precommits,
+	}
+}
+
+// checkStep refuses the requests of a step disabled by SetSignSteps
+func (rs *ReconnRemoteSigner) checkStep(step int8) error {
+	if !rs.disabledSteps[step] {
+		return nil
+	}
+	var option string
+	switch step {
+	case stepPropose:
+		option = "sign_proposals"
+	case stepPrevote:
+		option = "sign_prevotes"
+	case stepPrecommit:
+		option = "sign_precommits"
+	}
+	return fmt.Errorf("%w by %s = false", ErrStepDisabled, option)
+}
+
+// SetMetrics sets the metrics to report to. Must be called before Start.
+func (rs *ReconnRemoteSigner) SetMetrics(metrics *Metrics) {
+	rs.metrics = metrics
+}
+
 // OnStart implements cmn.Service.
 func (rs *ReconnRemoteSigner) OnStart() error {
+	if rs.insecure {
+		rs.Logger.Error("INSECURE: the connection to the node is not encrypted or authenticated, never use this in production", "address", rs.address)
+	}
+
+	rs.ctx, rs.cancel = context.WithCancel(context.Background())
 	go rs.loop()
+	if rs.watchdogTimeout > 0 {
+		go rs.watchdog()
+	}
 	return nil
 }
 
+// OnStop implements cmn.Service.
+func (rs *ReconnRemoteSigner) OnStop() {
+	rs.cancel()
+}
+
 // main loop for ReconnRemoteSigner
 func (rs *ReconnRemoteSigner) loop() {
+	if rs.startDelay > 0 {
+		select {
+		case <-time.After(rs.startDelay):
+		case <-rs.ctx.Done():
+			return
+		}
+	}
+
 	var conn net.Conn
+	connected := false
 	for {
 		if !rs.IsRunning() {
 			if conn != nil {
@@ -77,6 +272,7 @@ func (rs *ReconnRemoteSigner) loop() {
 			proto, address := tmNet.ProtocolAndAddress(rs.address)
 			netConn, err := rs.dialer.Dial(proto, address)
 			if err != nil {
+				rs.metrics.NodeDialFailures.With("node", rs.address).Add(1)
 				rs.Logger.Error("Dialing", "err", err)
 				rs.Logger.Info("Retrying", "sleep (s)", 3, "address", rs.address)
 				time.Sleep(time.Second * 3)
@@ -84,14 +280,28 @@ func (rs *ReconnRemoteSigner) loop() {
 			}
 
 			rs.Logger.Info("Connected", "address", rs.address)
-			conn, err = tmP2pConn.MakeSecretConnection(netConn, rs.privKey)
-			if err != nil {
-				conn = nil
-				rs.Logger.Error("Secret Conn", "err", err)
-				rs.Logger.Info("Retrying", "sleep (s)", 3, "address", rs.address)
-				time.Sleep(time.Second * 3)
-				continue
+			if rs.insecure {
+				conn = netConn
+			} else {
+				secretConn, err := tmP2pConn.MakeSecretConnection(netConn, rs.privKey)
+				if err == nil {
+					err = rs.authorize(secretConn.RemotePubKey())
+				}
+				if err != nil {
+					rs.metrics.NodeHandshakeFailures.With("node", rs.address).Add(1)
+					netConn.Close()
+					rs.Logger.Error("Secret Conn", "err", err)
+					rs.Logger.Info("Retrying", "sleep (s)", 3, "address", rs.address)
+					time.Sleep(time.Second * 3)
+					continue
+				}
+				conn = secretConn
+			}
+			if connected {
+				rs.metrics.NodeReconnects.With("node", rs.address).Add(1)
 			}
+			connected = true
+			rs.setConn(conn)
 		}
 
 		// since dialing can take time, we check running again
@@ -102,42 +312,156 @@ func (rs *ReconnRemoteSigner) loop() {
 			return
 		}
 
+		if err := setDeadline(conn.SetReadDeadline, rs.readTimeout); err != nil {
+			rs.Logger.Error("SetReadDeadline", "err", err)
+		}
 		req, err := ReadMsg(conn)
 		if err != nil {
 			rs.Logger.Error("readMsg", "err", err)
 			conn.Close()
 			conn = nil
+			rs.setConn(nil)
 			continue
 		}
 
-		res, err := rs.handleRequest(req)
+		ctx := withRequestID(rs.ctx, newRequestID())
+		res, err := rs.handleRequest(ctx, req)
 		if err != nil {
 			// only log the error; we reply with an error in handleRequest since the reply needs to be typed based on error
-			rs.Logger.Error("handleRequest", "err", err)
+			rs.Logger.Error("handleRequest", "err", err, "request", requestID(ctx))
+		}
+		equivocation := IsEquivocation(err)
+		if equivocation {
+			rs.reportEquivocation(ctx, err)
 		}
 
+		if err := setDeadline(conn.SetWriteDeadline, rs.writeTimeout); err != nil {
+			rs.Logger.Error("SetWriteDeadline", "err", err)
+		}
 		err = WriteMsg(conn, res)
 		if err != nil {
+			// part of the response may have been sent. The connection is never written to again,
+			// so the node cannot take the rest of a later message for it: after the redial,
+			// it starts reading a fresh stream, and a secret connection with fresh keys.
 			rs.Logger.Error("writeMsg", "err", err)
 			conn.Close()
 			conn = nil
+			rs.setConn(nil)
+			continue
+		}
+
+		if equivocation && rs.disconnectOnEquivocation {
+			rs.Logger.Error("Disconnecting from the node after an equivocation, restart the signer to reconnect", "address", rs.address)
+			if err := rs.Stop(); err != nil {
+				rs.Logger.Error("Stop", "err", err)
+			}
+			conn.Close()
+			rs.setConn(nil)
+			return
+		}
+
+		rs.touch()
+	}
+}
+
+// reportEquivocation raises the alarm for a request conflicting with an earlier signature
+func (rs *ReconnRemoteSigner) reportEquivocation(ctx context.Context, err error) {
+	rs.Logger.Error("EQUIVOCATION: the node requested a signature conflicting with one already given. "+
+		"The network may have forked or the node may be compromised", "address", rs.address, "err", err, "request", requestID(ctx))
+	rs.metrics.NodeEquivocations.With("node", rs.address).Add(1)
+}
+
+// setConn records the connection the loop is serving, nil once it has been dropped
+func (rs *ReconnRemoteSigner) setConn(conn net.Conn) {
+	rs.connMtx.Lock()
+	defer rs.connMtx.Unlock()
+
+	rs.conn = conn
+	rs.lastActivity = time.Now()
+	rs.nodeChainID = ""
+}
+
+// setDeadline sets a deadline timeout from now with set, or clears it if timeout is 0
+func setDeadline(set func(time.Time) error, timeout time.Duration) error {
+	if timeout <= 0 {
+		return set(time.Time{})
+	}
+	return set(time.Now().Add(timeout))
+}
+
+// IsConnected returns true while a connection to the node is established
+func (rs *ReconnRemoteSigner) IsConnected() bool {
+	rs.connMtx.Lock()
+	defer rs.connMtx.Unlock()
+	return rs.conn != nil
+}
+
+// touch records that a request was handled
+func (rs *ReconnRemoteSigner) touch() {
+	now := time.Now()
+
+	rs.connMtx.Lock()
+	rs.lastActivity = now
+	rs.connMtx.Unlock()
+
+	rs.metrics.NodeLastActivity.With("node", rs.address).Set(float64(now.Unix()))
+}
+
+// watchdog drops the connection if the loop stops handling requests while the connection is up,
+// e.g. when a write blocks on a stalled connection. Closing the connection fails the blocked
+// read or write so the loop reconnects.
+func (rs *ReconnRemoteSigner) watchdog() {
+	ticker := time.NewTicker(rs.watchdogTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rs.ctx.Done():
+			return
+		case <-ticker.C:
+			rs.checkActivity()
 		}
 	}
 }
 
-func (rs *ReconnRemoteSigner) handleRequest(req tmProtoPrivval.Message) (tmProtoPrivval.Message, error) {
+func (rs *ReconnRemoteSigner) checkActivity() {
+	rs.connMtx.Lock()
+	defer rs.connMtx.Unlock()
+
+	if rs.conn == nil {
+		return
+	}
+
+	idle := time.Since(rs.lastActivity)
+	if idle < rs.watchdogTimeout {
+		return
+	}
+
+	rs.Logger.Error("No requests handled, dropping connection", "address", rs.address, "idle", idle)
+	rs.metrics.NodeWatchdogReconnects.With("node", rs.address).Add(1)
+	if err := rs.conn.Close(); err != nil {
+		rs.Logger.Error("Close", "err", err)
+	}
+	rs.conn = nil
+}
+
+func (rs *ReconnRemoteSigner) handleRequest(ctx context.Context, req tmProtoPrivval.Message) (tmProtoPrivval.Message, error) {
 	msg := tmProtoPrivval.Message{}
 	var err error
 
 	switch typedReq := req.Sum.(type) {
 	case *tmProtoPrivval.Message_PubKeyRequest:
-		pubKey, err := rs.privVal.GetPubKey()
+		// the node asks for the key on connecting, so a node on another chain fails right away
+		var pubKey tmCrypto.PubKey
+		if err = rs.checkChainID(typedReq.PubKeyRequest.GetChainId()); err == nil {
+			pubKey, err = rs.privVal.GetPubKey()
+		}
 		if err != nil {
 			rs.Logger.Error("Failed to get Pub Key", "address", rs.address, "error", err, "pubKey", typedReq)
 			msg.Sum = &tmProtoPrivval.Message_PubKeyResponse{PubKeyResponse: &tmProtoPrivval.PubKeyResponse{
 				PubKey: tmProtoCrypto.PublicKey{},
 				Error: &tmProtoPrivval.RemoteSignerError{
-					Code:        0,
+					Code:        ErrorCode(err),
 					Description: err.Error(),
 				},
 			}}
@@ -148,7 +472,7 @@ func (rs *ReconnRemoteSigner) handleRequest(req tmProtoPrivval.Message) (tmProto
 				msg.Sum = &tmProtoPrivval.Message_PubKeyResponse{PubKeyResponse: &tmProtoPrivval.PubKeyResponse{
 					PubKey: tmProtoCrypto.PublicKey{},
 					Error: &tmProtoPrivval.RemoteSignerError{
-						Code:        0,
+						Code:        ErrorCode(err),
 						Description: err.Error(),
 					},
 				}}
@@ -157,35 +481,59 @@ func (rs *ReconnRemoteSigner) handleRequest(req tmProtoPrivval.Message) (tmProto
 			}
 		}
 	case *tmProtoPrivval.Message_SignVoteRequest:
-		vote := typedReq.SignVoteRequest.Vote
-		err = rs.privVal.SignVote(rs.chainID, vote)
+		vote := typedReq.SignVoteRequest.GetVote()
+		if vote == nil {
+			err = newSignerError(ErrorCodeInvalidRequest, errors.New("sign vote request is missing the vote"))
+		} else if !IsVoteType(vote.Type) {
+			err = newSignerError(ErrorCodeInvalidRequest, fmt.Errorf("unknown vote type %d", vote.Type))
+		} else if err = rs.checkChainID(typedReq.SignVoteRequest.GetChainId()); err == nil {
+			if err = rs.checkStep(VoteToStep(vote)); err == nil {
+				err = rs.signVote(ctx, vote)
+			}
+		}
+		if vote != nil {
+			rs.Logger.Debug("Canonical vote", "node", rs.address, "request", requestID(ctx), "canonical", canonicalJSON(func() []byte {
+				return tm.VoteSignBytes(typedReq.SignVoteRequest.GetChainId(), vote)
+			}))
+		}
 		if err != nil {
-			rs.Logger.Error("Failed to sign vote", "address", rs.address, "error", err, "vote", vote)
+			rs.Logger.Error("Failed to sign vote", "address", rs.address, "request", requestID(ctx), "error", err, "vote", vote)
 			msg.Sum = &tmProtoPrivval.Message_SignedVoteResponse{SignedVoteResponse: &tmProtoPrivval.SignedVoteResponse{
 				Vote: tmProto.Vote{},
 				Error: &tmProtoPrivval.RemoteSignerError{
-					Code:        0,
+					Code:        ErrorCode(err),
 					Description: err.Error(),
 				},
 			}}
 		} else {
-			rs.Logger.Info("Signed vote", "node", rs.address, "height", vote.Height, "round", vote.Round, "type", vote.Type)
+			rs.Logger.Info("Signed vote", "node", rs.address, "request", requestID(ctx), "height", vote.Height, "round", vote.Round, "type", vote.Type)
 			msg.Sum = &tmProtoPrivval.Message_SignedVoteResponse{SignedVoteResponse: &tmProtoPrivval.SignedVoteResponse{Vote: *vote, Error: nil}}
 		}
 	case *tmProtoPrivval.Message_SignProposalRequest:
-		proposal := typedReq.SignProposalRequest.Proposal
-		err = rs.privVal.SignProposal(rs.chainID, typedReq.SignProposalRequest.Proposal)
+		proposal := typedReq.SignProposalRequest.GetProposal()
+		if proposal == nil {
+			err = newSignerError(ErrorCodeInvalidRequest, errors.New("sign proposal request is missing the proposal"))
+		} else if err = rs.checkChainID(typedReq.SignProposalRequest.GetChainId()); err == nil {
+			if err = rs.checkStep(stepPropose); err == nil {
+				err = rs.signProposal(ctx, proposal)
+			}
+		}
+		if proposal != nil {
+			rs.Logger.Debug("Canonical proposal", "node", rs.address, "request", requestID(ctx), "canonical", canonicalJSON(func() []byte {
+				return tm.ProposalSignBytes(typedReq.SignProposalRequest.GetChainId(), proposal)
+			}))
+		}
 		if err != nil {
-			rs.Logger.Error("Failed to sign proposal", "address", rs.address, "error", err, "proposal", proposal)
+			rs.Logger.Error("Failed to sign proposal", "address", rs.address, "request", requestID(ctx), "error", err, "proposal", proposal)
 			msg.Sum = &tmProtoPrivval.Message_SignedProposalResponse{SignedProposalResponse: &tmProtoPrivval.SignedProposalResponse{
 				Proposal: tmProto.Proposal{},
 				Error: &tmProtoPrivval.RemoteSignerError{
-					Code:        0,
+					Code:        ErrorCode(err),
 					Description: err.Error(),
 				},
 			}}
 		} else {
-			rs.Logger.Info("Signed proposal", "node", rs.address, "height", proposal.Height, "round", proposal.Round, "type", proposal.Type)
+			rs.Logger.Info("Signed proposal", "node", rs.address, "request", requestID(ctx), "height", proposal.Height, "round", proposal.Round, "type", proposal.Type)
 			msg.Sum = &tmProtoPrivval.Message_SignedProposalResponse{SignedProposalResponse: &tmProtoPrivval.SignedProposalResponse{
 				Proposal: *proposal,
 				Error:    nil,
@@ -193,9 +541,96 @@ func (rs *ReconnRemoteSigner) handleRequest(req tmProtoPrivval.Message) (tmProto
 		}
 	case *tmProtoPrivval.Message_PingRequest:
 		msg.Sum = &tmProtoPrivval.Message_PingResponse{PingResponse: &tmProtoPrivval.PingResponse{}}
+	case nil:
+		// a message of another protocol decodes as an empty one, its fields unknown
+		err = errors.New("empty request, the node may be using the amino privval protocol of Tendermint v0.33 or earlier, which is not supported")
 	default:
 		err = fmt.Errorf("unknown msg: %v", typedReq)
 	}
 
 	return msg, err
 }
+
+// checkChainID refuses requests for another chain than configured
+// Nodes that do not send a chain id are trusted to be on the configured chain
+func (rs *ReconnRemoteSigner) checkChainID(chainID string) error {
+	if chainID == "" {
+		return nil
+	}
+
+	rs.connMtx.Lock()
+	changed := chainID != rs.nodeChainID
+	rs.nodeChainID = chainID
+	rs.connMtx.Unlock()
+
+	if chainID != rs.chainID {
+		if changed {
+			rs.Logger.Error("CHAIN ID MISMATCH: the node is on another chain than configured, refusing all its requests",
+				"node", rs.address, "node_chain_id", chainID, "chain_id", rs.chainID)
+		}
+		return newSignerError(ErrorCodeChainMismatch, fmt.Errorf("request for chain %s, signing for %s", chainID, rs.chainID))
+	}
+	return nil
+}
+
+// CheckReady returns an error unless the node is connected and its last request that carried
+// a chain id was for our chain. The error has ErrorCodeChainMismatch if it was for another chain.
+func (rs *ReconnRemoteSigner) CheckReady() error {
+	rs.connMtx.Lock()
+	defer rs.connMtx.Unlock()
+
+	switch {
+	case rs.conn == nil:
+		return fmt.Errorf("node %s is not connected", rs.address)
+	case rs.nodeChainID == "":
+		return fmt.Errorf("node %s has not sent its chain id yet", rs.address)
+	case rs.nodeChainID != rs.chainID:
+		return newSignerError(ErrorCodeChainMismatch, fmt.Errorf("node %s is on chain %s, signing for %s", rs.address, rs.nodeChainID, rs.chainID))
+	}
+	return nil
+}
+
+// signVote signs with the context if the privVal supports it
+func (rs *ReconnRemoteSigner) signVote(ctx context.Context, vote *tmProto.Vote) error {
+	if ctxPv, ok := rs.privVal.(ContextPrivValidator); ok {
+		return ctxPv.SignVoteContext(ctx, rs.chainID, vote)
+	}
+	return rs.privVal.SignVote(rs.chainID, vote)
+}
+
+// signProposal signs with the context if the privVal supports it
+func (rs *ReconnRemoteSigner) signProposal(ctx context.Context, proposal *tmProto.Proposal) error {
+	if ctxPv, ok := rs.privVal.(ContextPrivValidator); ok {
+		return ctxPv.SignProposalContext(ctx, rs.chainID, proposal)
+	}
+	return rs.privVal.SignProposal(rs.chainID, proposal)
+}
+
+// ResolveSourceAddress resolves the local address to dial nodes from
+// The source is either an IP address or the name of a network interface, in which case
+// the first address of the interface is used.
+func ResolveSourceAddress(source string) (net.Addr, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		return &net.TCPAddr{IP: ip}, nil
+	}
+
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil, fmt.Errorf("source address %s is neither an IP address nor an interface: %v", source, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			tcpAddr := &net.TCPAddr{IP: ipNet.IP}
+			if ipNet.IP.IsLinkLocalUnicast() {
+				tcpAddr.Zone = iface.Name
+			}
+			return tcpAddr, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no addresses", source)
+}
@@ -0,0 +1,510 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/p2p"
+	p2pConn "github.com/tendermint/tendermint/p2p/conn"
+	"github.com/tendermint/tendermint/privval"
+	tmProtoPrivval "github.com/tendermint/tendermint/proto/tendermint/privval"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func newTestRemoteSigner() *ReconnRemoteSigner {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	return NewReconnRemoteSigner("tcp://127.0.0.1:0", logger, "chain-id", tm.NewMockPV(), &net.Dialer{})
+}
+
+func TestRemoteSignerHandleRequestMissingVote(test *testing.T) {
+	rs := newTestRemoteSigner()
+
+	req := tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_SignVoteRequest{
+		SignVoteRequest: &tmProtoPrivval.SignVoteRequest{},
+	}}
+	res, err := rs.handleRequest(context.Background(), req)
+	require.Error(test, err)
+	require.NotNil(test, res.GetSignedVoteResponse().Error)
+	require.Equal(test, ErrorCodeInvalidRequest, res.GetSignedVoteResponse().Error.Code)
+}
+
+func TestRemoteSignerHandleRequestChainMismatch(test *testing.T) {
+	rs := newTestRemoteSigner()
+
+	req := tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_SignVoteRequest{
+		SignVoteRequest: &tmProtoPrivval.SignVoteRequest{
+			Vote:    &tmProto.Vote{Type: tmProto.PrevoteType, Height: 1},
+			ChainId: "other-chain",
+		},
+	}}
+	res, err := rs.handleRequest(context.Background(), req)
+	require.Error(test, err)
+	require.Equal(test, ErrorCodeChainMismatch, res.GetSignedVoteResponse().Error.Code)
+}
+
+func TestRemoteSignerHandleRequestStepDisabled(test *testing.T) {
+	rs := newTestRemoteSigner()
+	rs.SetSignSteps(false, true, true)
+
+	req := tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_SignProposalRequest{
+		SignProposalRequest: &tmProtoPrivval.SignProposalRequest{
+			Proposal: &tmProto.Proposal{Type: tmProto.ProposalType, Height: 1},
+			ChainId:  "chain-id",
+		},
+	}}
+	res, err := rs.handleRequest(context.Background(), req)
+	require.True(test, errors.Is(err, ErrStepDisabled))
+	require.Contains(test, err.Error(), "sign_proposals")
+	require.Equal(test, ErrorCodeRefused, res.GetSignedProposalResponse().Error.Code)
+
+	// votes are still signed
+	req = tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_SignVoteRequest{
+		SignVoteRequest: &tmProtoPrivval.SignVoteRequest{
+			Vote:    &tmProto.Vote{Type: tmProto.PrevoteType, Height: 1},
+			ChainId: "chain-id",
+		},
+	}}
+	res, err = rs.handleRequest(context.Background(), req)
+	require.NoError(test, err)
+	require.Nil(test, res.GetSignedVoteResponse().Error)
+}
+
+func TestRemoteSignerCheckReady(test *testing.T) {
+	rs := newTestRemoteSigner()
+	idle := newTestRemoteSigner()
+	service := &Service{nodes: []*ReconnRemoteSigner{rs, idle}}
+	require.Error(test, rs.CheckReady())
+	require.Error(test, service.checkReady())
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	rs.setConn(conn)
+	require.Error(test, rs.CheckReady())
+
+	pubKeyRequest := func(chainID string) *tmProtoPrivval.PubKeyResponse {
+		res, _ := rs.handleRequest(context.Background(), tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_PubKeyRequest{
+			PubKeyRequest: &tmProtoPrivval.PubKeyRequest{ChainId: chainID},
+		}})
+		return res.GetPubKeyResponse()
+	}
+
+	// one node on our chain is enough while the others are not connected
+	require.Nil(test, pubKeyRequest("chain-id").Error)
+	require.NoError(test, rs.CheckReady())
+	require.NoError(test, service.checkReady())
+
+	// a node on another chain is refused its key and keeps us not ready
+	res := pubKeyRequest("other-chain")
+	require.NotNil(test, res.Error)
+	require.Equal(test, ErrorCodeChainMismatch, res.Error.Code)
+	require.Equal(test, ErrorCodeChainMismatch, ErrorCode(rs.CheckReady()))
+	require.Equal(test, ErrorCodeChainMismatch, ErrorCode(service.checkReady()))
+
+	// until it reconnects
+	rs.setConn(conn)
+	require.Error(test, rs.CheckReady())
+	require.NotEqual(test, ErrorCodeChainMismatch, ErrorCode(rs.CheckReady()))
+}
+
+func TestRemoteSignerHandleRequestUnknownVoteType(test *testing.T) {
+	rs := newTestRemoteSigner()
+
+	req := tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_SignVoteRequest{
+		SignVoteRequest: &tmProtoPrivval.SignVoteRequest{
+			Vote: &tmProto.Vote{Type: tmProto.ProposalType, Height: 1},
+		},
+	}}
+	res, err := rs.handleRequest(context.Background(), req)
+	require.Error(test, err)
+	require.NotNil(test, res.GetSignedVoteResponse().Error)
+}
+
+func TestRemoteSignerHandleRequestEmpty(test *testing.T) {
+	rs := newTestRemoteSigner()
+
+	// e.g. an amino message, whose prefix bytes are no field of the protobuf message
+	var req tmProtoPrivval.Message
+	require.NoError(test, req.Unmarshal([]byte{0x6a, 0x03, 0x2c, 0x07, 0x00}))
+	_, err := rs.handleRequest(context.Background(), req)
+	require.Error(test, err)
+	require.Contains(test, err.Error(), "amino")
+}
+
+func TestRemoteSignerHandleRequestMissingProposal(test *testing.T) {
+	rs := newTestRemoteSigner()
+
+	req := tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_SignProposalRequest{
+		SignProposalRequest: &tmProtoPrivval.SignProposalRequest{},
+	}}
+	res, err := rs.handleRequest(context.Background(), req)
+	require.Error(test, err)
+	require.NotNil(test, res.GetSignedProposalResponse().Error)
+}
+
+func TestResolveSourceAddress(test *testing.T) {
+	addr, err := ResolveSourceAddress("::1")
+	require.NoError(test, err)
+	require.Equal(test, "[::1]:0", addr.String())
+
+	addr, err = ResolveSourceAddress("127.0.0.1")
+	require.NoError(test, err)
+	require.Equal(test, "127.0.0.1:0", addr.String())
+
+	_, err = ResolveSourceAddress("not-an-interface")
+	require.Error(test, err)
+}
+
+func TestLoadOrGenConnKey(test *testing.T) {
+	file := filepath.Join(test.TempDir(), "node_key.json")
+
+	privKey, err := LoadOrGenConnKey(file)
+	require.NoError(test, err)
+	require.FileExists(test, file)
+
+	// the same identity is loaded on the next start
+	loaded, err := LoadOrGenConnKey(file)
+	require.NoError(test, err)
+	require.Equal(test, privKey, loaded)
+}
+
+func TestRemoteSignerWatchdogDropsIdleConnection(test *testing.T) {
+	rs := newTestRemoteSigner()
+	rs.SetWatchdogTimeout(time.Second)
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	rs.setConn(conn)
+
+	// recently active connections are left alone
+	rs.checkActivity()
+	require.NotNil(test, rs.conn)
+
+	rs.lastActivity = time.Now().Add(-2 * time.Second)
+	rs.checkActivity()
+	require.Nil(test, rs.conn)
+
+	// the loop's blocked read or write on the closed connection fails
+	_, err := conn.Write([]byte{0})
+	require.Error(test, err)
+}
+
+func TestRemoteSignerInsecure(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer listener.Close()
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	rs := NewReconnRemoteSigner("tcp://"+listener.Addr().String(), logger, "chain-id", tm.NewMockPV(), &net.Dialer{})
+	rs.SetInsecure(true)
+	require.NoError(test, rs.Start())
+	defer rs.Stop()
+
+	conn, err := listener.Accept()
+	require.NoError(test, err)
+	defer conn.Close()
+
+	// the node side talks plain privval messages, without a secret connection handshake
+	err = WriteMsg(conn, tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_PingRequest{
+		PingRequest: &tmProtoPrivval.PingRequest{},
+	}})
+	require.NoError(test, err)
+
+	res, err := ReadMsg(conn)
+	require.NoError(test, err)
+	require.NotNil(test, res.GetPingResponse())
+}
+
+func TestRemoteSignerAuthorizedKeys(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer listener.Close()
+
+	authorizedKey := ed25519.GenPrivKey()
+	nodeID, err := ParseNodeID(strings.ToUpper(string(p2p.PubKeyToID(authorizedKey.PubKey()))))
+	require.NoError(test, err)
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	rs := NewReconnRemoteSigner("tcp://"+listener.Addr().String(), logger, "chain-id", tm.NewMockPV(), &net.Dialer{})
+	rs.SetAuthorizedKeys([]p2p.ID{nodeID})
+	handshakeFailures := &recordingCounter{}
+	reconnects := &recordingCounter{}
+	rs.metrics = NopMetrics()
+	rs.metrics.NodeHandshakeFailures = handshakeFailures
+	rs.metrics.NodeReconnects = reconnects
+	require.NoError(test, rs.Start())
+	defer rs.Stop()
+
+	ping := func(nodeKey ed25519.PrivKey) error {
+		conn, err := listener.Accept()
+		require.NoError(test, err)
+		defer conn.Close()
+		secretConn, err := p2pConn.MakeSecretConnection(conn, nodeKey)
+		require.NoError(test, err)
+
+		err = WriteMsg(secretConn, tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_PingRequest{
+			PingRequest: &tmProtoPrivval.PingRequest{},
+		}})
+		if err != nil {
+			return err
+		}
+		_, err = ReadMsg(secretConn)
+		return err
+	}
+
+	// an unknown node key is disconnected after the handshake
+	require.Error(test, ping(ed25519.GenPrivKey()))
+	require.Equal(test, 1.0, handshakeFailures.Value())
+	require.Equal(test, 0.0, reconnects.Value())
+
+	// the signer redials and serves the authorized key
+	require.NoError(test, ping(authorizedKey))
+	require.Equal(test, 1.0, handshakeFailures.Value())
+	require.Equal(test, 0.0, reconnects.Value())
+
+	_, err = ParseNodeID("not-a-node-id")
+	require.Error(test, err)
+}
+
+// recordingCounter sums what is added to it, with any labels
+type recordingCounter struct {
+	mtx   sync.Mutex
+	total float64
+}
+
+func (counter *recordingCounter) With(labelValues ...string) metrics.Counter {
+	return counter
+}
+
+func (counter *recordingCounter) Add(delta float64) {
+	counter.mtx.Lock()
+	defer counter.mtx.Unlock()
+	counter.total += delta
+}
+
+func (counter *recordingCounter) Value() float64 {
+	counter.mtx.Lock()
+	defer counter.mtx.Unlock()
+	return counter.total
+}
+
+func TestRemoteSignerDialFailures(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	rs := NewReconnRemoteSigner("tcp://"+address, logger, "chain-id", tm.NewMockPV(), &net.Dialer{})
+	dialFailures := &recordingCounter{}
+	rs.metrics = NopMetrics()
+	rs.metrics.NodeDialFailures = dialFailures
+	require.NoError(test, rs.Start())
+	defer rs.Stop()
+
+	require.Eventually(test, func() bool { return dialFailures.Value() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestRemoteSignerStartDelay(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer listener.Close()
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	rs := NewReconnRemoteSigner("tcp://"+listener.Addr().String(), logger, "chain-id", tm.NewMockPV(), &net.Dialer{})
+	rs.SetStartDelay(time.Hour)
+
+	// start does not wait for the delayed dial
+	start := time.Now()
+	require.NoError(test, rs.Start())
+	require.Less(test, int64(time.Since(start)), int64(time.Second))
+
+	accepted := make(chan struct{})
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+			close(accepted)
+		}
+	}()
+	select {
+	case <-accepted:
+		test.Fatal("dialed before the start delay")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// stopping abandons the delay
+	require.NoError(test, rs.Stop())
+}
+
+func TestRemoteSignerReadTimeoutRedials(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer listener.Close()
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	rs := NewReconnRemoteSigner("tcp://"+listener.Addr().String(), logger, "chain-id", tm.NewMockPV(), &net.Dialer{})
+	rs.SetInsecure(true)
+	rs.SetTimeouts(200*time.Millisecond, time.Second)
+	require.NoError(test, rs.Start())
+	defer rs.Stop()
+
+	// a node that never sends a request
+	stalled, err := listener.Accept()
+	require.NoError(test, err)
+	defer stalled.Close()
+
+	// the signer gives up on the stalled connection and dials again
+	require.NoError(test, stalled.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = stalled.Read(make([]byte, 1))
+	require.Error(test, err)
+	netErr, ok := err.(net.Error)
+	require.False(test, ok && netErr.Timeout(), "the signer did not close the connection")
+
+	redialed, err := listener.Accept()
+	require.NoError(test, err)
+	redialed.Close()
+}
+
+// partialWriteConn sends only the first half of its first write, then fails it
+type partialWriteConn struct {
+	net.Conn
+	failed bool
+}
+
+func (conn *partialWriteConn) Write(data []byte) (int, error) {
+	if conn.failed {
+		return conn.Conn.Write(data)
+	}
+	conn.failed = true
+	n, _ := conn.Conn.Write(data[:len(data)/2])
+	return n, errors.New("connection reset mid-message")
+}
+
+// partialWriteDialer wraps the first connection it dials in a partialWriteConn
+type partialWriteDialer struct {
+	net.Dialer
+	dialed bool
+}
+
+func (dialer *partialWriteDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := dialer.Dialer.Dial(network, address)
+	if err != nil || dialer.dialed {
+		return conn, err
+	}
+	dialer.dialed = true
+	return &partialWriteConn{Conn: conn}, nil
+}
+
+func TestRemoteSignerPartialWriteRedials(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer listener.Close()
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	rs := NewReconnRemoteSigner("tcp://"+listener.Addr().String(), logger, "chain-id", tm.NewMockPV(), &partialWriteDialer{})
+	rs.SetInsecure(true)
+	require.NoError(test, rs.Start())
+	defer rs.Stop()
+
+	signVote := func(conn net.Conn) (tmProtoPrivval.Message, error) {
+		hash := bytes.Repeat([]byte{1}, 32)
+		err := WriteMsg(conn, tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_SignVoteRequest{
+			SignVoteRequest: &tmProtoPrivval.SignVoteRequest{
+				Vote: &tmProto.Vote{
+					Type:    tmProto.PrevoteType,
+					Height:  1,
+					BlockID: tmProto.BlockID{Hash: hash, PartSetHeader: tmProto.PartSetHeader{Total: 1, Hash: hash}},
+				},
+				ChainId: "chain-id",
+			},
+		}})
+		require.NoError(test, err)
+		return ReadMsg(conn)
+	}
+
+	// the response is cut off and the connection closed, so the node never reads half a message as a whole one
+	first, err := listener.Accept()
+	require.NoError(test, err)
+	defer first.Close()
+	_, err = signVote(first)
+	require.Error(test, err)
+
+	// the redialed connection starts from a clean message boundary
+	second, err := listener.Accept()
+	require.NoError(test, err)
+	defer second.Close()
+	res, err := signVote(second)
+	require.NoError(test, err)
+	require.Nil(test, res.GetSignedVoteResponse().Error)
+	require.NotEmpty(test, res.GetSignedVoteResponse().Vote.Signature)
+
+	err = WriteMsg(second, tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_PingRequest{
+		PingRequest: &tmProtoPrivval.PingRequest{},
+	}})
+	require.NoError(test, err)
+	res, err = ReadMsg(second)
+	require.NoError(test, err)
+	require.NotNil(test, res.GetPingResponse())
+}
+
+func TestRemoteSignerDisconnectOnEquivocation(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer listener.Close()
+
+	dir := test.TempDir()
+	filePV := privval.GenFilePV(filepath.Join(dir, "key.json"), filepath.Join(dir, "state.json"))
+
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	rs := NewReconnRemoteSigner("tcp://"+listener.Addr().String(), logger, "chain-id", filePV, &net.Dialer{})
+	rs.SetInsecure(true)
+	rs.SetDisconnectOnEquivocation(true)
+	require.NoError(test, rs.Start())
+	defer rs.Stop()
+
+	conn, err := listener.Accept()
+	require.NoError(test, err)
+	defer conn.Close()
+
+	signVote := func(blockHash byte) *tmProtoPrivval.SignedVoteResponse {
+		hash := bytes.Repeat([]byte{blockHash}, 32)
+		err := WriteMsg(conn, tmProtoPrivval.Message{Sum: &tmProtoPrivval.Message_SignVoteRequest{
+			SignVoteRequest: &tmProtoPrivval.SignVoteRequest{
+				Vote: &tmProto.Vote{
+					Type:    tmProto.PrevoteType,
+					Height:  1,
+					BlockID: tmProto.BlockID{Hash: hash, PartSetHeader: tmProto.PartSetHeader{Total: 1, Hash: hash}},
+				},
+				ChainId: "chain-id",
+			},
+		}})
+		require.NoError(test, err)
+
+		res, err := ReadMsg(conn)
+		require.NoError(test, err)
+		return res.GetSignedVoteResponse()
+	}
+
+	require.Nil(test, signVote(1).Error)
+
+	res := signVote(2)
+	require.NotNil(test, res.Error)
+	require.Equal(test, ErrorCodeDoubleSign, res.Error.Code)
+
+	// the connection is dropped and not redialed
+	_, err = ReadMsg(conn)
+	require.Error(test, err)
+	require.False(test, rs.IsRunning())
+}
@@ -0,0 +1,32 @@
+package signer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// requestIDKey is the context key of the request id
+type requestIDKey struct{}
+
+// newRequestID returns a random id to correlate the log lines of a node request
+// across the signer and the cosigners asked for their shares
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// withRequestID returns ctx carrying the request id
+func withRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestID returns the request id carried by ctx, or "" if there is none
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,88 @@
+package signer
+
+import (
+	"bytes"
+	"fmt"
+
+	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
+)
+
+// ReshareKey deals fresh shares of the validator key held by keys, for a cosigner set of total
+// shares requiring threshold to sign.
+// keys must be at least the old threshold of shares of one validator key; the key is reconstructed
+// from them and checked against the validator public key before it is dealt again.
+// The reconstructed key exists in memory for the duration of the call, so like key2shares this is meant
+// to be run offline. The old shares still reconstruct the key and must be destroyed once the new set is running.
+// None of the old shares given is valid in the new set, see checkOldSharesInvalid.
+func ReshareKey(keys []CosignerKey, threshold uint8, total uint8) ([]tsed25519.Scalar, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key shares given")
+	}
+	if threshold < 1 || threshold > total {
+		return nil, fmt.Errorf("threshold %d needs between 1 and %d shares", threshold, total)
+	}
+
+	pubKey := keys[0].PubKey
+	oldTotal := len(keys[0].CosignerKeys)
+	seen := make(map[int]bool)
+	ids := make([]int, 0, len(keys))
+	shares := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if !key.PubKey.Equals(pubKey) {
+			return nil, fmt.Errorf("key %d is for a different validator public key", key.ID)
+		}
+		if len(key.CosignerKeys) != oldTotal {
+			return nil, fmt.Errorf("key %d has %d rsa_pubs, expected %d", key.ID, len(key.CosignerKeys), oldTotal)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("key %d appears more than once", key.ID)
+		}
+		seen[key.ID] = true
+		ids = append(ids, key.ID)
+		shares = append(shares, key.ShareKey)
+	}
+
+	secret := tsed25519.CombineShares(uint8(oldTotal), ids, shares)
+	defer zeroizeBytes(secret)
+	if !bytes.Equal(tsed25519.ScalarMultiplyBase(secret), pubKey.Bytes()) {
+		return nil, fmt.Errorf("the %d key shares do not reconstruct the validator public key, are they fewer than the threshold?", len(keys))
+	}
+	newShares := tsed25519.DealShares(secret, threshold, total)
+	if err := checkOldSharesInvalid(keys, newShares, threshold); err != nil {
+		return nil, err
+	}
+	return newShares, nil
+}
+
+// checkOldSharesInvalid checks that no old share, in place of the new share of the same id, completes
+// a quorum of the new shares reconstructing the validator public key.
+// With a threshold of 1, every share is the key itself, so there is nothing to check.
+func checkOldSharesInvalid(old []CosignerKey, newShares []tsed25519.Scalar, threshold uint8) error {
+	if threshold < 2 {
+		return nil
+	}
+	total := len(newShares)
+	for _, key := range old {
+		if key.ID > total {
+			continue
+		}
+
+		// the old share and the first threshold-1 new shares of other ids
+		ids := []int{key.ID}
+		shares := [][]byte{key.ShareKey}
+		for id := 1; id <= total && len(ids) < int(threshold); id++ {
+			if id != key.ID {
+				ids = append(ids, id)
+				shares = append(shares, newShares[id-1])
+			}
+		}
+
+		secret := tsed25519.CombineShares(uint8(total), ids, shares)
+		valid := bytes.Equal(tsed25519.ScalarMultiplyBase(secret), key.PubKey.Bytes())
+		zeroizeBytes(secret)
+		if valid {
+			return fmt.Errorf("old share %d is still valid in the new set", key.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package signer
+
+import (
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReshareKey(test *testing.T) {
+	keys := dealCosignerKeys(2, 3)
+
+	// a quorum of the old set deals shares for a larger set
+	shares, err := ReshareKey([]CosignerKey{keys[0], keys[2]}, 3, 4)
+	require.NoError(test, err)
+	require.Len(test, shares, 4)
+
+	reshared := make([]CosignerKey, 0)
+	for idx, share := range shares {
+		reshared = append(reshared, CosignerKey{
+			PubKey:       keys[0].PubKey,
+			ShareKey:     share,
+			ID:           idx + 1,
+			CosignerKeys: make([]*rsa.PublicKey, 4),
+		})
+	}
+	_, err = VerifyCosignerKeys(reshared, 3)
+	require.NoError(test, err)
+
+	// an old share in place of a new one does not reconstruct the key
+	require.NoError(test, checkOldSharesInvalid(keys, shares, 3))
+	mixed := append([]CosignerKey{}, reshared...)
+	mixed[1].ShareKey = keys[1].ShareKey
+	_, err = VerifyCosignerKeys(mixed, 3)
+	require.Error(test, err)
+
+	// a share of the new set passed as old is valid in it
+	require.Error(test, checkOldSharesInvalid(reshared[:1], shares, 3))
+
+	// fewer than the old threshold cannot reconstruct the key
+	_, err = ReshareKey(keys[:1], 2, 3)
+	require.Error(test, err)
+
+	other := dealCosignerKeys(2, 3)
+	_, err = ReshareKey([]CosignerKey{keys[0], other[1]}, 2, 3)
+	require.Error(test, err)
+}
@@ -0,0 +1,92 @@
+package signer
+
+import (
+	"fmt"
+
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/privval"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// SafeFilePV is a privval.FilePV returning a SignStateSaveError where the FilePV panics because
+// its sign state could not be written, after retrying the write up to retries times.
+//
+// The FilePV moves its sign state in memory before writing it, so a signature whose state was not
+// written is never returned: every later request first writes that state again, and a request for
+// the same height, round and step gets the signature once it is written.
+type SafeFilePV struct {
+	*privval.FilePV
+
+	retries int
+	logger  log.Logger
+
+	// set while the sign state in memory is not written
+	unsaved bool
+}
+
+// NewSafeFilePV wraps pv, retrying the writes of its sign state up to retries times
+func NewSafeFilePV(pv *privval.FilePV, retries int, logger log.Logger) *SafeFilePV {
+	return &SafeFilePV{FilePV: pv, retries: retries, logger: logger}
+}
+
+// SignVote implements PrivValidator
+func (pv *SafeFilePV) SignVote(chainID string, vote *tmProto.Vote) error {
+	return pv.sign(func() error { return pv.FilePV.SignVote(chainID, vote) })
+}
+
+// SignProposal implements PrivValidator
+func (pv *SafeFilePV) SignProposal(chainID string, proposal *tmProto.Proposal) error {
+	return pv.sign(func() error { return pv.FilePV.SignProposal(chainID, proposal) })
+}
+
+// sign calls sign once the sign state is written, writing it again if sign panicked writing it
+func (pv *SafeFilePV) sign(sign func() error) error {
+	if pv.unsaved {
+		if err := pv.save(); err != nil {
+			return err
+		}
+	}
+
+	panicked, err := recoverSave(sign)
+	if !panicked {
+		return err
+	}
+	pv.unsaved = true
+	if err := pv.save(); err != nil {
+		return err
+	}
+
+	// the same height, round and step again, which the FilePV answers from its sign state
+	panicked, err = recoverSave(sign)
+	if panicked {
+		return &SignStateSaveError{Err: err}
+	}
+	return err
+}
+
+// save writes the sign state, retrying
+func (pv *SafeFilePV) save() error {
+	err := retrySave(pv.retries, saveRetryBackoff, pv.logger, func() error {
+		_, err := recoverSave(func() error {
+			pv.LastSignState.Save()
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return &SignStateSaveError{Err: err}
+	}
+	pv.unsaved = false
+	return nil
+}
+
+// recoverSave calls fn, returning the panic of a failed FilePV sign state write as an error
+func recoverSave(fn func() error) (panicked bool, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panicked = true
+			err = fmt.Errorf("%v", recovered)
+		}
+	}()
+	return false, fn()
+}
@@ -0,0 +1,59 @@
+package signer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/privval"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func TestSafeFilePVSaveFailure(test *testing.T) {
+	dir := test.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	require.NoError(test, os.Mkdir(stateDir, 0700))
+	stateFile := filepath.Join(stateDir, "state.json")
+	filePV := privval.GenFilePV(filepath.Join(dir, "key.json"), stateFile)
+	filePV.Key.Save()
+
+	var failures []error
+	pv := &PvGuard{
+		PrivValidator: NewSafeFilePV(filePV, 1, log.NewNopLogger()),
+		OnSaveFailure: func(err error) { failures = append(failures, err) },
+	}
+	newVote := func(height int64) *tmProto.Vote {
+		hash := bytes.Repeat([]byte{1}, 32)
+		return &tmProto.Vote{
+			Type:    tmProto.PrevoteType,
+			Height:  height,
+			BlockID: tmProto.BlockID{Hash: hash, PartSetHeader: tmProto.PartSetHeader{Total: 1, Hash: hash}},
+		}
+	}
+
+	vote := newVote(1)
+	require.NoError(test, pv.SignVote("chain-id", vote))
+
+	// the state file cannot be written: the signature is refused instead of panicking
+	require.NoError(test, os.RemoveAll(stateDir))
+	vote = newVote(2)
+	err := pv.SignVote("chain-id", vote)
+	require.True(test, IsSignStateSaveError(err), err)
+	require.Empty(test, vote.Signature)
+	require.Len(test, failures, 1)
+
+	// nothing else is signed until the state is written
+	vote = newVote(3)
+	require.True(test, IsSignStateSaveError(pv.SignVote("chain-id", vote)))
+	require.Len(test, failures, 2)
+
+	// once it can be written, the signature of height 2 is handed out
+	require.NoError(test, os.Mkdir(stateDir, 0700))
+	vote = newVote(2)
+	require.NoError(test, pv.SignVote("chain-id", vote))
+	require.NotEmpty(test, vote.Signature)
+	require.Equal(test, int64(2), privval.LoadFilePV(filepath.Join(dir, "key.json"), stateFile).LastSignState.Height)
+}
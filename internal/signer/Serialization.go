@@ -1,7 +1,9 @@
 package signer
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/tendermint/tendermint/libs/protoio"
@@ -9,15 +11,20 @@ import (
 	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
 )
 
+// MaxRemoteSignerMsgSize bounds the size of a message read from a node
+// Larger messages are rejected before anything is allocated for them
+const MaxRemoteSignerMsgSize = 1024 * 10
+
 // ReadMsg reads a message from an io.Reader
+// Messages are length delimited protobuf tendermint.privval.Message, as sent by Tendermint v0.34 and CometBFT v0.37 nodes
+// The bytes come from the node connection and are untrusted: malformed input returns an error
 func ReadMsg(reader io.Reader) (msg tmProtoPrivval.Message, err error) {
-	const maxRemoteSignerMsgSize = 1024 * 10
-	protoReader := protoio.NewDelimitedReader(reader, maxRemoteSignerMsgSize)
+	protoReader := protoio.NewDelimitedReader(reader, MaxRemoteSignerMsgSize)
 	_, err = protoReader.ReadMsg(&msg)
 	return msg, err
 }
 
-// WriteMsg writes a message to an io.Writer
+// WriteMsg writes a message to an io.Writer, length prefix and all in a single Write
 func WriteMsg(writer io.Writer, msg tmProtoPrivval.Message) (err error) {
 	protoWriter := protoio.NewDelimitedWriter(writer)
 	_, err = protoWriter.WriteMsg(&msg)
@@ -36,9 +43,54 @@ func UnpackHRS(signBytes []byte) (height int64, round int64, step int8, err erro
 	{
 		var vote tmProto.CanonicalVote
 		if err := protoio.UnmarshalDelimited(signBytes, &vote); err == nil {
+			if !IsVoteType(vote.Type) {
+				return 0, 0, 0, fmt.Errorf("Unknown vote type %d in sign bytes", vote.Type)
+			}
 			return vote.Height, vote.Round, CanonicalVoteToStep(&vote), nil
 		}
 	}
 
 	return 0, 0, 0, errors.New("Could not UnpackHRS from sign bytes")
 }
+
+// DecodeSignBytes deserializes sign bytes into the canonical proposal or vote they were made from
+// Exactly one of the two is returned.
+func DecodeSignBytes(signBytes []byte) (*tmProto.CanonicalProposal, *tmProto.CanonicalVote, error) {
+	var proposal tmProto.CanonicalProposal
+	if err := protoio.UnmarshalDelimited(signBytes, &proposal); err == nil {
+		return &proposal, nil, nil
+	}
+
+	var vote tmProto.CanonicalVote
+	if err := protoio.UnmarshalDelimited(signBytes, &vote); err == nil {
+		if !IsVoteType(vote.Type) {
+			return nil, nil, fmt.Errorf("Unknown vote type %d in sign bytes", vote.Type)
+		}
+		return nil, &vote, nil
+	}
+
+	return nil, nil, errors.New("Could not decode sign bytes")
+}
+
+// canonicalJSON prints the canonical proposal or vote of the sign bytes returned by the func as indented json
+// The sign bytes are only built and decoded when the value is printed, so a log line filtered by
+// its level costs nothing.
+type canonicalJSON func() []byte
+
+func (signBytes canonicalJSON) String() string {
+	proposal, vote, err := DecodeSignBytes(signBytes())
+	if err != nil {
+		return err.Error()
+	}
+
+	var jsonBytes []byte
+	if proposal != nil {
+		jsonBytes, err = json.MarshalIndent(proposal, "", "  ")
+	} else {
+		jsonBytes, err = json.MarshalIndent(vote, "", "  ")
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return string(jsonBytes)
+}
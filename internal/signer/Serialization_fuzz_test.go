@@ -0,0 +1,45 @@
+//go:build go1.18
+// +build go1.18
+
+package signer
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	tmProtoPrivval "github.com/tendermint/tendermint/proto/tendermint/privval"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// FuzzReadMsg feeds arbitrary bytes from the node connection through the decode and
+// request handling path. Malformed input must return errors, never panic.
+func FuzzReadMsg(f *testing.F) {
+	seeds := []tmProtoPrivval.Message{
+		{Sum: &tmProtoPrivval.Message_PingRequest{PingRequest: &tmProtoPrivval.PingRequest{}}},
+		{Sum: &tmProtoPrivval.Message_PubKeyRequest{PubKeyRequest: &tmProtoPrivval.PubKeyRequest{ChainId: "chain-id"}}},
+		{Sum: &tmProtoPrivval.Message_SignVoteRequest{SignVoteRequest: &tmProtoPrivval.SignVoteRequest{
+			Vote: &tmProto.Vote{Type: tmProto.PrevoteType, Height: 1},
+		}}},
+		{Sum: &tmProtoPrivval.Message_SignProposalRequest{SignProposalRequest: &tmProtoPrivval.SignProposalRequest{
+			Proposal: &tmProto.Proposal{Type: tmProto.ProposalType, Height: 1},
+		}}},
+	}
+	for _, seed := range seeds {
+		var buf bytes.Buffer
+		if err := WriteMsg(&buf, seed); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+
+	rs := newTestRemoteSigner()
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := ReadMsg(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		rs.handleRequest(context.Background(), msg)
+	})
+}
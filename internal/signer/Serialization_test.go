@@ -1,6 +1,9 @@
 package signer
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -55,3 +58,68 @@ func TestUnpackHRSProposal(test *testing.T) {
 	require.Equal(test, int64(2), round)
 	require.Equal(test, int8(1), step)
 }
+
+func TestUnpackHRSUnknownVoteType(test *testing.T) {
+	vote := tmproto.Vote{
+		Height: 1,
+		Round:  2,
+		Type:   tmproto.SignedMsgType(42),
+	}
+
+	signBytes := tm.VoteSignBytes("chain-id", &vote)
+
+	_, _, _, err := UnpackHRS(signBytes)
+	require.Error(test, err)
+}
+
+func TestDecodeSignBytes(test *testing.T) {
+	vote := tmproto.Vote{
+		Height:  5,
+		Round:   1,
+		Type:    tmproto.PrecommitType,
+		BlockID: testBlockID(1),
+	}
+
+	proposal, canonicalVote, err := DecodeSignBytes(tm.VoteSignBytes("chain-id", &vote))
+	require.NoError(test, err)
+	require.Nil(test, proposal)
+	require.Equal(test, int64(5), canonicalVote.Height)
+	require.Equal(test, "chain-id", canonicalVote.ChainID)
+	require.Equal(test, vote.BlockID.Hash, canonicalVote.BlockID.Hash)
+
+	_, _, err = DecodeSignBytes([]byte{1, 2, 3})
+	require.Error(test, err)
+}
+
+func TestCanonicalJSON(test *testing.T) {
+	vote := tmproto.Vote{
+		Height: 5,
+		Round:  1,
+		Type:   tmproto.PrevoteType,
+	}
+
+	built := false
+	canonical := canonicalJSON(func() []byte {
+		built = true
+		return tm.VoteSignBytes("chain-id", &vote)
+	})
+	require.False(test, built)
+
+	printed := canonical.String()
+	require.Contains(test, printed, "\n  \"height\": 5,\n")
+	var decoded tmproto.CanonicalVote
+	require.NoError(test, json.Unmarshal([]byte(printed), &decoded))
+	require.Equal(test, int64(5), decoded.Height)
+	require.Equal(test, "chain-id", decoded.ChainID)
+}
+
+func TestReadMsgTooLarge(test *testing.T) {
+	// a length prefix larger than the limit is rejected without reading the body
+	var buf bytes.Buffer
+	lengthPrefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lengthPrefix, MaxRemoteSignerMsgSize+1)
+	buf.Write(lengthPrefix[:n])
+
+	_, err := ReadMsg(&buf)
+	require.Error(test, err)
+}
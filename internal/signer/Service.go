@@ -0,0 +1,976 @@
+package signer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	tmCrypto "github.com/tendermint/tendermint/crypto"
+	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
+	tmLog "github.com/tendermint/tendermint/libs/log"
+	tmRand "github.com/tendermint/tendermint/libs/rand"
+	tmService "github.com/tendermint/tendermint/libs/service"
+	tmP2p "github.com/tendermint/tendermint/p2p"
+	tm "github.com/tendermint/tendermint/types"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Service assembles the private validator, cosigner rpc server and node connections
+// described by a Config. It can be embedded in other programs in place of cmd/signer.
+type Service struct {
+	tmService.BaseService
+
+	config Config
+
+	privVal tm.PrivValidator
+
+	// services started and stopped along with this service
+	services []tmService.Service
+
+	// reported to by the node connections and cosigner rpcs
+	metrics *Metrics
+
+	// exports the sign flow spans if otel_endpoint is set
+	tracerProvider *sdktrace.TracerProvider
+
+	// holds the key share in mpc mode, zeroized on stop
+	localCosigner *LocalCosigner
+
+	// the cosigners of the extra_share key shares, zeroized on stop
+	extraCosigners []*LocalCosigner
+
+	// the other cosigners in mpc mode
+	remoteCosigners []*RemoteCosigner
+
+	// the connections to the nodes
+	nodes []*ReconnRemoteSigner
+
+	// by cosigner id, in faultinjection builds only
+	faultInjectors map[int]*FaultInjector
+
+	// the store of the threshold validator's sign state, if min_sign_interval_ms or fast_state_dir is set
+	deferredState FlushingSignStateStore
+
+	// closed on stop, if audit_log_file is set
+	auditLog *AuditLog
+}
+
+// The sign_state_save_failure options: refuse to sign and keep running, or exit for the supervisor to restart the signer
+const (
+	SaveFailureRefuse = "refuse"
+	SaveFailureExit   = "exit"
+)
+
+// DefaultLogLevel leaves out the debug logs, e.g. the canonical json of every sign request
+const DefaultLogLevel = "info"
+
+// New builds a Service from the config
+// Nothing is started until Start is called
+func New(config Config, logger tmLog.Logger) (*Service, error) {
+	if config.ChainID == "" {
+		return nil, errors.New("chain_id option is required")
+	}
+	if err := config.NormalizeAddresses(); err != nil {
+		return nil, err
+	}
+	if err := config.checkDuplicateAddresses(); err != nil {
+		return nil, err
+	}
+
+	if config.LogLevel != "" {
+		level, err := tmLog.AllowLevel(config.LogLevel)
+		if err != nil {
+			return nil, fmt.Errorf("log_level: %w", err)
+		}
+		logger = tmLog.NewFilter(logger, level)
+	}
+
+	service := &Service{
+		config: config,
+	}
+	service.BaseService = *tmService.NewBaseService(logger, "SignerService", service)
+
+	for _, problem := range CheckPermissions(config.PrivValStateDir, config.PrivValKeyFile) {
+		if config.StrictPermissions {
+			return nil, fmt.Errorf("strict_permissions: %w", problem)
+		}
+		logger.Error("Unsafe permissions", "err", problem)
+	}
+	if err := CheckStateDirWritable(config.PrivValStateDir); err != nil {
+		return nil, err
+	}
+
+	if config.Mlock {
+		// before the keys are read, so they are never swapped out
+		if err := LockMemory(); err != nil {
+			return nil, err
+		}
+		logger.Info("Locked memory")
+	}
+
+	if config.OtelEndpoint != "" {
+		tracerProvider, err := NewTracerProvider(context.Background(), config.OtelEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		service.tracerProvider = tracerProvider
+	}
+
+	service.metrics = NopMetrics()
+	var exported []*Metrics
+	if config.PrometheusAddress != "" {
+		// a registry of our own, several services may run in one process
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+		exported = append(exported, PrometheusMetrics(registry, MetricsNamespace))
+		metricsServer := NewMetricsServer(config.PrometheusAddress, registry, logger)
+		metricsServer.SetDualStack(config.DualStack)
+		service.services = append(service.services, metricsServer)
+	}
+	if config.StatsdAddress != "" {
+		exporter, err := NewStatsdExporter(config.StatsdAddress, config.StatsdProtocol, logger)
+		if err != nil {
+			return nil, err
+		}
+		exported = append(exported, exporter.Metrics())
+		service.services = append(service.services, exporter)
+	}
+	if len(exported) > 0 {
+		service.metrics = MultiMetrics(exported...)
+	}
+
+	if config.PprofAddress != "" {
+		if err := checkPprofAddress(&config); err != nil {
+			return nil, err
+		}
+		service.services = append(service.services, NewPprofServer(config.PprofAddress, logger))
+	}
+
+	if config.AuditLogFile != "" {
+		if config.Mode != "mpc" {
+			return nil, errors.New("audit_log_file is only supported in mpc mode")
+		}
+		auditLog, err := OpenAuditLog(config.AuditLogFile, int64(config.AuditLogMaxMB)*1024*1024)
+		if err != nil {
+			return nil, err
+		}
+		service.auditLog = auditLog
+	}
+
+	if config.MinSignInterval > 0 && config.Mode != "mpc" {
+		return nil, errors.New("min_sign_interval_ms is only supported in mpc mode")
+	}
+	if config.FastStateDir != "" {
+		if config.Mode != "mpc" {
+			return nil, errors.New("fast_state_dir is only supported in mpc mode")
+		}
+		if config.MinSignInterval > 0 {
+			return nil, errors.New("fast_state_dir and min_sign_interval_ms cannot be combined")
+		}
+		if err := CheckStateDirWritable(config.FastStateDir); err != nil {
+			return nil, fmt.Errorf("fast_state_dir: %w", err)
+		}
+	}
+	if config.EtcdAddress != "" {
+		if config.Mode != "mpc" {
+			return nil, errors.New("sign_state_etcd_address is only supported in mpc mode")
+		}
+		if config.MinSignInterval > 0 || config.FastStateDir != "" {
+			return nil, errors.New("sign_state_etcd_address cannot be combined with min_sign_interval_ms or fast_state_dir")
+		}
+	}
+
+	if config.KeySwitchHeight > 0 && config.Mode != "mpc" {
+		return nil, errors.New("key_switch_height is only supported in mpc mode")
+	}
+
+	if len(config.ExtraShares) > 0 && (config.Mode != "mpc" || !config.HasLocalShare()) {
+		return nil, errors.New("extra_share is only supported in mpc mode with a local share")
+	}
+
+	var val tm.PrivValidator
+	switch config.Mode {
+	case "single":
+		logger.Info("Mode: single")
+		singleVal, err := service.newSinglePrivValidator()
+		if err != nil {
+			return nil, err
+		}
+		val = singleVal
+	case "mpc":
+		logger.Info("Mode: mpc")
+		newPrivValidator := service.newThresholdPrivValidator
+		if !config.HasLocalShare() {
+			logger.Info("No local share, coordinating the cosigners only")
+			newPrivValidator = service.newCoordinatorPrivValidator
+		}
+		thresholdVal, err := newPrivValidator()
+		if err != nil {
+			return nil, err
+		}
+		val = thresholdVal
+	default:
+		return nil, fmt.Errorf("Unsupported mode: %s", config.Mode)
+	}
+
+	guard := &PvGuard{PrivValidator: val}
+	if config.MaxSignsPerMinute > 0 {
+		guard.RateLimiter = NewRateLimiter(config.MaxSignsPerMinute)
+	}
+	if config.ColdStartDelayMs > 0 {
+		guard.ColdStart = NewColdStart(time.Duration(config.ColdStartDelayMs) * time.Millisecond)
+	}
+	if config.SummaryInterval > 0 {
+		guard.Stats = &SignStats{}
+	}
+	if config.PreSignWebhook != "" {
+		guard.PreSign = NewPreSignWebhook(config.PreSignWebhook, time.Duration(config.PreSignTimeoutMs)*time.Millisecond)
+	}
+	switch config.SaveFailure {
+	case "", SaveFailureRefuse:
+	case SaveFailureExit:
+		guard.OnSaveFailure = func(err error) {
+			logger.Error("Exiting, the sign state could not be saved", "err", err)
+			os.Exit(1)
+		}
+	default:
+		return nil, fmt.Errorf("sign_state_save_failure must be %q or %q, got %q", SaveFailureRefuse, SaveFailureExit, config.SaveFailure)
+	}
+	if config.Standby {
+		guard.SetActive(false)
+		logger.Info("Starting in standby, not signing until activated")
+	}
+	if config.AdminAddress != "" && config.EventSubscribers > 0 {
+		guard.Events = NewSignEvents(config.EventSubscribers)
+	}
+	service.privVal = guard
+
+	if config.AdminAddress != "" {
+		adminServer := NewAdminServer(config.AdminAddress, guard, logger)
+		adminServer.SetDualStack(config.DualStack)
+		if service.localCosigner != nil {
+			adminServer.SetResync(service.resyncSignState)
+		}
+		adminServer.SetReadiness(service.checkReady)
+		adminServer.SetHealth(service.health)
+		adminServer.SetPubKey(service.pubKeyEncodings)
+		if guard.Events != nil {
+			adminServer.SetEvents(guard.Events)
+		}
+		adminServer.SetPause(func() error {
+			return guard.Pause(service.flushSignStates)
+		})
+		if FaultInjectionBuild {
+			adminServer.SetFaultInjection(service.setFaults)
+		}
+		service.services = append(service.services, adminServer)
+	}
+
+	var connKey tmCryptoEd25519.PrivKey
+	if config.NodeKeyFile != "" {
+		key, err := LoadOrGenConnKey(config.NodeKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		connKey = key
+		logger.Info("Node connection key", "file", config.NodeKeyFile, "id", tmP2p.PubKeyToID(connKey.PubKey()))
+	}
+
+	var authorizedKeys []tmP2p.ID
+	for _, id := range config.AuthorizedNodes {
+		nodeID, err := ParseNodeID(id)
+		if err != nil {
+			return nil, fmt.Errorf("authorized_node_keys: %w", err)
+		}
+		authorizedKeys = append(authorizedKeys, nodeID)
+	}
+	if len(authorizedKeys) > 0 && config.NodeInsecure {
+		return nil, fmt.Errorf("authorized_node_keys cannot be checked with node_insecure")
+	}
+
+	signProposals, signPrevotes, signPrecommits := config.SignsProposals(), config.SignsPrevotes(), config.SignsPrecommits()
+	if !signProposals || !signPrevotes || !signPrecommits {
+		logger.Info("Signing disabled for some steps, their requests are refused",
+			"sign_proposals", signProposals, "sign_prevotes", signPrevotes, "sign_precommits", signPrecommits)
+	}
+
+	nodes := []*ReconnRemoteSigner{}
+	for _, node := range config.Nodes {
+		chainID, err := nodeChainID(config, node)
+		if err != nil {
+			return nil, err
+		}
+
+		var sourceAddr net.Addr
+		if node.SourceAddress != "" {
+			sourceAddr, err = ResolveSourceAddress(node.SourceAddress)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dialer, err := NewNodeDialer(
+			time.Duration(config.NodeDialTimeout)*time.Second,
+			time.Duration(config.NodeKeepAlive)*time.Second,
+			sourceAddr,
+			config.NodeProxy,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("node_proxy: %w", err)
+		}
+		signer := NewReconnRemoteSigner(node.Address, logger, chainID, service.privVal, dialer)
+		signer.SetWatchdogTimeout(time.Duration(config.WatchdogTimeout) * time.Second)
+		signer.SetTimeouts(time.Duration(config.NodeReadTimeout)*time.Second, time.Duration(config.NodeWriteTimeout)*time.Second)
+		if config.NodeStartJitterMs > 0 {
+			// spreads the first dials instead of hitting every sentry at once
+			signer.SetStartDelay(time.Duration(tmRand.Int63n(int64(config.NodeStartJitterMs))) * time.Millisecond)
+		}
+		signer.SetMetrics(service.metrics)
+		signer.SetInsecure(config.NodeInsecure)
+		signer.SetAuthorizedKeys(authorizedKeys)
+		signer.SetDisconnectOnEquivocation(config.EquivocationStop)
+		signer.SetSignSteps(signProposals, signPrevotes, signPrecommits)
+		if connKey != nil {
+			signer.SetPrivKey(connKey)
+		}
+		service.services = append(service.services, signer)
+		nodes = append(nodes, signer)
+	}
+	service.nodes = nodes
+
+	if config.SummaryInterval > 0 {
+		thresholdVal, _ := val.(*ThresholdValidator)
+		summary := NewSignSummary(time.Duration(config.SummaryInterval)*time.Second, guard.Stats, nodes, thresholdVal, len(config.Cosigners)+1, logger)
+		service.services = append(service.services, summary)
+	}
+
+	return service, nil
+}
+
+// flushSignStates writes the sign states of the validator and the local cosigner again
+// In single mode, the sign state file is written with every signature and left as is.
+func (service *Service) flushSignStates() error {
+	if thresholdVal, ok := service.privVal.(*PvGuard).PrivValidator.(*ThresholdValidator); ok {
+		if err := thresholdVal.FlushSignState(); err != nil {
+			return err
+		}
+	}
+	if service.localCosigner != nil {
+		if err := service.localCosigner.FlushSignState(); err != nil {
+			return err
+		}
+	}
+	for _, cosigner := range service.extraCosigners {
+		if err := cosigner.FlushSignState(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandOff stops signing, writes the sign states and hands the watermark over to the standby at handoff_address,
+// waiting up to handoff_timeout for it to acknowledge. Without the acknowledgement the standby does not take over.
+// Does nothing unless handoff_address is set and we are signing.
+// Called before Stop on a planned shutdown, e.g. on SIGINT or SIGTERM, the signer stays paused afterwards.
+func (service *Service) HandOff() error {
+	guard := service.privVal.(*PvGuard)
+	if service.config.HandoffAddress == "" || !guard.IsActive() {
+		return nil
+	}
+	if err := guard.Pause(service.flushSignStates); err != nil {
+		service.Logger.Error("Sign state flush before handoff", "err", err)
+	}
+
+	watermark := service.watermark()
+	service.Logger.Info("Handing off to the standby", "address", redactURL(service.config.HandoffAddress),
+		"height", watermark.Height, "round", watermark.Round, "step", watermark.Step)
+
+	timeout := time.Duration(service.config.HandoffTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ack, err := HandOff(ctx, service.config.HandoffAddress, watermark)
+	if err != nil {
+		service.Logger.Error("HANDOFF FAILED, the standby did not take over", "err", err)
+		return err
+	}
+	service.Logger.Info("Standby took over", "height", ack.Height, "round", ack.Round, "step", ack.Step)
+	return nil
+}
+
+// watermark returns the height, round and step of the last signature of the validator
+func (service *Service) watermark() HRSKey {
+	switch val := service.privVal.(*PvGuard).PrivValidator.(type) {
+	case *ThresholdValidator:
+		return HRSKey{Height: val.lastSignState.Height, Round: val.lastSignState.Round, Step: val.lastSignState.Step}
+	case *SafeFilePV:
+		lss := val.LastSignState
+		return HRSKey{Height: lss.Height, Round: int64(lss.Round), Step: lss.Step}
+	}
+	return HRSKey{}
+}
+
+// checkReady returns an error while the nodes are not ready, see checkNodesReady,
+// or with degraded_not_ready while the signer is not healthy
+func (service *Service) checkReady() error {
+	if !service.config.DegradedNotReady {
+		return service.checkNodesReady()
+	}
+	if health := service.health(); health.Status != HealthHealthy {
+		return fmt.Errorf("%s: %s", health.Status, health.Reason)
+	}
+	return nil
+}
+
+// health returns the health of the nodes and, in mpc mode, of the cosigners
+func (service *Service) health() Health {
+	if err := service.checkNodesReady(); err != nil {
+		return Health{Status: HealthUnhealthy, Reason: err.Error()}
+	}
+	thresholdVal, ok := service.privVal.(*PvGuard).PrivValidator.(*ThresholdValidator)
+	if !ok {
+		return Health{Status: HealthHealthy}
+	}
+	total := len(service.config.Cosigners)
+	if service.config.HasLocalShare() {
+		total++
+	}
+	return cosignerHealth(thresholdVal.ReachableCosigners(), total, service.config.CosignerThreshold)
+}
+
+// checkNodesReady returns an error while no node is connected on our chain, or any node is on another chain
+func (service *Service) checkNodesReady() error {
+	notReady := errors.New("no node is configured")
+	ready := false
+	for _, node := range service.nodes {
+		err := node.CheckReady()
+		switch {
+		case err == nil:
+			ready = true
+		case ErrorCode(err) == ErrorCodeChainMismatch:
+			return err
+		default:
+			notReady = err
+		}
+	}
+	if ready {
+		return nil
+	}
+	return notReady
+}
+
+// pubKeyEncodings returns the encodings of the validator public key, with the consensus_address_prefix
+func (service *Service) pubKeyEncodings() (PubKeyEncodings, error) {
+	pubKey, err := service.privVal.GetPubKey()
+	if err != nil {
+		return PubKeyEncodings{}, err
+	}
+	return NewPubKeyEncodings(pubKey, service.addressPrefix())
+}
+
+// addressPrefix returns the bech32 prefix of consensus addresses
+func (service *Service) addressPrefix() string {
+	if service.config.AddressPrefix == "" {
+		return DefaultConsensusAddressPrefix
+	}
+	return service.config.AddressPrefix
+}
+
+// PrivValidator returns the private validator used to respond to nodes
+func (service *Service) PrivValidator() tm.PrivValidator {
+	return service.privVal
+}
+
+// OnStart starts the cosigner rpc server and the node connections
+func (service *Service) OnStart() error {
+	pubkey, err := service.privVal.GetPubKey()
+	if err != nil {
+		return err
+	}
+
+	address, err := ConsensusAddress(pubkey, service.addressPrefix())
+	if err != nil {
+		return err
+	}
+	service.Logger.Info("Signer", "pubkey", pubkey, "address", address)
+
+	for _, s := range service.services {
+		if err := s.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnStop stops everything started by OnStart
+func (service *Service) OnStop() {
+	for _, s := range service.services {
+		if !s.IsRunning() {
+			continue
+		}
+		if err := s.Stop(); err != nil {
+			service.Logger.Error("Stop", "err", err)
+		}
+	}
+
+	if service.deferredState != nil {
+		// the nodes are disconnected, nothing is signed anymore
+		if err := service.deferredState.Flush(); err != nil {
+			service.Logger.Error("Sign state flush", "err", err)
+		}
+	}
+
+	if service.localCosigner != nil {
+		service.localCosigner.Zeroize()
+	}
+	for _, cosigner := range service.extraCosigners {
+		cosigner.Zeroize()
+	}
+
+	if service.auditLog != nil {
+		if err := service.auditLog.Close(); err != nil {
+			service.Logger.Error("Audit log close", "err", err)
+		}
+	}
+
+	if service.tracerProvider != nil {
+		// flushes any spans not exported yet
+		if err := service.tracerProvider.Shutdown(context.Background()); err != nil {
+			service.Logger.Error("Tracer shutdown", "err", err)
+		}
+	}
+}
+
+func (service *Service) newSinglePrivValidator() (tm.PrivValidator, error) {
+	config := service.config
+	stateFile := path.Join(config.PrivValStateDir, fmt.Sprintf("%s_priv_validator_state.json", config.ChainID))
+
+	if !fileExists(stateFile) {
+		service.Logger.Info("Initializing empty state file", "file", stateFile)
+	}
+
+	reader, err := OpenKeyFile(config.PrivValKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	filePV, err := ReadFilePV(reader, config.PrivValKeyFile, stateFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewSafeFilePV(filePV, config.SaveRetries, service.Logger), nil
+}
+
+// newRemoteCosigner returns the cosigner set up with the remote cosigner options of the config
+func (service *Service) newRemoteCosigner(cosignerConfig CosignerConfig) (*RemoteCosigner, error) {
+	config := service.config
+
+	cosigner := NewRemoteCosigner(cosignerConfig.ID, cosignerConfig.Address)
+	cosigner.SetMetrics(service.metrics)
+	cosigner.SetReconnectWait(time.Duration(config.ReconnectWaitMs) * time.Millisecond)
+	if config.CosignerTransport != "" {
+		if err := cosigner.SetTransport(config.CosignerTransport); err != nil {
+			return nil, err
+		}
+	}
+	return cosigner, nil
+}
+
+// newPeerCosigners returns the remote cosigners of the [[cosigner]] sections, which our
+// threshold validator asks for share signatures, with faults injected in faultinjection builds
+func (service *Service) newPeerCosigners() ([]*RemoteCosigner, error) {
+	cosigners := []*RemoteCosigner{}
+	for _, cosignerConfig := range service.config.Cosigners {
+		cosigner, err := service.newRemoteCosigner(cosignerConfig)
+		if err != nil {
+			return nil, err
+		}
+		if FaultInjectionBuild {
+			if service.faultInjectors == nil {
+				service.faultInjectors = map[int]*FaultInjector{}
+				service.Logger.Error("Built with fault injection, the responses of the cosigners can be tampered with through /faults")
+			}
+			injector := NewFaultInjector()
+			cosigner.SetFaultInjector(injector)
+			service.faultInjectors[cosignerConfig.ID] = injector
+		}
+		cosigners = append(cosigners, cosigner)
+	}
+	return cosigners, nil
+}
+
+// setFaults sets the faults injected into the responses of a remote cosigner
+func (service *Service) setFaults(faults FaultConfig) error {
+	injector, ok := service.faultInjectors[faults.Cosigner]
+	if !ok {
+		return fmt.Errorf("no remote cosigner with id %d", faults.Cosigner)
+	}
+	if err := injector.Set(faults); err != nil {
+		return err
+	}
+	service.Logger.Info("Injecting faults", "cosigner", faults.Cosigner, "drop", faults.Drop,
+		"delay", faults.Delay, "delay_ms", faults.DelayMs, "corrupt", faults.Corrupt)
+	return nil
+}
+
+// loadValidatorSignState loads the sign state of the threshold validator, the cache of the last signature,
+// with its writes coalesced if min_sign_interval_ms is set, or saved to fast_state_dir and copied to state_dir
+// in the background if that is set, or kept in etcd instead of state_dir if sign_state_etcd_address is set.
+// The share sign state is always written to state_dir right away.
+func (service *Service) loadValidatorSignState() (SignState, error) {
+	config := service.config
+
+	stateFileName := fmt.Sprintf("%s_priv_validator_state.json", config.ChainID)
+	stateFile := path.Join(config.PrivValStateDir, stateFileName)
+	var store SignStateStore = service.newTimedSignStateStore(stateFile, "validator")
+	switch {
+	case config.EtcdAddress != "":
+		key := config.EtcdKey
+		if key == "" {
+			key = fmt.Sprintf("tendermint-signer/%s/priv_validator_state", config.ChainID)
+		}
+		store = service.timeSignStateStore(NewEtcdSignStateStore(config.EtcdAddress, key), "validator")
+	case config.MinSignInterval > 0:
+		coalesced := NewCoalescingSignStateStore(store, time.Duration(config.MinSignInterval)*time.Millisecond, service.Logger)
+		service.deferredState = coalesced
+		store = coalesced
+	case config.FastStateDir != "":
+		fastStore := service.newTimedSignStateStore(path.Join(config.FastStateDir, stateFileName), "validator")
+		tiered := NewTieredSignStateStore(fastStore, NewFileSignStateStore(stateFile), service.Logger)
+		service.deferredState = tiered
+		store = tiered
+	}
+	return LoadOrCreateSignStateFrom(store)
+}
+
+// newTimedSignStateStore returns the store of the sign state file, its saves timed and labeled with state,
+// and retried up to sign_state_save_retries times
+func (service *Service) newTimedSignStateStore(stateFile string, state string) SignStateStore {
+	return service.timeSignStateStore(NewFileSignStateStore(stateFile), state)
+}
+
+// timeSignStateStore returns store with its saves timed and labeled with state, and retried
+func (service *Service) timeSignStateStore(store SignStateStore, state string) SignStateStore {
+	slow := time.Duration(service.config.SlowSaveMs) * time.Millisecond
+	timed := NewTimedSignStateStore(store, state, slow, service.metrics, service.Logger)
+	return NewRetryingSignStateStore(timed, service.config.SaveRetries, service.Logger)
+}
+
+// newCoordinatorPrivValidator returns a ThresholdValidator holding no share, which only
+// coordinates the cosigners. It needs neither a key file nor a cosigner listener.
+func (service *Service) newCoordinatorPrivValidator() (tm.PrivValidator, error) {
+	config := service.config
+
+	if config.CosignerThreshold == 0 {
+		return nil, errors.New("The `cosigner_threshold` option is required in `threshold` mode")
+	}
+	if config.ValidatorPubKey == "" {
+		return nil, errors.New("The validator_pub_key option is required without a local share")
+	}
+	pubKeyBytes, err := base64.StdEncoding.DecodeString(config.ValidatorPubKey)
+	if err != nil || len(pubKeyBytes) != tmCryptoEd25519.PubKeySize {
+		return nil, fmt.Errorf("validator_pub_key must be a base64 ed25519 public key")
+	}
+	if err := config.Validate(0); err != nil {
+		return nil, err
+	}
+	var nextPubKey tmCrypto.PubKey
+	if config.KeySwitchHeight > 0 {
+		nextPubKeyBytes, err := base64.StdEncoding.DecodeString(config.NextValidatorKey)
+		if err != nil || len(nextPubKeyBytes) != tmCryptoEd25519.PubKeySize {
+			return nil, fmt.Errorf("key_switch_height requires next_validator_pub_key, a base64 ed25519 public key, without a local share")
+		}
+		nextPubKey = tmCryptoEd25519.PubKey(nextPubKeyBytes)
+	}
+
+	signState, err := service.loadValidatorSignState()
+	if err != nil {
+		return nil, err
+	}
+
+	peerCosigners, err := service.newPeerCosigners()
+	if err != nil {
+		return nil, err
+	}
+	cosigners := []Cosigner{}
+	for _, cosigner := range peerCosigners {
+		cosigners = append(cosigners, cosigner)
+	}
+
+	return NewThresholdValidator(&ThresholdValidatorOpt{
+		Pubkey:       tmCryptoEd25519.PubKey(pubKeyBytes),
+		Threshold:    config.CosignerThreshold,
+		SignState:    signState,
+		Peers:        cosigners,
+		Metrics:      service.metrics,
+		Logger:       service.Logger,
+		AuditLog:     service.auditLog,
+		SignDeadline: time.Duration(config.SignDeadlineMs) * time.Millisecond,
+		NextPubkey:   nextPubKey,
+		SwitchHeight: config.KeySwitchHeight,
+
+		ProposalThreshold: config.ProposalThreshold,
+	}), nil
+}
+
+func (service *Service) newThresholdPrivValidator() (tm.PrivValidator, error) {
+	config := service.config
+
+	if config.CosignerThreshold == 0 {
+		return nil, errors.New("The `cosigner_threshold` option is required in `threshold` mode")
+	}
+
+	if config.ListenAddress == "" {
+		return nil, errors.New("The cosigner_listen_address option is required in `threshold` mode")
+	}
+
+	key, err := LoadCosignerKey(config.PrivValKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(key.ID); err != nil {
+		return nil, err
+	}
+
+	// ok to auto initialize on disk since the cosigner share is the one that actually
+	// protects against double sign - this exists as a cache for the final signature
+	signState, err := service.loadValidatorSignState()
+	if err != nil {
+		return nil, err
+	}
+
+	// state for our cosigner share
+	// Not automatically initialized on disk to avoid double sign risk
+	shareStateFile := path.Join(config.PrivValStateDir, fmt.Sprintf("%s_share_sign_state.json", config.ChainID))
+	shareSignState, err := LoadSignStateFrom(service.newTimedSignStateStore(shareStateFile, "share"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ephemeralAuditLog *AuditLog
+	if config.AuditEphemeral {
+		if service.auditLog == nil {
+			return nil, errors.New("audit_log_ephemeral requires audit_log_file")
+		}
+		ephemeralAuditLog = service.auditLog
+	}
+	rsaPool := NewRSAWorkerPool(config.RSAWorkers, config.RSAQueueLength, service.metrics)
+
+	peerCosigners, err := service.newPeerCosigners()
+	if err != nil {
+		return nil, err
+	}
+	localCosigner, err := service.newLocalCosigner(key, &shareSignState, peerCosigners, ephemeralAuditLog, rsaPool)
+	if err != nil {
+		return nil, err
+	}
+	service.localCosigner = localCosigner
+	service.remoteCosigners = peerCosigners
+
+	cosigners := []Cosigner{}
+	remoteCosigners := []RemoteCosigner{}
+	for _, cosigner := range peerCosigners {
+		cosigners = append(cosigners, cosigner)
+		remoteCosigners = append(remoteCosigners, *cosigner)
+	}
+
+	val := NewThresholdValidator(&ThresholdValidatorOpt{
+		Pubkey:       key.PubKey,
+		Threshold:    config.CosignerThreshold,
+		SignState:    signState,
+		Cosigner:     localCosigner,
+		Peers:        cosigners,
+		Metrics:      service.metrics,
+		Logger:       service.Logger,
+		AuditLog:     service.auditLog,
+		SignDeadline: time.Duration(config.SignDeadlineMs) * time.Millisecond,
+		NextPubkey:   key.NextPubKey,
+		SwitchHeight: config.KeySwitchHeight,
+
+		ProposalThreshold: config.ProposalThreshold,
+	})
+
+	rpcServer := NewCosignerRpcServer(&CosignerRpcServerConfig{
+		Logger:        service.Logger,
+		ListenAddress: config.ListenAddress,
+		DualStack:     config.DualStack,
+		Cosigner:      localCosigner,
+		Peers:         remoteCosigners,
+
+		ExtraListenAddresses: config.ListenAddresses,
+	})
+	service.services = append(service.services, rpcServer)
+
+	for _, share := range config.ExtraShares {
+		if err := service.addExtraShare(share, key, ephemeralAuditLog, rsaPool); err != nil {
+			return nil, fmt.Errorf("extra_share %s: %w", share.KeyFile, err)
+		}
+	}
+
+	return val, nil
+}
+
+// newLocalCosigner returns the cosigner of a key share, with the remote cosigners it exchanges ephemeral secret parts with
+func (service *Service) newLocalCosigner(
+	key CosignerKey,
+	signState *SignState,
+	remoteCosigners []*RemoteCosigner,
+	auditLog *AuditLog,
+	rsaPool *RSAWorkerPool,
+) (*LocalCosigner, error) {
+	config := service.config
+
+	// add ourselves as a peer so localcosigner can handle GetEphSecPart requests
+	peers := []CosignerPeer{{
+		ID:        key.ID,
+		PublicKey: key.RSAKey.PublicKey,
+	}}
+	if key.PreviousRSAKey != nil {
+		peers[0].PreviousPublicKey = &key.PreviousRSAKey.PublicKey
+		service.Logger.Info("RSA key rotation in progress", "id", key.ID, "rsa-pubs-version", key.RSAPubsVersion)
+	}
+
+	for _, cosigner := range remoteCosigners {
+		id := cosigner.GetID()
+		if id < 1 || id > len(key.CosignerKeys) {
+			return nil, fmt.Errorf("Unexpected cosigner ID %d", id)
+		}
+
+		pubKey := key.CosignerKeys[id-1]
+		peer := CosignerPeer{
+			ID:        id,
+			PublicKey: *pubKey,
+		}
+		if id <= len(key.PreviousCosignerKeys) {
+			peer.PreviousPublicKey = key.PreviousCosignerKeys[id-1]
+		}
+		peers = append(peers, peer)
+	}
+
+	if config.KeySwitchHeight > 0 {
+		if _, ok := key.NextPubKey.(tmCryptoEd25519.PubKey); !ok || len(key.NextShareKey) == 0 {
+			return nil, fmt.Errorf("key_switch_height requires a key file of cosigner %d with a next ed25519 key share, see key2shares --next-key", key.ID)
+		}
+		service.Logger.Info("Switching validator keys", "id", key.ID, "height", config.KeySwitchHeight,
+			"next-pub-key", key.NextPubKey)
+	} else if key.NextPubKey != nil {
+		service.Logger.Info("The key file holds a next key share, unused without key_switch_height", "id", key.ID)
+	}
+
+	total := len(config.Cosigners) + 1
+	return NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: key,
+		SignState:   signState,
+		RsaKey:      key.RSAKey,
+		Peers:       peers,
+		Total:       uint8(total),
+		Threshold:   uint8(config.CosignerThreshold),
+
+		PreviousRsaKey: key.PreviousRSAKey,
+		AuditLog:       auditLog,
+		SwitchHeight:   config.KeySwitchHeight,
+		Metrics:        service.metrics,
+		RSAPool:        rsaPool,
+		Logger:         service.Logger,
+	}), nil
+}
+
+// addExtraShare adds the cosigner rpc server of another key share of the validator, for test setups
+// running several cosigners in one process. Its peers are our own share, at cosigner_listen_address,
+// and the [[cosigner]] sections other than itself. It only serves the other cosigners, the nodes
+// are signed for by our own share.
+func (service *Service) addExtraShare(share ShareConfig, ourKey CosignerKey, auditLog *AuditLog, rsaPool *RSAWorkerPool) error {
+	config := service.config
+
+	if share.ListenAddress == "" {
+		return errors.New("listen_address is required")
+	}
+	key, err := LoadCosignerKey(share.KeyFile)
+	if err != nil {
+		return err
+	}
+	if !key.PubKey.Equals(ourKey.PubKey) {
+		return errors.New("the key share is for another validator")
+	}
+	if key.ID == ourKey.ID {
+		return fmt.Errorf("the key share has our own id %d", key.ID)
+	}
+	listed := false
+	for _, cosignerConfig := range config.Cosigners {
+		listed = listed || cosignerConfig.ID == key.ID
+	}
+	if !listed {
+		return fmt.Errorf("cosigner id %d has no [[cosigner]] section, our own share could not reach it", key.ID)
+	}
+
+	// state for the share, not automatically initialized on disk either
+	stateFile := share.StateFile
+	if stateFile == "" {
+		stateFile = path.Join(config.PrivValStateDir, fmt.Sprintf("%s_share_%d_sign_state.json", config.ChainID, key.ID))
+	}
+	signState, err := LoadSignStateFrom(service.newTimedSignStateStore(stateFile, "share"))
+	if err != nil {
+		return err
+	}
+
+	peerConfigs := []CosignerConfig{{ID: ourKey.ID, Address: config.ListenAddress}}
+	for _, cosignerConfig := range config.Cosigners {
+		if cosignerConfig.ID != key.ID {
+			peerConfigs = append(peerConfigs, cosignerConfig)
+		}
+	}
+	remoteCosigners := []*RemoteCosigner{}
+	peers := []RemoteCosigner{}
+	for _, peerConfig := range peerConfigs {
+		cosigner, err := service.newRemoteCosigner(peerConfig)
+		if err != nil {
+			return err
+		}
+		remoteCosigners = append(remoteCosigners, cosigner)
+		peers = append(peers, *cosigner)
+	}
+
+	localCosigner, err := service.newLocalCosigner(key, &signState, remoteCosigners, auditLog, rsaPool)
+	if err != nil {
+		return err
+	}
+	service.extraCosigners = append(service.extraCosigners, localCosigner)
+
+	rpcServer := NewCosignerRpcServer(&CosignerRpcServerConfig{
+		Logger:        service.Logger.With("share", key.ID),
+		ListenAddress: share.ListenAddress,
+		DualStack:     config.DualStack,
+		Cosigner:      localCosigner,
+		Peers:         peers,
+	})
+	service.services = append(service.services, rpcServer)
+	service.Logger.Info("Serving an extra key share", "id", key.ID, "address", share.ListenAddress)
+	return nil
+}
+
+// nodeChainID returns the chain id requests from the node are signed for
+// The key and sign state are kept for the global chain_id only, so an override must name that chain.
+func nodeChainID(config Config, node NodeConfig) (string, error) {
+	if node.ChainID == "" || node.ChainID == config.ChainID {
+		return config.ChainID, nil
+	}
+	return "", fmt.Errorf("node %s: no key for chain_id %s, this signer holds the key for %s", node.Address, node.ChainID, config.ChainID)
+}
+
+func fileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false
+	}
+	return !info.IsDir()
+}
+
+// resyncSignState advances the share watermark of the local cosigner to the highest of the peers
+func (service *Service) resyncSignState(ctx context.Context) (SignStateResync, error) {
+	return ResyncSignState(ctx, service.localCosigner, service.remoteCosigners, service.Logger)
+}
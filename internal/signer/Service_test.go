@@ -0,0 +1,166 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/libs/log"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
+)
+
+func TestNewServiceRequiresChainID(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	_, err := New(Config{Mode: "mpc"}, logger)
+	require.Error(test, err)
+}
+
+func TestNewServiceUnsupportedMode(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	_, err := New(Config{Mode: "foo", ChainID: "chain-id"}, logger)
+	require.EqualError(test, err, "Unsupported mode: foo")
+}
+
+func TestNewServiceMpcRequiresThreshold(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	_, err := New(Config{Mode: "mpc", ChainID: "chain-id", ListenAddress: "tcp://0.0.0.0:0"}, logger)
+	require.Error(test, err)
+}
+
+func TestNewServiceRequiresWritableStateDir(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	stateDir := filepath.Join(test.TempDir(), "not-mounted")
+	_, err := New(Config{Mode: "mpc", ChainID: "chain-id", PrivValStateDir: stateDir}, logger)
+	require.Error(test, err)
+	require.Contains(test, err.Error(), "is not writable")
+
+	// the check leaves nothing behind
+	stateDir = test.TempDir()
+	require.NoError(test, CheckStateDirWritable(stateDir))
+	files, err := ioutil.ReadDir(stateDir)
+	require.NoError(test, err)
+	require.Empty(test, files)
+}
+
+func TestNodeChainID(test *testing.T) {
+	config := Config{ChainID: "chain-id"}
+
+	chainID, err := nodeChainID(config, NodeConfig{Address: "tcp://127.0.0.1:1234"})
+	require.NoError(test, err)
+	require.Equal(test, "chain-id", chainID)
+
+	chainID, err = nodeChainID(config, NodeConfig{Address: "tcp://127.0.0.1:1234", ChainID: "chain-id"})
+	require.NoError(test, err)
+	require.Equal(test, "chain-id", chainID)
+
+	_, err = nodeChainID(config, NodeConfig{Address: "tcp://127.0.0.1:1234", ChainID: "other-chain"})
+	require.Error(test, err)
+}
+
+func TestNewServiceCoordinatorRequiresPubKey(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	localShare := false
+	config := Config{
+		Mode:              "mpc",
+		ChainID:           "chain-id",
+		CosignerThreshold: 1,
+		LocalShare:        &localShare,
+		Cosigners:         []CosignerConfig{{ID: 1, Address: "tcp://127.0.0.1:1234"}},
+	}
+	_, err := New(config, logger)
+	require.EqualError(test, err, "The validator_pub_key option is required without a local share")
+
+	config.PrivValStateDir = test.TempDir()
+	config.ValidatorPubKey = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	service, err := New(config, logger)
+	require.NoError(test, err)
+	require.IsType(test, &ThresholdValidator{}, service.PrivValidator().(*PvGuard).PrivValidator)
+}
+
+func freeAddress(test *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer listener.Close()
+	return "tcp://" + listener.Addr().String()
+}
+
+func TestServiceExtraShares(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+	dir := test.TempDir()
+
+	// a 2 of 2 validator key, both shares run by the same signer
+	privKey := tmCryptoEd25519.GenPrivKey()
+	secretShares := tsed25519.DealShares(tsed25519.ExpandSecret(privKey[:32]), 2, 2)
+	rsaKeys := make([]*rsa.PrivateKey, 2)
+	pubKeys := make([]*rsa.PublicKey, 2)
+	for idx := range rsaKeys {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(test, err)
+		rsaKeys[idx] = rsaKey
+		pubKeys[idx] = &rsaKey.PublicKey
+	}
+	for idx, rsaKey := range rsaKeys {
+		key := CosignerKey{
+			PubKey:       privKey.PubKey(),
+			ShareKey:     secretShares[idx],
+			RSAKey:       *rsaKey,
+			ID:           idx + 1,
+			CosignerKeys: pubKeys,
+		}
+		require.NoError(test, SaveCosignerKey(filepath.Join(dir, fmt.Sprintf("share%d.json", idx+1)), &key))
+	}
+	_, err := LoadOrCreateSignState(filepath.Join(dir, "chain-id_share_sign_state.json"))
+	require.NoError(test, err)
+	_, err = LoadOrCreateSignState(filepath.Join(dir, "chain-id_share_2_sign_state.json"))
+	require.NoError(test, err)
+
+	extraAddress := freeAddress(test)
+	config := Config{
+		Mode:              "mpc",
+		ChainID:           "chain-id",
+		PrivValKeyFile:    filepath.Join(dir, "share1.json"),
+		PrivValStateDir:   dir,
+		CosignerThreshold: 2,
+		ListenAddress:     freeAddress(test),
+		Cosigners:         []CosignerConfig{{ID: 2, Address: extraAddress}},
+		ExtraShares:       []ShareConfig{{KeyFile: filepath.Join(dir, "share2.json"), ListenAddress: extraAddress}},
+	}
+	service, err := New(config, logger)
+	require.NoError(test, err)
+	require.Len(test, service.extraCosigners, 1)
+	require.NoError(test, service.Start())
+	defer service.Stop()
+
+	// the quorum of both shares signs
+	hash := bytes.Repeat([]byte{1}, 32)
+	vote := tmProto.Vote{
+		Type:    tmProto.PrevoteType,
+		Height:  1,
+		BlockID: tmProto.BlockID{Hash: hash, PartSetHeader: tmProto.PartSetHeader{Total: 1, Hash: hash}},
+	}
+	require.NoError(test, service.PrivValidator().SignVote("chain-id", &vote))
+	require.True(test, privKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+
+	// a share must be reachable by our own share
+	config.Cosigners = []CosignerConfig{{ID: 2, Address: "tcp://127.0.0.1:1"}}
+	config.ExtraShares = []ShareConfig{{KeyFile: filepath.Join(dir, "share1.json"), ListenAddress: extraAddress}}
+	_, err = New(config, logger)
+	require.Error(test, err)
+	require.Contains(test, err.Error(), "our own id")
+}
@@ -0,0 +1,83 @@
+package signer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultEventSubscribers is the default admin_event_subscribers
+const DefaultEventSubscribers = 4
+
+// events buffered for each subscriber, further events are dropped for a subscriber not keeping up
+const signEventBuffer = 64
+
+// ErrTooManySubscribers is returned by Subscribe once the maximum number of subscribers is reached
+var ErrTooManySubscribers = errors.New("too many sign event subscribers")
+
+// SignEvent is the outcome of a sign request, as streamed on /events
+type SignEvent struct {
+	Time    time.Time `json:"time"`
+	ChainID string    `json:"chain_id"`
+
+	// "prevote", "precommit" or "proposal"
+	Type   string `json:"type"`
+	Height int64  `json:"height"`
+	Round  int64  `json:"round"`
+
+	Success bool `json:"success"`
+
+	// why the request failed or was refused, empty on success
+	Error string `json:"error,omitempty"`
+
+	// time spent signing, 0 if refused before signing
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// SignEvents hands out the outcome of every sign request to a bounded number of subscribers.
+// Publishing never blocks signing: a subscriber that falls behind misses events.
+type SignEvents struct {
+	mtx            sync.Mutex
+	subscribers    map[chan SignEvent]struct{}
+	maxSubscribers int
+}
+
+// NewSignEvents returns SignEvents accepting up to maxSubscribers subscribers at once
+func NewSignEvents(maxSubscribers int) *SignEvents {
+	return &SignEvents{
+		subscribers:    make(map[chan SignEvent]struct{}),
+		maxSubscribers: maxSubscribers,
+	}
+}
+
+// Subscribe returns a channel receiving the events published from now on, and the func to unsubscribe
+func (events *SignEvents) Subscribe() (<-chan SignEvent, func(), error) {
+	events.mtx.Lock()
+	defer events.mtx.Unlock()
+
+	if len(events.subscribers) >= events.maxSubscribers {
+		return nil, nil, ErrTooManySubscribers
+	}
+
+	subscriber := make(chan SignEvent, signEventBuffer)
+	events.subscribers[subscriber] = struct{}{}
+	unsubscribe := func() {
+		events.mtx.Lock()
+		defer events.mtx.Unlock()
+		delete(events.subscribers, subscriber)
+	}
+	return subscriber, unsubscribe, nil
+}
+
+// publish hands event to every subscriber with room for it
+func (events *SignEvents) publish(event SignEvent) {
+	events.mtx.Lock()
+	defer events.mtx.Unlock()
+
+	for subscriber := range events.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
@@ -1,18 +1,16 @@
 package signer
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"os"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
 	tmBytes "github.com/tendermint/tendermint/libs/bytes"
-	tmJson "github.com/tendermint/tendermint/libs/json"
 	"github.com/tendermint/tendermint/libs/protoio"
-	"github.com/tendermint/tendermint/libs/tempfile"
 	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
-	tmtime "github.com/tendermint/tendermint/types/time"
 )
 
 const (
@@ -22,6 +20,12 @@ const (
 	stepPrecommit int8 = 3
 )
 
+// IsVoteType returns true for the vote types that can be signed: prevotes and precommits
+// VoteToStep and CanonicalVoteToStep panic for any other type
+func IsVoteType(voteType tmProto.SignedMsgType) bool {
+	return voteType == tmProto.PrevoteType || voteType == tmProto.PrecommitType
+}
+
 func CanonicalVoteToStep(vote *tmProto.CanonicalVote) int8 {
 	switch vote.Type {
 	case tmProto.PrevoteType:
@@ -57,23 +61,66 @@ type SignState struct {
 	Signature       []byte           `json:"signature,omitempty"`
 	SignBytes       tmBytes.HexBytes `json:"signbytes,omitempty"`
 
-	filePath string
+	store SignStateStore
+
+	// the state last loaded from or saved to the store, compared against on save
+	saved *SignState
+}
+
+// SignStateSaveError is returned when a sign state could not be saved. The signature it was saved for is not returned.
+type SignStateSaveError struct {
+	Err error
+}
+
+func (err *SignStateSaveError) Error() string {
+	return "saving sign state: " + err.Err.Error()
+}
+
+func (err *SignStateSaveError) Unwrap() error {
+	return err.Err
 }
 
-// Save persists the FilePvLastSignState to its filePath.
-func (signState *SignState) Save() {
-	outFile := signState.filePath
-	if outFile == "" {
-		panic("cannot save SignState: filePath not set")
+// IsSignStateSaveError returns true if err, or an error it wraps, is a SignStateSaveError
+func IsSignStateSaveError(err error) bool {
+	var saveErr *SignStateSaveError
+	return errors.As(err, &saveErr)
+}
+
+// Save persists the sign state to its store.
+// A signature must not be handed out unless its sign state was saved.
+func (signState *SignState) Save() error {
+	if signState.store == nil {
+		return errors.New("cannot save SignState: no store set")
 	}
-	jsonBytes, err := tmJson.MarshalIndent(signState, "", "  ")
-	if err != nil {
-		panic(err)
+
+	next := *signState
+	next.store = nil
+	next.saved = nil
+
+	prev := next
+	if signState.saved != nil {
+		prev = *signState.saved
 	}
-	err = tempfile.WriteFileAtomic(outFile, jsonBytes, 0600)
-	if err != nil {
-		panic(err)
+	if err := signState.store.CompareAndSave(prev, next); err != nil {
+		return &SignStateSaveError{Err: err}
+	}
+	signState.saved = &next
+	return nil
+}
+
+// FlushingSignStateStore is a SignStateStore that may keep writes back until Flush,
+// see CoalescingSignStateStore and TieredSignStateStore
+type FlushingSignStateStore interface {
+	SignStateStore
+	Flush() error
+}
+
+// Flush writes the sign state if its store keeps it back
+func (signState *SignState) Flush() error {
+	if store, ok := signState.store.(FlushingSignStateStore); ok {
+		return store.Flush()
 	}
+	return nil
 }
 
 // CheckHRS checks the given height, round, step (HRS) against that of the
@@ -84,17 +131,17 @@ func (signState *SignState) Save() {
 // It panics if the HRS matches the arguments, there's a SignBytes, but no Signature.
 func (signState *SignState) CheckHRS(height int64, round int64, step int8) (bool, error) {
 	if signState.Height > height {
-		return false, fmt.Errorf("height regression. Got %v, last height %v", height, signState.Height)
+		return false, newSignerError(ErrorCodeDoubleSign, fmt.Errorf("height regression. Got %v, last height %v", height, signState.Height))
 	}
 
 	if signState.Height == height {
 		if signState.Round > round {
-			return false, fmt.Errorf("round regression at height %v. Got %v, last round %v", height, round, signState.Round)
+			return false, newSignerError(ErrorCodeDoubleSign, fmt.Errorf("round regression at height %v. Got %v, last round %v", height, round, signState.Round))
 		}
 
 		if signState.Round == round {
 			if signState.Step > step {
-				return false, fmt.Errorf("step regression at height %v round %v. Got %v, last step %v", height, round, step, signState.Step)
+				return false, newSignerError(ErrorCodeDoubleSign, fmt.Errorf("step regression at height %v round %v. Got %v, last step %v", height, round, step, signState.Step))
 			} else if signState.Step == step {
 				if signState.SignBytes != nil {
 					if signState.Signature == nil {
@@ -111,34 +158,46 @@ func (signState *SignState) CheckHRS(height int64, round int64, step int8) (bool
 
 // LoadSignState loads a sign state from disk.
 func LoadSignState(filepath string) (SignState, error) {
-	state := SignState{}
-	stateJSONBytes, err := ioutil.ReadFile(filepath)
-	if err != nil {
-		return state, err
-	}
+	return LoadSignStateFrom(NewFileSignStateStore(filepath))
+}
 
-	err = tmJson.Unmarshal(stateJSONBytes, &state)
+// LoadSignStateFrom loads a sign state from the store, it is saved back to the store
+func LoadSignStateFrom(store SignStateStore) (SignState, error) {
+	state, err := store.Load()
 	if err != nil {
-		return state, err
+		return SignState{}, err
 	}
-	state.filePath = filepath
+	saved := state
+	state.store = store
+	state.saved = &saved
 	return state, nil
 }
 
 // LoadOrCreateSignState loads the sign state from filepath
-// If the sign state could not be loaded, an empty sign state is initialized
-// and saved to filepath.
+// If the file does not exist, an empty sign state is initialized and saved to filepath.
+// Any other error is returned, since replacing an existing sign state would
+// discard its watermark and risk a double sign.
 func LoadOrCreateSignState(filepath string) (SignState, error) {
-	existing, err := LoadSignState(filepath)
+	return LoadOrCreateSignStateFrom(NewFileSignStateStore(filepath))
+}
+
+// LoadOrCreateSignStateFrom is LoadOrCreateSignState for any store
+func LoadOrCreateSignStateFrom(store SignStateStore) (SignState, error) {
+	existing, err := LoadSignStateFrom(store)
 	if err == nil {
 		return existing, nil
 	}
 
-	// There was an error loading the sign state
+	if !os.IsNotExist(err) {
+		return SignState{}, fmt.Errorf("error loading sign state from %v, refusing to overwrite it: %w", store, err)
+	}
+
+	// There is no sign state yet
 	// Make an empty sign state and save it
-	state := SignState{}
-	state.filePath = filepath
-	state.Save()
+	state := SignState{store: store}
+	if err := state.Save(); err != nil {
+		return SignState{}, err
+	}
 	return state, nil
 }
 
@@ -154,39 +213,56 @@ func (signState *SignState) OnlyDifferByTimestamp(signBytes []byte) (time.Time,
 	return time.Time{}, false
 }
 
+// timestamps are replaced with this before comparing sign bytes, so that both sides are
+// encoded from the same value whatever the monotonic reading or location of their own timestamps
+var normalizedTimestamp = time.Unix(0, 0).UTC()
+
+// checkVoteOnlyDifferByTimestamp re-encodes both votes with the same timestamp and compares the
+// bytes, so a difference in any other field is detected.
+// Sign bytes that cannot be decoded are reported as differing, refusing to sign is the safe side.
 func checkVoteOnlyDifferByTimestamp(lastSignBytes, newSignBytes []byte) (time.Time, bool) {
 	var lastVote, newVote tmProto.CanonicalVote
 	if err := protoio.UnmarshalDelimited(lastSignBytes, &lastVote); err != nil {
-		panic(fmt.Sprintf("LastSignBytes cannot be unmarshalled into vote: %v", err))
+		return time.Time{}, false
 	}
 	if err := protoio.UnmarshalDelimited(newSignBytes, &newVote); err != nil {
-		panic(fmt.Sprintf("signBytes cannot be unmarshalled into vote: %v", err))
+		return time.Time{}, false
 	}
 
 	lastTime := lastVote.Timestamp
+	lastVote.Timestamp = normalizedTimestamp
+	newVote.Timestamp = normalizedTimestamp
 
-	// set the times to the same value and check equality
-	now := tmtime.Now()
-	lastVote.Timestamp = now
-	newVote.Timestamp = now
-
-	return lastTime, proto.Equal(&newVote, &lastVote)
+	return lastTime, equalEncoding(&lastVote, &newVote)
 }
 
+// checkProposalOnlyDifferByTimestamp is checkVoteOnlyDifferByTimestamp for proposals
 func checkProposalOnlyDifferByTimestamp(lastSignBytes, newSignBytes []byte) (time.Time, bool) {
 	var lastProposal, newProposal tmProto.CanonicalProposal
 	if err := protoio.UnmarshalDelimited(lastSignBytes, &lastProposal); err != nil {
-		panic(fmt.Sprintf("LastSignBytes cannot be unmarshalled into proposal: %v", err))
+		return time.Time{}, false
 	}
 	if err := protoio.UnmarshalDelimited(newSignBytes, &newProposal); err != nil {
-		panic(fmt.Sprintf("signBytes cannot be unmarshalled into proposal: %v", err))
+		return time.Time{}, false
 	}
 
 	lastTime := lastProposal.Timestamp
-	// set the times to the same value and check equality
-	now := tmtime.Now()
-	lastProposal.Timestamp = now
-	newProposal.Timestamp = now
+	lastProposal.Timestamp = normalizedTimestamp
+	newProposal.Timestamp = normalizedTimestamp
+
+	return lastTime, equalEncoding(&lastProposal, &newProposal)
+}
 
-	return lastTime, proto.Equal(&newProposal, &lastProposal)
+// equalEncoding returns true if both messages encode to the same bytes.
+// Votes and proposals are both compared by their canonical protobuf encoding, the one they are signed in.
+func equalEncoding(last, next proto.Message) bool {
+	lastBytes, err := protoio.MarshalDelimited(last)
+	if err != nil {
+		return false
+	}
+	newBytes, err := protoio.MarshalDelimited(next)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(lastBytes, newBytes)
 }
@@ -0,0 +1,72 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+// SignStateResync is the outcome of ResyncSignState
+type SignStateResync struct {
+	// the share watermark after the resync
+	Height int64 `json:"height"`
+	Round  int64 `json:"round"`
+	Step   int8  `json:"step"`
+
+	// whether the watermark was moved forward
+	Advanced bool `json:"advanced"`
+
+	// ids of the peers that could not be queried
+	Unreachable []int `json:"unreachable,omitempty"`
+}
+
+// ResyncSignState advances the share watermark of local to the highest share watermark of the peers.
+// The watermark is never lowered. A cosigner restored from a backup of its sign state can
+// otherwise sign a share for a height, round and step that a peer already signed for.
+func ResyncSignState(ctx context.Context, local *LocalCosigner, peers []*RemoteCosigner, logger log.Logger) (SignStateResync, error) {
+	result := SignStateResync{}
+
+	highest := HRSKey{}
+	highestPeer := 0
+	for _, peer := range peers {
+		watermark, err := peer.GetSignStateWatermark(ctx)
+		if err != nil {
+			logger.Error("Could not get the share watermark of a peer", "peer", peer.GetID(), "err", err)
+			result.Unreachable = append(result.Unreachable, peer.GetID())
+			continue
+		}
+		if highest.Less(watermark) {
+			highest = watermark
+			highestPeer = peer.GetID()
+		}
+	}
+
+	if len(peers) > 0 && len(result.Unreachable) == len(peers) {
+		return result, fmt.Errorf("none of the %d peers returned their share watermark", len(peers))
+	}
+
+	previous := local.SignStateWatermark()
+	advanced, err := local.AdvanceSignState(highest)
+	if err != nil {
+		return result, err
+	}
+
+	current := local.SignStateWatermark()
+	result.Height, result.Round, result.Step = current.Height, current.Round, current.Step
+	result.Advanced = advanced
+
+	if advanced {
+		logger.Info("Advanced the share watermark to a peer's",
+			"peer", highestPeer,
+			"from-height", previous.Height, "from-round", previous.Round, "from-step", previous.Step,
+			"to-height", current.Height, "to-round", current.Round, "to-step", current.Step,
+		)
+	} else {
+		logger.Info("Share watermark is not behind the peers, left unchanged",
+			"height", current.Height, "round", current.Round, "step", current.Step,
+			"highest-peer-height", highest.Height, "highest-peer-round", highest.Round, "highest-peer-step", highest.Step,
+		)
+	}
+	return result, nil
+}
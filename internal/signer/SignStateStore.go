@@ -0,0 +1,460 @@
+package signer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	tmJson "github.com/tendermint/tendermint/libs/json"
+	tmLog "github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/libs/tempfile"
+)
+
+// ErrSignStateConflict is returned by a SignStateStore when the stored watermark was moved by another writer
+var ErrSignStateConflict = errors.New("sign state was changed by another signer")
+
+// SignStateStore persists the watermark of a SignState.
+//
+// The watermark is what prevents a double sign, so a store shared by several signers, e.g. a
+// hot-standby pair, must be strongly consistent: CompareAndSave must be an atomic compare-and-set
+// against the latest stored state (a linearizable read, such as an etcd transaction on the key's
+// revision or a Redis script on a primary with fencing), never against a replica or a cache.
+// A signer must not return a signature whose state was not saved.
+type SignStateStore interface {
+	// Load returns the stored sign state, or an error satisfying os.IsNotExist if there is none yet
+	Load() (SignState, error)
+
+	// CompareAndSave stores next if the stored state still has the height, round and step of prev,
+	// and returns ErrSignStateConflict otherwise
+	CompareAndSave(prev SignState, next SignState) error
+}
+
+// FileSignStateStore keeps the sign state in a json file
+// The file must only be written by a single signer, prev is not checked against the file.
+type FileSignStateStore struct {
+	path string
+}
+
+// NewFileSignStateStore returns a store for the sign state at path
+func NewFileSignStateStore(path string) *FileSignStateStore {
+	return &FileSignStateStore{path: path}
+}
+
+// Load implements SignStateStore
+func (store *FileSignStateStore) Load() (SignState, error) {
+	state := SignState{}
+	stateJSONBytes, err := ioutil.ReadFile(store.path)
+	if err != nil {
+		return state, err
+	}
+
+	err = tmJson.Unmarshal(stateJSONBytes, &state)
+	return state, err
+}
+
+// CompareAndSave implements SignStateStore
+func (store *FileSignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	jsonBytes, err := tmJson.MarshalIndent(&next, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tempfile.WriteFileAtomic(store.path, jsonBytes, 0600)
+}
+
+// String returns the path of the file
+func (store *FileSignStateStore) String() string {
+	return store.path
+}
+
+// CheckStateDirWritable writes and removes a file in the state directory, so that a directory the
+// signer cannot write to, e.g. a volume not mounted yet or mounted read-only, fails startup rather
+// than the first signature
+func CheckStateDirWritable(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	file, err := ioutil.TempFile(dir, ".write-check-")
+	if err != nil {
+		return fmt.Errorf("state directory %s is not writable: %w", dir, err)
+	}
+	_, err = file.Write([]byte("ok"))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(file.Name()); err == nil {
+		err = removeErr
+	}
+	if err != nil {
+		return fmt.Errorf("state directory %s is not writable: %w", dir, err)
+	}
+	return nil
+}
+
+// checkSameHRS returns ErrSignStateConflict if stored does not have the height, round and step of expected
+// For stores to implement CompareAndSave with.
+func checkSameHRS(stored SignState, expected SignState) error {
+	if stored.Height != expected.Height || stored.Round != expected.Round || stored.Step != expected.Step {
+		return fmt.Errorf("%w: expected height %d round %d step %d, stored height %d round %d step %d", ErrSignStateConflict,
+			expected.Height, expected.Round, expected.Step, stored.Height, stored.Round, stored.Step)
+	}
+	return nil
+}
+
+// CoalescingSignStateStore writes to its store at most once per interval, keeping the latest state
+// in memory in between and writing it once the interval passed, or on Flush.
+//
+// A state that is not written yet is lost on a crash, so this is only safe for a sign state that
+// does not protect against a double sign by itself: the threshold validator's cache of the last
+// signature, with every cosigner's share sign state still written before its share is returned.
+type CoalescingSignStateStore struct {
+	store    SignStateStore
+	interval time.Duration
+	logger   tmLog.Logger
+
+	mtx       sync.Mutex
+	written   SignState
+	pending   *SignState
+	lastWrite time.Time
+	timer     *time.Timer
+
+	// of the last write, if it failed
+	err error
+}
+
+// NewCoalescingSignStateStore returns a store writing to store at most once per interval
+func NewCoalescingSignStateStore(store SignStateStore, interval time.Duration, logger tmLog.Logger) *CoalescingSignStateStore {
+	return &CoalescingSignStateStore{store: store, interval: interval, logger: logger}
+}
+
+// Load implements SignStateStore
+func (store *CoalescingSignStateStore) Load() (SignState, error) {
+	state, err := store.store.Load()
+	if err != nil {
+		return state, err
+	}
+
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	store.written = state
+	store.pending = nil
+	return state, nil
+}
+
+// CompareAndSave implements SignStateStore, prev is checked against the latest state saved, written or not
+func (store *CoalescingSignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	latest := store.written
+	if store.pending != nil {
+		latest = *store.pending
+	}
+	if err := checkSameHRS(latest, prev); err != nil {
+		return err
+	}
+
+	kept := store.pending
+	store.pending = &next
+	wait := store.interval - time.Since(store.lastWrite)
+	if wait <= 0 || store.err != nil {
+		// a failed deferred write is retried right away
+		if err := store.write(); err != nil {
+			// next is refused, the state kept back before it is still to be written
+			store.pending = kept
+			return err
+		}
+		return nil
+	}
+	if store.timer == nil {
+		store.timer = time.AfterFunc(wait, func() {
+			store.mtx.Lock()
+			defer store.mtx.Unlock()
+			store.timer = nil
+			if err := store.write(); err != nil {
+				store.logger.Error("Writing sign state", "store", store.store, "err", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush writes the state kept back, if any
+func (store *CoalescingSignStateStore) Flush() error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	if store.timer != nil {
+		store.timer.Stop()
+		store.timer = nil
+	}
+	return store.write()
+}
+
+// write writes the pending state to the store, with the mutex held
+func (store *CoalescingSignStateStore) write() error {
+	if store.pending == nil {
+		return nil
+	}
+	if err := store.store.CompareAndSave(store.written, *store.pending); err != nil {
+		store.err = err
+		return err
+	}
+	store.written = *store.pending
+	store.pending = nil
+	store.lastWrite = time.Now()
+	store.err = nil
+	return nil
+}
+
+// String returns the underlying store
+func (store *CoalescingSignStateStore) String() string {
+	return fmt.Sprint(store.store)
+}
+
+// TieredSignStateStore saves to a fast primary store, e.g. a file on a tmpfs, and copies the states
+// saved to a durable backup store in the background. Load recovers the higher of the two: the primary
+// after a restart that kept it, and the backup after one that lost it, e.g. a reboot clearing the tmpfs.
+// Either way, nothing is signed below the watermark of the backup.
+//
+// The backup lags the primary by the copy in flight, lost on a crash, so like CoalescingSignStateStore
+// this is only safe for the threshold validator's cache of the last signature, not a share sign state.
+type TieredSignStateStore struct {
+	primary SignStateStore
+	backup  SignStateStore
+	logger  tmLog.Logger
+
+	mtx      sync.Mutex
+	idle     *sync.Cond
+	backedUp SignState
+	pending  *SignState
+	copying  bool
+
+	// of the last copy, if it failed
+	err error
+}
+
+// NewTieredSignStateStore returns a store saving to primary and copying to backup in the background
+func NewTieredSignStateStore(primary SignStateStore, backup SignStateStore, logger tmLog.Logger) *TieredSignStateStore {
+	store := &TieredSignStateStore{primary: primary, backup: backup, logger: logger}
+	store.idle = sync.NewCond(&store.mtx)
+	return store
+}
+
+// Load implements SignStateStore, returning the higher of the primary and backup states.
+// A primary behind the backup is brought up to it, a backup behind the primary is copied to in the background.
+func (store *TieredSignStateStore) Load() (SignState, error) {
+	primary, primaryErr := store.primary.Load()
+	if primaryErr != nil && !os.IsNotExist(primaryErr) {
+		return SignState{}, primaryErr
+	}
+	backup, backupErr := store.backup.Load()
+	if backupErr != nil && !os.IsNotExist(backupErr) {
+		return SignState{}, backupErr
+	}
+	if primaryErr != nil && backupErr != nil {
+		return SignState{}, primaryErr
+	}
+
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	store.backedUp = backup
+	store.pending = nil
+
+	switch {
+	case primaryErr != nil || hrsBefore(primary, backup):
+		if err := store.primary.CompareAndSave(primary, backup); err != nil {
+			return SignState{}, err
+		}
+		return backup, nil
+	case backupErr != nil || hrsBefore(backup, primary):
+		store.copy(primary)
+	}
+	return primary, nil
+}
+
+// CompareAndSave implements SignStateStore, saving to the primary and copying to the backup once
+// the copy in flight, if any, is done
+func (store *TieredSignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	if err := store.primary.CompareAndSave(prev, next); err != nil {
+		return err
+	}
+	store.copy(next)
+	return nil
+}
+
+// copy queues state to be copied to the backup, with the mutex held
+func (store *TieredSignStateStore) copy(state SignState) {
+	store.pending = &state
+	if !store.copying {
+		store.copying = true
+		go store.copyPending()
+	}
+}
+
+// copyPending copies the latest state queued to the backup until none is left
+func (store *TieredSignStateStore) copyPending() {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	store.copyLocked()
+}
+
+// copyLocked is copyPending with the mutex held, and copying set
+func (store *TieredSignStateStore) copyLocked() {
+	for store.pending != nil {
+		if err := store.writeBackup(); err != nil {
+			// retried with the next save, or on Flush
+			store.logger.Error("Copying sign state", "store", store.backup, "err", err)
+			break
+		}
+	}
+	store.copying = false
+	store.idle.Broadcast()
+}
+
+// writeBackup writes the pending state to the backup, with the mutex held, releasing it while writing
+func (store *TieredSignStateStore) writeBackup() error {
+	state := *store.pending
+	prev := store.backedUp
+	store.pending = nil
+
+	store.mtx.Unlock()
+	err := store.backup.CompareAndSave(prev, state)
+	store.mtx.Lock()
+
+	if err != nil {
+		if store.pending == nil {
+			store.pending = &state
+		}
+		store.err = err
+		return err
+	}
+	store.backedUp = state
+	store.err = nil
+	return nil
+}
+
+// Flush waits for the copy in flight and writes any state left to the backup
+func (store *TieredSignStateStore) Flush() error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	for store.copying {
+		store.idle.Wait()
+	}
+	if store.pending != nil {
+		store.copying = true
+		store.copyLocked()
+	}
+	return store.err
+}
+
+// String returns both stores
+func (store *TieredSignStateStore) String() string {
+	return fmt.Sprintf("%v (backed up to %v)", store.primary, store.backup)
+}
+
+// hrsBefore returns true if the height, round and step of state are before those of other
+func hrsBefore(state SignState, other SignState) bool {
+	if state.Height != other.Height {
+		return state.Height < other.Height
+	}
+	if state.Round != other.Round {
+		return state.Round < other.Round
+	}
+	return state.Step < other.Step
+}
+
+// DefaultSaveRetries is the default sign_state_save_retries
+const DefaultSaveRetries = 3
+
+// wait before retrying a failed save, doubling with every further retry
+const saveRetryBackoff = 10 * time.Millisecond
+
+// RetryingSignStateStore retries a failed save to its store up to retries times, e.g. on a disk
+// briefly full or a transient NFS error. A conflict with another writer is not retried.
+type RetryingSignStateStore struct {
+	store   SignStateStore
+	retries int
+	backoff time.Duration
+	logger  tmLog.Logger
+}
+
+// NewRetryingSignStateStore returns a store retrying the saves to store
+func NewRetryingSignStateStore(store SignStateStore, retries int, logger tmLog.Logger) *RetryingSignStateStore {
+	return &RetryingSignStateStore{store: store, retries: retries, backoff: saveRetryBackoff, logger: logger}
+}
+
+// Load implements SignStateStore
+func (store *RetryingSignStateStore) Load() (SignState, error) {
+	return store.store.Load()
+}
+
+// CompareAndSave implements SignStateStore
+func (store *RetryingSignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	return retrySave(store.retries, store.backoff, store.logger, func() error {
+		return store.store.CompareAndSave(prev, next)
+	})
+}
+
+// String returns the underlying store
+func (store *RetryingSignStateStore) String() string {
+	return fmt.Sprint(store.store)
+}
+
+// retrySave calls save until it succeeds, up to retries more times, waiting backoff, doubling, in between
+func retrySave(retries int, backoff time.Duration, logger tmLog.Logger, save func() error) error {
+	err := save()
+	for retry := 0; err != nil && retry < retries && !errors.Is(err, ErrSignStateConflict); retry++ {
+		logger.Error("Saving sign state, retrying", "backoff", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+		err = save()
+	}
+	return err
+}
+
+// DefaultSlowSaveMs is the default sign_state_slow_save_ms
+const DefaultSlowSaveMs = 100
+
+// TimedSignStateStore records how long each save to its store takes, and logs the saves slower
+// than a threshold, to tell when the state volume is what slows signing down
+type TimedSignStateStore struct {
+	store   SignStateStore
+	state   string
+	slow    time.Duration
+	metrics *Metrics
+	logger  tmLog.Logger
+}
+
+// NewTimedSignStateStore returns a store timing the saves to store, labeled with state.
+// Saves taking slow or longer are logged, none if slow is 0.
+func NewTimedSignStateStore(store SignStateStore, state string, slow time.Duration, metrics *Metrics, logger tmLog.Logger) *TimedSignStateStore {
+	return &TimedSignStateStore{store: store, state: state, slow: slow, metrics: metrics, logger: logger}
+}
+
+// Load implements SignStateStore
+func (store *TimedSignStateStore) Load() (SignState, error) {
+	return store.store.Load()
+}
+
+// CompareAndSave implements SignStateStore
+func (store *TimedSignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	start := time.Now()
+	err := store.store.CompareAndSave(prev, next)
+	elapsed := time.Since(start)
+
+	store.metrics.SignStateSaveDuration.With("state", store.state).Observe(elapsed.Seconds())
+	if store.slow > 0 && elapsed >= store.slow {
+		store.logger.Error("Slow sign state save", "state", store.state, "store", store.store,
+			"height", next.Height, "round", next.Round, "step", next.Step, "ms", elapsed.Milliseconds())
+	}
+	return err
+}
+
+// String returns the underlying store
+func (store *TimedSignStateStore) String() string {
+	return fmt.Sprint(store.store)
+}
@@ -0,0 +1,55 @@
+package signer
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SignStateAnomalies returns what is unexpected about a sign state file moving from last to next,
+// for watching the file of a running signer from the outside, see signer watch-state:
+//
+//	a regression of the height, round or step, which the signer never writes
+//	the same height, round and step signed again with different sign bytes, not only another timestamp
+//	heights skipped, which the validator did not sign
+//	a step skipped within a round, e.g. a precommit without a prevote
+//
+// The last two happen when the validator misses blocks or rounds, e.g. while it restarts.
+func SignStateAnomalies(last SignState, next SignState) []string {
+	anomalies := make([]string, 0)
+
+	// CheckHRS panics on such a state, the signer never writes one
+	if len(next.SignBytes) > 0 && len(next.Signature) == 0 {
+		anomalies = append(anomalies, fmt.Sprintf("height %d round %d step %d has sign bytes but no signature",
+			next.Height, next.Round, next.Step))
+	}
+	if len(last.SignBytes) > 0 && len(last.Signature) == 0 {
+		return anomalies
+	}
+
+	sameHRS, err := last.CheckHRS(next.Height, next.Round, next.Step)
+	if err != nil {
+		return append(anomalies, err.Error())
+	}
+	if sameHRS {
+		if bytes.Equal(last.SignBytes, next.SignBytes) {
+			return anomalies
+		}
+		if _, ok := last.OnlyDifferByTimestamp(next.SignBytes); !ok {
+			anomalies = append(anomalies, fmt.Sprintf("height %d round %d step %d signed again with different sign bytes",
+				next.Height, next.Round, next.Step))
+		}
+		return anomalies
+	}
+
+	// an empty state is the start, nothing was skipped
+	if last.Height == 0 {
+		return anomalies
+	}
+	if next.Height > last.Height+1 {
+		anomalies = append(anomalies, fmt.Sprintf("heights %d to %d skipped", last.Height+1, next.Height-1))
+	}
+	if next.Height == last.Height && next.Round == last.Round && next.Step > last.Step+1 {
+		anomalies = append(anomalies, fmt.Sprintf("step %d skipped at height %d round %d", last.Step+1, next.Height, next.Round))
+	}
+	return anomalies
+}
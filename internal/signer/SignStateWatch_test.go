@@ -0,0 +1,43 @@
+package signer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func TestSignStateAnomalies(test *testing.T) {
+	voteState := func(height int64, round int64, step int8, blockID byte, timestamp time.Time) SignState {
+		voteType := tmProto.PrevoteType
+		if step == stepPrecommit {
+			voteType = tmProto.PrecommitType
+		}
+		vote := tmProto.Vote{Type: voteType, Height: height, Round: int32(round), BlockID: testBlockID(blockID), Timestamp: timestamp}
+		return SignState{Height: height, Round: round, Step: step, SignBytes: tm.VoteSignBytes("chain-id", &vote), Signature: []byte{blockID}}
+	}
+	now := time.Now()
+
+	last := voteState(10, 0, stepPrevote, 0xaa, now)
+	require.Empty(test, SignStateAnomalies(SignState{}, last))
+	require.Empty(test, SignStateAnomalies(last, last))
+	require.Empty(test, SignStateAnomalies(last, voteState(10, 0, stepPrecommit, 0xaa, now)))
+	require.Empty(test, SignStateAnomalies(last, voteState(11, 0, stepPrevote, 0xaa, now)))
+
+	// only the timestamp differs
+	require.Empty(test, SignStateAnomalies(last, voteState(10, 0, stepPrevote, 0xaa, now.Add(time.Second))))
+
+	require.Len(test, SignStateAnomalies(last, voteState(9, 0, stepPrevote, 0xaa, now)), 1)
+	require.Len(test, SignStateAnomalies(last, voteState(10, 0, stepPrevote, 0xbb, now)), 1)
+	require.Len(test, SignStateAnomalies(last, voteState(13, 0, stepPrevote, 0xaa, now)), 1)
+
+	propose := SignState{Height: 10, Round: 1, Step: stepPropose}
+	require.Len(test, SignStateAnomalies(propose, voteState(10, 1, stepPrecommit, 0xaa, now)), 1)
+
+	unsigned := voteState(11, 0, stepPrevote, 0xaa, now)
+	unsigned.Signature = nil
+	require.Len(test, SignStateAnomalies(last, unsigned), 1)
+	require.Len(test, SignStateAnomalies(unsigned, last), 0)
+}
@@ -0,0 +1,406 @@
+package signer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func TestLoadOrCreateSignStateCreatesMissingFile(test *testing.T) {
+	stateFile := filepath.Join(test.TempDir(), "state.json")
+
+	state, err := LoadOrCreateSignState(stateFile)
+	require.NoError(test, err)
+	require.Equal(test, int64(0), state.Height)
+
+	state.Height = 10
+	require.NoError(test, state.Save())
+
+	state, err = LoadOrCreateSignState(stateFile)
+	require.NoError(test, err)
+	require.Equal(test, int64(10), state.Height)
+}
+
+func TestLoadOrCreateSignStateKeepsUnreadableFile(test *testing.T) {
+	stateFile := filepath.Join(test.TempDir(), "state.json")
+
+	// e.g. a partially written state
+	partial := []byte(`{"height": "10", "round": "0",`)
+	require.NoError(test, ioutil.WriteFile(stateFile, partial, 0600))
+
+	_, err := LoadOrCreateSignState(stateFile)
+	require.Error(test, err)
+
+	// the existing watermark must not be replaced with an empty state
+	contents, err := ioutil.ReadFile(stateFile)
+	require.NoError(test, err)
+	require.Equal(test, partial, contents)
+}
+
+func TestErrorCode(test *testing.T) {
+	signState := SignState{Height: 2, Round: 0, Step: stepPrevote}
+	_, err := signState.CheckHRS(1, 0, stepPrevote)
+	require.Equal(test, ErrorCodeDoubleSign, ErrorCode(err))
+
+	require.Equal(test, ErrorCodeUnavailable, ErrorCode(fmt.Errorf("sign: %w", ErrQuorumLost)))
+	require.Equal(test, ErrorCodeRefused, ErrorCode(ErrStandby))
+	require.Equal(test, ErrorCodeUnknown, ErrorCode(errors.New("other")))
+}
+
+func testBlockID(fill byte) tmProto.BlockID {
+	hash := bytes.Repeat([]byte{fill}, 32)
+	return tmProto.BlockID{Hash: hash, PartSetHeader: tmProto.PartSetHeader{Total: 1, Hash: hash}}
+}
+
+func TestOnlyDifferByTimestampVote(test *testing.T) {
+	// a reading of the monotonic clock, which the encoding strips
+	now := time.Now()
+	vote := tmProto.Vote{
+		Type:      tmProto.PrecommitType,
+		Height:    10,
+		Round:     1,
+		BlockID:   testBlockID(0xaa),
+		Timestamp: now,
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(vote *tmProto.Vote)
+		equal  bool
+	}{
+		{"same", func(vote *tmProto.Vote) {}, true},
+		{"monotonic reading stripped", func(vote *tmProto.Vote) { vote.Timestamp = now.Round(0) }, true},
+		{"other location", func(vote *tmProto.Vote) { vote.Timestamp = now.In(time.FixedZone("skewed", 3600)) }, true},
+		{"timestamp", func(vote *tmProto.Vote) { vote.Timestamp = now.Add(-5 * time.Second) }, true},
+		{"block id", func(vote *tmProto.Vote) { vote.BlockID = testBlockID(0xbb) }, false},
+		{"nil block id", func(vote *tmProto.Vote) { vote.BlockID = tmProto.BlockID{} }, false},
+		{"timestamp and block id", func(vote *tmProto.Vote) {
+			vote.Timestamp = now.Add(time.Second)
+			vote.BlockID = testBlockID(0xbb)
+		}, false},
+	}
+
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			next := vote
+			testCase.modify(&next)
+
+			signState := SignState{Height: 10, Round: 1, Step: stepPrecommit, SignBytes: tm.VoteSignBytes("chain-id", &vote)}
+			lastTime, equal := signState.OnlyDifferByTimestamp(tm.VoteSignBytes("chain-id", &next))
+			require.Equal(test, testCase.equal, equal)
+			if equal {
+				require.True(test, lastTime.Equal(now))
+			}
+		})
+	}
+}
+
+func TestOnlyDifferByTimestampProposal(test *testing.T) {
+	now := time.Now()
+	proposal := tmProto.Proposal{
+		Type:      tmProto.ProposalType,
+		Height:    10,
+		Round:     1,
+		PolRound:  -1,
+		BlockID:   testBlockID(0xaa),
+		Timestamp: now,
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(proposal *tmProto.Proposal)
+		equal  bool
+	}{
+		{"same", func(proposal *tmProto.Proposal) {}, true},
+		{"monotonic reading stripped", func(proposal *tmProto.Proposal) { proposal.Timestamp = now.Round(0) }, true},
+		{"other location", func(proposal *tmProto.Proposal) { proposal.Timestamp = now.In(time.FixedZone("skewed", 3600)) }, true},
+		{"timestamp", func(proposal *tmProto.Proposal) { proposal.Timestamp = now.Add(5 * time.Second) }, true},
+		{"block id", func(proposal *tmProto.Proposal) { proposal.BlockID = testBlockID(0xbb) }, false},
+		{"pol round", func(proposal *tmProto.Proposal) { proposal.PolRound = 0 }, false},
+	}
+
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			next := proposal
+			testCase.modify(&next)
+
+			signState := SignState{Height: 10, Round: 1, Step: stepPropose, SignBytes: tm.ProposalSignBytes("chain-id", &proposal)}
+			lastTime, equal := signState.OnlyDifferByTimestamp(tm.ProposalSignBytes("chain-id", &next))
+			require.Equal(test, testCase.equal, equal)
+			if equal {
+				require.True(test, lastTime.Equal(now))
+			}
+		})
+	}
+}
+
+func TestOnlyDifferByTimestampMalformed(test *testing.T) {
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 10, Timestamp: time.Now()}
+	signState := SignState{Height: 10, Step: stepPrevote, SignBytes: []byte{0xff, 0xff}}
+
+	_, equal := signState.OnlyDifferByTimestamp(tm.VoteSignBytes("chain-id", &vote))
+	require.False(test, equal)
+}
+
+// memorySignStateStore is a shared store like a remote key value store would be
+type memorySignStateStore struct {
+	state *SignState
+}
+
+func (store *memorySignStateStore) Load() (SignState, error) {
+	if store.state == nil {
+		return SignState{}, os.ErrNotExist
+	}
+	return *store.state, nil
+}
+
+func (store *memorySignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	if store.state != nil {
+		if err := checkSameHRS(*store.state, prev); err != nil {
+			return err
+		}
+	}
+	store.state = &next
+	return nil
+}
+
+func TestSignStateSharedStoreConflict(test *testing.T) {
+	store := &memorySignStateStore{}
+
+	first, err := LoadOrCreateSignStateFrom(store)
+	require.NoError(test, err)
+	second, err := LoadOrCreateSignStateFrom(store)
+	require.NoError(test, err)
+
+	first.Height = 10
+	require.NoError(test, first.Save())
+
+	// the second signer has not seen height 10 and must not overwrite it
+	second.Height = 10
+	second.Round = 1
+	err = second.Save()
+	require.True(test, errors.Is(err, ErrSignStateConflict))
+	require.Equal(test, int64(0), store.state.Round)
+
+	first.Step = stepPrevote
+	require.NoError(test, first.Save())
+}
+
+func TestCoalescingSignStateStore(test *testing.T) {
+	backing := &memorySignStateStore{}
+	store := NewCoalescingSignStateStore(backing, 50*time.Millisecond, log.NewNopLogger())
+
+	signState, err := LoadOrCreateSignStateFrom(store)
+	require.NoError(test, err)
+	written := func() int64 {
+		store.mtx.Lock()
+		defer store.mtx.Unlock()
+		return backing.state.Height
+	}
+
+	// the new state was written right away, the next ones wait for the interval
+	signState.Height = 2
+	require.NoError(test, signState.Save())
+	signState.Height = 3
+	require.NoError(test, signState.Save())
+	require.Equal(test, int64(0), written())
+
+	require.Eventually(test, func() bool { return written() == 3 }, time.Second, time.Millisecond)
+
+	// flushed without waiting
+	signState.Height = 4
+	require.NoError(test, signState.Save())
+	require.NoError(test, signState.Flush())
+	require.Equal(test, int64(4), written())
+
+	// another writer of the same state is refused whether or not the latest state was written
+	other := signState
+	other.Height = 5
+	require.NoError(test, other.Save())
+	signState.Height = 6
+	require.True(test, errors.Is(signState.Save(), ErrSignStateConflict))
+}
+
+func TestCoalescingSignStateStoreFailedWrite(test *testing.T) {
+	backing := &failingSignStateStore{}
+	store := NewCoalescingSignStateStore(backing, time.Hour, log.NewNopLogger())
+
+	signState, err := LoadOrCreateSignStateFrom(store)
+	require.NoError(test, err)
+
+	// a state refused is not kept to be written, the next save goes on from the last one saved
+	store.lastWrite = time.Time{}
+	backing.err = errors.New("disk full")
+	signState.Height = 2
+	require.Error(test, signState.Save())
+	backing.err = nil
+	require.NoError(test, signState.Save())
+	require.Equal(test, int64(2), backing.state.Height)
+
+	// a state kept back is written once a later write fails
+	signState.Height = 3
+	require.NoError(test, signState.Save())
+	store.err = errors.New("deferred write failed")
+	backing.err = errors.New("disk full")
+	signState.Height = 4
+	require.Error(test, signState.Save())
+	backing.err = nil
+	require.NoError(test, store.Flush())
+	require.Equal(test, int64(3), backing.state.Height)
+}
+
+// slowSignStateStore takes delay to save
+type slowSignStateStore struct {
+	memorySignStateStore
+	delay time.Duration
+}
+
+func (store *slowSignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	time.Sleep(store.delay)
+	return store.memorySignStateStore.CompareAndSave(prev, next)
+}
+
+// recordingHistogram keeps what is observed, and the labels of the last With
+type recordingHistogram struct {
+	labels       []string
+	observations []float64
+}
+
+func (histogram *recordingHistogram) With(labelValues ...string) metrics.Histogram {
+	histogram.labels = labelValues
+	return histogram
+}
+
+func (histogram *recordingHistogram) Observe(value float64) {
+	histogram.observations = append(histogram.observations, value)
+}
+
+func TestTimedSignStateStore(test *testing.T) {
+	var logs bytes.Buffer
+	logger := log.NewTMLogger(&logs)
+	durations := &recordingHistogram{}
+	metrics := NopMetrics()
+	metrics.SignStateSaveDuration = durations
+
+	backing := &slowSignStateStore{}
+	signState, err := LoadOrCreateSignStateFrom(NewTimedSignStateStore(backing, "share", 20*time.Millisecond, metrics, logger))
+	require.NoError(test, err)
+	require.Len(test, durations.observations, 1)
+	require.Equal(test, []string{"state", "share"}, durations.labels)
+	require.Empty(test, logs.String())
+
+	// a save slower than the threshold is logged
+	backing.delay = 30 * time.Millisecond
+	signState.Height = 1
+	require.NoError(test, signState.Save())
+	require.Len(test, durations.observations, 2)
+	require.GreaterOrEqual(test, durations.observations[1], 0.03)
+	require.Contains(test, logs.String(), "Slow sign state save")
+	require.Equal(test, int64(1), backing.state.Height)
+}
+
+// failingSignStateStore fails to save while err is set
+type failingSignStateStore struct {
+	memorySignStateStore
+	err error
+}
+
+func (store *failingSignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	if store.err != nil {
+		return store.err
+	}
+	return store.memorySignStateStore.CompareAndSave(prev, next)
+}
+
+func TestTieredSignStateStore(test *testing.T) {
+	primary := &memorySignStateStore{}
+	backup := &failingSignStateStore{}
+	store := NewTieredSignStateStore(primary, backup, log.NewNopLogger())
+
+	signState, err := LoadOrCreateSignStateFrom(store)
+	require.NoError(test, err)
+	for height := int64(1); height <= 4; height++ {
+		signState.Height = height
+		require.NoError(test, signState.Save())
+		require.Equal(test, height, primary.state.Height)
+	}
+	require.NoError(test, signState.Flush())
+	require.Equal(test, int64(4), backup.state.Height)
+
+	// a failed copy does not fail the save, and is retried
+	backup.err = errors.New("disk full")
+	signState.Height = 5
+	require.NoError(test, signState.Save())
+	require.Error(test, signState.Flush())
+	require.Equal(test, int64(4), backup.state.Height)
+	backup.err = nil
+	require.NoError(test, signState.Flush())
+	require.Equal(test, int64(5), backup.state.Height)
+
+	// a lost primary, e.g. a tmpfs after a reboot, is recovered from the backup
+	primary = &memorySignStateStore{}
+	signState, err = LoadOrCreateSignStateFrom(NewTieredSignStateStore(primary, backup, log.NewNopLogger()))
+	require.NoError(test, err)
+	require.Equal(test, int64(5), signState.Height)
+	require.Equal(test, int64(5), primary.state.Height)
+
+	// a primary ahead of the backup, after a crash, is kept and copied
+	primary.state.Height = 7
+	signState, err = LoadOrCreateSignStateFrom(NewTieredSignStateStore(primary, backup, log.NewNopLogger()))
+	require.NoError(test, err)
+	require.Equal(test, int64(7), signState.Height)
+	require.NoError(test, signState.Flush())
+	require.Equal(test, int64(7), backup.state.Height)
+}
+
+// flakySignStateStore fails the first failures saves after attempts is reset
+type flakySignStateStore struct {
+	memorySignStateStore
+	failures int
+	attempts int
+}
+
+func (store *flakySignStateStore) CompareAndSave(prev SignState, next SignState) error {
+	store.attempts++
+	if store.attempts <= store.failures {
+		return errors.New("transient")
+	}
+	return store.memorySignStateStore.CompareAndSave(prev, next)
+}
+
+func TestRetryingSignStateStore(test *testing.T) {
+	backing := &flakySignStateStore{failures: 2}
+	store := NewRetryingSignStateStore(backing, 2, log.NewNopLogger())
+	store.backoff = time.Millisecond
+
+	signState, err := LoadOrCreateSignStateFrom(store)
+	require.NoError(test, err)
+	require.Equal(test, 3, backing.attempts)
+
+	// beyond the retries, the error is returned
+	backing.attempts, backing.failures = 0, 3
+	signState.Height = 1
+	require.True(test, IsSignStateSaveError(signState.Save()))
+	require.Equal(test, 3, backing.attempts)
+
+	// a conflict is not retried
+	backing.failures = 0
+	other := signState
+	other.Height = 2
+	require.NoError(test, other.Save())
+	backing.attempts = 0
+	signState.Height = 3
+	require.True(test, errors.Is(signState.Save(), ErrSignStateConflict))
+	require.Equal(test, 1, backing.attempts)
+}
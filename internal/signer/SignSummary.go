@@ -0,0 +1,142 @@
+package signer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/libs/service"
+)
+
+// SignStats counts the signatures handed out since it was last reset
+type SignStats struct {
+	mtx          sync.Mutex
+	signed       int64
+	height       int64
+	totalLatency time.Duration
+}
+
+// SignStatsSummary is a snapshot of SignStats
+type SignStatsSummary struct {
+	Signed     int64
+	Height     int64
+	AvgLatency time.Duration
+}
+
+// record counts a successful signature at height that took latency
+func (stats *SignStats) record(height int64, latency time.Duration) {
+	stats.mtx.Lock()
+	defer stats.mtx.Unlock()
+	stats.signed++
+	stats.totalLatency += latency
+	if height > stats.height {
+		stats.height = height
+	}
+}
+
+// Reset returns the stats since the last reset and starts counting again
+// The height is kept, it is the highest signed so far.
+func (stats *SignStats) Reset() SignStatsSummary {
+	stats.mtx.Lock()
+	defer stats.mtx.Unlock()
+	summary := SignStatsSummary{
+		Signed: stats.signed,
+		Height: stats.height,
+	}
+	if stats.signed > 0 {
+		summary.AvgLatency = stats.totalLatency / time.Duration(stats.signed)
+	}
+	stats.signed = 0
+	stats.totalLatency = 0
+	return summary
+}
+
+// SignSummary periodically logs the signing stats, the connected nodes and the reachable cosigners
+// A heartbeat for operators without a metrics stack.
+type SignSummary struct {
+	service.BaseService
+
+	interval time.Duration
+	stats    *SignStats
+	nodes    []*ReconnRemoteSigner
+
+	// nil in single mode
+	validator      *ThresholdValidator
+	totalCosigners int
+
+	quit chan struct{}
+}
+
+// NewSignSummary returns a SignSummary logging every interval once started
+// validator may be nil when not signing with cosigners.
+func NewSignSummary(
+	interval time.Duration,
+	stats *SignStats,
+	nodes []*ReconnRemoteSigner,
+	validator *ThresholdValidator,
+	totalCosigners int,
+	logger log.Logger,
+) *SignSummary {
+	summary := &SignSummary{
+		interval:       interval,
+		stats:          stats,
+		nodes:          nodes,
+		validator:      validator,
+		totalCosigners: totalCosigners,
+	}
+	summary.BaseService = *service.NewBaseService(logger, "SignSummary", summary)
+	return summary
+}
+
+// OnStart starts logging
+func (summary *SignSummary) OnStart() error {
+	summary.quit = make(chan struct{})
+	go summary.loop()
+	return nil
+}
+
+// OnStop stops logging
+func (summary *SignSummary) OnStop() {
+	close(summary.quit)
+}
+
+func (summary *SignSummary) loop() {
+	ticker := time.NewTicker(summary.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-summary.quit:
+			return
+		case <-ticker.C:
+			summary.log()
+		}
+	}
+}
+
+func (summary *SignSummary) log() {
+	stats := summary.stats.Reset()
+
+	connected := 0
+	for _, node := range summary.nodes {
+		if node.IsConnected() {
+			connected++
+		}
+	}
+
+	keyvals := []interface{}{
+		"signed", stats.Signed,
+		"height", stats.Height,
+		"nodes_connected", fmt.Sprintf("%d/%d", connected, len(summary.nodes)),
+	}
+	if summary.validator != nil {
+		reachable := "none yet"
+		if count := summary.validator.ReachableCosigners(); count != noBlockYet {
+			reachable = fmt.Sprintf("%d/%d", count, summary.totalCosigners)
+		}
+		keyvals = append(keyvals, "cosigners_reachable", reachable)
+	}
+	keyvals = append(keyvals, "avg_sign_latency", stats.AvgLatency)
+
+	summary.Logger.Info("Signing summary", keyvals...)
+}
@@ -0,0 +1,55 @@
+package signer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func TestPvGuardSignStats(test *testing.T) {
+	stats := &SignStats{}
+	guard := &PvGuard{PrivValidator: tm.NewMockPV(), Stats: stats}
+
+	require.NoError(test, guard.SignVote("chain-id", &tmProto.Vote{Type: tmProto.PrevoteType, Height: 5}))
+	require.NoError(test, guard.SignProposal("chain-id", &tmProto.Proposal{Type: tmProto.ProposalType, Height: 6}))
+
+	// refused signatures are not counted
+	guard.SetActive(false)
+	require.Error(test, guard.SignVote("chain-id", &tmProto.Vote{Type: tmProto.PrevoteType, Height: 7}))
+
+	summary := stats.Reset()
+	require.Equal(test, int64(2), summary.Signed)
+	require.Equal(test, int64(6), summary.Height)
+
+	// the height is kept across resets
+	summary = stats.Reset()
+	require.Equal(test, int64(0), summary.Signed)
+	require.Equal(test, int64(6), summary.Height)
+	require.Zero(test, summary.AvgLatency)
+}
+
+func TestSignSummaryLog(test *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewTMLogger(log.NewSyncWriter(&buf))
+
+	stats := &SignStats{}
+	stats.record(10, 20*time.Millisecond)
+	stats.record(11, 40*time.Millisecond)
+
+	nodes := []*ReconnRemoteSigner{newTestRemoteSigner()}
+	summary := NewSignSummary(time.Minute, stats, nodes, nil, 0, logger)
+	summary.log()
+
+	out := buf.String()
+	require.Contains(test, out, "Signing summary")
+	require.Contains(test, out, "signed=2")
+	require.Contains(test, out, "height=11")
+	require.Contains(test, out, "nodes_connected=0/1")
+	require.Contains(test, out, "avg_sign_latency=30ms")
+	require.NotContains(test, out, "cosigners_reachable")
+}
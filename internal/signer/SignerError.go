@@ -0,0 +1,88 @@
+package signer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Codes of the RemoteSignerError returned to the node, so tooling can tell failures that are
+// safe to retry from those that are not
+const (
+	// ErrorCodeUnknown is any failure not classified below
+	ErrorCodeUnknown int32 = 0
+
+	// ErrorCodeInvalidRequest is a malformed request, retrying it fails again
+	ErrorCodeInvalidRequest int32 = 1
+
+	// ErrorCodeChainMismatch is a request for a different chain id than configured, do not retry
+	ErrorCodeChainMismatch int32 = 2
+
+	// ErrorCodeDoubleSign is a request at or below the watermark, or conflicting with what was
+	// signed at the same height, round and step. Signing it could be a double sign, do not retry
+	ErrorCodeDoubleSign int32 = 3
+
+	// ErrorCodeUnavailable is a transient failure to reach enough cosigners, safe to retry
+	ErrorCodeUnavailable int32 = 4
+
+	// ErrorCodeRefused is signing refused by policy, i.e. standby, the rate limit or a disabled step, safe to retry later
+	ErrorCodeRefused int32 = 5
+)
+
+// SignerError is an error with one of the ErrorCode values
+type SignerError struct {
+	Code int32
+	Err  error
+}
+
+func (err *SignerError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *SignerError) Unwrap() error {
+	return err.Err
+}
+
+// ErrEquivocation is wrapped by the error for a request at an already signed height, round and step
+// whose data conflicts with what was signed, e.g. a different BlockID. Either the network forked or
+// the node is compromised, see IsEquivocation.
+var ErrEquivocation = errors.New("conflicting data")
+
+// newEquivocationError returns the ErrEquivocation for the HRS
+func newEquivocationError(height int64, round int64, step int8) error {
+	return newSignerError(ErrorCodeDoubleSign, fmt.Errorf("%w at height %d, round %d, step %d", ErrEquivocation, height, round, step))
+}
+
+// IsEquivocation returns true if err is an ErrEquivocation, or the equivalent error of a tendermint FilePV
+func IsEquivocation(err error) bool {
+	if err == nil {
+		return false
+	}
+	// the FilePV returns an unexported fmt.Errorf("conflicting data"), wrapped with %v
+	return errors.Is(err, ErrEquivocation) || strings.HasSuffix(err.Error(), ErrEquivocation.Error())
+}
+
+// newSignerError returns err with the code
+func newSignerError(code int32, err error) error {
+	return &SignerError{Code: code, Err: err}
+}
+
+// ErrorCode returns the code to report err to the node with
+func ErrorCode(err error) int32 {
+	var signerErr *SignerError
+	switch {
+	case errors.As(err, &signerErr):
+		return signerErr.Code
+	case IsEquivocation(err):
+		return ErrorCodeDoubleSign
+	case errors.Is(err, ErrQuorumLost), errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return ErrorCodeUnavailable
+	case errors.Is(err, ErrBelowHandoff):
+		return ErrorCodeDoubleSign
+	case errors.Is(err, ErrStandby), errors.Is(err, ErrRateLimited), errors.Is(err, ErrStepDisabled):
+		return ErrorCodeRefused
+	default:
+		return ErrorCodeUnknown
+	}
+}
@@ -0,0 +1,135 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/dogstatsd"
+	"github.com/go-kit/kit/metrics/statsd"
+	"github.com/tendermint/tendermint/libs/log"
+	"github.com/tendermint/tendermint/libs/service"
+)
+
+const (
+	// StatsdProtocolDogStatsD sends the labels of the metrics as DogStatsD tags, the default
+	StatsdProtocolDogStatsD = "dogstatsd"
+
+	// StatsdProtocolStatsD sends plain StatsD, without the labels
+	StatsdProtocolStatsD = "statsd"
+)
+
+// interval of the pushes to the statsd server
+const statsdFlushInterval = 10 * time.Second
+
+// StatsdExporter pushes the metrics to a StatsD or DogStatsD server over udp
+type StatsdExporter struct {
+	service.BaseService
+
+	address  string
+	interval time.Duration
+	metrics  *Metrics
+	sendLoop func(ctx context.Context, c <-chan time.Time, network string, address string)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewStatsdExporter returns a StatsdExporter pushing to the host:port address with protocol, "dogstatsd" or "statsd",
+// once started. The metrics are prefixed with the MetricsNamespace, e.g. signer.cosigner_up.
+func NewStatsdExporter(address string, protocol string, logger log.Logger) (*StatsdExporter, error) {
+	address = strings.TrimPrefix(address, "udp://")
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("statsd_address must be host:port: %w", err)
+	}
+
+	exporter := &StatsdExporter{address: address, interval: statsdFlushInterval}
+	exporter.BaseService = *service.NewBaseService(logger, "StatsdExporter", exporter)
+
+	prefix := MetricsNamespace + "."
+	errorLogger := statsdLogger{logger}
+	switch protocol {
+	case "", StatsdProtocolDogStatsD:
+		client := dogstatsd.New(prefix, errorLogger)
+		exporter.metrics = statsdMetrics(dogstatsdProvider{client})
+		exporter.sendLoop = client.SendLoop
+	case StatsdProtocolStatsD:
+		client := statsd.New(prefix, errorLogger)
+		exporter.metrics = statsdMetrics(plainStatsdProvider{client})
+		exporter.sendLoop = client.SendLoop
+	default:
+		return nil, fmt.Errorf("statsd_protocol must be %q or %q, got %q", StatsdProtocolDogStatsD, StatsdProtocolStatsD, protocol)
+	}
+	return exporter, nil
+}
+
+// Metrics returns the metrics pushed by the exporter
+func (exporter *StatsdExporter) Metrics() *Metrics {
+	return exporter.metrics
+}
+
+// OnStart starts pushing the metrics every 10 seconds
+func (exporter *StatsdExporter) OnStart() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	exporter.cancel = cancel
+	exporter.done = make(chan struct{})
+
+	ticker := time.NewTicker(exporter.interval)
+	go func() {
+		defer close(exporter.done)
+		defer ticker.Stop()
+		exporter.sendLoop(ctx, ticker.C, "udp", exporter.address)
+	}()
+	return nil
+}
+
+// OnStop stops pushing the metrics
+func (exporter *StatsdExporter) OnStop() {
+	exporter.cancel()
+	<-exporter.done
+}
+
+type dogstatsdProvider struct {
+	client *dogstatsd.Dogstatsd
+}
+
+func (p dogstatsdProvider) NewCounter(name string) metrics.Counter {
+	return p.client.NewCounter(name, 1)
+}
+
+func (p dogstatsdProvider) NewGauge(name string) metrics.Gauge {
+	return p.client.NewGauge(name)
+}
+
+func (p dogstatsdProvider) NewTiming(name string) metrics.Histogram {
+	return p.client.NewTiming(name, 1)
+}
+
+type plainStatsdProvider struct {
+	client *statsd.Statsd
+}
+
+func (p plainStatsdProvider) NewCounter(name string) metrics.Counter {
+	return p.client.NewCounter(name, 1)
+}
+
+func (p plainStatsdProvider) NewGauge(name string) metrics.Gauge {
+	return p.client.NewGauge(name)
+}
+
+func (p plainStatsdProvider) NewTiming(name string) metrics.Histogram {
+	return p.client.NewTiming(name, 1)
+}
+
+// statsdLogger logs the errors of the statsd clients, which log through a go-kit logger
+type statsdLogger struct {
+	logger log.Logger
+}
+
+func (statsdLogger statsdLogger) Log(keyvals ...interface{}) error {
+	statsdLogger.logger.Error("StatsD", keyvals...)
+	return nil
+}
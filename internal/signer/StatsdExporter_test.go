@@ -0,0 +1,48 @@
+package signer
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+)
+
+func TestStatsdExporter(test *testing.T) {
+	logger := log.NewTMLogger(log.NewSyncWriter(os.Stdout))
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(test, err)
+	defer server.Close()
+
+	exporter, err := NewStatsdExporter("udp://"+server.LocalAddr().String(), "", logger)
+	require.NoError(test, err)
+	exporter.interval = 10 * time.Millisecond
+
+	// reported to prometheus and statsd alike
+	metrics := MultiMetrics(NopMetrics(), exporter.Metrics())
+	metrics.NodeReconnects.With("node", "tcp://10.0.0.1:1234").Add(2)
+	metrics.SignStateSaveDuration.With("state", "share").Observe(0.25)
+
+	require.NoError(test, exporter.Start())
+	defer exporter.Stop()
+
+	var received string
+	buf := make([]byte, 4096)
+	require.NoError(test, server.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for !strings.Contains(received, "sign_state_save_ms") || !strings.Contains(received, "node_reconnects_total") {
+		n, _, err := server.ReadFrom(buf)
+		require.NoError(test, err)
+		received += string(buf[:n])
+	}
+	require.Contains(test, received, "signer.node_reconnects_total:2.000000|c|#node:tcp://10.0.0.1:1234")
+	require.Contains(test, received, "signer.sign_state_save_ms:250.000000|ms|#state:share")
+
+	_, err = NewStatsdExporter("127.0.0.1:8125", "graphite", logger)
+	require.Error(test, err)
+	_, err = NewStatsdExporter("127.0.0.1", "", logger)
+	require.Error(test, err)
+}
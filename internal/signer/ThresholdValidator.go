@@ -5,67 +5,210 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/libs/log"
 	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
 	tm "github.com/tendermint/tendermint/types"
 	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ThresholdValidator struct {
 	threshold int
 
+	// cosigners, ourselves included, that must return a share for a proposal, at least threshold
+	proposalThreshold int
+
 	pubkey crypto.PubKey
 
+	// the key signing from switchHeight on, if the chain switches validator keys
+	nextPubkey   crypto.PubKey
+	switchHeight int64
+
 	// stores the last sign state for a block we have fully signed
 	// Cached to respond to SignVote requests if we already have a signature
 	lastSignState SignState
 
-	// our own cosigner
+	// the most recent fully signed blocks, newest last
+	// A node that timed out may retry a request after the watermark moved on, e.g. re-request
+	// a proposal after we signed the prevote. Kept in memory only.
+	recentSignStates []SignState
+
+	// our own cosigner, nil for a coordinator holding no share
 	cosigner Cosigner
 
 	// peer cosigners
 	peers []Cosigner
+
+	// fails sign requests fast while fewer than threshold cosigners are reachable
+	breaker quorumBreaker
+
+	// cosigners, ourselves included, that returned a share for the last block, read atomically
+	// noBlockYet before the first block
+	reachable int32
+
+	// optional, records every signature produced
+	auditLog *AuditLog
+
+	// optional, bounds the threshold signing of a block
+	signDeadline time.Duration
+
+	metrics *Metrics
+	logger  log.Logger
 }
 
+// ErrSignDeadline is returned when the cosigners did not sign a block within the sign deadline
+var ErrSignDeadline = errors.New("signer busy, threshold signing exceeded the sign deadline")
+
+// noBlockYet is the number of reachable cosigners reported before the first block,
+// 0 reachable cosigners means that none returned a share
+const noBlockYet = -1
+
+// ReachableCosigners returns the number of cosigners, ourselves included if we hold a share,
+// that returned a share for the last block, -1 before the first block
+func (pv *ThresholdValidator) ReachableCosigners() int {
+	return int(atomic.LoadInt32(&pv.reachable))
+}
+
+// number of signed blocks kept to answer retried requests
+const maxRecentSignStates = 10
+
 type ThresholdValidatorOpt struct {
 	Pubkey    crypto.PubKey
 	Threshold int
 	SignState SignState
-	Cosigner  Cosigner
 	Peers     []Cosigner
+
+	// our own cosigner, or nil to only coordinate the peers, with threshold of them signing
+	Cosigner Cosigner
+
+	// optional, defaults to NopMetrics
+	Metrics *Metrics
+
+	// optional, defaults to a logger discarding everything
+	Logger log.Logger
+
+	// optional, defaults to DefaultQuorumProbeInterval
+	QuorumProbeInterval time.Duration
+
+	// optional, records every signature produced
+	AuditLog *AuditLog
+
+	// optional, abandons the threshold signing of a block after this long, 0 to wait on the cosigners
+	SignDeadline time.Duration
+
+	// optional, the number of cosigners, ourselves included, that must return a share to sign a proposal.
+	// Defaults to Threshold, votes always need Threshold. Any threshold sized group of the shares still
+	// makes the signature, so this can only be stricter than Threshold.
+	ProposalThreshold int
+
+	// optional, the validator key signing from SwitchHeight on, for a chain switching validator keys.
+	// The cosigners must sign with the shares of this key from the same height.
+	NextPubkey   crypto.PubKey
+	SwitchHeight int64
 }
 
 // NewThresholdValidator creates and returns a new ThresholdValidator
 func NewThresholdValidator(opt *ThresholdValidatorOpt) *ThresholdValidator {
-	validator := &ThresholdValidator{}
+	validator := &ThresholdValidator{reachable: noBlockYet}
 	validator.cosigner = opt.Cosigner
 	validator.peers = opt.Peers
 	validator.threshold = opt.Threshold
+	validator.proposalThreshold = opt.ProposalThreshold
+	if validator.proposalThreshold < opt.Threshold {
+		validator.proposalThreshold = opt.Threshold
+	}
 	validator.pubkey = opt.Pubkey
+	if opt.NextPubkey != nil && opt.SwitchHeight > 0 {
+		validator.nextPubkey = opt.NextPubkey
+		validator.switchHeight = opt.SwitchHeight
+	}
 	validator.lastSignState = opt.SignState
+	validator.auditLog = opt.AuditLog
+	validator.signDeadline = opt.SignDeadline
+
+	metrics := opt.Metrics
+	if metrics == nil {
+		metrics = NopMetrics()
+	}
+	validator.metrics = metrics
+	logger := opt.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	validator.logger = logger
+	validator.breaker = quorumBreaker{
+		threshold:     opt.Threshold,
+		probeInterval: opt.QuorumProbeInterval,
+		gauge:         metrics.QuorumBreakerOpen,
+		logger:        logger,
+	}
+	if validator.breaker.probeInterval == 0 {
+		validator.breaker.probeInterval = DefaultQuorumProbeInterval
+	}
 	return validator
 }
 
+// FlushSignState writes the sign state of the last block signed to its store again
+// Must not be called while signing, e.g. only through a PvGuard.
+func (pv *ThresholdValidator) FlushSignState() error {
+	if err := pv.lastSignState.Save(); err != nil {
+		return err
+	}
+	return pv.lastSignState.Flush()
+}
+
 // GetPubKey returns the public key of the validator.
 // Implements PrivValidator.
+// For a chain switching validator keys, this is the key of the height after the last block signed.
 func (pv *ThresholdValidator) GetPubKey() (crypto.PubKey, error) {
-	return pv.pubkey, nil
+	return pv.pubkeyFor(pv.lastSignState.Height + 1), nil
+}
+
+// pubkeyFor returns the validator public key signing height
+func (pv *ThresholdValidator) pubkeyFor(height int64) crypto.PubKey {
+	if pv.nextPubkey != nil && height >= pv.switchHeight {
+		return pv.nextPubkey
+	}
+	return pv.pubkey
 }
 
 // SignVote signs a canonical representation of the vote, along with the
 // chainID. Implements PrivValidator.
 func (pv *ThresholdValidator) SignVote(chainID string, vote *tmProto.Vote) error {
+	return pv.SignVoteContext(context.Background(), chainID, vote)
+}
+
+// SignVoteContext is SignVote, abandoning the threshold signing round once ctx is done.
+// Implements ContextPrivValidator.
+func (pv *ThresholdValidator) SignVoteContext(ctx context.Context, chainID string, vote *tmProto.Vote) error {
+	if !IsVoteType(vote.Type) {
+		return fmt.Errorf("unknown vote type %d", vote.Type)
+	}
+
+	ctx, span := tracer.Start(ctx, "SignVote", trace.WithAttributes(
+		attribute.Int64("height", vote.Height),
+		attribute.Int64("round", int64(vote.Round)),
+		attribute.String("type", vote.Type.String()),
+	))
+
 	block := &block{
 		Height:    vote.Height,
 		Round:     int64(vote.Round),
 		Step:      VoteToStep(vote),
 		Timestamp: vote.Timestamp,
 		SignBytes: tm.VoteSignBytes(chainID, vote),
+		BlockID:   vote.BlockID.Hash,
 	}
-	sig, stamp, err := pv.signBlock(chainID, block)
+	sig, stamp, err := pv.signBlock(ctx, chainID, block)
+	endSpan(span, err)
 
 	vote.Signature = sig
 	vote.Timestamp = stamp
@@ -76,14 +219,27 @@ func (pv *ThresholdValidator) SignVote(chainID string, vote *tmProto.Vote) error
 // SignProposal signs a canonical representation of the proposal, along with
 // the chainID. Implements PrivValidator.
 func (pv *ThresholdValidator) SignProposal(chainID string, proposal *tmProto.Proposal) error {
+	return pv.SignProposalContext(context.Background(), chainID, proposal)
+}
+
+// SignProposalContext is SignProposal, abandoning the threshold signing round once ctx is done.
+// Implements ContextPrivValidator.
+func (pv *ThresholdValidator) SignProposalContext(ctx context.Context, chainID string, proposal *tmProto.Proposal) error {
+	ctx, span := tracer.Start(ctx, "SignProposal", trace.WithAttributes(
+		attribute.Int64("height", proposal.Height),
+		attribute.Int64("round", int64(proposal.Round)),
+	))
+
 	block := &block{
 		Height:    proposal.Height,
 		Round:     int64(proposal.Round),
 		Step:      ProposalToStep(proposal),
 		Timestamp: proposal.Timestamp,
 		SignBytes: tm.ProposalSignBytes(chainID, proposal),
+		BlockID:   proposal.BlockID.Hash,
 	}
-	sig, stamp, err := pv.signBlock(chainID, block)
+	sig, stamp, err := pv.signBlock(ctx, chainID, block)
+	endSpan(span, err)
 
 	proposal.Signature = sig
 	proposal.Timestamp = stamp
@@ -97,17 +253,32 @@ type block struct {
 	Step      int8
 	SignBytes []byte
 	Timestamp time.Time
+
+	// for the audit log only
+	BlockID []byte
 }
 
-func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, time.Time, error) {
+func (pv *ThresholdValidator) signBlock(ctx context.Context, chainID string, block *block) ([]byte, time.Time, error) {
 	height, round, step, stamp := block.Height, block.Round, block.Step, block.Timestamp
 
+	if err := ctx.Err(); err != nil {
+		return nil, stamp, err
+	}
+
 	// the block sign state for caching full block signatures
 	lss := pv.lastSignState
 
 	// check watermark
 	sameHRS, err := lss.CheckHRS(height, int64(round), step)
 	if err != nil {
+		// the node may be retrying a request we signed before the watermark moved on
+		signature, timestamp, found, conflicting := pv.recentSignature(block)
+		if found {
+			return signature, timestamp, nil
+		}
+		if conflicting {
+			return nil, stamp, newEquivocationError(height, round, step)
+		}
 		return nil, stamp, err
 	}
 
@@ -120,31 +291,75 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 			return lss.Signature, timestamp, nil
 		}
 
-		return nil, stamp, errors.New("conflicting data")
+		// same HRS but the sign bytes differ by more than the timestamp (e.g. a different BlockID or POLRound)
+		// signing this would be a double sign
+		return nil, stamp, newEquivocationError(height, round, step)
+	}
+
+	if err := pv.breaker.allow(time.Now()); err != nil {
+		return nil, stamp, err
+	}
+
+	signCtx := ctx
+	if pv.signDeadline > 0 {
+		var cancel context.CancelFunc
+		signCtx, cancel = context.WithTimeout(ctx, pv.signDeadline)
+		defer cancel()
+	}
+
+	signature, err := pv.thresholdSign(signCtx, chainID, block)
+	if err != nil && signCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		// the node can retry, our share was not signed and the watermark did not move
+		return nil, stamp, newSignerError(ErrorCodeUnavailable, fmt.Errorf("%w of %v", ErrSignDeadline, pv.signDeadline))
+	}
+	if err != nil {
+		return nil, stamp, err
+	}
+	return signature, stamp, nil
+}
+
+// thresholdSign collects the share signatures of the cosigners for the block and combines them
+// Once ctx is done, it returns before our own share signs.
+func (pv *ThresholdValidator) thresholdSign(ctx context.Context, chainID string, block *block) ([]byte, error) {
+	height, round, step := block.Height, block.Round, block.Step
+	signBytes := block.SignBytes
+
+	if pv.cosigner == nil {
+		return pv.coordinateBlock(ctx, chainID, block)
 	}
 
 	total := uint8(len(pv.peers) + 1)
 
-	// destination for share signatures
+	// destination for share signatures and the ephemeral public keys they were made with
 	shareSignatures := make([][]byte, total)
+	ephemeralPublics := make([][]byte, total)
 
 	// share sigs is updated by goroutines
 	shareSignaturesMutex := sync.Mutex{}
 
+	// peers that could not be reached or did not answer in time, as opposed to those refusing to sign.
+	// Updated by the goroutines under shareSignaturesMutex.
+	unreachable := 0
+	markUnreachable := func() {
+		shareSignaturesMutex.Lock()
+		defer shareSignaturesMutex.Unlock()
+		unreachable++
+	}
+
 	wg := sync.WaitGroup{}
 	wg.Add(len(pv.peers))
 
 	ourID := pv.cosigner.GetID()
 
 	// have our cosigner generate ephemeral info at the current height
-	_, err = pv.cosigner.GetEphemeralSecretPart(CosignerGetEphemeralSecretPartRequest{
+	_, err := pv.cosigner.GetEphemeralSecretPart(ctx, CosignerGetEphemeralSecretPartRequest{
 		ID:     ourID,
 		Height: height,
 		Round:  round,
 		Step:   step,
 	})
 	if err != nil {
-		return nil, stamp, err
+		return nil, err
 	}
 
 	// There are two layers of goroutines for each cosigner.
@@ -157,13 +372,32 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 			peerId := peer.GetID()
 			peerIdx := peerId - 1
 
-			// cosigner.Sign makes a blocking RPC request (with no timeout)
+			// cosigner.Sign makes a blocking RPC request
 			// to prevent it from hanging our process indefinitely, we use a timeout context
-			// and another goroutine
-			signCtx, signCtxCancel := context.WithTimeout(context.Background(), 4*time.Second)
+			// and another goroutine. The timeout context is canceled along with ctx.
+			signCtx, signCtxCancel := context.WithTimeout(ctx, 4*time.Second)
+
+			// gives up on the peer, which only counts as unreachable for a transport error
+			fail := func(err error) {
+				if isTransportError(err) {
+					markUnreachable()
+				}
+				signCtxCancel()
+			}
 
 			go func() {
-				hasResp, err := pv.cosigner.HasEphemeralSecretPart(CosignerHasEphemeralSecretPartRequest{
+				// a child span for each cosigner, ended once it signed, failed or timed out
+				spanCtx, span := tracer.Start(signCtx, "Cosigner", trace.WithAttributes(attribute.Int("cosigner", peerId)))
+				var err error
+				signed := false
+				defer func() {
+					if err == nil && !signed {
+						err = signCtx.Err()
+					}
+					endSpan(span, err)
+				}()
+
+				hasResp, err := pv.cosigner.HasEphemeralSecretPart(spanCtx, CosignerHasEphemeralSecretPartRequest{
 					ID:     peerId,
 					Height: height,
 					Round:  round,
@@ -178,14 +412,15 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 				}
 
 				if err != nil {
-					fmt.Printf("ERROR HasEphemeralSecretPart: %s\n", err)
-					signCtxCancel()
+					pv.logger.Error("HasEphemeralSecretPart", "request", requestID(ctx), "cosigner", peerId, "err", err)
+					fail(err)
 					return
 				}
 
 				if !hasResp.Exists {
 					// if we don't already have an ephemeral secret part for the HRS, we need to get one
-					ephSecretResp, err := peer.GetEphemeralSecretPart(CosignerGetEphemeralSecretPartRequest{
+					var ephSecretResp CosignerGetEphemeralSecretPartResponse
+					ephSecretResp, err = peer.GetEphemeralSecretPart(spanCtx, CosignerGetEphemeralSecretPartRequest{
 						ID:     ourID,
 						Height: height,
 						Round:  round,
@@ -193,7 +428,7 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 					})
 
 					if err != nil {
-						fmt.Printf("ERROR GetEphemeralSecretPart %s\n", err)
+						pv.logger.Error("GetEphemeralSecretPart", "request", requestID(ctx), "cosigner", peerId, "err", err)
 					}
 
 					// did we timeout or finish elsewhere?
@@ -204,12 +439,12 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 					}
 
 					if err != nil {
-						signCtxCancel()
+						fail(err)
 						return
 					}
 
 					// set the response for ourselves
-					err = pv.cosigner.SetEphemeralSecretPart(CosignerSetEphemeralSecretPartRequest{
+					err = pv.cosigner.SetEphemeralSecretPart(spanCtx, CosignerSetEphemeralSecretPartRequest{
 						SourceSig:                      ephSecretResp.SourceSig,
 						SourceID:                       ephSecretResp.SourceID,
 						SourceEphemeralSecretPublicKey: ephSecretResp.SourceEphemeralSecretPublicKey,
@@ -220,7 +455,8 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 					})
 
 					if err != nil {
-						fmt.Printf("ERROR SetEphemeralSecretPart %s\n", err)
+						pv.logger.Error("SetEphemeralSecretPart", "request", requestID(ctx), "cosigner", peerId, "err", err)
+						pv.metrics.CosignerInvalidParts.With("cosigner", strconv.Itoa(peerId)).Add(1)
 					}
 
 					// did we timeout or finish elsewhere?
@@ -231,18 +467,18 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 					}
 
 					if err != nil {
-						signCtxCancel()
+						fail(err)
 						return
 					}
 				}
 
 				// ask the cosigner to sign with their share
-				sigResp, err := peer.Sign(CosignerSignRequest{
+				sigResp, err := peer.Sign(spanCtx, CosignerSignRequest{
 					SignBytes: signBytes,
 				})
 
 				if err != nil {
-					fmt.Printf("ERROR Sign %s\n", err)
+					pv.logger.Error("Sign", "request", requestID(ctx), "cosigner", peerId, "err", err)
 				}
 
 				// did we timeout or finish elsewhere?
@@ -253,7 +489,7 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 				}
 
 				if err != nil {
-					signCtxCancel()
+					fail(err)
 					return
 				}
 
@@ -271,12 +507,17 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 
 				shareSignatures[peerIdx] = make([]byte, len(sigResp.Signature))
 				copy(shareSignatures[peerIdx], sigResp.Signature)
+				ephemeralPublics[peerIdx] = sigResp.EphemeralPublic
+				signed = true
 			}()
 
 			// the sign context finished or timed out
 			select {
 			case <-signCtx.Done():
 			}
+			if signCtx.Err() == context.DeadlineExceeded {
+				markUnreachable()
+			}
 
 			wg.Done()
 		}
@@ -291,53 +532,334 @@ func (pv *ThresholdValidator) signBlock(chainID string, block *block) ([]byte, t
 	shareSignaturesMutex.Lock()
 	defer shareSignaturesMutex.Unlock()
 
+	// the request was abandoned while waiting on the cosigners
+	// return before our share advances its watermark
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// ourselves and every peer that returned a share signature
+	reachable := 1
+	for idx, shareSig := range shareSignatures {
+		if idx != ourID-1 && len(shareSig) > 0 {
+			reachable++
+		}
+	}
+	pv.breaker.record(int(total)-unreachable, time.Now())
+	atomic.StoreInt32(&pv.reachable, int32(reachable))
+	if err := pv.checkQuorum(step, reachable); err != nil {
+		return nil, err
+	}
+
 	// sign with our share now
-	signResp, err := pv.cosigner.Sign(CosignerSignRequest{
+	localCtx, localSpan := tracer.Start(ctx, "Cosigner", trace.WithAttributes(attribute.Int("cosigner", ourID)))
+	signResp, err := pv.cosigner.Sign(localCtx, CosignerSignRequest{
 		SignBytes: signBytes,
 	})
+	endSpan(localSpan, err)
 	if err != nil {
-		return nil, stamp, err
+		return nil, err
 	}
 
-	ephemeralPublic := signResp.EphemeralPublic
-
 	shareSignatures[ourID-1] = make([]byte, len(signResp.Signature))
 	copy(shareSignatures[ourID-1], signResp.Signature)
+	ephemeralPublics[ourID-1] = signResp.EphemeralPublic
 
-	// collect all valid responses into array of ids and signatures for the threshold lib
-	sigIds := make([]int, 0)
-	shareSigs := make([][]byte, 0)
+	// cosigners of earlier releases do not return the ephemeral public key they signed with, assume ours
+	for idx, shareSig := range shareSignatures {
+		if len(shareSig) > 0 && len(ephemeralPublics[idx]) == 0 {
+			ephemeralPublics[idx] = signResp.EphemeralPublic
+		}
+	}
+
+	return pv.completeBlock(ctx, chainID, block, total, ephemeralPublics, shareSignatures)
+}
+
+// completeBlock combines the share signatures, indexed by cosigner id - 1 along with the ephemeral public keys
+// they were made with and empty for cosigners that did not sign, and advances the watermark to the block
+func (pv *ThresholdValidator) completeBlock(
+	ctx context.Context,
+	chainID string,
+	block *block,
+	total uint8,
+	ephemeralPublics [][]byte,
+	shareSignatures [][]byte,
+) ([]byte, error) {
+	height, round, step, signBytes := block.Height, block.Round, block.Step, block.SignBytes
+
+	signature, sigIds, err := pv.combineShares(ctx, pv.pubkeyFor(height), total, signBytes, ephemeralPublics, shareSignatures)
+	if err != nil {
+		return nil, err
+	}
+
+	// report the cosigners that returned a share which did not fit in
+	for idx, shareSig := range shareSignatures {
+		if len(shareSig) == 0 || containsID(sigIds, idx+1) {
+			continue
+		}
+		pv.logger.Error("Share signature excluded, it did not combine into a valid signature",
+			"request", requestID(ctx), "cosigner", idx+1, "height", height, "round", round, "step", step)
+		pv.metrics.CosignerExcludedShares.With("cosigner", strconv.Itoa(idx+1)).Add(1)
+	}
+
+	// the signature is only kept once saved, a retry must not get an unsaved signature back
+	next := pv.lastSignState
+	next.Height = height
+	next.Round = round
+	next.Step = step
+	next.Signature = signature
+	next.SignBytes = signBytes
+
+	_, saveSpan := tracer.Start(ctx, "SignState.Save")
+	err = next.Save()
+	saveSpan.End()
+	if err != nil {
+		return nil, err
+	}
+	pv.lastSignState = next
+
+	pv.recentSignStates = append(pv.recentSignStates, pv.lastSignState)
+	if len(pv.recentSignStates) > maxRecentSignStates {
+		pv.recentSignStates = pv.recentSignStates[1:]
+	}
+
+	if pv.auditLog != nil {
+		// the signature is returned even if it could not be recorded, not signing would halt the validator
+		err := pv.auditLog.Write(AuditEntry{
+			Time:      time.Now().UTC(),
+			Event:     AuditEventSignature,
+			ChainID:   chainID,
+			Height:    height,
+			Round:     round,
+			Step:      stepName(step),
+			BlockID:   block.BlockID,
+			Cosigners: sigIds,
+			RequestID: requestID(ctx),
+		})
+		if err != nil {
+			pv.logger.Error("Audit log", "request", requestID(ctx), "err", err)
+		}
+	}
+
+	return signature, nil
+}
+
+// coordinateBlock signs the block without a share of our own, for a coordinator that only
+// orchestrates the remote cosigners. The cosigners exchange their ephemeral parts among themselves
+// when asked to sign, each share signature comes with the ephemeral public key it was made with.
+// Only shares made with the same ephemeral public key combine, the largest such group is used.
+func (pv *ThresholdValidator) coordinateBlock(ctx context.Context, chainID string, block *block) ([]byte, error) {
+	total := uint8(len(pv.peers))
+
+	type shareResponse struct {
+		id       int
+		response CosignerSignResponse
+
+		// the peer could not be reached or did not answer in time
+		unreachable bool
+	}
+	responses := make(chan shareResponse, len(pv.peers))
+
+	for _, peer := range pv.peers {
+		go func(peer Cosigner) {
+			signCtx, signCtxCancel := context.WithTimeout(ctx, 4*time.Second)
+			defer signCtxCancel()
+
+			spanCtx, span := tracer.Start(signCtx, "Cosigner", trace.WithAttributes(attribute.Int("cosigner", peer.GetID())))
+			sigResp, err := peer.Sign(spanCtx, CosignerSignRequest{SignBytes: block.SignBytes})
+			endSpan(span, err)
+			if err != nil {
+				pv.logger.Error("Sign", "request", requestID(ctx), "cosigner", peer.GetID(), "err", err)
+				sigResp = CosignerSignResponse{}
+			}
+			responses <- shareResponse{id: peer.GetID(), response: sigResp, unreachable: isTransportError(err)}
+		}(peer)
+	}
+
+	// share signatures and their ephemeral public keys indexed by cosigner id - 1
+	shareSignatures := make([][]byte, total)
+	ephemeralPublics := make([][]byte, total)
+	reachable := 0
+	unreachable := 0
+	for range pv.peers {
+		share := <-responses
+		if share.unreachable {
+			unreachable++
+		}
+		if len(share.response.Signature) == 0 {
+			continue
+		}
+		reachable++
+
+		shareSignatures[share.id-1] = share.response.Signature
+		ephemeralPublics[share.id-1] = share.response.EphemeralPublic
+	}
+	pv.breaker.record(int(total)-unreachable, time.Now())
+	atomic.StoreInt32(&pv.reachable, int32(reachable))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := pv.checkQuorum(block.Step, reachable); err != nil {
+		return nil, err
+	}
+
+	return pv.completeBlock(ctx, chainID, block, total, ephemeralPublics, shareSignatures)
+}
+
+// checkQuorum returns an error if fewer cosigners than the step requires returned a share
+func (pv *ThresholdValidator) checkQuorum(step int8, reachable int) error {
+	if step == stepPropose && reachable < pv.proposalThreshold {
+		return newSignerError(ErrorCodeUnavailable,
+			fmt.Errorf("%d cosigners returned a share for the proposal, %d required", reachable, pv.proposalThreshold))
+	}
+	return nil
+}
+
+// combineShares combines the share signatures, indexed by cosigner id - 1, into a signature of signBytes
+// that verifies with pubkey, and returns it with the ids of the cosigners whose shares were combined.
+//
+// Only shares made with the same ephemeral public key combine, the largest such group is tried first.
+// A cosigner that returned a bad share signature, or dealt a bad ephemeral part to some of the others,
+// makes the combination of the whole group invalid. The threshold sized subsets of the group are then
+// tried in turn, so that a valid signature is still assembled if enough of the shares are good.
+//
+// A share signature is not an ed25519 signature of its own and cannot be verified, or batch verified,
+// alone: the cosigners never reveal the public parts of their shares. The happy path verifies the one
+// combined signature, see BenchmarkCombineShares.
+func (pv *ThresholdValidator) combineShares(
+	ctx context.Context,
+	pubkey crypto.PubKey,
+	total uint8,
+	signBytes []byte,
+	ephemeralPublics [][]byte,
+	shareSignatures [][]byte,
+) ([]byte, []int, error) {
+	// the ids of the cosigners that signed with each ephemeral public key, in order of first appearance
+	groups := make([][]int, 0)
+	groupIdx := make(map[string]int)
 	for idx, shareSig := range shareSignatures {
 		if len(shareSig) == 0 {
 			continue
 		}
-		sigIds = append(sigIds, idx+1)
+		key := string(ephemeralPublics[idx])
+		if _, ok := groupIdx[key]; !ok {
+			groupIdx[key] = len(groups)
+			groups = append(groups, nil)
+		}
+		groups[groupIdx[key]] = append(groups[groupIdx[key]], idx+1)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i]) > len(groups[j])
+	})
+	if len(groups) > 1 {
+		pv.logger.Error("Cosigners signed with different ephemeral keys", "request", requestID(ctx), "keys", len(groups), "largest_group", len(groups[0]))
+	}
 
-		// we are ok to use the share signatures - complete boolean
-		// prevents future concurrent access
-		shareSigs = append(shareSigs, shareSig)
+	if len(groups) == 0 || len(groups[0]) < pv.threshold {
+		return nil, nil, newSignerError(ErrorCodeUnavailable, errors.New("Not enough co-signers"))
 	}
 
-	if len(sigIds) < pv.threshold {
-		return nil, stamp, errors.New("Not enough co-signers")
+	combine := func(sigIds []int) []byte {
+		shareSigs := make([][]byte, len(sigIds))
+		for idx, id := range sigIds {
+			shareSigs[idx] = shareSignatures[id-1]
+		}
+		combinedSig := tsed25519.CombineShares(total, sigIds, shareSigs)
+
+		ephemeralPublic := ephemeralPublics[sigIds[0]-1]
+		signature := append(append([]byte{}, ephemeralPublic...), combinedSig...)
+
+		// verify the combined signature before saving to watermark
+		if !pubkey.VerifySignature(signBytes, signature) {
+			return nil
+		}
+		return signature
 	}
 
-	// assemble into final signature
-	combinedSig := tsed25519.CombineShares(total, sigIds, shareSigs)
+	for _, group := range groups {
+		if len(group) < pv.threshold {
+			break
+		}
+		if signature := combine(group); signature != nil {
+			return signature, group, nil
+		}
+		if len(group) == pv.threshold {
+			continue
+		}
 
-	signature := append(ephemeralPublic, combinedSig...)
+		var signature []byte
+		var sigIds []int
+		forEachSubset(group, pv.threshold, func(subset []int) bool {
+			signature = combine(subset)
+			sigIds = subset
+			return signature != nil
+		})
+		if signature != nil {
+			return signature, sigIds, nil
+		}
+	}
 
-	// verify the combined signature before saving to watermark
-	if !pv.pubkey.VerifySignature(signBytes, signature) {
-		return nil, stamp, errors.New("Combined signature is not valid")
+	return nil, nil, errors.New("Combined signature is not valid")
+}
+
+// forEachSubset calls fn with every size sized subset of ids, in lexicographic order, until fn returns true
+func forEachSubset(ids []int, size int, fn func(subset []int) bool) {
+	picked := make([]int, size)
+	for idx := range picked {
+		picked[idx] = idx
 	}
 
-	pv.lastSignState.Height = height
-	pv.lastSignState.Round = round
-	pv.lastSignState.Step = step
-	pv.lastSignState.Signature = signature
-	pv.lastSignState.SignBytes = signBytes
-	pv.lastSignState.Save()
+	for {
+		subset := make([]int, size)
+		for idx, pick := range picked {
+			subset[idx] = ids[pick]
+		}
+		if fn(subset) {
+			return
+		}
 
-	return signature, stamp, nil
+		// advance to the next combination of positions
+		pos := size - 1
+		for pos >= 0 && picked[pos] == len(ids)-size+pos {
+			pos--
+		}
+		if pos < 0 {
+			return
+		}
+		picked[pos]++
+		for next := pos + 1; next < size; next++ {
+			picked[next] = picked[next-1] + 1
+		}
+	}
+}
+
+// containsID returns whether id is one of ids
+func containsID(ids []int, id int) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// recentSignature returns the signature of a recently signed block with the same HRS
+// if its sign bytes are the same as the block's, or differ only by timestamp.
+// A block with the same HRS but e.g. a different BlockID is never matched, it is reported as conflicting.
+func (pv *ThresholdValidator) recentSignature(block *block) (signature []byte, timestamp time.Time, found bool, conflicting bool) {
+	for _, recent := range pv.recentSignStates {
+		if recent.Height != block.Height || recent.Round != block.Round || recent.Step != block.Step {
+			continue
+		}
+
+		if bytes.Equal(block.SignBytes, recent.SignBytes) {
+			return recent.Signature, block.Timestamp, true, false
+		}
+		if timestamp, ok := recent.OnlyDifferByTimestamp(block.SignBytes); ok {
+			return recent.Signature, timestamp, true, false
+		}
+		return nil, block.Timestamp, false, true
+	}
+	return nil, block.Timestamp, false, false
 }
@@ -1,11 +1,16 @@
 package signer
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
-	"io/ioutil"
-	"os"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
@@ -46,11 +51,7 @@ func TestThresholdValidator2of2(test *testing.T) {
 		ID:       1,
 	}
 
-	stateFile1, err := ioutil.TempFile("", "state1.json")
-	require.NoError(test, err)
-	defer os.Remove(stateFile1.Name())
-
-	signState1, err := LoadOrCreateSignState(stateFile1.Name())
+	signState1, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "state1.json"))
 
 	key2 := CosignerKey{
 		PubKey:   privateKey.PubKey(),
@@ -58,10 +59,7 @@ func TestThresholdValidator2of2(test *testing.T) {
 		ID:       2,
 	}
 
-	stateFile2, err := ioutil.TempFile("", "state2.json")
-	require.NoError(test, err)
-	defer os.Remove(stateFile2.Name())
-	signState2, err := LoadOrCreateSignState(stateFile2.Name())
+	signState2, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "state2.json"))
 	require.NoError(test, err)
 
 	config1 := LocalCosignerConfig{
@@ -118,7 +116,7 @@ func TestThresholdValidator2of2(test *testing.T) {
 	//
 	// An enhancement could be to have Local cosigner logic directly interface their peers.
 	{
-		cosigner1EphSecretPart, err := cosigner1.GetEphemeralSecretPart(CosignerGetEphemeralSecretPartRequest{
+		cosigner1EphSecretPart, err := cosigner1.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
 			ID:     2,
 			Height: proposal.Height,
 			Round:  int64(proposal.Round),
@@ -126,7 +124,7 @@ func TestThresholdValidator2of2(test *testing.T) {
 		})
 		require.NoError(test, err)
 
-		cosigner2.SetEphemeralSecretPart(CosignerSetEphemeralSecretPartRequest{
+		cosigner2.SetEphemeralSecretPart(context.Background(), CosignerSetEphemeralSecretPartRequest{
 			SourceSig:                      cosigner1EphSecretPart.SourceSig,
 			SourceID:                       cosigner1EphSecretPart.SourceID,
 			SourceEphemeralSecretPublicKey: cosigner1EphSecretPart.SourceEphemeralSecretPublicKey,
@@ -143,3 +141,613 @@ func TestThresholdValidator2of2(test *testing.T) {
 	require.True(test, privateKey.PubKey().VerifySignature(signBytes, proposal.Signature))
 
 }
+
+// newThresholdValidator2of2 sets up a 2-of-2 threshold validator backed by local cosigners
+// It returns the validator, the peer cosigner, and the validator's private key
+func newThresholdValidator2of2(test *testing.T) (*ThresholdValidator, Cosigner, Cosigner, tmCryptoEd25519.PrivKey) {
+	total := uint8(2)
+	threshold := uint8(2)
+
+	bitSize := 4096
+	rsaKey1, err := rsa.GenerateKey(rand.Reader, bitSize)
+	require.NoError(test, err)
+
+	rsaKey2, err := rsa.GenerateKey(rand.Reader, bitSize)
+	require.NoError(test, err)
+
+	peers := []CosignerPeer{{
+		ID:        1,
+		PublicKey: rsaKey1.PublicKey,
+	}, {
+		ID:        2,
+		PublicKey: rsaKey2.PublicKey,
+	}}
+
+	privateKey := tmCryptoEd25519.GenPrivKey()
+
+	privKeyBytes := [64]byte{}
+	copy(privKeyBytes[:], privateKey[:])
+	secretShares := tsed25519.DealShares(tsed25519.ExpandSecret(privKeyBytes[:32]), threshold, total)
+
+	signState1, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "state1.json"))
+	require.NoError(test, err)
+
+	signState2, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "state2.json"))
+	require.NoError(test, err)
+
+	validatorSignState, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "validator_state.json"))
+	require.NoError(test, err)
+
+	cosigner1 := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ShareKey: secretShares[0], ID: 1},
+		SignState:   &signState1,
+		RsaKey:      *rsaKey1,
+		Peers:       peers,
+		Total:       total,
+		Threshold:   threshold,
+	})
+
+	cosigner2 := NewLocalCosigner(LocalCosignerConfig{
+		CosignerKey: CosignerKey{PubKey: privateKey.PubKey(), ShareKey: secretShares[1], ID: 2},
+		SignState:   &signState2,
+		RsaKey:      *rsaKey2,
+		Peers:       peers,
+		Total:       total,
+		Threshold:   threshold,
+	})
+
+	validator := NewThresholdValidator(&ThresholdValidatorOpt{
+		Pubkey:    privateKey.PubKey(),
+		Threshold: int(threshold),
+		SignState: validatorSignState,
+		Cosigner:  cosigner1,
+		Peers:     []Cosigner{cosigner2},
+	})
+
+	return validator, cosigner1, cosigner2, privateKey
+}
+
+// exchangeEphemeralPart hands the ephemeral secret part of source to dest for the HRS
+// During normal operation this is done over rpc by the destination cosigner
+func exchangeEphemeralPart(test *testing.T, source Cosigner, dest Cosigner, height int64, round int64, step int8) {
+	part, err := source.GetEphemeralSecretPart(context.Background(), CosignerGetEphemeralSecretPartRequest{
+		ID:     dest.GetID(),
+		Height: height,
+		Round:  round,
+		Step:   step,
+	})
+	require.NoError(test, err)
+
+	err = dest.SetEphemeralSecretPart(context.Background(), CosignerSetEphemeralSecretPartRequest{
+		SourceSig:                      part.SourceSig,
+		SourceID:                       part.SourceID,
+		SourceEphemeralSecretPublicKey: part.SourceEphemeralSecretPublicKey,
+		EncryptedSharePart:             part.EncryptedSharePart,
+		Height:                         height,
+		Round:                          round,
+		Step:                           step,
+	})
+	require.NoError(test, err)
+}
+
+func TestThresholdValidatorProposalOnlyDifferByTimestamp(test *testing.T) {
+	validator, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	proposal := tmProto.Proposal{
+		Type:      tmProto.ProposalType,
+		Height:    1,
+		Round:     0,
+		PolRound:  -1,
+		Timestamp: time.Unix(1000, 0).UTC(),
+	}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, proposal.Height, int64(proposal.Round), stepPropose)
+
+	err := validator.SignProposal("chain-id", &proposal)
+	require.NoError(test, err)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.ProposalSignBytes("chain-id", &proposal), proposal.Signature))
+
+	// the node re-requests the same proposal with a later timestamp
+	retry := proposal
+	retry.Signature = nil
+	retry.Timestamp = time.Unix(2000, 0).UTC()
+
+	err = validator.SignProposal("chain-id", &retry)
+	require.NoError(test, err)
+
+	// the cached signature and the original timestamp are returned
+	require.Equal(test, proposal.Signature, retry.Signature)
+	require.Equal(test, proposal.Timestamp, retry.Timestamp)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.ProposalSignBytes("chain-id", &retry), retry.Signature))
+}
+
+func TestThresholdValidatorProposalConflictingBlockID(test *testing.T) {
+	validator, cosigner1, cosigner2, _ := newThresholdValidator2of2(test)
+
+	proposal := tmProto.Proposal{
+		Type:     tmProto.ProposalType,
+		Height:   1,
+		Round:    0,
+		PolRound: -1,
+		BlockID: tmProto.BlockID{
+			Hash:          bytes.Repeat([]byte{1}, 32),
+			PartSetHeader: tmProto.PartSetHeader{Total: 1, Hash: bytes.Repeat([]byte{2}, 32)},
+		},
+	}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, proposal.Height, int64(proposal.Round), stepPropose)
+
+	err := validator.SignProposal("chain-id", &proposal)
+	require.NoError(test, err)
+
+	// same HRS with a different BlockID must be rejected
+	conflicting := proposal
+	conflicting.Signature = nil
+	conflicting.BlockID.Hash = bytes.Repeat([]byte{3}, 32)
+
+	err = validator.SignProposal("chain-id", &conflicting)
+	require.Error(test, err)
+	require.True(test, IsEquivocation(err))
+	require.Equal(test, ErrorCodeDoubleSign, ErrorCode(err))
+	require.Nil(test, conflicting.Signature)
+
+	// same HRS with a different POLRound must be rejected
+	conflicting = proposal
+	conflicting.Signature = nil
+	conflicting.PolRound = 0
+
+	err = validator.SignProposal("chain-id", &conflicting)
+	require.Error(test, err)
+	require.Nil(test, conflicting.Signature)
+}
+
+func TestThresholdValidatorSignCanceled(test *testing.T) {
+	validator, cosigner1, cosigner2, _ := newThresholdValidator2of2(test)
+
+	vote := tmProto.Vote{
+		Type:   tmProto.PrevoteType,
+		Height: 1,
+		Round:  0,
+	}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, vote.Height, int64(vote.Round), stepPrevote)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := validator.SignVoteContext(ctx, "chain-id", &vote)
+	require.Equal(test, context.Canceled, err)
+	require.Nil(test, vote.Signature)
+
+	// the abandoned request did not advance the watermark
+	err = validator.SignVote("chain-id", &vote)
+	require.NoError(test, err)
+	require.NotNil(test, vote.Signature)
+}
+
+func TestThresholdValidatorProposalRetryAfterWatermarkMoved(test *testing.T) {
+	validator, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	proposal := tmProto.Proposal{
+		Type:     tmProto.ProposalType,
+		Height:   1,
+		Round:    0,
+		PolRound: -1,
+		BlockID: tmProto.BlockID{
+			Hash:          bytes.Repeat([]byte{1}, 32),
+			PartSetHeader: tmProto.PartSetHeader{Total: 1, Hash: bytes.Repeat([]byte{2}, 32)},
+		},
+		Timestamp: time.Unix(1000, 0).UTC(),
+	}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, proposal.Height, int64(proposal.Round), stepPropose)
+
+	err := validator.SignProposal("chain-id", &proposal)
+	require.NoError(test, err)
+
+	// the watermark moves on to the prevote
+	vote := tmProto.Vote{
+		Type:    tmProto.PrevoteType,
+		Height:  1,
+		Round:   0,
+		BlockID: proposal.BlockID,
+	}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, vote.Height, int64(vote.Round), stepPrevote)
+
+	err = validator.SignVote("chain-id", &vote)
+	require.NoError(test, err)
+
+	// the node, having timed out, retries the proposal with a later timestamp
+	retry := proposal
+	retry.Signature = nil
+	retry.Timestamp = time.Unix(2000, 0).UTC()
+
+	err = validator.SignProposal("chain-id", &retry)
+	require.NoError(test, err)
+	require.Equal(test, proposal.Signature, retry.Signature)
+	require.Equal(test, proposal.Timestamp, retry.Timestamp)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.ProposalSignBytes("chain-id", &retry), retry.Signature))
+
+	// a retry for a different block is still a regression
+	conflicting := proposal
+	conflicting.Signature = nil
+	conflicting.BlockID.Hash = bytes.Repeat([]byte{3}, 32)
+
+	err = validator.SignProposal("chain-id", &conflicting)
+	require.Error(test, err)
+	require.Nil(test, conflicting.Signature)
+}
+
+// unreachableCosigner fails every request to the wrapped cosigner while down
+type unreachableCosigner struct {
+	Cosigner
+	down bool
+}
+
+// errUnreachable is the error of a cosigner refusing connections
+var errUnreachable = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func (cosigner *unreachableCosigner) Sign(ctx context.Context, req CosignerSignRequest) (CosignerSignResponse, error) {
+	if cosigner.down {
+		return CosignerSignResponse{}, errUnreachable
+	}
+	return cosigner.Cosigner.Sign(ctx, req)
+}
+
+func (cosigner *unreachableCosigner) GetEphemeralSecretPart(ctx context.Context, req CosignerGetEphemeralSecretPartRequest) (CosignerGetEphemeralSecretPartResponse, error) {
+	if cosigner.down {
+		return CosignerGetEphemeralSecretPartResponse{}, errUnreachable
+	}
+	return cosigner.Cosigner.GetEphemeralSecretPart(ctx, req)
+}
+
+func TestThresholdValidatorQuorumBreaker(test *testing.T) {
+	validator, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	peer := &unreachableCosigner{Cosigner: cosigner2, down: true}
+	validator.peers = []Cosigner{peer}
+	validator.breaker.probeInterval = 100 * time.Millisecond
+
+	vote := tmProto.Vote{
+		Type:   tmProto.PrevoteType,
+		Height: 1,
+		Round:  0,
+	}
+	err := validator.SignVote("chain-id", &vote)
+	require.Error(test, err)
+	require.True(test, validator.breaker.open)
+
+	// rejected without waiting on the cosigners
+	vote.Height = 2
+	err = validator.SignVote("chain-id", &vote)
+	require.Equal(test, ErrQuorumLost, err)
+
+	// the next request after the probe interval reaches the recovered cosigner
+	peer.down = false
+	time.Sleep(validator.breaker.probeInterval)
+	exchangeEphemeralPart(test, cosigner1, cosigner2, vote.Height, int64(vote.Round), stepPrevote)
+
+	err = validator.SignVote("chain-id", &vote)
+	require.NoError(test, err)
+	require.False(test, validator.breaker.open)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+}
+
+func TestThresholdValidatorRetryAfterFailedSave(test *testing.T) {
+	validator, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	store := &failingSignStateStore{}
+	signState, err := LoadOrCreateSignStateFrom(store)
+	require.NoError(test, err)
+	validator.lastSignState = signState
+	store.err = errors.New("disk full")
+
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, 1, 0, stepPrevote)
+	err = validator.SignVote("chain-id", &vote)
+	require.True(test, IsSignStateSaveError(err))
+	require.Nil(test, vote.Signature)
+	require.Equal(test, int64(0), validator.lastSignState.Height)
+
+	// the retry does not get the unsaved signature back, it is only handed out once saved
+	store.err = nil
+	require.NoError(test, validator.SignVote("chain-id", &vote))
+	require.Equal(test, int64(1), store.state.Height)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+
+	// the same for the share of a cosigner
+	local := cosigner1.(*LocalCosigner)
+	shareStore := &failingSignStateStore{err: errors.New("disk full")}
+	local.lastSignState.store = shareStore
+	vote = tmProto.Vote{Type: tmProto.PrevoteType, Height: 2}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, 2, 0, stepPrevote)
+	err = validator.SignVote("chain-id", &vote)
+	require.True(test, IsSignStateSaveError(err))
+	require.Equal(test, int64(1), local.SignStateWatermark().Height)
+
+	shareStore.err = nil
+	require.NoError(test, validator.SignVote("chain-id", &vote))
+	require.Equal(test, int64(2), shareStore.state.Height)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+}
+
+// refusingCosigner answers every sign request to the wrapped cosigner with an error
+type refusingCosigner struct {
+	Cosigner
+}
+
+func (cosigner *refusingCosigner) Sign(ctx context.Context, req CosignerSignRequest) (CosignerSignResponse, error) {
+	return CosignerSignResponse{}, errors.New("height regression")
+}
+
+func TestThresholdValidatorQuorumBreakerIgnoresRefusals(test *testing.T) {
+	validator, cosigner1, cosigner2, _ := newThresholdValidator2of2(test)
+
+	// the peer is reachable, it only refuses to sign
+	validator.peers = []Cosigner{&refusingCosigner{Cosigner: cosigner2}}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, 1, 0, stepPrevote)
+
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1}
+	require.Error(test, validator.SignVote("chain-id", &vote))
+	require.False(test, validator.breaker.open)
+}
+
+// slowCosigner delays every sign request to the wrapped cosigner until ctx is done or delay passed
+type slowCosigner struct {
+	Cosigner
+	delay time.Duration
+}
+
+func (cosigner *slowCosigner) Sign(ctx context.Context, req CosignerSignRequest) (CosignerSignResponse, error) {
+	select {
+	case <-time.After(cosigner.delay):
+	case <-ctx.Done():
+		return CosignerSignResponse{}, ctx.Err()
+	}
+	return cosigner.Cosigner.Sign(ctx, req)
+}
+
+func TestThresholdValidatorSignDeadline(test *testing.T) {
+	validator, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	validator.peers = []Cosigner{&slowCosigner{Cosigner: cosigner2, delay: time.Second}}
+	validator.signDeadline = 100 * time.Millisecond
+
+	vote := tmProto.Vote{
+		Type:   tmProto.PrevoteType,
+		Height: 1,
+		Round:  0,
+	}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, vote.Height, int64(vote.Round), stepPrevote)
+
+	start := time.Now()
+	err := validator.SignVote("chain-id", &vote)
+	require.True(test, errors.Is(err, ErrSignDeadline))
+	require.Equal(test, ErrorCodeUnavailable, ErrorCode(err))
+	require.Less(test, int64(time.Since(start)), int64(time.Second))
+	require.Nil(test, vote.Signature)
+
+	// neither our share nor the watermark moved, the retry signs once the peer is fast again
+	require.Equal(test, int64(0), validator.lastSignState.Height)
+	validator.peers = []Cosigner{cosigner2}
+	err = validator.SignVote("chain-id", &vote)
+	require.NoError(test, err)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+}
+
+// countingCosigner counts the sign requests to the wrapped cosigner
+type countingCosigner struct {
+	Cosigner
+	signs int32
+}
+
+func (cosigner *countingCosigner) Sign(ctx context.Context, req CosignerSignRequest) (CosignerSignResponse, error) {
+	atomic.AddInt32(&cosigner.signs, 1)
+	return cosigner.Cosigner.Sign(ctx, req)
+}
+
+func TestThresholdValidatorConcurrentIdenticalRequests(test *testing.T) {
+	validator, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	peer := &countingCosigner{Cosigner: cosigner2}
+	validator.peers = []Cosigner{peer}
+	guard := &PvGuard{PrivValidator: validator}
+
+	exchangeEphemeralPart(test, cosigner1, cosigner2, 1, 0, stepPrevote)
+
+	// two sentries request the same vote at once
+	votes := make([]tmProto.Vote, 2)
+	errs := make(chan error, len(votes))
+	for idx := range votes {
+		votes[idx] = tmProto.Vote{Type: tmProto.PrevoteType, Height: 1}
+		go func(vote *tmProto.Vote) {
+			errs <- guard.SignVote("chain-id", vote)
+		}(&votes[idx])
+	}
+	for range votes {
+		require.NoError(test, <-errs)
+	}
+
+	// the guard serializes the requests, the second is answered from the sign state of the first
+	// without another threshold round
+	require.Equal(test, int32(1), atomic.LoadInt32(&peer.signs))
+	require.Equal(test, votes[0].Signature, votes[1].Signature)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &votes[0]), votes[0].Signature))
+}
+
+func TestThresholdValidatorCoordinatorWithoutShare(test *testing.T) {
+	_, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	signState, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "coordinator_state.json"))
+	require.NoError(test, err)
+
+	coordinator := NewThresholdValidator(&ThresholdValidatorOpt{
+		Pubkey:    privateKey.PubKey(),
+		Threshold: 2,
+		SignState: signState,
+		Peers:     []Cosigner{cosigner1, cosigner2},
+	})
+
+	// the cosigners exchange their parts among themselves, as their rpc servers do when asked to sign
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1, Timestamp: time.Now()}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, 1, 0, stepPrevote)
+	exchangeEphemeralPart(test, cosigner2, cosigner1, 1, 0, stepPrevote)
+
+	require.NoError(test, coordinator.SignVote("chain-id", &vote))
+	require.True(test, privateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+	require.Equal(test, 2, coordinator.ReachableCosigners())
+}
+
+func TestThresholdValidatorProposalThreshold(test *testing.T) {
+	_, cosigner1, cosigner2, privateKey := newThresholdValidator2of2(test)
+
+	signState, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "coordinator_state.json"))
+	require.NoError(test, err)
+
+	// more cosigners than there are for a proposal, as if one of 3 were down
+	coordinator := NewThresholdValidator(&ThresholdValidatorOpt{
+		Pubkey:            privateKey.PubKey(),
+		Threshold:         2,
+		SignState:         signState,
+		Peers:             []Cosigner{cosigner1, cosigner2},
+		ProposalThreshold: 3,
+	})
+
+	exchangeEphemeralPart(test, cosigner1, cosigner2, 1, 0, stepPropose)
+	exchangeEphemeralPart(test, cosigner2, cosigner1, 1, 0, stepPropose)
+	proposal := tmProto.Proposal{Type: tmProto.ProposalType, Height: 1, PolRound: -1, Timestamp: time.Now()}
+	err = coordinator.SignProposal("chain-id", &proposal)
+	require.Error(test, err)
+	require.Equal(test, ErrorCodeUnavailable, ErrorCode(err))
+	require.Nil(test, proposal.Signature)
+
+	// votes only need the threshold
+	exchangeEphemeralPart(test, cosigner1, cosigner2, 1, 0, stepPrevote)
+	exchangeEphemeralPart(test, cosigner2, cosigner1, 1, 0, stepPrevote)
+	vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: 1, Timestamp: time.Now()}
+	require.NoError(test, coordinator.SignVote("chain-id", &vote))
+	require.True(test, privateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+}
+
+// BenchmarkCombineShares combines the share signatures of 7 cosigners with a threshold of 4, with all
+// shares good, which takes a single verification of the combined signature, and with one bad share,
+// which falls back to verifying the combinations of the threshold sized subsets until one is valid
+func BenchmarkCombineShares(b *testing.B) {
+	const total, threshold = 7, 4
+
+	privateKey := tmCryptoEd25519.GenPrivKey()
+	keyShares := tsed25519.DealShares(tsed25519.ExpandSecret(privateKey[:32]), threshold, total)
+
+	// every cosigner deals parts of its ephemeral secret to all of them
+	ephemeralShares := make([][]tsed25519.Scalar, total)
+	ephemeralPublics := make([]tsed25519.Element, total)
+	for dealer := 0; dealer < total; dealer++ {
+		seed := make([]byte, 32)
+		_, err := rand.Read(seed)
+		require.NoError(b, err)
+		secret := tsed25519.ExpandSecret(seed)
+		ephemeralPublics[dealer] = tsed25519.ScalarMultiplyBase(secret)
+		for idx, part := range tsed25519.DealShares(secret, threshold, total) {
+			ephemeralShares[idx] = append(ephemeralShares[idx], part)
+		}
+	}
+	ephemeralPublic := tsed25519.AddElements(ephemeralPublics)
+
+	signBytes := []byte("block")
+	shareSignatures := make([][]byte, total)
+	publics := make([][]byte, total)
+	for idx := range shareSignatures {
+		ephemeralShare := tsed25519.AddScalars(ephemeralShares[idx])
+		shareSignatures[idx] = tsed25519.SignWithShare(signBytes, keyShares[idx], ephemeralShare, privateKey.PubKey().Bytes(), ephemeralPublic)
+		publics[idx] = ephemeralPublic
+	}
+
+	validator := &ThresholdValidator{threshold: threshold, pubkey: privateKey.PubKey()}
+
+	b.Run("valid", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _, err := validator.combineShares(context.Background(), validator.pubkey, total, signBytes, publics, shareSignatures)
+			require.NoError(b, err)
+		}
+	})
+
+	badSignatures := append([][]byte{}, shareSignatures...)
+	badSignatures[0] = append([]byte{}, shareSignatures[0]...)
+	badSignatures[0][0] ^= 1
+	b.Run("one-bad-share", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, sigIds, err := validator.combineShares(context.Background(), validator.pubkey, total, signBytes, publics, badSignatures)
+			require.NoError(b, err)
+			require.NotContains(b, sigIds, 1)
+		}
+	})
+}
+
+func TestThresholdValidatorKeySwitch(test *testing.T) {
+	total := uint8(2)
+	threshold := uint8(2)
+	switchHeight := int64(3)
+
+	rsaKey1, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+	rsaKey2, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(test, err)
+	peers := []CosignerPeer{{ID: 1, PublicKey: rsaKey1.PublicKey}, {ID: 2, PublicKey: rsaKey2.PublicKey}}
+
+	privateKey := tmCryptoEd25519.GenPrivKey()
+	nextPrivateKey := tmCryptoEd25519.GenPrivKey()
+	shares := tsed25519.DealShares(tsed25519.ExpandSecret(privateKey[:32]), threshold, total)
+	nextShares := tsed25519.DealShares(tsed25519.ExpandSecret(nextPrivateKey[:32]), threshold, total)
+
+	cosigners := []Cosigner{}
+	for idx, rsaKey := range []*rsa.PrivateKey{rsaKey1, rsaKey2} {
+		signState, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "share_state.json"))
+		require.NoError(test, err)
+		cosigners = append(cosigners, NewLocalCosigner(LocalCosignerConfig{
+			CosignerKey: CosignerKey{
+				PubKey:       privateKey.PubKey(),
+				ShareKey:     shares[idx],
+				ID:           idx + 1,
+				NextPubKey:   nextPrivateKey.PubKey(),
+				NextShareKey: nextShares[idx],
+			},
+			SignState:    &signState,
+			RsaKey:       *rsaKey,
+			Peers:        peers,
+			Total:        total,
+			Threshold:    threshold,
+			SwitchHeight: switchHeight,
+		}))
+	}
+
+	signState, err := LoadOrCreateSignState(filepath.Join(test.TempDir(), "coordinator_state.json"))
+	require.NoError(test, err)
+	coordinator := NewThresholdValidator(&ThresholdValidatorOpt{
+		Pubkey:       privateKey.PubKey(),
+		Threshold:    int(threshold),
+		SignState:    signState,
+		Peers:        cosigners,
+		NextPubkey:   nextPrivateKey.PubKey(),
+		SwitchHeight: switchHeight,
+	})
+
+	signVote := func(height int64) tmProto.Vote {
+		vote := tmProto.Vote{Type: tmProto.PrevoteType, Height: height, Timestamp: time.Now()}
+		exchangeEphemeralPart(test, cosigners[0], cosigners[1], height, 0, stepPrevote)
+		exchangeEphemeralPart(test, cosigners[1], cosigners[0], height, 0, stepPrevote)
+		require.NoError(test, coordinator.SignVote("chain-id", &vote))
+		return vote
+	}
+
+	pubKey, err := coordinator.GetPubKey()
+	require.NoError(test, err)
+	require.Equal(test, privateKey.PubKey(), pubKey)
+
+	// the height before the switch is signed with the old key
+	vote := signVote(switchHeight - 1)
+	require.True(test, privateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+
+	// the nodes are given the next key for the switch height
+	pubKey, err = coordinator.GetPubKey()
+	require.NoError(test, err)
+	require.Equal(test, nextPrivateKey.PubKey(), pubKey)
+
+	vote = signVote(switchHeight)
+	require.True(test, nextPrivateKey.PubKey().VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+}
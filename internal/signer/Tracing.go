@@ -0,0 +1,101 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName names the tracer of the threshold sign flow
+const TracerName = "tendermint-signer"
+
+// tracer creates the spans of the threshold sign flow
+// Spans are dropped unless a tracer provider is installed, see NewTracerProvider.
+var tracer = otel.Tracer(TracerName)
+
+// NewTracerProvider returns a tracer provider exporting spans over OTLP/gRPC to endpoint,
+// e.g. http://localhost:4317 for an unencrypted local collector or https://collector:4317.
+// The provider and W3C trace context propagation are installed globally, so cosigner
+// rpc requests carry the trace context to the peers.
+func NewTracerProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid otel_endpoint %s: %w", endpoint, err)
+	}
+
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpointURL.Host)}
+	switch endpointURL.Scheme {
+	case "http":
+		options = append(options, otlptracegrpc.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("otel_endpoint %s must start with http:// or https://", endpoint)
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", TracerName))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider, nil
+}
+
+// traceCarrier carries a trace context in an rpc request
+type traceCarrier map[string]string
+
+func (carrier traceCarrier) Get(key string) string {
+	return carrier[key]
+}
+
+func (carrier traceCarrier) Set(key string, value string) {
+	carrier[key] = value
+}
+
+func (carrier traceCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// injectTraceContext returns the trace context of ctx to send along with an rpc request
+func injectTraceContext(ctx context.Context) map[string]string {
+	carrier := traceCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// extractTraceContext continues the trace sent along with an rpc request
+func extractTraceContext(ctx context.Context, traceContext map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, traceCarrier(traceContext))
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,79 @@
+package signer
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the global tracer only ever delegates to the first provider set, so tests share one
+var (
+	testTracerProvider     *sdktrace.TracerProvider
+	testTracerProviderOnce sync.Once
+)
+
+func TestThresholdValidatorSpans(test *testing.T) {
+	testTracerProviderOnce.Do(func() {
+		testTracerProvider = sdktrace.NewTracerProvider()
+		otel.SetTracerProvider(testTracerProvider)
+	})
+	recorder := tracetest.NewSpanRecorder()
+	testTracerProvider.RegisterSpanProcessor(recorder)
+	defer testTracerProvider.UnregisterSpanProcessor(recorder)
+
+	validator, cosigner1, cosigner2, _ := newThresholdValidator2of2(test)
+
+	vote := tmProto.Vote{
+		Type:   tmProto.PrevoteType,
+		Height: 1,
+		Round:  0,
+	}
+	exchangeEphemeralPart(test, cosigner1, cosigner2, vote.Height, int64(vote.Round), stepPrevote)
+
+	err := validator.SignVote("chain-id", &vote)
+	require.NoError(test, err)
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	require.Len(test, spans["SignVote"], 1)
+	signVote := spans["SignVote"][0]
+
+	// a child span for our own share and for the peer
+	require.Len(test, spans["Cosigner"], 2)
+	for _, span := range spans["Cosigner"] {
+		require.Equal(test, signVote.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+	require.Len(test, spans["SignState.Save"], 1)
+}
+
+func TestTraceContextPropagation(test *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+
+	traceContext := injectTraceContext(ctx)
+	require.NotEmpty(test, traceContext["traceparent"])
+
+	remote := trace.SpanContextFromContext(extractTraceContext(context.Background(), traceContext))
+	require.Equal(test, span.SpanContext().TraceID(), remote.TraceID())
+	require.True(test, remote.IsRemote())
+}
+
+func TestNewTracerProviderInvalidEndpoint(test *testing.T) {
+	_, err := NewTracerProvider(context.Background(), "localhost:4317")
+	require.Error(test, err)
+}
@@ -0,0 +1,45 @@
+package signer
+
+import (
+	"crypto/rsa"
+	"math/big"
+)
+
+// zeroizeBytes overwrites b with zeros
+func zeroizeBytes(b []byte) {
+	for idx := range b {
+		b[idx] = 0
+	}
+}
+
+// zeroizeInt overwrites the words backing x with zeros, then sets x to 0
+func zeroizeInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+	words := x.Bits()
+	for idx := range words {
+		words[idx] = 0
+	}
+	x.SetInt64(0)
+}
+
+// zeroizeRSAKey overwrites the private values of key. Copies of the key made by the
+// standard library, e.g. the moduli cached by Precompute, cannot be reached and are left to the GC.
+func zeroizeRSAKey(key *rsa.PrivateKey) {
+	if key == nil {
+		return
+	}
+	zeroizeInt(key.D)
+	for _, prime := range key.Primes {
+		zeroizeInt(prime)
+	}
+	zeroizeInt(key.Precomputed.Dp)
+	zeroizeInt(key.Precomputed.Dq)
+	zeroizeInt(key.Precomputed.Qinv)
+	for _, crt := range key.Precomputed.CRTValues {
+		zeroizeInt(crt.Exp)
+		zeroizeInt(crt.Coeff)
+		zeroizeInt(crt.R)
+	}
+}
@@ -0,0 +1,51 @@
+// Package signer exposes the threshold validator for embedding in other Go programs.
+//
+// The implementation lives in internal/signer; this package re-exports the pieces
+// needed to configure and run a signer without the cmd/signer wiring.
+package signer
+
+import (
+	internalSigner "tendermint-signer/internal/signer"
+
+	tmLog "github.com/tendermint/tendermint/libs/log"
+)
+
+type (
+	Config         = internalSigner.Config
+	NodeConfig     = internalSigner.NodeConfig
+	CosignerConfig = internalSigner.CosignerConfig
+	Service        = internalSigner.Service
+)
+
+// The threshold signing pieces, for assembling a quorum in process, see signertest
+type (
+	Cosigner           = internalSigner.Cosigner
+	CosignerKey        = internalSigner.CosignerKey
+	LocalCosigner      = internalSigner.LocalCosigner
+	ThresholdValidator = internalSigner.ThresholdValidator
+
+	CosignerSignRequest                    = internalSigner.CosignerSignRequest
+	CosignerSignResponse                   = internalSigner.CosignerSignResponse
+	CosignerGetEphemeralSecretPartRequest  = internalSigner.CosignerGetEphemeralSecretPartRequest
+	CosignerGetEphemeralSecretPartResponse = internalSigner.CosignerGetEphemeralSecretPartResponse
+	CosignerSetEphemeralSecretPartRequest  = internalSigner.CosignerSetEphemeralSecretPartRequest
+	CosignerHasEphemeralSecretPartRequest  = internalSigner.CosignerHasEphemeralSecretPartRequest
+	CosignerHasEphemeralSecretPartResponse = internalSigner.CosignerHasEphemeralSecretPartResponse
+)
+
+// New builds a Service from the config
+// The returned service is started and stopped with Start() and Stop()
+func New(config Config, logger tmLog.Logger) (*Service, error) {
+	return internalSigner.New(config, logger)
+}
+
+// LoadConfigFromFile loads a toml configuration file
+func LoadConfigFromFile(file string) (Config, error) {
+	return internalSigner.LoadConfigFromFile(file)
+}
+
+// LoadConfigFromDir merges the toml configuration fragments of a directory, see the README
+// Options overridden by a later fragment are returned in conflicts.
+func LoadConfigFromDir(dir string) (config Config, conflicts []string, err error) {
+	return internalSigner.LoadConfigFromDir(dir)
+}
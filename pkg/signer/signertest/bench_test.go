@@ -0,0 +1,102 @@
+package signertest
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"tendermint-signer/internal/signer"
+
+	"github.com/stretchr/testify/require"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+// reportThroughput adds the operations per second since start to the benchmark results
+func reportThroughput(b *testing.B, start time.Time, unit string) {
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), unit)
+}
+
+// BenchmarkThresholdSign signs a prevote per height with a 2-of-3 quorum,
+// including the ephemeral share exchange and both sign state writes
+func BenchmarkThresholdSign(b *testing.B) {
+	quorum, err := NewQuorum(2, 3, b.TempDir())
+	require.NoError(b, err)
+
+	validator, err := quorum.Validator(1)
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < b.N; i++ {
+		vote := tmProto.Vote{
+			Height:    int64(i + 1),
+			Type:      tmProto.PrevoteType,
+			Timestamp: time.Now(),
+		}
+		require.NoError(b, validator.SignVote("chain-id", &vote))
+	}
+
+	reportThroughput(b, start, "signatures/sec")
+}
+
+// BenchmarkSignStateSave persists the sign state of a new height
+func BenchmarkSignStateSave(b *testing.B) {
+	signState, err := signer.LoadOrCreateSignState(path.Join(b.TempDir(), "sign_state.json"))
+	require.NoError(b, err)
+
+	signState.EphemeralPublic = make([]byte, 32)
+	signState.Signature = make([]byte, 64)
+	signState.SignBytes = make([]byte, 128)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < b.N; i++ {
+		signState.Height = int64(i + 1)
+		require.NoError(b, signState.Save())
+	}
+
+	reportThroughput(b, start, "saves/sec")
+}
+
+// BenchmarkEphSecPart hands the ephemeral secret part of one cosigner to another,
+// as done for each peer of every signature
+func BenchmarkEphSecPart(b *testing.B) {
+	quorum, err := NewQuorum(2, 3, b.TempDir())
+	require.NoError(b, err)
+
+	source := quorum.Cosigners[0]
+	target := quorum.Cosigners[1]
+	ctx := context.Background()
+	step := signer.VoteToStep(&tmProto.Vote{Type: tmProto.PrevoteType})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+
+	for i := 0; i < b.N; i++ {
+		height := int64(i + 1)
+		part, err := source.GetEphemeralSecretPart(ctx, signer.CosignerGetEphemeralSecretPartRequest{
+			ID:     target.GetID(),
+			Height: height,
+			Step:   step,
+		})
+		require.NoError(b, err)
+
+		err = target.SetEphemeralSecretPart(ctx, signer.CosignerSetEphemeralSecretPartRequest{
+			SourceID:                       part.SourceID,
+			SourceEphemeralSecretPublicKey: part.SourceEphemeralSecretPublicKey,
+			EncryptedSharePart:             part.EncryptedSharePart,
+			SourceSig:                      part.SourceSig,
+			Height:                         height,
+			Step:                           step,
+		})
+		require.NoError(b, err)
+	}
+
+	reportThroughput(b, start, "parts/sec")
+}
@@ -0,0 +1,149 @@
+package signertest
+
+import (
+	"context"
+
+	internalSigner "tendermint-signer/internal/signer"
+	"tendermint-signer/pkg/signer"
+)
+
+// InProcessCosigner forwards requests to a cosigner in the same process, standing in for
+// a RemoteCosigner and the CosignerRpcServer on the other end.
+//
+// Like a remote call, a request returns once ctx is done even if the cosigner has not replied,
+// and a sign request first collects the cosigner's ephemeral secret parts from its peers.
+type InProcessCosigner struct {
+	cosigner signer.Cosigner
+	peers    []signer.Cosigner
+}
+
+// NewInProcessCosigner returns an InProcessCosigner for cosigner, which collects
+// ephemeral secret parts from peers when asked to sign
+func NewInProcessCosigner(cosigner signer.Cosigner, peers []signer.Cosigner) *InProcessCosigner {
+	return &InProcessCosigner{
+		cosigner: cosigner,
+		peers:    peers,
+	}
+}
+
+// call runs fn on its own goroutine and stops waiting once ctx is done
+func call(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GetID implements the Cosigner interface
+func (cosigner *InProcessCosigner) GetID() int {
+	return cosigner.cosigner.GetID()
+}
+
+// GetEphemeralSecretPart implements the Cosigner interface
+func (cosigner *InProcessCosigner) GetEphemeralSecretPart(
+	ctx context.Context,
+	req signer.CosignerGetEphemeralSecretPartRequest,
+) (signer.CosignerGetEphemeralSecretPartResponse, error) {
+	var res signer.CosignerGetEphemeralSecretPartResponse
+	err := call(ctx, func() (err error) {
+		res, err = cosigner.cosigner.GetEphemeralSecretPart(ctx, req)
+		return err
+	})
+	if err != nil {
+		return signer.CosignerGetEphemeralSecretPartResponse{}, err
+	}
+	return res, nil
+}
+
+// SetEphemeralSecretPart implements the Cosigner interface
+func (cosigner *InProcessCosigner) SetEphemeralSecretPart(ctx context.Context, req signer.CosignerSetEphemeralSecretPartRequest) error {
+	return call(ctx, func() error {
+		return cosigner.cosigner.SetEphemeralSecretPart(ctx, req)
+	})
+}
+
+// HasEphemeralSecretPart implements the Cosigner interface
+func (cosigner *InProcessCosigner) HasEphemeralSecretPart(
+	ctx context.Context,
+	req signer.CosignerHasEphemeralSecretPartRequest,
+) (signer.CosignerHasEphemeralSecretPartResponse, error) {
+	var res signer.CosignerHasEphemeralSecretPartResponse
+	err := call(ctx, func() (err error) {
+		res, err = cosigner.cosigner.HasEphemeralSecretPart(ctx, req)
+		return err
+	})
+	if err != nil {
+		return signer.CosignerHasEphemeralSecretPartResponse{}, err
+	}
+	return res, nil
+}
+
+// Sign implements the Cosigner interface
+// As with the cosigner rpc, the ephemeral public key, timestamp and signature are returned
+func (cosigner *InProcessCosigner) Sign(ctx context.Context, req signer.CosignerSignRequest) (signer.CosignerSignResponse, error) {
+	var res signer.CosignerSignResponse
+	err := call(ctx, func() error {
+		cosigner.collectEphemeralSecretParts(ctx, req.SignBytes)
+
+		signResp, err := cosigner.cosigner.Sign(ctx, req)
+		if err != nil {
+			return err
+		}
+		res.EphemeralPublic = signResp.EphemeralPublic
+		res.Timestamp = signResp.Timestamp
+		res.Signature = signResp.Signature
+		return nil
+	})
+	if err != nil {
+		return signer.CosignerSignResponse{}, err
+	}
+	return res, nil
+}
+
+// collectEphemeralSecretParts asks each peer for its ephemeral secret part for the HRS,
+// as the CosignerRpcServer does before signing. Failures are left for Sign to report.
+func (cosigner *InProcessCosigner) collectEphemeralSecretParts(ctx context.Context, signBytes []byte) {
+	height, round, step, err := internalSigner.UnpackHRS(signBytes)
+	if err != nil {
+		return
+	}
+
+	for _, peer := range cosigner.peers {
+		hasResp, err := cosigner.cosigner.HasEphemeralSecretPart(ctx, signer.CosignerHasEphemeralSecretPartRequest{
+			ID:     peer.GetID(),
+			Height: height,
+			Round:  round,
+			Step:   step,
+		})
+		if err != nil || hasResp.Exists {
+			continue
+		}
+
+		partResp, err := peer.GetEphemeralSecretPart(ctx, signer.CosignerGetEphemeralSecretPartRequest{
+			ID:     cosigner.GetID(),
+			Height: height,
+			Round:  round,
+			Step:   step,
+		})
+		if err != nil {
+			continue
+		}
+
+		cosigner.cosigner.SetEphemeralSecretPart(ctx, signer.CosignerSetEphemeralSecretPartRequest{
+			SourceID:                       partResp.SourceID,
+			SourceEphemeralSecretPublicKey: partResp.SourceEphemeralSecretPublicKey,
+			EncryptedSharePart:             partResp.EncryptedSharePart,
+			Height:                         height,
+			Round:                          round,
+			Step:                           step,
+			SourceSig:                      partResp.SourceSig,
+		})
+	}
+}
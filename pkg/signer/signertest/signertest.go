@@ -0,0 +1,143 @@
+// Package signertest runs a threshold signer quorum in a single process for tests.
+//
+// A Quorum deals a fresh validator key into t-of-n cosigner shares with in-memory rsa keys.
+// Cosigners talk to each other through InProcessCosigner instead of the cosigner rpc,
+// so the full threshold signing flow runs without opening any sockets.
+package signertest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"path"
+
+	internalSigner "tendermint-signer/internal/signer"
+	"tendermint-signer/pkg/signer"
+
+	tmCrypto "github.com/tendermint/tendermint/crypto"
+	tmCryptoEd25519 "github.com/tendermint/tendermint/crypto/ed25519"
+	tsed25519 "gitlab.com/polychainlabs/threshold-ed25519/pkg"
+)
+
+// rsa keys only protect ephemeral share parts in flight, smaller keys keep tests fast
+const rsaKeyBits = 2048
+
+// Quorum is a t-of-n set of cosigners sharing one validator key
+type Quorum struct {
+	Threshold int
+
+	// PrivKey is the full validator key, kept so tests can compare against a single signer
+	PrivKey tmCrypto.PrivKey
+	PubKey  tmCrypto.PubKey
+
+	// Keys and Cosigners are indexed by cosigner ID - 1
+	Keys      []signer.CosignerKey
+	Cosigners []*signer.LocalCosigner
+
+	stateDir string
+}
+
+// NewQuorum deals a new validator key into total shares, any threshold of which can sign.
+// Sign states are written to stateDir, typically test.TempDir().
+func NewQuorum(threshold int, total int, stateDir string) (*Quorum, error) {
+	if threshold < 1 || threshold > total {
+		return nil, fmt.Errorf("invalid threshold %d for %d cosigners", threshold, total)
+	}
+
+	privKey := tmCryptoEd25519.GenPrivKey()
+	secretShares := tsed25519.DealShares(tsed25519.ExpandSecret(privKey[:32]), uint8(threshold), uint8(total))
+
+	rsaKeys := make([]*rsa.PrivateKey, total)
+	pubKeys := make([]*rsa.PublicKey, total)
+	peers := make([]internalSigner.CosignerPeer, total)
+	for idx := range rsaKeys {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		rsaKeys[idx] = rsaKey
+		pubKeys[idx] = &rsaKey.PublicKey
+		peers[idx] = internalSigner.CosignerPeer{
+			ID:        idx + 1,
+			PublicKey: rsaKey.PublicKey,
+		}
+	}
+
+	quorum := &Quorum{
+		Threshold: threshold,
+		PrivKey:   privKey,
+		PubKey:    privKey.PubKey(),
+		stateDir:  stateDir,
+	}
+
+	for idx, rsaKey := range rsaKeys {
+		key := signer.CosignerKey{
+			PubKey:       privKey.PubKey(),
+			ShareKey:     secretShares[idx],
+			RSAKey:       *rsaKey,
+			ID:           idx + 1,
+			CosignerKeys: pubKeys,
+		}
+
+		stateFile := path.Join(stateDir, fmt.Sprintf("cosigner_%d_share_sign_state.json", key.ID))
+		signState, err := internalSigner.LoadOrCreateSignState(stateFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cosigner := internalSigner.NewLocalCosigner(internalSigner.LocalCosignerConfig{
+			CosignerKey: key,
+			SignState:   &signState,
+			RsaKey:      *rsaKey,
+			Peers:       peers,
+			Total:       uint8(total),
+			Threshold:   uint8(threshold),
+		})
+
+		quorum.Keys = append(quorum.Keys, key)
+		quorum.Cosigners = append(quorum.Cosigners, cosigner)
+	}
+
+	return quorum, nil
+}
+
+// Validator returns a ThresholdValidator using cosigner id as its own share
+// and reaching the other cosigners in process.
+func (quorum *Quorum) Validator(id int) (*signer.ThresholdValidator, error) {
+	if id < 1 || id > len(quorum.Cosigners) {
+		return nil, fmt.Errorf("unexpected cosigner ID %d", id)
+	}
+
+	stateFile := path.Join(quorum.stateDir, fmt.Sprintf("validator_%d_sign_state.json", id))
+	signState, err := internalSigner.LoadOrCreateSignState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]signer.Cosigner, 0)
+	for _, cosigner := range quorum.Cosigners {
+		if cosigner.GetID() == id {
+			continue
+		}
+		peers = append(peers, quorum.InProcessCosigner(cosigner.GetID()))
+	}
+
+	return internalSigner.NewThresholdValidator(&internalSigner.ThresholdValidatorOpt{
+		Pubkey:    quorum.PubKey,
+		Threshold: quorum.Threshold,
+		SignState: signState,
+		Cosigner:  quorum.Cosigners[id-1],
+		Peers:     peers,
+	}), nil
+}
+
+// InProcessCosigner returns cosigner id as seen by its peers, in place of a RemoteCosigner
+func (quorum *Quorum) InProcessCosigner(id int) *InProcessCosigner {
+	peers := make([]signer.Cosigner, 0)
+	for _, cosigner := range quorum.Cosigners {
+		if cosigner.GetID() != id {
+			peers = append(peers, cosigner)
+		}
+	}
+	return NewInProcessCosigner(quorum.Cosigners[id-1], peers)
+}
@@ -0,0 +1,90 @@
+package signertest
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"tendermint-signer/internal/signer"
+
+	"github.com/stretchr/testify/require"
+	tmProto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tm "github.com/tendermint/tendermint/types"
+)
+
+func TestQuorumSignsVote(test *testing.T) {
+	quorum, err := NewQuorum(2, 3, test.TempDir())
+	require.NoError(test, err)
+
+	validator, err := quorum.Validator(1)
+	require.NoError(test, err)
+
+	vote := tmProto.Vote{
+		Height:    1,
+		Round:     0,
+		Type:      tmProto.PrevoteType,
+		Timestamp: time.Now(),
+	}
+	require.NoError(test, validator.SignVote("chain-id", &vote))
+	require.True(test, quorum.PubKey.VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+
+	// another validator of the same quorum continues from the next height
+	validator, err = quorum.Validator(3)
+	require.NoError(test, err)
+
+	proposal := tmProto.Proposal{
+		Height:    2,
+		Round:     0,
+		Type:      tmProto.ProposalType,
+		Timestamp: time.Now(),
+	}
+	require.NoError(test, validator.SignProposal("chain-id", &proposal))
+	require.True(test, quorum.PubKey.VerifySignature(tm.ProposalSignBytes("chain-id", &proposal), proposal.Signature))
+}
+
+func TestNewQuorumInvalidThreshold(test *testing.T) {
+	_, err := NewQuorum(3, 2, test.TempDir())
+	require.Error(test, err)
+}
+
+// corruptCosigner returns share signatures that do not combine
+type corruptCosigner struct {
+	signer.Cosigner
+}
+
+func (cosigner *corruptCosigner) Sign(ctx context.Context, req signer.CosignerSignRequest) (signer.CosignerSignResponse, error) {
+	resp, err := cosigner.Cosigner.Sign(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Signature = append([]byte{}, resp.Signature...)
+	resp.Signature[0] ^= 0xff
+	return resp, nil
+}
+
+func TestQuorumExcludesCorruptShare(test *testing.T) {
+	quorum, err := NewQuorum(2, 3, test.TempDir())
+	require.NoError(test, err)
+
+	signState, err := signer.LoadOrCreateSignState(path.Join(test.TempDir(), "validator_sign_state.json"))
+	require.NoError(test, err)
+
+	validator := signer.NewThresholdValidator(&signer.ThresholdValidatorOpt{
+		Pubkey:    quorum.PubKey,
+		Threshold: quorum.Threshold,
+		SignState: signState,
+		Cosigner:  quorum.Cosigners[0],
+		Peers:     []signer.Cosigner{quorum.InProcessCosigner(2), &corruptCosigner{quorum.InProcessCosigner(3)}},
+	})
+
+	// the shares of all three do not combine, the two good ones do
+	vote := tmProto.Vote{
+		Height:    1,
+		Round:     0,
+		Type:      tmProto.PrevoteType,
+		Timestamp: time.Now(),
+	}
+	require.NoError(test, validator.SignVote("chain-id", &vote))
+	require.True(test, quorum.PubKey.VerifySignature(tm.VoteSignBytes("chain-id", &vote), vote.Signature))
+}